@@ -0,0 +1,172 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/EliasManj/tx-parser/parser"
+)
+
+// TenantRequest creates or updates a tenant. The id is only read when
+// creating a tenant, and generated if empty.
+type TenantRequest struct {
+	ID               string  `json:"id"`
+	Name             string  `json:"name"`
+	MaxSubscriptions int     `json:"maxSubscriptions"`
+	RateLimit        float64 `json:"rateLimit"`
+	Burst            int     `json:"burst"`
+}
+
+func (r TenantRequest) tenant() parser.Tenant {
+	return parser.Tenant{ID: r.ID, Name: r.Name, MaxSubscriptions: r.MaxSubscriptions, RateLimit: r.RateLimit, Burst: r.Burst}
+}
+
+type TenantsResponse struct {
+	Tenants []parser.Tenant `json:"tenants"`
+}
+
+// KeyRequest creates a key for a tenant, or an admin key.
+type KeyRequest struct {
+	Tenant string `json:"tenant"`
+	Admin  bool   `json:"admin"`
+}
+
+// KeyResponse describes an API key. The key itself is only returned when it
+// is created.
+type KeyResponse struct {
+	ID      string    `json:"id"`
+	Tenant  string    `json:"tenant,omitempty"`
+	Admin   bool      `json:"admin"`
+	Created time.Time `json:"created"`
+	Key     string    `json:"key,omitempty"`
+}
+
+type KeysResponse struct {
+	Keys []KeyResponse `json:"keys"`
+}
+
+func keyResponse(key parser.APIKey) KeyResponse {
+	return KeyResponse{ID: key.ID, Tenant: key.Tenant, Admin: key.Admin, Created: key.Created}
+}
+
+// registerAdmin adds the routes managing tenants and keys.
+func (s *Server) registerAdmin() {
+	s.mux.HandleFunc("/v1/admin/tenants", methods(map[string]http.HandlerFunc{
+		http.MethodGet:  s.v1Tenants,
+		http.MethodPost: s.v1CreateTenant,
+	}))
+	s.mux.HandleFunc("/v1/admin/tenants/{tenant}", methods(map[string]http.HandlerFunc{
+		http.MethodGet:    s.v1Tenant,
+		http.MethodPut:    s.v1UpdateTenant,
+		http.MethodDelete: s.v1DeleteTenant,
+	}))
+	s.mux.HandleFunc("/v1/admin/keys", methods(map[string]http.HandlerFunc{
+		http.MethodGet:  s.v1Keys,
+		http.MethodPost: s.v1CreateKey,
+	}))
+	s.mux.HandleFunc("/v1/admin/keys/{key}", methods(map[string]http.HandlerFunc{
+		http.MethodDelete: s.v1RevokeKey,
+	}))
+}
+
+func (s *Server) v1Tenants(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, TenantsResponse{Tenants: s.access.Tenants()})
+}
+
+func (s *Server) v1CreateTenant(w http.ResponseWriter, r *http.Request) {
+	var request TenantRequest
+	if !decodeBody(w, r, &request) {
+		return
+	}
+	tenant, err := s.access.CreateTenant(request.tenant())
+	if err != nil {
+		writeV1Error(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, tenant)
+}
+
+func (s *Server) v1Tenant(w http.ResponseWriter, r *http.Request) {
+	tenant, err := s.access.Tenant(r.PathValue("tenant"))
+	if err != nil {
+		writeV1Error(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, tenant)
+}
+
+func (s *Server) v1UpdateTenant(w http.ResponseWriter, r *http.Request) {
+	var request TenantRequest
+	if !decodeBody(w, r, &request) {
+		return
+	}
+	request.ID = r.PathValue("tenant")
+	tenant, err := s.access.UpdateTenant(request.tenant())
+	if err != nil {
+		writeV1Error(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, tenant)
+}
+
+// v1DeleteTenant removes a tenant and its keys. The parsers stop tracking
+// the addresses no other tenant holds.
+func (s *Server) v1DeleteTenant(w http.ResponseWriter, r *http.Request) {
+	s.subscriptions.Lock()
+	defer s.subscriptions.Unlock()
+
+	tenant, err := s.access.DeleteTenant(r.PathValue("tenant"))
+	if err != nil {
+		writeV1Error(w, err)
+		return
+	}
+	for chainID, addresses := range tenant.Subscriptions {
+		p, exists := s.parsers[chainID]
+		if !exists {
+			continue
+		}
+		for _, address := range addresses {
+			if s.access.Held(chainID, address) {
+				continue
+			}
+			err := p.Unsubscribe(r.Context(), address)
+			if err != nil && !errors.Is(err, parser.ErrNotSubscribed) {
+				writeV1Error(w, err)
+				return
+			}
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) v1Keys(w http.ResponseWriter, r *http.Request) {
+	keys := []KeyResponse{}
+	for _, key := range s.access.Keys() {
+		keys = append(keys, keyResponse(key))
+	}
+	writeJSON(w, http.StatusOK, KeysResponse{Keys: keys})
+}
+
+func (s *Server) v1CreateKey(w http.ResponseWriter, r *http.Request) {
+	var request KeyRequest
+	if !decodeBody(w, r, &request) {
+		return
+	}
+	secret, key, err := s.access.CreateKey(request.Tenant, request.Admin)
+	if err != nil {
+		writeV1Error(w, err)
+		return
+	}
+	response := keyResponse(key)
+	response.Key = secret
+	writeJSON(w, http.StatusCreated, response)
+}
+
+func (s *Server) v1RevokeKey(w http.ResponseWriter, r *http.Request) {
+	if err := s.access.RevokeKey(r.PathValue("key")); err != nil {
+		writeV1Error(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
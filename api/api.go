@@ -1,73 +1,432 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math/big"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/EliasManj/tx-parser/parser"
+	"github.com/EliasManj/tx-parser/utils"
 )
 
+// Parser is the set of parser operations exposed over HTTP.
+type Parser interface {
+	parser.ParserV2
+
+	// Stop observing an address and drop its transactions
+	Unsubscribe(ctx context.Context, address string) error
+
+	// List of subscribed addresses
+	GetSubscriptions(ctx context.Context) ([]string, error)
+
+	// Id of the chain the parser tracks
+	ChainID() int64
+
+	// Canonical address for a hex address or ENS name
+	ResolveAddress(ctx context.Context, address string) (string, error)
+
+	// Primary ENS name of an address, empty if it has none
+	LookupName(ctx context.Context, address string) (string, error)
+
+	// Stream of the transactions the parser records
+	Stream() *parser.TxStream
+
+	// Filtered page of the transactions of an address and the next cursor
+	QueryTransactions(ctx context.Context, address string, q parser.TransactionQuery) ([]parser.Transaction, string, error)
+
+	// Webhook the events of a subscribed address are posted to
+	SetWebhook(ctx context.Context, address string, webhook parser.Webhook) (parser.Webhook, error)
+	GetWebhook(ctx context.Context, address string) (parser.Webhook, error)
+	RemoveWebhook(ctx context.Context, address string) error
+
+	// Delivery queue and failed list of the webhooks
+	Webhooks() *parser.Webhooks
+
+	// State of the parser loop, RPC endpoint and storage
+	Health() parser.Health
+
+	// Updates the metrics sampled when scraped
+	CollectMetrics()
+}
+
+var _ Parser = &parser.MyParser{}
+
+// Server serves the HTTP API for one or more parsers, one per chain.
+//
+// Routes under /chains/{id}/ are scoped to the parser for that chain id. The
+// unscoped routes operate on the first parser, for compatibility with
+// single-chain deployments. The versioned JSON API is served under /v1/.
+type Server struct {
+	parsers       map[int64]Parser
+	defaultParser Parser
+	mux           *http.ServeMux
+	heartbeat     time.Duration
+
+	// API keys and tenants, nil if authentication is disabled
+	access        *parser.Access
+	limiter       *rateLimiter
+	subscriptions sync.Mutex
+}
+
+var _ http.Handler = &Server{}
+
+func NewServer(parsers ...Parser) *Server {
+	s := &Server{
+		parsers:   make(map[int64]Parser),
+		mux:       http.NewServeMux(),
+		heartbeat: DefaultHeartbeat,
+	}
+	for _, p := range parsers {
+		if s.defaultParser == nil {
+			s.defaultParser = p
+		}
+		s.parsers[p.ChainID()] = p
+	}
+
+	s.mux.HandleFunc("/", HelloHandler)
+	s.mux.HandleFunc("/healthz", s.HealthHandler)
+	s.mux.HandleFunc("/readyz", s.ReadyHandler)
+	s.mux.HandleFunc("/metrics", s.MetricsHandler)
+	s.mux.HandleFunc("/getCurrentBlock", s.GetCurrentBlockHandler)
+	s.mux.HandleFunc("/subscribe", s.SubscribeHandler)
+	s.mux.HandleFunc("/getTransactions", s.GetTransactionsHandler)
+	s.mux.HandleFunc("/getSubscriptions", s.GetSubscriptionsHandler)
+
+	s.mux.HandleFunc("/chains", s.GetChainsHandler)
+	s.mux.HandleFunc("/chains/{id}/getCurrentBlock", s.GetCurrentBlockHandler)
+	s.mux.HandleFunc("/chains/{id}/subscribe", s.SubscribeHandler)
+	s.mux.HandleFunc("/chains/{id}/getTransactions", s.GetTransactionsHandler)
+	s.mux.HandleFunc("/chains/{id}/getSubscriptions", s.GetSubscriptionsHandler)
+	s.registerV1()
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.access != nil && !publicPaths[r.URL.Path] {
+		if r = s.authenticate(w, r); r == nil {
+			return
+		}
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// publicPaths are served without an API key.
+var publicPaths = map[string]bool{"/": true, "/healthz": true, "/readyz": true}
+
+var (
+	errNoChains       = errors.New("No chains configured")
+	errInvalidChainID = errors.New("Invalid chain id")
+	errUnknownChain   = errors.New("Unknown chain id")
+)
+
+// lookupParser returns the parser addressed by the request's chain id, or
+// the default parser for unscoped routes. With authentication enabled, it
+// is scoped to the tenant of the request's API key.
+func (s *Server) lookupParser(r *http.Request) (Parser, error) {
+	p := s.defaultParser
+	if id := r.PathValue("id"); id != "" {
+		chainID, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			return nil, errInvalidChainID
+		}
+		var exists bool
+		if p, exists = s.parsers[chainID]; !exists {
+			return nil, errUnknownChain
+		}
+	} else if p == nil {
+		return nil, errNoChains
+	}
+	if key, ok := requestKey(r); ok {
+		return &tenantParser{Parser: p, access: s.access, tenant: key.Tenant, mu: &s.subscriptions}, nil
+	}
+	return p, nil
+}
+
+// parserFor returns the parser addressed by the request. It writes an error
+// response and returns nil when no parser matches.
+func (s *Server) parserFor(w http.ResponseWriter, r *http.Request) Parser {
+	p, err := s.lookupParser(r)
+	if errors.Is(err, errInvalidChainID) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+	}
+	return p
+}
+
 func HelloHandler(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintln(w, "Hello, the server is running!")
 }
 
-func GetCurrentBlockHandler(w http.ResponseWriter, r *http.Request) {
-	myparser := parser.GetParser()
-	currentBlock := myparser.GetCurrentBlock()
+func (s *Server) GetChainsHandler(w http.ResponseWriter, r *http.Request) {
+	chains := make([]int64, 0, len(s.parsers))
+	for chainID := range s.parsers {
+		chains = append(chains, chainID)
+	}
+	sort.Slice(chains, func(i, j int) bool { return chains[i] < chains[j] })
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(chains)
+}
+
+// resolveNames fills in the primary names of the counterparties of
+// transactions.
+func resolveNames(ctx context.Context, p Parser, transactions []parser.Transaction) error {
+	var err error
+	for i := range transactions {
+		tx := &transactions[i]
+		tx.FromName, err = p.LookupName(ctx, tx.From)
+		if err != nil {
+			return err
+		}
+		tx.ToName, err = p.LookupName(ctx, tx.To)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeParserError maps parser errors to HTTP responses.
+func writeParserError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, parser.ErrInvalidAddress), errors.Is(err, parser.ErrInvalidQuery),
+		errors.Is(err, parser.ErrInvalidCursor):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, parser.ErrAlreadySubscribed):
+		http.Error(w, "Address already subscribed", http.StatusBadRequest)
+	case errors.Is(err, parser.ErrNotSubscribed):
+		http.Error(w, "Address not subscribed", http.StatusNotFound)
+	case errors.Is(err, parser.ErrQuotaExceeded):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	default:
+		http.Error(w, fmt.Sprintf("Internal error: %v", err), http.StatusInternalServerError)
+	}
+}
+
+func (s *Server) GetCurrentBlockHandler(w http.ResponseWriter, r *http.Request) {
+	p := s.parserFor(w, r)
+	if p == nil {
+		return
+	}
+	currentBlock, err := p.GetCurrentBlock(r.Context())
+	if err != nil {
+		writeParserError(w, err)
+		return
+	}
 	response := struct {
-		CurrentBlock int `json:"currentBlock"`
+		CurrentBlock int64 `json:"currentBlock"`
 	}{
 		CurrentBlock: currentBlock,
 	}
 	w.Header().Set("Content-Type", "application/json")
-	err := json.NewEncoder(w).Encode(response)
+	err = json.NewEncoder(w).Encode(response)
 	if err != nil {
 		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
 	}
 }
 
-func SubscribeHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) SubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	p := s.parserFor(w, r)
+	if p == nil {
+		return
+	}
 	address := r.URL.Query().Get("address")
 	if address == "" {
 		http.Error(w, "Missing address parameter", http.StatusBadRequest)
 		return
 	}
-	myparser := parser.GetParser()
-	success := myparser.Subscribe(strings.ToLower(address))
-	if !success {
-		http.Error(w, "Address already subscribed", http.StatusBadRequest)
+	resolved, err := p.ResolveAddress(r.Context(), address)
+	if err != nil {
+		writeParserError(w, err)
 		return
 	}
-	fmt.Fprintf(w, "Address %s subscribed successfully", address)
+	err = p.Subscribe(r.Context(), resolved)
+	if err != nil {
+		writeParserError(w, err)
+		return
+	}
+	if resolved != strings.ToLower(address) {
+		fmt.Fprintf(w, "Address %s (%s) subscribed successfully", utils.ChecksumAddress(resolved), address)
+		return
+	}
+	fmt.Fprintf(w, "Address %s subscribed successfully", utils.ChecksumAddress(resolved))
 }
 
-func GetSubscriptionsHandler(w http.ResponseWriter, r *http.Request) {
-	myparser := parser.GetParser()
-	subscriptions := myparser.GetSubscriptions()
+func (s *Server) GetSubscriptionsHandler(w http.ResponseWriter, r *http.Request) {
+	p := s.parserFor(w, r)
+	if p == nil {
+		return
+	}
+	subscriptions, err := p.GetSubscriptions(r.Context())
+	if err != nil {
+		writeParserError(w, err)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(subscriptions)
+	json.NewEncoder(w).Encode(checksumAddresses(subscriptions))
 }
 
-func GetTransactionsHandler(w http.ResponseWriter, r *http.Request) {
+// checksumAddresses returns the EIP-55 form of stored lowercase addresses.
+func checksumAddresses(addresses []string) []string {
+	checksummed := make([]string, len(addresses))
+	for i, address := range addresses {
+		checksummed[i] = utils.ChecksumAddress(address)
+	}
+	return checksummed
+}
+
+func (s *Server) GetTransactionsHandler(w http.ResponseWriter, r *http.Request) {
+	p := s.parserFor(w, r)
+	if p == nil {
+		return
+	}
 	address := r.URL.Query().Get("address")
 	if address == "" {
 		http.Error(w, "Missing address parameter", http.StatusBadRequest)
 		return
 	}
-	address = strings.ToLower(address)
 
-	myparser := parser.GetParser()
-
-	transactions := myparser.GetTransactions(address)
-	if transactions == nil {
-		http.Error(w, "No transactions found", http.StatusNotFound)
+	// Without paging or filters, respond with every transaction as before
+	paged := isQuery(r.URL.Query())
+	var transactions []parser.Transaction
+	var next string
+	var err error
+	if paged {
+		var q parser.TransactionQuery
+		q, err = parseTransactionQuery(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		transactions, next, err = p.QueryTransactions(r.Context(), address, q)
+	} else {
+		transactions, err = p.GetTransactions(r.Context(), address)
+	}
+	if err != nil {
+		writeParserError(w, err)
 		return
 	}
+	if r.URL.Query().Get("names") == "true" {
+		err = resolveNames(r.Context(), p, transactions)
+		if err != nil {
+			writeParserError(w, err)
+			return
+		}
+	}
+	var response any = legacyTransactions(transactions)
+	if paged {
+		response = legacyTransactionsResponse{Transactions: legacyTransactions(transactions), NextCursor: next}
+	}
 	w.Header().Set("Content-Type", "application/json")
-	err := json.NewEncoder(w).Encode(transactions)
+	err = json.NewEncoder(w).Encode(response)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error encoding response: %v", err), http.StatusInternalServerError)
 	}
 }
+
+// legacyTransaction is a transaction as getTransactions returns it. The gas
+// price is also sent as blobGasPrice, its name before schema version 2, so
+// clients of the original endpoint keep working.
+type legacyTransaction struct {
+	parser.Transaction
+	BlobGasPrice string `json:"blobGasPrice"`
+}
+
+type legacyTransactionsResponse struct {
+	Transactions []legacyTransaction `json:"transactions"`
+	NextCursor   string              `json:"nextCursor,omitempty"`
+}
+
+func legacyTransactions(transactions []parser.Transaction) []legacyTransaction {
+	legacy := make([]legacyTransaction, len(transactions))
+	for i, tx := range transactions {
+		legacy[i] = legacyTransaction{Transaction: tx, BlobGasPrice: tx.GasPrice}
+	}
+	return legacy
+}
+
+// queryParams are the getTransactions parameters that select a paginated
+// response.
+var queryParams = []string{"direction", "fromBlock", "toBlock", "type", "minValue", "status",
+	"counterparty", "sort", "limit", "cursor"}
+
+func isQuery(values url.Values) bool {
+	for _, param := range queryParams {
+		if values.Has(param) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTransactionQuery reads a TransactionQuery from request parameters.
+// Block numbers, types and values are decimal or 0x-prefixed hex.
+func parseTransactionQuery(values url.Values) (parser.TransactionQuery, error) {
+	q := parser.TransactionQuery{
+		Direction:    values.Get("direction"),
+		Status:       values.Get("status"),
+		Counterparty: values.Get("counterparty"),
+		Sort:         values.Get("sort"),
+		Cursor:       values.Get("cursor"),
+	}
+	var err error
+	if q.FromBlock, err = parseBlockParam(values, "fromBlock"); err != nil {
+		return q, err
+	}
+	if q.ToBlock, err = parseBlockParam(values, "toBlock"); err != nil {
+		return q, err
+	}
+	if txtype := values.Get("type"); txtype != "" {
+		number, ok := parseNumber(txtype)
+		if !ok {
+			return q, fmt.Errorf("Invalid type parameter")
+		}
+		q.Type = "0x" + number.Text(16)
+	}
+	if minValue := values.Get("minValue"); minValue != "" {
+		number, ok := parseNumber(minValue)
+		if !ok {
+			return q, fmt.Errorf("Invalid minValue parameter")
+		}
+		q.MinValue = number
+	}
+	if limit := values.Get("limit"); limit != "" {
+		q.Limit, err = strconv.Atoi(limit)
+		if err != nil || q.Limit <= 0 {
+			return q, fmt.Errorf("Invalid limit parameter")
+		}
+	}
+	return q, nil
+}
+
+func parseBlockParam(values url.Values, param string) (int64, error) {
+	value := values.Get(param)
+	if value == "" {
+		return 0, nil
+	}
+	number, ok := parseNumber(value)
+	if !ok || !number.IsInt64() {
+		return 0, fmt.Errorf("Invalid %s parameter", param)
+	}
+	return number.Int64(), nil
+}
+
+// parseNumber parses a non-negative decimal or 0x-prefixed hex number.
+func parseNumber(value string) (*big.Int, bool) {
+	number, ok := new(big.Int), false
+	if hex, found := strings.CutPrefix(value, "0x"); found {
+		number, ok = number.SetString(hex, 16)
+	} else {
+		number, ok = number.SetString(value, 10)
+	}
+	return number, ok && number.Sign() >= 0
+}
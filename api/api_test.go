@@ -13,34 +13,32 @@ import (
 
 	"github.com/EliasManj/tx-parser/parser"
 	"github.com/EliasManj/tx-parser/rpcclient"
+	"github.com/EliasManj/tx-parser/utils"
 	"github.com/stretchr/testify/require"
 )
 
 var (
-	Accounts []interface{}
-	Server   *httptest.Server
-	AnvilUrl string = "http://127.0.0.1:8545"
+	Accounts   []interface{}
+	HTTPServer *httptest.Server
+	AnvilUrl   string = "http://127.0.0.1:8545"
 )
 
 func TestMain(m *testing.M) {
 	var err error
 	url := "http://localhost:8545"
 	ctx := context.Background()
-	parser.Init(ctx, url, nil)
+	p, err := parser.Init(url, nil)
+	if err != nil {
+		log.Fatalf("Error initializing parser: %v", err)
+	}
+	p.Start(ctx)
 	Accounts, err = rpcclient.AnvilGetAccounts(url)
 	if err != nil {
 		log.Fatalf("Error getting accounts: %v", err)
 	}
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("/", HelloHandler)
-	mux.HandleFunc("/getCurrentBlock", GetCurrentBlockHandler)
-	mux.HandleFunc("/subscribe", SubscribeHandler)
-	mux.HandleFunc("/getTransactions", GetTransactionsHandler)
-	mux.HandleFunc("/getSubscriptions", GetSubscriptionsHandler)
-
-	Server = httptest.NewServer(mux)
-	defer Server.Close()
+	HTTPServer = httptest.NewServer(NewServer(p))
+	defer HTTPServer.Close()
 
 	code := m.Run()
 	os.Exit(code)
@@ -66,17 +64,17 @@ func TestAPI(t *testing.T) {
 	acc1 := Accounts[1].(string)
 	acc2 := Accounts[2].(string)
 
-	resp1 := subscribeAddress(t, Server, acc0)
+	resp1 := subscribeAddress(t, HTTPServer, acc0)
 	defer resp1.Body.Close()
 	time.Sleep(5 * time.Second)
-	resp2 := subscribeAddress(t, Server, acc1)
+	resp2 := subscribeAddress(t, HTTPServer, acc1)
 	defer resp2.Body.Close()
 	time.Sleep(5 * time.Second)
 
-	subscriptions := getSubscriptions(t, Server)
+	subscriptions := getSubscriptions(t, HTTPServer)
 	require.Len(t, subscriptions, 2, "Expected 2 subscriptions")
-	require.Contains(t, subscriptions, acc0, "Expected address 1 to be subscribed")
-	require.Contains(t, subscriptions, acc1, "Expected address 2 to be subscribed")
+	require.Contains(t, subscriptions, utils.ChecksumAddress(acc0), "Expected address 1 to be subscribed")
+	require.Contains(t, subscriptions, utils.ChecksumAddress(acc1), "Expected address 2 to be subscribed")
 
 	// do some txs
 	_, err := rpcclient.AnvilSendWei(acc0, acc1, 100, AnvilUrl)
@@ -86,10 +84,25 @@ func TestAPI(t *testing.T) {
 	require.NoError(t, err)
 	time.Sleep(10 * time.Second)
 
-	transactions := getTransactions(t, Server, acc0)
+	transactions := getTransactions(t, HTTPServer, acc0)
 	require.GreaterOrEqual(t, len(transactions), 2, "Expected at least 2 transactions")
 }
 
+func TestMultipleParsers(t *testing.T) {
+	acc0 := Accounts[0].(string)
+
+	server1 := httptest.NewServer(NewServer(parser.NewParser(nil, 0)))
+	defer server1.Close()
+	server2 := httptest.NewServer(NewServer(parser.NewParser(nil, 0)))
+	defer server2.Close()
+
+	resp := subscribeAddress(t, server1, acc0)
+	defer resp.Body.Close()
+
+	require.Len(t, getSubscriptions(t, server1), 1, "Expected 1 subscription")
+	require.Empty(t, getSubscriptions(t, server2), "Expected parsers to be independent")
+}
+
 func subscribeAddress(t *testing.T, server *httptest.Server, address string) *http.Response {
 	req, err := http.NewRequest("GET", server.URL+"/subscribe?address="+address, nil)
 	if err != nil {
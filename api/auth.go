@@ -0,0 +1,287 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/EliasManj/tx-parser/parser"
+)
+
+// APIKeyHeader is the header carrying the API key, which may also be sent
+// as a bearer token in the Authorization header.
+const APIKeyHeader = "X-API-Key"
+
+type keyContext struct{}
+
+// SetAccess requires every request to carry an API key of access, except
+// the root greeting and health probes. Admin keys see every subscription,
+// manage tenants and keys under /v1/admin/ and read /metrics, whose series
+// name subscribed addresses. Other keys only see the subscriptions of their
+// tenant and are rate limited.
+func (s *Server) SetAccess(access *parser.Access) {
+	s.access = access
+	s.limiter = newRateLimiter()
+	s.registerAdmin()
+}
+
+// authenticate checks the API key of a request and its rate limit, and
+// adds the key to the request context. It writes an error response and
+// returns nil when the request is rejected.
+func (s *Server) authenticate(w http.ResponseWriter, r *http.Request) *http.Request {
+	writeError := func(status int, code, message string) {
+		if strings.HasPrefix(r.URL.Path, "/v1/") {
+			writeAPIError(w, status, code, message)
+		} else {
+			http.Error(w, message, status)
+		}
+	}
+
+	secret := r.Header.Get(APIKeyHeader)
+	if bearer, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); found {
+		secret = bearer
+	}
+	if secret == "" {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(http.StatusUnauthorized, CodeUnauthorized, "Missing API key")
+		return nil
+	}
+	key, err := s.access.Authenticate(secret)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(http.StatusUnauthorized, CodeUnauthorized, "Invalid API key")
+		return nil
+	}
+	if (strings.HasPrefix(r.URL.Path, "/v1/admin/") || r.URL.Path == "/metrics") && !key.Admin {
+		writeError(http.StatusForbidden, CodeForbidden, "Admin API key required")
+		return nil
+	}
+	if !key.Admin {
+		tenant, err := s.access.Tenant(key.Tenant)
+		if err != nil {
+			writeError(http.StatusUnauthorized, CodeUnauthorized, "Invalid API key")
+			return nil
+		}
+		if ok, wait := s.limiter.allow(key.ID, tenant.RateLimit, tenant.Burst, time.Now()); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeError(http.StatusTooManyRequests, CodeRateLimited, "Rate limit exceeded")
+			return nil
+		}
+	}
+	return r.WithContext(context.WithValue(r.Context(), keyContext{}, key))
+}
+
+// requestKey returns the API key a request was authenticated with.
+func requestKey(r *http.Request) (parser.APIKey, bool) {
+	key, ok := r.Context().Value(keyContext{}).(parser.APIKey)
+	return key, ok
+}
+
+// rateLimiter keeps a token bucket per API key.
+type rateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{buckets: make(map[string]*tokenBucket)}
+}
+
+// allow takes a token from the bucket of key, which refills at rate tokens
+// per second up to burst. A rate of 0 is unlimited. When the bucket is
+// empty it returns how long until the next token.
+func (l *rateLimiter) allow(key string, rate float64, burst int, now time.Time) (bool, time.Duration) {
+	if rate <= 0 {
+		return true, 0
+	}
+	capacity := float64(burst)
+	if burst <= 0 {
+		capacity = max(1, math.Ceil(rate))
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	bucket, exists := l.buckets[key]
+	if !exists {
+		bucket = &tokenBucket{tokens: capacity, last: now}
+		l.buckets[key] = bucket
+	}
+	bucket.tokens = min(capacity, bucket.tokens+now.Sub(bucket.last).Seconds()*rate)
+	bucket.last = now
+	if bucket.tokens < 1 {
+		return false, time.Duration((1 - bucket.tokens) / rate * float64(time.Second))
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+// tenantParser scopes a parser to the subscriptions of a tenant. Other
+// addresses look unsubscribed. The parser tracks an address as long as a
+// tenant holds it, so tenants can subscribe the same address. For admin
+// keys, tenant is empty and unsubscribing also drops the address from
+// every tenant. A tenant subscription is saved before the parser tracks the
+// address, and addresses left untracked by a crash are subscribed again on
+// startup.
+type tenantParser struct {
+	Parser
+	access *parser.Access
+	tenant string
+
+	// Serializes changes to the subscriptions of the parser and tenants
+	mu *sync.Mutex
+}
+
+// scoped reports whether p only sees the subscriptions of a tenant.
+func scoped(p Parser) (*tenantParser, bool) {
+	tp, ok := p.(*tenantParser)
+	return tp, ok && tp.tenant != ""
+}
+
+// owns reports whether the tenant subscribed address.
+func (p *tenantParser) owns(address string) bool {
+	return p.access.Owns(p.tenant, p.ChainID(), strings.ToLower(address))
+}
+
+// owned resolves address and checks the tenant subscribed it.
+func (p *tenantParser) owned(ctx context.Context, address string) (string, error) {
+	resolved, err := p.ResolveAddress(ctx, address)
+	if err != nil {
+		return "", err
+	}
+	if p.tenant != "" && !p.owns(resolved) {
+		return "", parser.ErrNotSubscribed
+	}
+	return resolved, nil
+}
+
+func (p *tenantParser) Subscribe(ctx context.Context, address string) error {
+	if p.tenant == "" {
+		return p.Parser.Subscribe(ctx, address)
+	}
+	resolved, err := p.ResolveAddress(ctx, address)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.access.Subscribe(p.tenant, p.ChainID(), resolved); err != nil {
+		return err
+	}
+	err = p.Parser.Subscribe(ctx, resolved)
+	if err != nil && !errors.Is(err, parser.ErrAlreadySubscribed) {
+		p.access.Unsubscribe(p.tenant, p.ChainID(), resolved)
+		return err
+	}
+	return nil
+}
+
+func (p *tenantParser) Unsubscribe(ctx context.Context, address string) error {
+	resolved, err := p.ResolveAddress(ctx, address)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.tenant == "" {
+		if err := p.Parser.Unsubscribe(ctx, resolved); err != nil {
+			return err
+		}
+		return p.access.Release(p.ChainID(), resolved)
+	}
+	orphaned, err := p.access.Unsubscribe(p.tenant, p.ChainID(), resolved)
+	if err != nil || !orphaned {
+		return err
+	}
+	err = p.Parser.Unsubscribe(ctx, resolved)
+	if errors.Is(err, parser.ErrNotSubscribed) {
+		return nil
+	}
+	return err
+}
+
+func (p *tenantParser) GetSubscriptions(ctx context.Context) ([]string, error) {
+	if p.tenant == "" {
+		return p.Parser.GetSubscriptions(ctx)
+	}
+	subscriptions := p.access.Subscriptions(p.tenant, p.ChainID())
+	if subscriptions == nil {
+		subscriptions = []string{}
+	}
+	return subscriptions, nil
+}
+
+func (p *tenantParser) GetTransactions(ctx context.Context, address string) ([]parser.Transaction, error) {
+	resolved, err := p.owned(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	return p.Parser.GetTransactions(ctx, resolved)
+}
+
+func (p *tenantParser) QueryTransactions(ctx context.Context, address string, q parser.TransactionQuery) ([]parser.Transaction, string, error) {
+	resolved, err := p.owned(ctx, address)
+	if err != nil {
+		return nil, "", err
+	}
+	return p.Parser.QueryTransactions(ctx, resolved, q)
+}
+
+func (p *tenantParser) SetWebhook(ctx context.Context, address string, webhook parser.Webhook) (parser.Webhook, error) {
+	resolved, err := p.owned(ctx, address)
+	if err != nil {
+		return parser.Webhook{}, err
+	}
+	return p.Parser.SetWebhook(ctx, resolved, webhook)
+}
+
+func (p *tenantParser) GetWebhook(ctx context.Context, address string) (parser.Webhook, error) {
+	resolved, err := p.owned(ctx, address)
+	if err != nil {
+		return parser.Webhook{}, err
+	}
+	return p.Parser.GetWebhook(ctx, resolved)
+}
+
+func (p *tenantParser) RemoveWebhook(ctx context.Context, address string) error {
+	resolved, err := p.owned(ctx, address)
+	if err != nil {
+		return err
+	}
+	return p.Parser.RemoveWebhook(ctx, resolved)
+}
+
+// visibleDeliveries returns the deliveries of the addresses p sees.
+func visibleDeliveries(p Parser, deliveries []parser.WebhookDelivery) []parser.WebhookDelivery {
+	tp, ok := scoped(p)
+	if !ok {
+		return deliveries
+	}
+	visible := []parser.WebhookDelivery{}
+	for _, delivery := range deliveries {
+		if tp.owns(delivery.Address) {
+			visible = append(visible, delivery)
+		}
+	}
+	return visible
+}
+
+// visibleDelivery checks p sees the failed delivery with id.
+func visibleDelivery(p Parser, id string) error {
+	for _, delivery := range visibleDeliveries(p, p.Webhooks().Failed()) {
+		if delivery.ID == id {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", parser.ErrDeliveryNotFound, id)
+}
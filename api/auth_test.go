@@ -0,0 +1,158 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/EliasManj/tx-parser/parser"
+	"github.com/stretchr/testify/require"
+)
+
+// authRequest sends a request with an API key and decodes the response
+// into response, if not nil.
+func authRequest(t *testing.T, server *httptest.Server, key, method, path, body string, response any) *http.Response {
+	req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	require.NoError(t, err)
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	if response != nil {
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(response))
+	}
+	return resp
+}
+
+func TestAuth(t *testing.T) {
+	p := parser.NewParser(nil, 0)
+	access, err := parser.OpenAccess(parser.NewMemoryStorage(parser.RetentionPolicy{}))
+	require.NoError(t, err)
+	admin, _, err := access.CreateKey("", true)
+	require.NoError(t, err)
+	handler := NewServer(p)
+	handler.SetAccess(access)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	alice := "0x0000000000000000000000000000000000000001"
+	bob := "0x0000000000000000000000000000000000000002"
+
+	var apiError ErrorResponse
+	resp := authRequest(t, server, "", http.MethodGet, "/v1/subscriptions", "", &apiError)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	require.Equal(t, CodeUnauthorized, apiError.Error.Code)
+	require.Equal(t, http.StatusUnauthorized, authRequest(t, server, "txp_1_2", http.MethodGet, "/getSubscriptions", "", nil).StatusCode)
+	require.Equal(t, http.StatusOK, authRequest(t, server, "", http.MethodGet, "/", "", nil).StatusCode)
+
+	// Admins create tenants and their keys
+	var tenant parser.Tenant
+	resp = authRequest(t, server, admin, http.MethodPost, "/v1/admin/tenants", `{"id":"acme","maxSubscriptions":1}`, &tenant)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	require.Equal(t, 1, tenant.MaxSubscriptions)
+	resp = authRequest(t, server, admin, http.MethodPost, "/v1/admin/tenants", `{"id":"globex"}`, nil)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	resp = authRequest(t, server, admin, http.MethodPost, "/v1/admin/tenants", `{"id":"acme"}`, &apiError)
+	require.Equal(t, http.StatusConflict, resp.StatusCode)
+	require.Equal(t, CodeTenantExists, apiError.Error.Code)
+	var acme, globex KeyResponse
+	resp = authRequest(t, server, admin, http.MethodPost, "/v1/admin/keys", `{"tenant":"acme"}`, &acme)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	require.NotEmpty(t, acme.Key)
+	authRequest(t, server, admin, http.MethodPost, "/v1/admin/keys", `{"tenant":"globex"}`, &globex)
+	var keys KeysResponse
+	authRequest(t, server, admin, http.MethodGet, "/v1/admin/keys", "", &keys)
+	require.Len(t, keys.Keys, 3)
+	require.Empty(t, keys.Keys[1].Key)
+	resp = authRequest(t, server, acme.Key, http.MethodGet, "/v1/admin/tenants", "", &apiError)
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+	require.Equal(t, CodeForbidden, apiError.Error.Code)
+
+	// Tenants subscribe within their quota and only see their own
+	// subscriptions, while the parser tracks every address once
+	resp = authRequest(t, server, acme.Key, http.MethodPost, "/v1/subscriptions", `{"address":"`+alice+`"}`, nil)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	resp = authRequest(t, server, acme.Key, http.MethodPost, "/v1/subscriptions", `{"address":"`+bob+`"}`, &apiError)
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+	require.Equal(t, CodeQuotaExceeded, apiError.Error.Code)
+	resp = authRequest(t, server, globex.Key, http.MethodPost, "/v1/subscriptions", `{"address":"`+alice+`"}`, nil)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	resp = authRequest(t, server, globex.Key, http.MethodPost, "/v1/subscriptions", `{"address":"`+bob+`"}`, nil)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	var subscriptions SubscriptionsResponse
+	authRequest(t, server, acme.Key, http.MethodGet, "/v1/subscriptions", "", &subscriptions)
+	require.Equal(t, []string{alice}, subscriptions.Subscriptions)
+	var legacy []string
+	authRequest(t, server, acme.Key, http.MethodGet, "/getSubscriptions", "", &legacy)
+	require.Equal(t, []string{alice}, legacy)
+	authRequest(t, server, admin, http.MethodGet, "/v1/subscriptions", "", &subscriptions)
+	require.Equal(t, []string{alice, bob}, subscriptions.Subscriptions)
+	resp = authRequest(t, server, acme.Key, http.MethodGet, "/v1/subscriptions/"+bob+"/transactions", "", &apiError)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	require.Equal(t, CodeNotSubscribed, apiError.Error.Code)
+	resp = authRequest(t, server, acme.Key, http.MethodGet, "/getTransactions?address="+bob, "", nil)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp = authRequest(t, server, acme.Key, http.MethodGet, "/v1/subscriptions/"+alice+"/transactions", "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// The parser stops tracking an address once no tenant holds it
+	resp = authRequest(t, server, acme.Key, http.MethodDelete, "/v1/subscriptions/"+alice, "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	tracked, err := p.GetSubscriptions(context.Background())
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{alice, bob}, tracked)
+	resp = authRequest(t, server, acme.Key, http.MethodDelete, "/v1/subscriptions/"+bob, "", nil)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp = authRequest(t, server, globex.Key, http.MethodDelete, "/v1/subscriptions/"+alice, "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	tracked, err = p.GetSubscriptions(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []string{bob}, tracked)
+
+	// Deleting a tenant revokes its keys and drops its subscriptions
+	resp = authRequest(t, server, admin, http.MethodDelete, "/v1/admin/tenants/globex", "", nil)
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+	tracked, err = p.GetSubscriptions(context.Background())
+	require.NoError(t, err)
+	require.Empty(t, tracked)
+	resp = authRequest(t, server, globex.Key, http.MethodGet, "/v1/subscriptions", "", nil)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// Keys of rate limited tenants are throttled
+	resp = authRequest(t, server, admin, http.MethodPut, "/v1/admin/tenants/acme", `{"rateLimit":0.5,"burst":1}`, &tenant)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, http.StatusOK, authRequest(t, server, acme.Key, http.MethodGet, "/v1/block", "", nil).StatusCode)
+	resp = authRequest(t, server, acme.Key, http.MethodGet, "/v1/block", "", &apiError)
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	require.Equal(t, CodeRateLimited, apiError.Error.Code)
+	require.Equal(t, "2", resp.Header.Get("Retry-After"))
+	resp = authRequest(t, server, admin, http.MethodDelete, "/v1/admin/keys/"+acme.ID, "", nil)
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+	require.Equal(t, http.StatusUnauthorized, authRequest(t, server, acme.Key, http.MethodGet, "/v1/block", "", nil).StatusCode)
+}
+
+func TestRateLimiter(t *testing.T) {
+	limiter := newRateLimiter()
+	now := time.Unix(0, 0)
+	for i := 0; i < 3; i++ {
+		ok, _ := limiter.allow("key", 2, 3, now)
+		require.True(t, ok)
+	}
+	ok, wait := limiter.allow("key", 2, 3, now)
+	require.False(t, ok)
+	require.Equal(t, 500*time.Millisecond, wait)
+	ok, _ = limiter.allow("key", 2, 3, now.Add(wait))
+	require.True(t, ok)
+
+	// Buckets are per key, and a rate of 0 is unlimited
+	ok, _ = limiter.allow("other", 2, 3, now)
+	require.True(t, ok)
+	ok, _ = limiter.allow("key", 0, 0, now)
+	require.True(t, ok)
+}
@@ -0,0 +1,378 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/EliasManj/tx-parser/parser"
+)
+
+const (
+	// DefaultHeartbeat is how often an idle event stream sends a comment to
+	// keep proxies from closing the connection.
+	DefaultHeartbeat = 15 * time.Second
+
+	// eventBuffer is how many events a stream buffers for a slow client
+	// before dropping it.
+	eventBuffer = 256
+
+	// eventRetry is the reconnection delay suggested to clients, in
+	// milliseconds.
+	eventRetry = 3000
+)
+
+// eventAddresses returns the resolved addresses of the address parameters,
+// which may be repeated or comma separated, checking they are subscribed.
+// Without parameters, tenants receive the events of their subscriptions.
+func eventAddresses(r *http.Request, p Parser) ([]string, error) {
+	subscriptions, err := p.GetSubscriptions(r.Context())
+	if err != nil {
+		return nil, err
+	}
+	var addresses []string
+	for _, param := range r.URL.Query()["address"] {
+		for _, address := range strings.Split(param, ",") {
+			resolved, err := p.ResolveAddress(r.Context(), strings.TrimSpace(address))
+			if err != nil {
+				return nil, err
+			}
+			if !slices.Contains(subscriptions, resolved) {
+				return nil, fmt.Errorf("%w: %s", parser.ErrNotSubscribed, resolved)
+			}
+			addresses = append(addresses, resolved)
+		}
+	}
+	if _, ok := scoped(p); ok && len(addresses) == 0 {
+		if len(subscriptions) == 0 {
+			return nil, fmt.Errorf("%w: no subscriptions to stream", parser.ErrNotSubscribed)
+		}
+		addresses = subscriptions
+	}
+	return addresses, nil
+}
+
+// v1Events streams the transactions recorded for the address parameters,
+// or for every subscription, as Server-Sent Events. Each event carries its
+// sequence number as id, so a client reconnecting with Last-Event-ID (or
+// the lastEventId parameter) receives the events it missed.
+func (s *Server) v1Events(w http.ResponseWriter, r *http.Request) {
+	p := s.v1Parser(w, r)
+	if p == nil {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeAPIError(w, http.StatusInternalServerError, CodeInternal, "Streaming is not supported")
+		return
+	}
+	addresses, err := eventAddresses(r, p)
+	if err != nil {
+		writeV1Error(w, err)
+		return
+	}
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("lastEventId")
+	}
+	var last uint64
+	if lastEventID != "" {
+		last, err = strconv.ParseUint(lastEventID, 10, 64)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, CodeInvalidRequest, "Invalid Last-Event-ID")
+			return
+		}
+	}
+
+	// Listen before reading the backlog so no event falls in between
+	stream := p.Stream()
+	listener := stream.Listen(addresses, eventBuffer)
+	defer listener.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", eventRetry)
+
+	if lastEventID != "" {
+		backlog, complete := stream.Since(addresses, last)
+		if !complete {
+			// Events were pruned from the stream, the client has to
+			// refetch the transactions it missed
+			fmt.Fprintf(w, "event: reset\ndata: {\"lastEventId\":%d}\n\n", last)
+		}
+		for _, event := range backlog {
+			if err := writeEvent(w, event); err != nil {
+				return
+			}
+			last = event.Seq
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(s.heartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case event, ok := <-listener.C:
+			if !ok {
+				if listener.Overflowed() {
+					// Too slow to keep up, the client resumes from the
+					// last event it received
+					fmt.Fprint(w, "event: overflow\ndata: {}\n\n")
+					flusher.Flush()
+				}
+				return
+			}
+			if event.Seq <= last {
+				continue
+			}
+			if err := writeEvent(w, event); err != nil {
+				return
+			}
+			last = event.Seq
+			flusher.Flush()
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event parser.TxEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Seq, event.Type, data)
+	return err
+}
+
+// wsRequest is a message from a WebSocket client.
+type wsRequest struct {
+	// subscribe or unsubscribe
+	Type      string   `json:"type"`
+	Addresses []string `json:"addresses"`
+
+	// For subscribe, replay the retained events after this sequence number
+	After *uint64 `json:"after,omitempty"`
+}
+
+// wsResponse acknowledges a wsRequest or reports an error.
+type wsResponse struct {
+	// subscribed, unsubscribed, reset or error
+	Type      string       `json:"type"`
+	Addresses []string     `json:"addresses,omitempty"`
+	Error     *ErrorDetail `json:"error,omitempty"`
+}
+
+// wsSession is the state of a WebSocket client.
+type wsSession struct {
+	conn     *wsConn
+	parser   Parser
+	stream   *parser.TxStream
+	listener *parser.TxListener
+
+	// Addresses the client listens to, with the sequence number up to
+	// which their events were replayed
+	mu        sync.Mutex
+	addresses map[string]uint64
+}
+
+// v1WebSocket pushes the events of the addresses a client subscribes to
+// over a WebSocket. Subscribing also makes the parser track an address.
+func (s *Server) v1WebSocket(w http.ResponseWriter, r *http.Request) {
+	p := s.v1Parser(w, r)
+	if p == nil {
+		return
+	}
+	conn, err := upgradeWebSocket(w, r)
+	if err != nil {
+		return
+	}
+	conn.readTimeout = 3 * s.heartbeat
+	// The listener gets the addresses the client subscribes to
+	listener := p.Stream().Listen([]string{}, eventBuffer)
+	defer listener.Close()
+	session := &wsSession{conn: conn, parser: p, stream: p.Stream(), listener: listener, addresses: make(map[string]uint64)}
+	done := make(chan error, 1)
+	go func() {
+		done <- session.readRequests(r.Context())
+	}()
+
+	heartbeat := time.NewTicker(s.heartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case err := <-done:
+			conn.closeWithError(err)
+			return
+		case <-heartbeat.C:
+			if err := conn.Ping(); err != nil {
+				conn.Close(wsCloseGoingAway, "")
+				return
+			}
+		case event, ok := <-listener.C:
+			if !ok {
+				if listener.Overflowed() {
+					conn.Close(wsClosePolicyViolation, "Too slow, reconnect and resume")
+				} else {
+					conn.Close(wsCloseGoingAway, "")
+				}
+				return
+			}
+			if err := session.send(event); err != nil {
+				conn.Close(wsCloseGoingAway, "")
+				return
+			}
+		}
+	}
+}
+
+// send writes event if the client listens to its address and has not
+// received it in a replay.
+func (s *wsSession) send(event parser.TxEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	replayed, exists := s.addresses[event.Address]
+	if !exists || event.Seq <= replayed {
+		return nil
+	}
+	return s.write(event)
+}
+
+func (s *wsSession) write(message any) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	return s.conn.WriteText(data)
+}
+
+func (s *wsSession) writeError(code, message string) error {
+	return s.write(wsResponse{Type: "error", Error: &ErrorDetail{Code: code, Message: message}})
+}
+
+// readRequests handles the client's requests until the connection fails.
+func (s *wsSession) readRequests(ctx context.Context) error {
+	for {
+		opcode, message, err := s.conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		if opcode != wsText {
+			return &wsError{wsCloseUnsupportedData, "only text messages are supported"}
+		}
+		var request wsRequest
+		decoder := json.NewDecoder(bytes.NewReader(message))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&request); err != nil {
+			err = s.writeError(CodeInvalidRequest, fmt.Sprintf("Invalid message: %v", err))
+		} else if len(request.Addresses) == 0 {
+			err = s.writeError(CodeInvalidRequest, "Missing addresses")
+		} else {
+			switch request.Type {
+			case "subscribe":
+				err = s.subscribe(ctx, request)
+			case "unsubscribe":
+				err = s.unsubscribe(ctx, request)
+			default:
+				err = s.writeError(CodeInvalidRequest, fmt.Sprintf("Unknown message type %q", request.Type))
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// resolve returns the resolved addresses of a request.
+func (s *wsSession) resolve(ctx context.Context, request wsRequest) ([]string, error) {
+	var addresses []string
+	for _, address := range request.Addresses {
+		resolved, err := s.parser.ResolveAddress(ctx, address)
+		if err != nil {
+			return nil, err
+		}
+		addresses = append(addresses, resolved)
+	}
+	return addresses, nil
+}
+
+func (s *wsSession) subscribe(ctx context.Context, request wsRequest) error {
+	addresses, err := s.resolve(ctx, request)
+	for i := 0; err == nil && i < len(addresses); i++ {
+		err = s.parser.Subscribe(ctx, addresses[i])
+		if errors.Is(err, parser.ErrAlreadySubscribed) {
+			err = nil
+		}
+	}
+	if err != nil {
+		return s.writeV1Error(err)
+	}
+
+	// Hold the lock while replaying, so live events of these addresses
+	// are sent after the replayed ones. Listen before reading the backlog
+	// so no event falls in between.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.listener.Add(addresses...)
+	if err := s.write(wsResponse{Type: "subscribed", Addresses: addresses}); err != nil {
+		return err
+	}
+	var replayed uint64
+	if request.After != nil {
+		backlog, complete := s.stream.Since(addresses, *request.After)
+		if !complete {
+			if err := s.write(wsResponse{Type: "reset"}); err != nil {
+				return err
+			}
+		}
+		for _, event := range backlog {
+			if err := s.write(event); err != nil {
+				return err
+			}
+			replayed = event.Seq
+		}
+	}
+	for _, address := range addresses {
+		s.addresses[address] = max(s.addresses[address], replayed)
+	}
+	return nil
+}
+
+func (s *wsSession) unsubscribe(ctx context.Context, request wsRequest) error {
+	addresses, err := s.resolve(ctx, request)
+	if err != nil {
+		return s.writeV1Error(err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listener.Remove(addresses...)
+	for _, address := range addresses {
+		delete(s.addresses, address)
+	}
+	return s.write(wsResponse{Type: "unsubscribed", Addresses: addresses})
+}
+
+// writeV1Error reports a parser error with the codes of the v1 API.
+func (s *wsSession) writeV1Error(err error) error {
+	_, detail := v1Error(err)
+	return s.write(wsResponse{Type: "error", Error: &detail})
+}
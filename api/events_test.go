@@ -0,0 +1,83 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/EliasManj/tx-parser/parser"
+	"github.com/stretchr/testify/require"
+)
+
+// readEvent reads the next event of an SSE stream as its field lines.
+func readEvent(t *testing.T, reader *bufio.Reader) []string {
+	var lines []string
+	for {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return lines
+		}
+		lines = append(lines, line)
+	}
+}
+
+func TestV1Events(t *testing.T) {
+	p := parser.NewParser(nil, 0)
+	ctx := context.Background()
+	alice := "0x0000000000000000000000000000000000000001"
+	bob := "0x0000000000000000000000000000000000000002"
+	require.NoError(t, p.Subscribe(ctx, alice))
+	require.NoError(t, p.Subscribe(ctx, bob))
+	s := NewServer(p)
+	s.heartbeat = 50 * time.Millisecond
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	stream := p.Stream()
+	require.NoError(t, stream.Publish(
+		parser.TxEvent{Type: parser.TxDetected, Address: alice, Transaction: parser.Transaction{Txhash: "0x1"}},
+		parser.TxEvent{Type: parser.TxDetected, Address: bob, Transaction: parser.Transaction{Txhash: "0x2"}},
+	))
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/v1/events?address="+alice, nil)
+	require.NoError(t, err)
+	req.Header.Set("Last-Event-ID", "0")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	reader := bufio.NewReader(resp.Body)
+
+	require.Equal(t, []string{"retry: 3000"}, readEvent(t, reader))
+	event := readEvent(t, reader)
+	require.Equal(t, "id: 1", event[0])
+	require.Equal(t, "event: transaction", event[1])
+	require.Contains(t, event[2], `"txhash":"0x1"`)
+
+	// Live events of other addresses are filtered out
+	require.NoError(t, stream.Publish(
+		parser.TxEvent{Type: parser.TxDetected, Address: alice, Transaction: parser.Transaction{Txhash: "0x3"}},
+		parser.TxEvent{Type: parser.TxDetected, Address: bob, Transaction: parser.Transaction{Txhash: "0x4"}},
+	))
+	event = readEvent(t, reader)
+	for event[0] == ": heartbeat" {
+		event = readEvent(t, reader)
+	}
+	require.Equal(t, "id: 3", event[0])
+	require.Equal(t, []string{": heartbeat"}, readEvent(t, reader))
+
+	// Unsubscribed addresses are rejected
+	var response ErrorResponse
+	resp = v1Request(t, server, http.MethodGet, "/v1/events?address=0x0000000000000000000000000000000000000003", "", &response)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	require.Equal(t, CodeNotSubscribed, response.Error.Code)
+	resp = v1Request(t, server, http.MethodGet, "/v1/events?lastEventId=x", "", &response)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/EliasManj/tx-parser/parser"
+)
+
+// HealthResponse reports the health of every parser. Status is "ok" or
+// "fail".
+type HealthResponse struct {
+	Status string          `json:"status"`
+	Chains []parser.Health `json:"chains"`
+}
+
+// HealthHandler responds 200 while every parser loop is running, and 503
+// once one stalled.
+func (s *Server) HealthHandler(w http.ResponseWriter, r *http.Request) {
+	s.writeHealth(w, func(h parser.Health) bool { return h.Live })
+}
+
+// ReadyHandler responds 200 while every parser reaches its RPC endpoint,
+// writes to storage and keeps up with the chain head, and 503 otherwise.
+func (s *Server) ReadyHandler(w http.ResponseWriter, r *http.Request) {
+	s.writeHealth(w, func(h parser.Health) bool { return h.Ready })
+}
+
+func (s *Server) writeHealth(w http.ResponseWriter, ok func(parser.Health) bool) {
+	response := HealthResponse{Status: "ok", Chains: []parser.Health{}}
+	for _, p := range s.parsers {
+		health := p.Health()
+		if !ok(health) {
+			response.Status = "fail"
+		}
+		response.Chains = append(response.Chains, health)
+	}
+	sort.Slice(response.Chains, func(i, j int) bool { return response.Chains[i].ChainID < response.Chains[j].ChainID })
+
+	status := http.StatusOK
+	if response.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, status, response)
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/EliasManj/tx-parser/parser"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthEndpoints(t *testing.T) {
+	rpc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x5"}`))
+	}))
+	defer rpc.Close()
+	p := parser.NewParser(nil, 0)
+	access, err := parser.OpenAccess(parser.NewMemoryStorage(parser.RetentionPolicy{}))
+	require.NoError(t, err)
+	handler := NewServer(p)
+	handler.SetAccess(access)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	// Probes need no API key
+	var health HealthResponse
+	resp := authRequest(t, server, "", http.MethodGet, "/healthz", "", &health)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "ok", health.Status)
+	require.Len(t, health.Chains, 1)
+	resp = authRequest(t, server, "", http.MethodGet, "/readyz", "", &health)
+	require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	require.Equal(t, "fail", health.Status)
+	require.Equal(t, []string{"the RPC endpoint was not polled yet"}, health.Chains[0].Problems)
+
+	_, err = p.PollLatestBlock(rpc.URL)
+	require.NoError(t, err)
+	resp = authRequest(t, server, "", http.MethodGet, "/readyz", "", &health)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, int64(5), health.Chains[0].Head)
+	require.Equal(t, int64(5), health.Chains[0].Lag)
+
+	p.SetLagThreshold(4)
+	resp = authRequest(t, server, "", http.MethodGet, "/readyz", "", &health)
+	require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	require.False(t, health.Chains[0].Ready)
+}
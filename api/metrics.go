@@ -0,0 +1,21 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/EliasManj/tx-parser/metrics"
+)
+
+// MetricsHandler serves the metrics of the RPC client, parsers, storage and
+// event sinks in the Prometheus text format.
+func (s *Server) MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	for _, p := range s.parsers {
+		p.CollectMetrics()
+	}
+	w.Header().Set("Content-Type", metrics.ContentType)
+	w.Header().Set("Cache-Control", "no-store")
+	if err := metrics.Default.WriteText(w); err != nil {
+		fmt.Printf("Error writing metrics: %v\n", err)
+	}
+}
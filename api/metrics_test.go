@@ -0,0 +1,45 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/EliasManj/tx-parser/metrics"
+	"github.com/EliasManj/tx-parser/parser"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricsEndpoint(t *testing.T) {
+	p := parser.NewParser(nil, 0)
+	access, err := parser.OpenAccess(parser.NewMemoryStorage(parser.RetentionPolicy{}))
+	require.NoError(t, err)
+	admin, _, err := access.CreateKey("", true)
+	require.NoError(t, err)
+	_, err = access.CreateTenant(parser.Tenant{ID: "acme"})
+	require.NoError(t, err)
+	tenant, _, err := access.CreateKey("acme", false)
+	require.NoError(t, err)
+	handler := NewServer(p)
+	handler.SetAccess(access)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	// Series name subscribed addresses, so only admins may scrape
+	require.Equal(t, http.StatusUnauthorized, authRequest(t, server, "", http.MethodGet, "/metrics", "", nil).StatusCode)
+	require.Equal(t, http.StatusForbidden, authRequest(t, server, tenant, http.MethodGet, "/metrics", "", nil).StatusCode)
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/metrics", nil)
+	require.NoError(t, err)
+	req.Header.Set(APIKeyHeader, admin)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, metrics.ContentType, resp.Header.Get("Content-Type"))
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Contains(t, string(body), "# TYPE txparser_head_lag_blocks gauge\n")
+	require.Contains(t, string(body), `txparser_current_block{chain="0"} 0`)
+}
@@ -0,0 +1,356 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/EliasManj/tx-parser/parser"
+	"github.com/EliasManj/tx-parser/utils"
+)
+
+// Error codes of the v1 API. They are part of the API and do not change.
+const (
+	CodeNotFound          = "not_found"
+	CodeMethodNotAllowed  = "method_not_allowed"
+	CodeInvalidRequest    = "invalid_request"
+	CodeInvalidChainID    = "invalid_chain_id"
+	CodeUnknownChain      = "unknown_chain"
+	CodeInvalidAddress    = "invalid_address"
+	CodeInvalidQuery      = "invalid_query"
+	CodeInvalidCursor     = "invalid_cursor"
+	CodeAlreadySubscribed = "already_subscribed"
+	CodeNotSubscribed     = "not_subscribed"
+	CodeInvalidWebhook    = "invalid_webhook"
+	CodeWebhookNotFound   = "webhook_not_found"
+	CodeDeliveryNotFound  = "delivery_not_found"
+	CodeUnauthorized      = "unauthorized"
+	CodeForbidden         = "forbidden"
+	CodeRateLimited       = "rate_limited"
+	CodeQuotaExceeded     = "quota_exceeded"
+	CodeInvalidTenant     = "invalid_tenant"
+	CodeTenantNotFound    = "tenant_not_found"
+	CodeTenantExists      = "tenant_exists"
+	CodeKeyNotFound       = "key_not_found"
+	CodeInternal          = "internal_error"
+)
+
+// maxBodySize limits the size of v1 request bodies.
+const maxBodySize = 1 << 20
+
+// ErrorResponse is the body of every v1 error response.
+type ErrorResponse struct {
+	Error ErrorDetail `json:"error"`
+}
+
+type ErrorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type SubscriptionResponse struct {
+	Address string `json:"address"`
+
+	// Name the address was resolved from, if it was subscribed by name
+	Name string `json:"name,omitempty"`
+}
+
+type SubscriptionsResponse struct {
+	Subscriptions []string `json:"subscriptions"`
+}
+
+type CurrentBlockResponse struct {
+	CurrentBlock int64 `json:"currentBlock"`
+}
+
+type ChainsResponse struct {
+	Chains []int64 `json:"chains"`
+}
+
+type TransactionsResponse struct {
+	Transactions []parser.Transaction `json:"transactions"`
+	NextCursor   string               `json:"nextCursor,omitempty"`
+}
+
+// registerV1 adds the v1 routes. Each route is available for the default
+// chain and under /v1/chains/{id}/.
+func (s *Server) registerV1() {
+	s.mux.HandleFunc("/v1/", func(w http.ResponseWriter, r *http.Request) {
+		writeAPIError(w, http.StatusNotFound, CodeNotFound, "Unknown endpoint")
+	})
+	s.mux.HandleFunc("/v1/chains", methods(map[string]http.HandlerFunc{
+		http.MethodGet: s.v1Chains,
+	}))
+	for _, prefix := range []string{"/v1", "/v1/chains/{id}"} {
+		s.mux.HandleFunc(prefix+"/block", methods(map[string]http.HandlerFunc{
+			http.MethodGet: s.v1CurrentBlock,
+		}))
+		s.mux.HandleFunc(prefix+"/subscriptions", methods(map[string]http.HandlerFunc{
+			http.MethodGet:  s.v1Subscriptions,
+			http.MethodPost: s.v1Subscribe,
+		}))
+		s.mux.HandleFunc(prefix+"/subscriptions/{address}", methods(map[string]http.HandlerFunc{
+			http.MethodDelete: s.v1Unsubscribe,
+		}))
+		s.mux.HandleFunc(prefix+"/subscriptions/{address}/transactions", methods(map[string]http.HandlerFunc{
+			http.MethodGet: s.v1Transactions,
+		}))
+		s.mux.HandleFunc(prefix+"/subscriptions/{address}/webhook", methods(map[string]http.HandlerFunc{
+			http.MethodGet:    s.v1GetWebhook,
+			http.MethodPut:    s.v1SetWebhook,
+			http.MethodDelete: s.v1RemoveWebhook,
+		}))
+		s.mux.HandleFunc(prefix+"/webhooks/pending", methods(map[string]http.HandlerFunc{
+			http.MethodGet: s.v1PendingDeliveries,
+		}))
+		s.mux.HandleFunc(prefix+"/webhooks/failed", methods(map[string]http.HandlerFunc{
+			http.MethodGet: s.v1FailedDeliveries,
+		}))
+		s.mux.HandleFunc(prefix+"/webhooks/failed/{delivery}", methods(map[string]http.HandlerFunc{
+			http.MethodDelete: s.v1DiscardDelivery,
+		}))
+		s.mux.HandleFunc(prefix+"/webhooks/failed/{delivery}/replay", methods(map[string]http.HandlerFunc{
+			http.MethodPost: s.v1ReplayDelivery,
+		}))
+		s.mux.HandleFunc(prefix+"/events", methods(map[string]http.HandlerFunc{
+			http.MethodGet: s.v1Events,
+		}))
+		s.mux.HandleFunc(prefix+"/ws", methods(map[string]http.HandlerFunc{
+			http.MethodGet: s.v1WebSocket,
+		}))
+	}
+}
+
+// methods dispatches requests by method, answering others with 405.
+func methods(handlers map[string]http.HandlerFunc) http.HandlerFunc {
+	allowed := make([]string, 0, len(handlers))
+	for method := range handlers {
+		allowed = append(allowed, method)
+	}
+	sort.Strings(allowed)
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler, exists := handlers[r.Method]
+		if !exists {
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			writeAPIError(w, http.StatusMethodNotAllowed, CodeMethodNotAllowed,
+				fmt.Sprintf("Method %s not allowed, use %s", r.Method, strings.Join(allowed, " or ")))
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, response any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, ErrorResponse{Error: ErrorDetail{Code: code, Message: message}})
+}
+
+// writeV1Error maps parser and request errors to v1 error responses.
+func writeV1Error(w http.ResponseWriter, err error) {
+	status, detail := v1Error(err)
+	writeAPIError(w, status, detail.Code, detail.Message)
+}
+
+// v1Error returns the status and error detail reporting err.
+func v1Error(err error) (int, ErrorDetail) {
+	switch {
+	case errors.Is(err, errInvalidChainID):
+		return http.StatusBadRequest, ErrorDetail{CodeInvalidChainID, err.Error()}
+	case errors.Is(err, errUnknownChain), errors.Is(err, errNoChains):
+		return http.StatusNotFound, ErrorDetail{CodeUnknownChain, err.Error()}
+	case errors.Is(err, parser.ErrInvalidAddress):
+		return http.StatusBadRequest, ErrorDetail{CodeInvalidAddress, err.Error()}
+	case errors.Is(err, parser.ErrInvalidQuery):
+		return http.StatusBadRequest, ErrorDetail{CodeInvalidQuery, err.Error()}
+	case errors.Is(err, parser.ErrInvalidCursor):
+		return http.StatusBadRequest, ErrorDetail{CodeInvalidCursor, err.Error()}
+	case errors.Is(err, parser.ErrAlreadySubscribed):
+		return http.StatusConflict, ErrorDetail{CodeAlreadySubscribed, "Address already subscribed"}
+	case errors.Is(err, parser.ErrNotSubscribed):
+		return http.StatusNotFound, ErrorDetail{CodeNotSubscribed, "Address not subscribed"}
+	case errors.Is(err, parser.ErrInvalidWebhook):
+		return http.StatusBadRequest, ErrorDetail{CodeInvalidWebhook, err.Error()}
+	case errors.Is(err, parser.ErrWebhookNotFound):
+		return http.StatusNotFound, ErrorDetail{CodeWebhookNotFound, "Address has no webhook"}
+	case errors.Is(err, parser.ErrDeliveryNotFound):
+		return http.StatusNotFound, ErrorDetail{CodeDeliveryNotFound, "Failed delivery not found"}
+	case errors.Is(err, parser.ErrQuotaExceeded):
+		return http.StatusForbidden, ErrorDetail{CodeQuotaExceeded, err.Error()}
+	case errors.Is(err, parser.ErrInvalidTenant):
+		return http.StatusBadRequest, ErrorDetail{CodeInvalidTenant, err.Error()}
+	case errors.Is(err, parser.ErrTenantNotFound):
+		return http.StatusNotFound, ErrorDetail{CodeTenantNotFound, "Tenant not found"}
+	case errors.Is(err, parser.ErrTenantExists):
+		return http.StatusConflict, ErrorDetail{CodeTenantExists, "Tenant already exists"}
+	case errors.Is(err, parser.ErrKeyNotFound):
+		return http.StatusNotFound, ErrorDetail{CodeKeyNotFound, "API key not found"}
+	default:
+		return http.StatusInternalServerError, ErrorDetail{CodeInternal, fmt.Sprintf("Internal error: %v", err)}
+	}
+}
+
+// decodeBody decodes the JSON object in the request body into v, rejecting
+// unknown fields. On failure it writes an error response and returns false.
+func decodeBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		writeAPIError(w, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("Invalid request body: %v", err))
+		return false
+	}
+	if decoder.Decode(&struct{}{}) != io.EOF {
+		writeAPIError(w, http.StatusBadRequest, CodeInvalidRequest, "Invalid request body: unexpected data after object")
+		return false
+	}
+	return true
+}
+
+// v1Parser returns the parser addressed by the request, writing an error
+// response and returning nil when no parser matches.
+func (s *Server) v1Parser(w http.ResponseWriter, r *http.Request) Parser {
+	p, err := s.lookupParser(r)
+	if err != nil {
+		writeV1Error(w, err)
+	}
+	return p
+}
+
+func (s *Server) v1Chains(w http.ResponseWriter, r *http.Request) {
+	chains := make([]int64, 0, len(s.parsers))
+	for chainID := range s.parsers {
+		chains = append(chains, chainID)
+	}
+	sort.Slice(chains, func(i, j int) bool { return chains[i] < chains[j] })
+	writeJSON(w, http.StatusOK, ChainsResponse{Chains: chains})
+}
+
+func (s *Server) v1CurrentBlock(w http.ResponseWriter, r *http.Request) {
+	p := s.v1Parser(w, r)
+	if p == nil {
+		return
+	}
+	currentBlock, err := p.GetCurrentBlock(r.Context())
+	if err != nil {
+		writeV1Error(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, CurrentBlockResponse{CurrentBlock: currentBlock})
+}
+
+func (s *Server) v1Subscriptions(w http.ResponseWriter, r *http.Request) {
+	p := s.v1Parser(w, r)
+	if p == nil {
+		return
+	}
+	subscriptions, err := p.GetSubscriptions(r.Context())
+	if err != nil {
+		writeV1Error(w, err)
+		return
+	}
+	sort.Strings(subscriptions)
+	writeJSON(w, http.StatusOK, SubscriptionsResponse{Subscriptions: checksumAddresses(subscriptions)})
+}
+
+// v1Subscribe subscribes the address in a {"address": "..."} body.
+func (s *Server) v1Subscribe(w http.ResponseWriter, r *http.Request) {
+	p := s.v1Parser(w, r)
+	if p == nil {
+		return
+	}
+	var request struct {
+		Address string `json:"address"`
+	}
+	if !decodeBody(w, r, &request) {
+		return
+	}
+	if request.Address == "" {
+		writeAPIError(w, http.StatusBadRequest, CodeInvalidRequest, "Missing address")
+		return
+	}
+
+	resolved, err := p.ResolveAddress(r.Context(), request.Address)
+	if err != nil {
+		writeV1Error(w, err)
+		return
+	}
+	if err := p.Subscribe(r.Context(), resolved); err != nil {
+		writeV1Error(w, err)
+		return
+	}
+	response := SubscriptionResponse{Address: utils.ChecksumAddress(resolved)}
+	if resolved != strings.ToLower(request.Address) {
+		response.Name = request.Address
+	}
+	writeJSON(w, http.StatusCreated, response)
+}
+
+func (s *Server) v1Unsubscribe(w http.ResponseWriter, r *http.Request) {
+	p := s.v1Parser(w, r)
+	if p == nil {
+		return
+	}
+	address := r.PathValue("address")
+	resolved, err := p.ResolveAddress(r.Context(), address)
+	if err != nil {
+		writeV1Error(w, err)
+		return
+	}
+	if err := p.Unsubscribe(r.Context(), resolved); err != nil {
+		writeV1Error(w, err)
+		return
+	}
+	response := SubscriptionResponse{Address: utils.ChecksumAddress(resolved)}
+	if resolved != strings.ToLower(address) {
+		response.Name = address
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// v1Transactions returns a page of transactions. It accepts the filters of
+// the legacy getTransactions route, and always responds with a page.
+func (s *Server) v1Transactions(w http.ResponseWriter, r *http.Request) {
+	p := s.v1Parser(w, r)
+	if p == nil {
+		return
+	}
+	values := r.URL.Query()
+	for param := range values {
+		if param != "names" && !slices.Contains(queryParams, param) {
+			writeAPIError(w, http.StatusBadRequest, CodeInvalidQuery, fmt.Sprintf("Unknown parameter %s", param))
+			return
+		}
+	}
+	names := values.Get("names")
+	if names != "" && names != "true" && names != "false" {
+		writeAPIError(w, http.StatusBadRequest, CodeInvalidQuery, "Invalid names parameter")
+		return
+	}
+	q, err := parseTransactionQuery(values)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, CodeInvalidQuery, err.Error())
+		return
+	}
+
+	transactions, next, err := p.QueryTransactions(r.Context(), r.PathValue("address"), q)
+	if err != nil {
+		writeV1Error(w, err)
+		return
+	}
+	if names == "true" {
+		if err := resolveNames(r.Context(), p, transactions); err != nil {
+			writeV1Error(w, err)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, TransactionsResponse{Transactions: transactions, NextCursor: next})
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/EliasManj/tx-parser/parser"
+	"github.com/stretchr/testify/require"
+)
+
+func v1Request(t *testing.T, server *httptest.Server, method, path, body string, response any) *http.Response {
+	req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(response))
+	return resp
+}
+
+func TestV1Subscriptions(t *testing.T) {
+	server := httptest.NewServer(NewServer(parser.NewParser(nil, 0)))
+	defer server.Close()
+	address := "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"
+
+	var subscription SubscriptionResponse
+	resp := v1Request(t, server, http.MethodPost, "/v1/subscriptions", `{"address":"`+strings.ToLower(address)+`"}`, &subscription)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	require.Equal(t, address, subscription.Address)
+
+	var subscriptions SubscriptionsResponse
+	resp = v1Request(t, server, http.MethodGet, "/v1/chains/1/subscriptions", "", &subscriptions)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp = v1Request(t, server, http.MethodGet, "/v1/subscriptions", "", &subscriptions)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, []string{address}, subscriptions.Subscriptions)
+
+	var page TransactionsResponse
+	resp = v1Request(t, server, http.MethodGet, "/v1/subscriptions/"+address+"/transactions?limit=10", "", &page)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Empty(t, page.Transactions)
+
+	resp = v1Request(t, server, http.MethodDelete, "/v1/subscriptions/"+address, "", &subscription)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, address, subscription.Address)
+	resp = v1Request(t, server, http.MethodGet, "/v1/subscriptions", "", &subscriptions)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Empty(t, subscriptions.Subscriptions)
+}
+
+func TestV1Errors(t *testing.T) {
+	server := httptest.NewServer(NewServer(parser.NewParser(nil, 0)))
+	defer server.Close()
+	address := "0x0000000000000000000000000000000000000001"
+
+	tests := []struct {
+		name, method, path, body string
+		status                   int
+		code                     string
+	}{
+		{"wrong method", http.MethodGet, "/v1/subscriptions/" + address, "", http.StatusMethodNotAllowed, CodeMethodNotAllowed},
+		{"unknown endpoint", http.MethodGet, "/v1/unknown", "", http.StatusNotFound, CodeNotFound},
+		{"invalid chain id", http.MethodGet, "/v1/chains/x/block", "", http.StatusBadRequest, CodeInvalidChainID},
+		{"unknown chain", http.MethodGet, "/v1/chains/5/block", "", http.StatusNotFound, CodeUnknownChain},
+		{"invalid body", http.MethodPost, "/v1/subscriptions", `{"address":1}`, http.StatusBadRequest, CodeInvalidRequest},
+		{"unknown field", http.MethodPost, "/v1/subscriptions", `{"addr":"` + address + `"}`, http.StatusBadRequest, CodeInvalidRequest},
+		{"missing address", http.MethodPost, "/v1/subscriptions", `{}`, http.StatusBadRequest, CodeInvalidRequest},
+		{"invalid address", http.MethodPost, "/v1/subscriptions", `{"address":"0x1"}`, http.StatusBadRequest, CodeInvalidAddress},
+		{"not subscribed", http.MethodDelete, "/v1/subscriptions/" + address, "", http.StatusNotFound, CodeNotSubscribed},
+		{"unknown parameter", http.MethodGet, "/v1/subscriptions/" + address + "/transactions?page=2", "", http.StatusBadRequest, CodeInvalidQuery},
+		{"invalid limit", http.MethodGet, "/v1/subscriptions/" + address + "/transactions?limit=0", "", http.StatusBadRequest, CodeInvalidQuery},
+		{"invalid cursor", http.MethodGet, "/v1/subscriptions/" + address + "/transactions?cursor=x", "", http.StatusBadRequest, CodeInvalidCursor},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var response ErrorResponse
+			resp := v1Request(t, server, test.method, test.path, test.body, &response)
+			require.Equal(t, test.status, resp.StatusCode)
+			require.Equal(t, test.code, response.Error.Code)
+			require.NotEmpty(t, response.Error.Message)
+		})
+	}
+
+	var response ErrorResponse
+	v1Request(t, server, http.MethodPost, "/v1/subscriptions", `{"address":"`+address+`"}`, &SubscriptionResponse{})
+	resp := v1Request(t, server, http.MethodPost, "/v1/subscriptions", `{"address":"`+address+`"}`, &response)
+	require.Equal(t, http.StatusConflict, resp.StatusCode)
+	require.Equal(t, CodeAlreadySubscribed, response.Error.Code)
+}
+
+func TestLegacyGasPriceField(t *testing.T) {
+	address := "0x0000000000000000000000000000000000000001"
+	storage := parser.NewMemoryStorage(parser.RetentionPolicy{})
+	require.NoError(t, storage.AddSubscription(address))
+	require.NoError(t, storage.AppendBlock(1, map[string][]parser.Transaction{
+		address: {{Txhash: "0x1", BlockNumber: "0x1", GasPrice: "0x3b9aca00"}},
+	}))
+	server := httptest.NewServer(NewServer(parser.NewParser(storage, 0)))
+	defer server.Close()
+
+	// The original endpoint also sends the gas price under its old name
+	var legacy []map[string]any
+	resp, err := http.Get(server.URL + "/getTransactions?address=" + address)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&legacy))
+	require.Len(t, legacy, 1)
+	require.Equal(t, "0x3b9aca00", legacy[0]["gasPrice"])
+	require.Equal(t, "0x3b9aca00", legacy[0]["blobGasPrice"])
+
+	var page struct {
+		Transactions []map[string]any `json:"transactions"`
+	}
+	v1Request(t, server, http.MethodGet, "/v1/subscriptions/"+address+"/transactions", "", &page)
+	require.Len(t, page.Transactions, 1)
+	require.NotContains(t, page.Transactions[0], "blobGasPrice")
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/EliasManj/tx-parser/parser"
+	"github.com/EliasManj/tx-parser/utils"
+)
+
+// WebhookResponse describes the webhook of a subscription. The secret is
+// only returned when the webhook is set.
+type WebhookResponse struct {
+	Address string `json:"address"`
+	URL     string `json:"url"`
+	Secret  string `json:"secret,omitempty"`
+}
+
+type DeliveriesResponse struct {
+	Deliveries []parser.WebhookDelivery `json:"deliveries"`
+}
+
+func (s *Server) v1SetWebhook(w http.ResponseWriter, r *http.Request) {
+	p := s.v1Parser(w, r)
+	if p == nil {
+		return
+	}
+	var request parser.Webhook
+	if !decodeBody(w, r, &request) {
+		return
+	}
+	address, err := p.ResolveAddress(r.Context(), r.PathValue("address"))
+	if err != nil {
+		writeV1Error(w, err)
+		return
+	}
+	webhook, err := p.SetWebhook(r.Context(), address, request)
+	if err != nil {
+		writeV1Error(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, WebhookResponse{Address: utils.ChecksumAddress(address), URL: webhook.URL, Secret: webhook.Secret})
+}
+
+func (s *Server) v1GetWebhook(w http.ResponseWriter, r *http.Request) {
+	p := s.v1Parser(w, r)
+	if p == nil {
+		return
+	}
+	address, err := p.ResolveAddress(r.Context(), r.PathValue("address"))
+	if err != nil {
+		writeV1Error(w, err)
+		return
+	}
+	webhook, err := p.GetWebhook(r.Context(), address)
+	if err != nil {
+		writeV1Error(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, WebhookResponse{Address: utils.ChecksumAddress(address), URL: webhook.URL})
+}
+
+func (s *Server) v1RemoveWebhook(w http.ResponseWriter, r *http.Request) {
+	p := s.v1Parser(w, r)
+	if p == nil {
+		return
+	}
+	if err := p.RemoveWebhook(r.Context(), r.PathValue("address")); err != nil {
+		writeV1Error(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) v1PendingDeliveries(w http.ResponseWriter, r *http.Request) {
+	p := s.v1Parser(w, r)
+	if p == nil {
+		return
+	}
+	writeJSON(w, http.StatusOK, DeliveriesResponse{Deliveries: visibleDeliveries(p, p.Webhooks().Pending())})
+}
+
+func (s *Server) v1FailedDeliveries(w http.ResponseWriter, r *http.Request) {
+	p := s.v1Parser(w, r)
+	if p == nil {
+		return
+	}
+	writeJSON(w, http.StatusOK, DeliveriesResponse{Deliveries: visibleDeliveries(p, p.Webhooks().Failed())})
+}
+
+// v1ReplayDelivery queues a failed delivery again.
+func (s *Server) v1ReplayDelivery(w http.ResponseWriter, r *http.Request) {
+	p := s.v1Parser(w, r)
+	if p == nil {
+		return
+	}
+	if err := visibleDelivery(p, r.PathValue("delivery")); err != nil {
+		writeV1Error(w, err)
+		return
+	}
+	if err := p.Webhooks().Replay(r.PathValue("delivery")); err != nil {
+		writeV1Error(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *Server) v1DiscardDelivery(w http.ResponseWriter, r *http.Request) {
+	p := s.v1Parser(w, r)
+	if p == nil {
+		return
+	}
+	if err := visibleDelivery(p, r.PathValue("delivery")); err != nil {
+		writeV1Error(w, err)
+		return
+	}
+	if err := p.Webhooks().Discard(r.PathValue("delivery")); err != nil {
+		writeV1Error(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,100 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/EliasManj/tx-parser/parser"
+	"github.com/stretchr/testify/require"
+)
+
+// v1Status sends a request without body and returns the response status.
+func v1Status(t *testing.T, server *httptest.Server, method, path string) int {
+	req, err := http.NewRequest(method, server.URL+path, nil)
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestV1Webhooks(t *testing.T) {
+	var calls atomic.Int64
+	bodies := make(chan []byte, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		timestamp, _ := strconv.ParseInt(r.Header.Get(parser.WebhookTimestampHeader), 10, 64)
+		if r.Header.Get(parser.WebhookSignatureHeader) != parser.SignWebhook("secret", timestamp, body) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		bodies <- body
+	}))
+	defer receiver.Close()
+
+	p := parser.NewParser(nil, 0)
+	p.SetWebhooks(parser.NewWebhooks(parser.WebhookOptions{MaxAttempts: 1, AllowPrivate: true}))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go p.Webhooks().Run(ctx, p.Stream())
+	address := "0x0000000000000000000000000000000000000001"
+	require.NoError(t, p.Subscribe(ctx, address))
+	server := httptest.NewServer(NewServer(p))
+	defer server.Close()
+
+	var webhook WebhookResponse
+	resp := v1Request(t, server, http.MethodPut, "/v1/subscriptions/"+address+"/webhook",
+		`{"url":"`+receiver.URL+`","secret":"secret"}`, &webhook)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, WebhookResponse{Address: address, URL: receiver.URL, Secret: "secret"}, webhook)
+	webhook = WebhookResponse{}
+	resp = v1Request(t, server, http.MethodGet, "/v1/subscriptions/"+address+"/webhook", "", &webhook)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Empty(t, webhook.Secret)
+
+	// The first attempt fails and is moved to the failed list
+	require.NoError(t, p.Stream().Publish(parser.TxEvent{Type: parser.TxDetected, Address: address}))
+	var deliveries DeliveriesResponse
+	require.Eventually(t, func() bool {
+		v1Request(t, server, http.MethodGet, "/v1/webhooks/failed", "", &deliveries)
+		return len(deliveries.Deliveries) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, address, deliveries.Deliveries[0].Address)
+
+	require.Equal(t, http.StatusAccepted, v1Status(t, server, http.MethodPost, "/v1/webhooks/failed/"+deliveries.Deliveries[0].ID+"/replay"))
+	select {
+	case body := <-bodies:
+		require.Contains(t, string(body), `"seq":1`)
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook not delivered")
+	}
+	v1Request(t, server, http.MethodGet, "/v1/webhooks/failed", "", &deliveries)
+	require.Empty(t, deliveries.Deliveries)
+
+	var response ErrorResponse
+	resp = v1Request(t, server, http.MethodPost, "/v1/webhooks/failed/unknown/replay", "", &response)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	require.Equal(t, CodeDeliveryNotFound, response.Error.Code)
+	resp = v1Request(t, server, http.MethodPut, "/v1/subscriptions/"+address+"/webhook", `{"url":"not a url"}`, &response)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	require.Equal(t, CodeInvalidWebhook, response.Error.Code)
+	resp = v1Request(t, server, http.MethodPut, "/v1/subscriptions/0x0000000000000000000000000000000000000002/webhook",
+		`{"url":"`+receiver.URL+`"}`, &response)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	require.Equal(t, CodeNotSubscribed, response.Error.Code)
+
+	require.Equal(t, http.StatusNoContent, v1Status(t, server, http.MethodDelete, "/v1/subscriptions/"+address+"/webhook"))
+	resp = v1Request(t, server, http.MethodGet, "/v1/subscriptions/"+address+"/webhook", "", &response)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	require.Equal(t, CodeWebhookNotFound, response.Error.Code)
+}
@@ -0,0 +1,283 @@
+package api
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// WebSocket opcodes, RFC 6455 section 5.2
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xa
+)
+
+// WebSocket close codes, RFC 6455 section 7.4.1
+const (
+	wsCloseNormal          = 1000
+	wsCloseGoingAway       = 1001
+	wsCloseProtocolError   = 1002
+	wsCloseUnsupportedData = 1003
+	wsCloseInvalidPayload  = 1007
+	wsClosePolicyViolation = 1008
+	wsCloseTooBig          = 1009
+)
+
+// wsGUID is appended to the client's key to compute the accept header.
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// wsMaxMessageSize limits the size of messages read from clients.
+const wsMaxMessageSize = 64 << 10
+
+// wsError is a protocol error, reported to the client with a close frame.
+type wsError struct {
+	code   int
+	reason string
+}
+
+func (e *wsError) Error() string {
+	return fmt.Sprintf("websocket error %d: %s", e.code, e.reason)
+}
+
+// wsConn is the server side of a WebSocket connection. Reads must come
+// from one goroutine, writes are safe from several.
+type wsConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+
+	// Time to wait for each frame, 0 waits forever
+	readTimeout time.Duration
+
+	mu     sync.Mutex
+	writer *bufio.Writer
+	closed bool
+}
+
+func headerContains(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wsAccept returns the Sec-WebSocket-Accept value for a client key.
+func wsAccept(key string) string {
+	hash := sha1.Sum([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// upgradeWebSocket performs the opening handshake and takes over the
+// connection. On failure it has written an error response.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	if !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") ||
+		err != nil || len(decoded) != 16 {
+		writeAPIError(w, http.StatusBadRequest, CodeInvalidRequest, "Not a WebSocket handshake")
+		return nil, errors.New("not a websocket handshake")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		writeAPIError(w, http.StatusUpgradeRequired, CodeInvalidRequest, "Unsupported WebSocket version")
+		return nil, errors.New("unsupported websocket version")
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		writeAPIError(w, http.StatusInternalServerError, CodeInternal, "WebSockets are not supported")
+		return nil, errors.New("connection cannot be hijacked")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, fmt.Errorf("failed to hijack connection: %v", err)
+	}
+	conn.SetDeadline(time.Time{})
+
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", wsAccept(key))
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to write handshake: %v", err)
+	}
+	return &wsConn{conn: conn, reader: rw.Reader, writer: rw.Writer}, nil
+}
+
+// readFrame reads one frame and unmasks its payload.
+func (c *wsConn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	if c.readTimeout > 0 {
+		c.conn.SetReadDeadline(time.Now().Add(c.readTimeout))
+	}
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin = header[0]&0x80 != 0
+	opcode = header[0] & 0x0f
+	if header[0]&0x70 != 0 {
+		return false, 0, nil, &wsError{wsCloseProtocolError, "reserved bits set"}
+	}
+	if header[1]&0x80 == 0 {
+		return false, 0, nil, &wsError{wsCloseProtocolError, "client frames must be masked"}
+	}
+
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var extended [2]byte
+		if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(extended[:])
+	}
+	if opcode >= wsClose && (length > 125 || !fin) {
+		return false, 0, nil, &wsError{wsCloseProtocolError, "invalid control frame"}
+	}
+	if length > wsMaxMessageSize {
+		return false, 0, nil, &wsError{wsCloseTooBig, "message too big"}
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+// ReadMessage returns the next text or binary message, answering pings and
+// reassembling fragments. It returns io.EOF once the client closed the
+// connection.
+func (c *wsConn) ReadMessage() (opcode byte, message []byte, err error) {
+	for {
+		fin, frameOpcode, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch frameOpcode {
+		case wsPing:
+			if err := c.writeFrame(wsPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			c.Close(wsCloseNormal, "")
+			return 0, nil, io.EOF
+		case wsContinuation:
+			if opcode == 0 {
+				return 0, nil, &wsError{wsCloseProtocolError, "unexpected continuation frame"}
+			}
+		case wsText, wsBinary:
+			if opcode != 0 {
+				return 0, nil, &wsError{wsCloseProtocolError, "expected continuation frame"}
+			}
+			opcode = frameOpcode
+		default:
+			return 0, nil, &wsError{wsCloseProtocolError, "unknown opcode"}
+		}
+		if len(message)+len(payload) > wsMaxMessageSize {
+			return 0, nil, &wsError{wsCloseTooBig, "message too big"}
+		}
+		message = append(message, payload...)
+		if !fin {
+			continue
+		}
+		if opcode == wsText && !utf8.Valid(message) {
+			return 0, nil, &wsError{wsCloseInvalidPayload, "invalid UTF-8 text"}
+		}
+		return opcode, message, nil
+	}
+}
+
+// writeFrame writes an unfragmented, unmasked frame.
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.writeFrameLocked(opcode, payload)
+}
+
+func (c *wsConn) writeFrameLocked(opcode byte, payload []byte) error {
+	if c.closed {
+		return net.ErrClosed
+	}
+	header := []byte{0x80 | opcode}
+	switch {
+	case len(payload) <= 125:
+		header = append(header, byte(len(payload)))
+	case len(payload) <= 0xffff:
+		header = append(header, 126)
+		header = binary.BigEndian.AppendUint16(header, uint16(len(payload)))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(len(payload)))
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	c.writer.Write(header)
+	c.writer.Write(payload)
+	return c.writer.Flush()
+}
+
+// WriteText sends a text message.
+func (c *wsConn) WriteText(message []byte) error {
+	return c.writeFrame(wsText, message)
+}
+
+// Ping sends a ping, which the client answers with a pong.
+func (c *wsConn) Ping() error {
+	return c.writeFrame(wsPing, nil)
+}
+
+// Close sends a close frame and closes the connection. Later calls do
+// nothing.
+func (c *wsConn) Close(code int, reason string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil
+	}
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	payload = append(payload, reason[:min(len(reason), 123)]...)
+	c.writeFrameLocked(wsClose, payload)
+	c.closed = true
+	return c.conn.Close()
+}
+
+// closeWithError closes the connection with the code of a read error.
+func (c *wsConn) closeWithError(err error) {
+	var protocolErr *wsError
+	if errors.As(err, &protocolErr) {
+		c.Close(protocolErr.code, protocolErr.reason)
+		return
+	}
+	c.Close(wsCloseGoingAway, "")
+}
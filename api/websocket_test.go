@@ -0,0 +1,186 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/EliasManj/tx-parser/parser"
+	"github.com/stretchr/testify/require"
+)
+
+// wsClient is a minimal WebSocket client for tests.
+type wsClient struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func dialWebSocket(t *testing.T, server *httptest.Server, path string) *wsClient {
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	key := "dGhlIHNhbXBsZSBub25jZQ=="
+	fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: test\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: %s\r\nSec-WebSocket-Version: 13\r\n\r\n", path, key)
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	require.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", resp.Header.Get("Sec-WebSocket-Accept"))
+	return &wsClient{t: t, conn: conn, reader: reader}
+}
+
+func (c *wsClient) writeFrame(opcode byte, payload []byte) {
+	mask := [4]byte{1, 2, 3, 4}
+	frame := []byte{0x80 | opcode, 0x80 | byte(len(payload))}
+	frame = append(frame, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	_, err := c.conn.Write(frame)
+	require.NoError(c.t, err)
+}
+
+func (c *wsClient) send(message any) {
+	data, err := json.Marshal(message)
+	require.NoError(c.t, err)
+	c.writeFrame(wsText, data)
+}
+
+// readFrame returns the next frame other than a ping.
+func (c *wsClient) readFrame() (byte, []byte) {
+	for {
+		var header [2]byte
+		_, err := io.ReadFull(c.reader, header[:])
+		require.NoError(c.t, err)
+		length := int(header[1] & 0x7f)
+		if length == 126 {
+			var extended [2]byte
+			_, err := io.ReadFull(c.reader, extended[:])
+			require.NoError(c.t, err)
+			length = int(binary.BigEndian.Uint16(extended[:]))
+		}
+		payload := make([]byte, length)
+		_, err = io.ReadFull(c.reader, payload)
+		require.NoError(c.t, err)
+		if opcode := header[0] & 0x0f; opcode != wsPing {
+			return opcode, payload
+		}
+	}
+}
+
+func (c *wsClient) read(message any) {
+	opcode, payload := c.readFrame()
+	require.Equal(c.t, byte(wsText), opcode, string(payload))
+	require.NoError(c.t, json.Unmarshal(payload, message))
+}
+
+func TestV1WebSocket(t *testing.T) {
+	p := parser.NewParser(nil, 0)
+	ctx := context.Background()
+	alice := "0x0000000000000000000000000000000000000001"
+	bob := "0x0000000000000000000000000000000000000002"
+	require.NoError(t, p.Subscribe(ctx, alice))
+	s := NewServer(p)
+	s.heartbeat = 50 * time.Millisecond
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	stream := p.Stream()
+	require.NoError(t, stream.Publish(
+		parser.TxEvent{Type: parser.TxDetected, Address: alice, Transaction: parser.Transaction{Txhash: "0x1"}},
+		parser.TxEvent{Type: parser.TxDetected, Address: bob, Transaction: parser.Transaction{Txhash: "0x2"}},
+	))
+
+	client := dialWebSocket(t, server, "/v1/ws")
+	var after uint64
+	client.send(wsRequest{Type: "subscribe", Addresses: []string{alice}, After: &after})
+	var response wsResponse
+	client.read(&response)
+	require.Equal(t, wsResponse{Type: "subscribed", Addresses: []string{alice}}, response)
+	var event parser.TxEvent
+	client.read(&event)
+	require.Equal(t, uint64(1), event.Seq)
+	require.Equal(t, "0x1", event.Transaction.Txhash)
+
+	// Subscribing also makes the parser track the address
+	client.send(wsRequest{Type: "subscribe", Addresses: []string{bob}})
+	client.read(&response)
+	require.Equal(t, "subscribed", response.Type)
+	subscriptions, err := p.GetSubscriptions(ctx)
+	require.NoError(t, err)
+	require.Contains(t, subscriptions, bob)
+
+	require.NoError(t, stream.Publish(
+		parser.TxEvent{Type: parser.TxRemoved, Address: alice, Transaction: parser.Transaction{Txhash: "0x1"}},
+		parser.TxEvent{Type: parser.TxConfirmed, Address: bob, Transaction: parser.Transaction{Txhash: "0x3"}, Confirmations: 12},
+	))
+	client.read(&event)
+	require.Equal(t, parser.TxRemoved, event.Type)
+	client.read(&event)
+	require.Equal(t, parser.TxConfirmed, event.Type)
+	require.Equal(t, int64(12), event.Confirmations)
+
+	// Unsubscribing only stops the delivery on this connection
+	client.send(wsRequest{Type: "unsubscribe", Addresses: []string{alice}})
+	client.read(&response)
+	require.Equal(t, wsResponse{Type: "unsubscribed", Addresses: []string{alice}}, response)
+	require.NoError(t, stream.Publish(
+		parser.TxEvent{Type: parser.TxDetected, Address: alice, Transaction: parser.Transaction{Txhash: "0x4"}},
+		parser.TxEvent{Type: parser.TxDetected, Address: bob, Transaction: parser.Transaction{Txhash: "0x5"}},
+	))
+	client.read(&event)
+	require.Equal(t, "0x5", event.Transaction.Txhash)
+
+	client.send(wsRequest{Type: "subscribe", Addresses: []string{"0x1234"}})
+	client.read(&response)
+	require.Equal(t, "error", response.Type)
+	require.Equal(t, CodeInvalidAddress, response.Error.Code)
+	client.writeFrame(wsText, []byte(`{"type":"watch","addresses":["`+alice+`"]}`))
+	client.read(&response)
+	require.Equal(t, CodeInvalidRequest, response.Error.Code)
+
+	// Pings are answered, binary messages close the connection
+	client.writeFrame(wsPing, []byte("hi"))
+	opcode, payload := client.readFrame()
+	require.Equal(t, byte(wsPong), opcode)
+	require.Equal(t, "hi", string(payload))
+	client.writeFrame(wsBinary, []byte{1})
+	opcode, payload = client.readFrame()
+	require.Equal(t, byte(wsClose), opcode)
+	require.Equal(t, uint16(wsCloseUnsupportedData), binary.BigEndian.Uint16(payload))
+}
+
+func TestV1WebSocketHandshake(t *testing.T) {
+	server := httptest.NewServer(NewServer(parser.NewParser(nil, 0)))
+	defer server.Close()
+
+	var response ErrorResponse
+	resp := v1Request(t, server, http.MethodGet, "/v1/ws", "", &response)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	require.Equal(t, CodeInvalidRequest, response.Error.Code)
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/v1/ws", nil)
+	require.NoError(t, err)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("Sec-WebSocket-Version", "8")
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusUpgradeRequired, resp.StatusCode)
+	require.Equal(t, "13", resp.Header.Get("Sec-WebSocket-Version"))
+}
@@ -0,0 +1,116 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// ChainConfig describes one chain to parse.
+type ChainConfig struct {
+	// RPC URL of the chain
+	URL string `json:"url"`
+
+	// Optional block number to start parsing from
+	StartBlock *int64 `json:"startBlock,omitempty"`
+}
+
+// Config is the server configuration read from a JSON file.
+type Config struct {
+	// File to persist the subscribed addresses and transactions
+	File string `json:"file"`
+
+	// Storage backend: "json" (default), "log", "kv", "sql" or "memory"
+	Storage string `json:"storage"`
+
+	// database/sql driver name for the sql backend, which uses File as the
+	// data source name
+	SQLDriver string `json:"sqlDriver"`
+
+	// Optional file with the keys to encrypt stored data with
+	KeyFile string `json:"keyFile"`
+
+	// Optional retention: keep only transactions from the last MaxAge
+	// blocks and the newest MaxCount per address, 0 keeps all
+	MaxAge   int64 `json:"maxAge"`
+	MaxCount int   `json:"maxCount"`
+
+	// Optional gzip file pruned transactions are archived to
+	Archive string `json:"archive"`
+
+	// Optional directory streamed events are journaled in, one file per
+	// chain
+	EventLog string `json:"eventLog"`
+
+	// Optional directory webhooks and their delivery queues are kept in,
+	// one file per chain
+	Webhooks string `json:"webhooks"`
+
+	// Allow webhooks to loopback, private and link-local addresses
+	WebhookAllowPrivate bool `json:"webhookAllowPrivate"`
+
+	// Optional NATS server transaction events are published to, as
+	// nats://[user:password@]host[:port], under NATSSubject
+	NATS        string `json:"nats"`
+	NATSSubject string `json:"natsSubject"`
+
+	// Optional directory transaction events are spooled to, one JSONL file
+	// per chain
+	Spool string `json:"spool"`
+
+	// Optional number of blocks after which transactions are confirmed,
+	// and up to which reorganizations are detected, 0 uses the default
+	Confirmations int64 `json:"confirmations"`
+
+	// Optional number of blocks a parser may be behind the chain head
+	// before it is reported as lagging and not ready, 0 uses the default
+	MaxLag int64 `json:"maxLag"`
+
+	// Require API keys, stored with the first chain's data, for the HTTP
+	// API
+	Auth bool `json:"auth"`
+
+	// Address the HTTP server listens on
+	Addr string `json:"addr"`
+
+	Chains []ChainConfig `json:"chains"`
+}
+
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %v", err)
+	}
+
+	var cfg Config
+	err = json.Unmarshal(data, &cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal config: %v", err)
+	}
+
+	if len(cfg.Chains) == 0 {
+		return nil, fmt.Errorf("config must define at least one chain")
+	}
+	if err := CheckStorage(cfg.Storage); err != nil {
+		return nil, err
+	}
+	if cfg.MaxAge < 0 || cfg.MaxCount < 0 {
+		return nil, fmt.Errorf("retention limits must not be negative")
+	}
+	for i, chain := range cfg.Chains {
+		if chain.URL == "" {
+			return nil, fmt.Errorf("chain %d is missing url", i)
+		}
+	}
+	return &cfg, nil
+}
+
+// CheckStorage returns an error if backend is not a known storage backend.
+// An empty name selects the default JSON backend.
+func CheckStorage(backend string) error {
+	switch backend {
+	case "", "json", "log", "kv", "sql", "memory":
+		return nil
+	}
+	return fmt.Errorf("unknown storage backend: %s", backend)
+}
@@ -0,0 +1,202 @@
+package ens
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/EliasManj/tx-parser/rpcclient"
+	"github.com/EliasManj/tx-parser/utils"
+)
+
+// RegistryAddress is the ENS registry, deployed at the same address on
+// mainnet and the public testnets.
+const RegistryAddress = "0x00000000000c2e074ec69a0dfb2997ba6c7d2e1e"
+
+// Function selectors of the registry and resolver methods used here.
+const (
+	resolverSelector = "0x0178b8bf" // resolver(bytes32)
+	addrSelector     = "0x3b3b57de" // addr(bytes32)
+	nameSelector     = "0x691f3431" // name(bytes32)
+)
+
+// ErrNotFound is returned when a name or address has no ENS record.
+var ErrNotFound = errors.New("ens record not found")
+
+const zeroAddress = "0x0000000000000000000000000000000000000000"
+
+// IsName reports whether s looks like an ENS name rather than a hex address.
+func IsName(s string) bool {
+	return strings.Contains(s, ".") && !strings.HasPrefix(s, "0x")
+}
+
+// Namehash computes the EIP-137 namehash of name. Names are lowercased but
+// otherwise not normalized, so callers should pass names in their
+// normalized form.
+func Namehash(name string) []byte {
+	node := make([]byte, 32)
+	if name == "" {
+		return node
+	}
+	labels := strings.Split(strings.ToLower(name), ".")
+	for i := len(labels) - 1; i >= 0; i-- {
+		node = utils.Keccak256(node, utils.Keccak256([]byte(labels[i])))
+	}
+	return node
+}
+
+type cacheEntry struct {
+	value   string
+	err     error
+	expires time.Time
+}
+
+// Resolver resolves ENS names through the registry and resolver contracts
+// with eth_call. Results, including misses, are cached for TTL.
+type Resolver struct {
+	Endpoint string
+	Registry string
+	TTL      time.Duration
+
+	mu    sync.Mutex
+	cache map[string]cacheEntry
+}
+
+func NewResolver(endpoint string, ttl time.Duration) *Resolver {
+	return &Resolver{
+		Endpoint: endpoint,
+		Registry: RegistryAddress,
+		TTL:      ttl,
+		cache:    make(map[string]cacheEntry),
+	}
+}
+
+// Resolve returns the lowercase address name points to.
+func (r *Resolver) Resolve(name string) (string, error) {
+	name = strings.ToLower(name)
+	return r.cached("addr:"+name, func() (string, error) {
+		node := Namehash(name)
+		resolver, err := r.resolverFor(node)
+		if err != nil {
+			return "", err
+		}
+		data, err := rpcclient.Call(resolver, addrSelector+hex.EncodeToString(node), r.Endpoint)
+		if err != nil {
+			return "", fmt.Errorf("error calling resolver: %v", err)
+		}
+		address, err := decodeAddress(data)
+		if err != nil {
+			return "", err
+		}
+		if address == zeroAddress {
+			return "", fmt.Errorf("%w: %s", ErrNotFound, name)
+		}
+		return address, nil
+	})
+}
+
+// LookupAddress returns the primary name of address. The name is only
+// returned if it resolves back to the same address.
+func (r *Resolver) LookupAddress(address string) (string, error) {
+	address = strings.ToLower(address)
+	return r.cached("name:"+address, func() (string, error) {
+		node := Namehash(strings.TrimPrefix(address, "0x") + ".addr.reverse")
+		resolver, err := r.resolverFor(node)
+		if err != nil {
+			return "", err
+		}
+		data, err := rpcclient.Call(resolver, nameSelector+hex.EncodeToString(node), r.Endpoint)
+		if err != nil {
+			return "", fmt.Errorf("error calling resolver: %v", err)
+		}
+		name, err := decodeString(data)
+		if err != nil {
+			return "", err
+		}
+		if name == "" {
+			return "", fmt.Errorf("%w: %s", ErrNotFound, address)
+		}
+
+		forward, err := r.Resolve(name)
+		if err != nil || forward != address {
+			return "", fmt.Errorf("%w: %s does not resolve back to %s", ErrNotFound, name, address)
+		}
+		return name, nil
+	})
+}
+
+func (r *Resolver) resolverFor(node []byte) (string, error) {
+	data, err := rpcclient.Call(r.Registry, resolverSelector+hex.EncodeToString(node), r.Endpoint)
+	if err != nil {
+		return "", fmt.Errorf("error calling registry: %v", err)
+	}
+	resolver, err := decodeAddress(data)
+	if err != nil {
+		return "", err
+	}
+	if resolver == zeroAddress {
+		return "", fmt.Errorf("%w: no resolver set", ErrNotFound)
+	}
+	return resolver, nil
+}
+
+func (r *Resolver) cached(key string, lookup func() (string, error)) (string, error) {
+	r.mu.Lock()
+	entry, ok := r.cache[key]
+	r.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.value, entry.err
+	}
+
+	value, err := lookup()
+	// Only cache definitive answers, not transport failures
+	if err == nil || errors.Is(err, ErrNotFound) {
+		r.mu.Lock()
+		r.cache[key] = cacheEntry{value: value, err: err, expires: time.Now().Add(r.TTL)}
+		r.mu.Unlock()
+	}
+	return value, err
+}
+
+func decodeHex(data string) ([]byte, error) {
+	decoded, err := hex.DecodeString(strings.TrimPrefix(data, "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid return data: %v", err)
+	}
+	return decoded, nil
+}
+
+func decodeAddress(data string) (string, error) {
+	decoded, err := decodeHex(data)
+	if err != nil {
+		return "", err
+	}
+	if len(decoded) < 32 {
+		return "", fmt.Errorf("%w: empty return data", ErrNotFound)
+	}
+	return "0x" + hex.EncodeToString(decoded[12:32]), nil
+}
+
+func decodeString(data string) (string, error) {
+	decoded, err := decodeHex(data)
+	if err != nil {
+		return "", err
+	}
+	if len(decoded) < 64 {
+		return "", fmt.Errorf("%w: empty return data", ErrNotFound)
+	}
+	offset := new(big.Int).SetBytes(decoded[:32])
+	if !offset.IsInt64() || offset.Int64()+32 > int64(len(decoded)) {
+		return "", fmt.Errorf("invalid string offset in return data")
+	}
+	start := offset.Int64() + 32
+	length := new(big.Int).SetBytes(decoded[offset.Int64():start])
+	if !length.IsInt64() || start+length.Int64() > int64(len(decoded)) {
+		return "", fmt.Errorf("invalid string length in return data")
+	}
+	return string(decoded[start : start+length.Int64()]), nil
+}
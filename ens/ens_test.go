@@ -0,0 +1,128 @@
+package ens
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testResolver = "0x4976fb03c32e5b8cfe2b6ccb31c09ba78ebaba41"
+	testAddress  = "0xd8da6bf26964af9d7eed9e03e53415d37aa96045"
+	testName     = "vitalik.eth"
+)
+
+func word(hexValue string) string {
+	return fmt.Sprintf("%064s", strings.TrimPrefix(hexValue, "0x"))
+}
+
+func encodeString(s string) string {
+	data := hex.EncodeToString([]byte(s))
+	padded := data + strings.Repeat("0", (64-len(data)%64)%64)
+	return "0x" + word("20") + word(fmt.Sprintf("%x", len(s))) + padded
+}
+
+// newFakeENS serves eth_call for a registry and a single resolver holding
+// testName <-> testAddress. It returns the server and a counter of calls.
+func newFakeENS(t *testing.T) (*httptest.Server, *int32) {
+	var calls int32
+	forward := hex.EncodeToString(Namehash(testName))
+	reverse := hex.EncodeToString(Namehash(strings.TrimPrefix(testAddress, "0x") + ".addr.reverse"))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		var req struct {
+			Params []json.RawMessage `json:"params"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		var call struct {
+			To   string `json:"to"`
+			Data string `json:"data"`
+		}
+		json.Unmarshal(req.Params[0], &call)
+
+		selector, node := call.Data[:10], call.Data[10:]
+		result := "0x" + word("0")
+		switch {
+		case call.To == RegistryAddress && selector == resolverSelector && (node == forward || node == reverse):
+			result = "0x" + word(testResolver)
+		case call.To == testResolver && selector == addrSelector && node == forward:
+			result = "0x" + word(testAddress)
+		case call.To == testResolver && selector == nameSelector && node == reverse:
+			result = encodeString(testName)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": 1, "result": result})
+	}))
+	t.Cleanup(server.Close)
+	return server, &calls
+}
+
+func TestNamehash(t *testing.T) {
+	tests := map[string]string{
+		"":        "0000000000000000000000000000000000000000000000000000000000000000",
+		"eth":     "93cdeb708b7545dc668eb9280176169d1c33cfd8ed6f04690a0bcc88a93fc4ae",
+		"foo.eth": "de9b09fd7c5f901e23a3f19fecc54828e9c848539801e86591bd9801b019f84f",
+	}
+	for name, expected := range tests {
+		require.Equal(t, expected, hex.EncodeToString(Namehash(name)), "name %q", name)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	server, _ := newFakeENS(t)
+	resolver := NewResolver(server.URL, time.Minute)
+
+	address, err := resolver.Resolve("Vitalik.eth")
+	require.NoError(t, err)
+	require.Equal(t, testAddress, address)
+
+	_, err = resolver.Resolve("unknown.eth")
+	require.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestLookupAddress(t *testing.T) {
+	server, calls := newFakeENS(t)
+	resolver := NewResolver(server.URL, time.Minute)
+
+	name, err := resolver.LookupAddress(testAddress)
+	require.NoError(t, err)
+	require.Equal(t, testName, name)
+
+	// Served from the cache
+	before := atomic.LoadInt32(calls)
+	name, err = resolver.LookupAddress(testAddress)
+	require.NoError(t, err)
+	require.Equal(t, testName, name)
+	require.Equal(t, before, atomic.LoadInt32(calls))
+
+	_, err = resolver.LookupAddress("0x0000000000000000000000000000000000000001")
+	require.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestCacheExpires(t *testing.T) {
+	server, calls := newFakeENS(t)
+	resolver := NewResolver(server.URL, time.Millisecond)
+
+	_, err := resolver.Resolve(testName)
+	require.NoError(t, err)
+	before := atomic.LoadInt32(calls)
+	time.Sleep(5 * time.Millisecond)
+
+	_, err = resolver.Resolve(testName)
+	require.NoError(t, err)
+	require.Greater(t, atomic.LoadInt32(calls), before)
+}
+
+func TestIsName(t *testing.T) {
+	require.True(t, IsName("vitalik.eth"))
+	require.False(t, IsName(testAddress))
+	require.False(t, IsName("hello"))
+}
@@ -0,0 +1,592 @@
+package kv
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DB is a small embedded log-structured merge key-value store.
+//
+// Writes go to a write-ahead log and an in-memory table. When the memory
+// table grows past Options.MemtableSize it is flushed to an immutable sorted
+// table file, and once there are more than Options.MaxTables tables they
+// are merged into one. The live tables are listed in a manifest that is
+// replaced atomically, so a crash during a flush or compaction leaves
+// either the old or the new tables in use, never a mix. Reads check the
+// memory table first and then the tables from newest to oldest.
+type DB struct {
+	dir  string
+	opts Options
+
+	mu       sync.RWMutex
+	memtable *memtable
+	wal      *os.File
+	walSize  int64
+	tables   []*table
+	nextID   int
+	closed   bool
+}
+
+type Options struct {
+	// Approximate size in bytes after which the memory table is flushed
+	MemtableSize int
+
+	// Number of tables that triggers a compaction
+	MaxTables int
+
+	// Sync the write-ahead log after every write
+	SyncWrites bool
+}
+
+var DefaultOptions = Options{
+	MemtableSize: 4 << 20,
+	MaxTables:    8,
+	SyncWrites:   true,
+}
+
+var ErrClosed = errors.New("kv: database is closed")
+
+type entry struct {
+	value   []byte
+	deleted bool
+}
+
+const (
+	opPut    byte = 1
+	opDelete byte = 2
+
+	walName      = "wal.log"
+	manifestName = "MANIFEST"
+)
+
+// Batch is a set of writes applied atomically.
+type Batch struct {
+	keys    []string
+	entries []entry
+}
+
+func (b *Batch) Put(key, value []byte) {
+	b.keys = append(b.keys, string(key))
+	b.entries = append(b.entries, entry{value: append([]byte(nil), value...)})
+}
+
+func (b *Batch) Delete(key []byte) {
+	b.keys = append(b.keys, string(key))
+	b.entries = append(b.entries, entry{deleted: true})
+}
+
+func (b *Batch) Len() int {
+	return len(b.keys)
+}
+
+func encodeEntry(buf *bytes.Buffer, key string, e entry) {
+	var scratch [binary.MaxVarintLen64]byte
+	if e.deleted {
+		buf.WriteByte(opDelete)
+	} else {
+		buf.WriteByte(opPut)
+	}
+	n := binary.PutUvarint(scratch[:], uint64(len(key)))
+	buf.Write(scratch[:n])
+	buf.WriteString(key)
+	if !e.deleted {
+		n = binary.PutUvarint(scratch[:], uint64(len(e.value)))
+		buf.Write(scratch[:n])
+		buf.Write(e.value)
+	}
+}
+
+// decodeEntry reads one entry and returns the offset of its value within
+// data, or -1 for deletions.
+func decodeEntry(data []byte) (key string, e entry, valueOffset int, n int, err error) {
+	if len(data) < 1 {
+		return "", entry{}, 0, 0, io.ErrUnexpectedEOF
+	}
+	op := data[0]
+	pos := 1
+	keyLen, m := binary.Uvarint(data[pos:])
+	if m <= 0 || uint64(len(data)-pos-m) < keyLen {
+		return "", entry{}, 0, 0, io.ErrUnexpectedEOF
+	}
+	pos += m
+	key = string(data[pos : pos+int(keyLen)])
+	pos += int(keyLen)
+
+	switch op {
+	case opDelete:
+		return key, entry{deleted: true}, -1, pos, nil
+	case opPut:
+		valueLen, m := binary.Uvarint(data[pos:])
+		if m <= 0 || uint64(len(data)-pos-m) < valueLen {
+			return "", entry{}, 0, 0, io.ErrUnexpectedEOF
+		}
+		pos += m
+		value := data[pos : pos+int(valueLen)]
+		return key, entry{value: value}, pos, pos + int(valueLen), nil
+	default:
+		return "", entry{}, 0, 0, fmt.Errorf("unknown op %d", op)
+	}
+}
+
+// Open opens the database in dir, creating it if needed, and replays the
+// write-ahead log. A torn record at the end of the log is discarded.
+func Open(dir string, opts Options) (*DB, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %v", err)
+	}
+	db := &DB{
+		dir:      dir,
+		opts:     opts,
+		memtable: newMemtable(),
+		nextID:   1,
+	}
+
+	live, hasManifest, err := readManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory: %v", err)
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if strings.HasSuffix(name, ".sst.tmp") {
+			os.Remove(filepath.Join(dir, name))
+			continue
+		}
+		if !strings.HasSuffix(name, ".sst") {
+			continue
+		}
+		id, err := strconv.Atoi(strings.TrimSuffix(name, ".sst"))
+		if err != nil {
+			continue
+		}
+		db.nextID = max(db.nextID, id+1)
+		// Tables missing from the manifest were replaced by a compaction
+		// or never committed by a flush
+		if hasManifest && !live[id] {
+			if err := os.Remove(filepath.Join(dir, name)); err != nil {
+				fmt.Printf("Error removing obsolete table %s: %v\n", name, err)
+			}
+			continue
+		}
+		t, err := openTable(filepath.Join(dir, name), id)
+		if err != nil {
+			db.closeTables()
+			return nil, err
+		}
+		db.tables = append(db.tables, t)
+	}
+	sort.Slice(db.tables, func(i, j int) bool { return db.tables[i].id < db.tables[j].id })
+	if len(db.tables) < len(live) {
+		db.closeTables()
+		return nil, fmt.Errorf("%s lists %d tables, found %d", manifestName, len(live), len(db.tables))
+	}
+	if !hasManifest {
+		// Databases created before manifests use every table
+		if err := writeManifest(dir, db.tables); err != nil {
+			db.closeTables()
+			return nil, err
+		}
+	}
+
+	if err := db.replayWAL(); err != nil {
+		db.closeTables()
+		return nil, err
+	}
+	db.wal, err = os.OpenFile(filepath.Join(dir, walName), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		db.closeTables()
+		return nil, fmt.Errorf("failed to open write-ahead log: %v", err)
+	}
+	info, err := db.wal.Stat()
+	if err != nil {
+		db.wal.Close()
+		db.closeTables()
+		return nil, fmt.Errorf("failed to stat write-ahead log: %v", err)
+	}
+	db.walSize = info.Size()
+	return db, nil
+}
+
+// readManifest returns the ids of the live tables, and false if there is
+// no manifest yet.
+func readManifest(dir string) (map[int]bool, bool, error) {
+	data, err := os.ReadFile(filepath.Join(dir, manifestName))
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read %s: %v", manifestName, err)
+	}
+	live := make(map[int]bool)
+	for _, line := range strings.Fields(string(data)) {
+		id, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, false, fmt.Errorf("corrupt %s: %v", manifestName, err)
+		}
+		live[id] = true
+	}
+	return live, true, nil
+}
+
+// writeManifest atomically replaces the manifest with tables.
+func writeManifest(dir string, tables []*table) error {
+	var buf bytes.Buffer
+	for _, t := range tables {
+		fmt.Fprintf(&buf, "%d\n", t.id)
+	}
+	path := filepath.Join(dir, manifestName)
+	tmpPath := path + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %v", manifestName, err)
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write %s: %v", manifestName, err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to sync %s: %v", manifestName, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close %s: %v", manifestName, err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to rename %s: %v", manifestName, err)
+	}
+	return syncDir(dir)
+}
+
+// commitTables makes tables the live set, dropping the table added last
+// if the manifest cannot be written.
+func (db *DB) commitTables(tables []*table) error {
+	if err := writeManifest(db.dir, tables); err != nil {
+		added := tables[len(tables)-1]
+		added.close()
+		os.Remove(added.path)
+		return err
+	}
+	db.tables = tables
+	return nil
+}
+
+func (db *DB) replayWAL() error {
+	path := filepath.Join(db.dir, walName)
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open write-ahead log: %v", err)
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	var offset int64
+	for {
+		payload, n, err := readRecord(reader)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			fmt.Printf("Truncating torn write in %s at offset %d: %v\n", path, offset, err)
+			return os.Truncate(path, offset)
+		}
+		for len(payload) > 0 {
+			key, e, _, m, err := decodeEntry(payload)
+			if err != nil {
+				return fmt.Errorf("corrupt write-ahead log record at offset %d: %v", offset, err)
+			}
+			if !e.deleted {
+				e.value = append([]byte(nil), e.value...)
+			}
+			db.memtable.set(key, e)
+			payload = payload[m:]
+		}
+		offset += n
+	}
+}
+
+func readRecord(r io.Reader) ([]byte, int64, error) {
+	var header [8]byte
+	n, err := io.ReadFull(r, header[:])
+	if err == io.EOF {
+		return nil, 0, io.EOF
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("short header (%d bytes)", n)
+	}
+	length := binary.LittleEndian.Uint32(header[0:4])
+	checksum := binary.LittleEndian.Uint32(header[4:8])
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, 0, errors.New("short payload")
+	}
+	if crc32.ChecksumIEEE(payload) != checksum {
+		return nil, 0, errors.New("checksum mismatch")
+	}
+	return payload, int64(len(header)) + int64(length), nil
+}
+
+// Write applies batch atomically. It succeeds once the batch is in the
+// write-ahead log. A flush or compaction that fails afterwards is logged
+// and retried by the next write, which fails without being applied if it
+// fails again.
+func (db *DB) Write(batch *Batch) error {
+	if batch.Len() == 0 {
+		return nil
+	}
+	// Encode the record in place after a header filled in afterwards
+	var buf bytes.Buffer
+	buf.Write(make([]byte, 8))
+	for i, key := range batch.keys {
+		encodeEntry(&buf, key, batch.entries[i])
+	}
+	record := buf.Bytes()
+	binary.LittleEndian.PutUint32(record[0:4], uint32(len(record)-8))
+	binary.LittleEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(record[8:]))
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return ErrClosed
+	}
+	if err := db.maintain(); err != nil {
+		return err
+	}
+
+	if _, err := db.wal.Write(record); err != nil {
+		// Drop the partial record so later records are not lost behind it
+		db.wal.Truncate(db.walSize)
+		return fmt.Errorf("failed to write to write-ahead log: %v", err)
+	}
+	db.walSize += int64(len(record))
+	if db.opts.SyncWrites {
+		if err := db.wal.Sync(); err != nil {
+			return fmt.Errorf("failed to sync write-ahead log: %v", err)
+		}
+	}
+	for i, key := range batch.keys {
+		db.memtable.set(key, batch.entries[i])
+	}
+
+	if err := db.maintain(); err != nil {
+		fmt.Printf("Error maintaining %s, retrying on the next write: %v\n", db.dir, err)
+	}
+	return nil
+}
+
+// maintain flushes the memory table once it is full and compacts the
+// tables once there are too many.
+func (db *DB) maintain() error {
+	if db.memtable.size >= db.opts.MemtableSize {
+		if err := db.flush(); err != nil {
+			return err
+		}
+	}
+	if db.opts.MaxTables > 0 && len(db.tables) > db.opts.MaxTables {
+		return db.compact()
+	}
+	return nil
+}
+
+func (db *DB) Put(key, value []byte) error {
+	var batch Batch
+	batch.Put(key, value)
+	return db.Write(&batch)
+}
+
+func (db *DB) Delete(key []byte) error {
+	var batch Batch
+	batch.Delete(key)
+	return db.Write(&batch)
+}
+
+// Get returns the value of key and whether it exists.
+func (db *DB) Get(key []byte) ([]byte, bool, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return nil, false, ErrClosed
+	}
+
+	if e, exists := db.memtable.get(string(key)); exists {
+		if e.deleted {
+			return nil, false, nil
+		}
+		return append([]byte(nil), e.value...), true, nil
+	}
+	for i := len(db.tables) - 1; i >= 0; i-- {
+		value, deleted, found, err := db.tables[i].get(string(key))
+		if err != nil {
+			return nil, false, err
+		}
+		if found {
+			return value, !deleted, nil
+		}
+	}
+	return nil, false, nil
+}
+
+// Scan calls fn for every live key with the given prefix in ascending key
+// order, until fn returns false.
+func (db *DB) Scan(prefix []byte, fn func(key, value []byte) bool) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return ErrClosed
+	}
+
+	p := string(prefix)
+	merged := make(map[string]entry)
+	for _, t := range db.tables {
+		if err := t.scan(p, func(key string, e entry) { merged[key] = e }); err != nil {
+			return err
+		}
+	}
+	for node := db.memtable.seek(p, nil); node != nil && strings.HasPrefix(node.key, p); node = node.next[0] {
+		merged[node.key] = node.e
+	}
+
+	keys := make([]string, 0, len(merged))
+	for key, e := range merged {
+		if !e.deleted {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !fn([]byte(key), merged[key].value) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// flush writes the memory table to a new table and starts a new
+// write-ahead log.
+func (db *DB) flush() error {
+	if db.memtable.count == 0 {
+		return nil
+	}
+	keys, entries := db.memtable.entries()
+	t, err := writeTable(db.dir, db.nextID, keys, entries)
+	if err != nil {
+		return err
+	}
+	db.nextID++
+	tables := append(slices.Clip(db.tables), t)
+	if err := db.commitTables(tables); err != nil {
+		return err
+	}
+
+	if err := db.wal.Close(); err != nil {
+		return fmt.Errorf("failed to close write-ahead log: %v", err)
+	}
+	db.wal, err = os.OpenFile(filepath.Join(db.dir, walName), os.O_WRONLY|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to reset write-ahead log: %v", err)
+	}
+	db.walSize = 0
+	db.memtable = newMemtable()
+	return nil
+}
+
+// compact merges all tables into one, dropping deleted keys. The old tables
+// are removed once the manifest lists the merged table alone; tables left
+// behind by a crash or failed removal are removed by Open.
+func (db *DB) compact() error {
+	merged := make(map[string]entry)
+	for _, t := range db.tables {
+		if err := t.scan("", func(key string, e entry) { merged[key] = e }); err != nil {
+			return err
+		}
+	}
+	keys := make([]string, 0, len(merged))
+	for key, e := range merged {
+		if !e.deleted {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	entries := make([]entry, len(keys))
+	for i, key := range keys {
+		entries[i] = merged[key]
+	}
+
+	t, err := writeTable(db.dir, db.nextID, keys, entries)
+	if err != nil {
+		return err
+	}
+	db.nextID++
+	old := db.tables
+	if err := db.commitTables([]*table{t}); err != nil {
+		return err
+	}
+	for _, replaced := range old {
+		replaced.close()
+		if err := os.Remove(replaced.path); err != nil {
+			fmt.Printf("Error removing compacted table: %v\n", err)
+		}
+	}
+	return nil
+}
+
+// Flush writes the memory table to disk.
+func (db *DB) Flush() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return ErrClosed
+	}
+	return db.flush()
+}
+
+// Compact flushes the memory table and merges all tables into one.
+func (db *DB) Compact() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return ErrClosed
+	}
+	if err := db.flush(); err != nil {
+		return err
+	}
+	return db.compact()
+}
+
+func (db *DB) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return nil
+	}
+	db.closed = true
+	db.closeTables()
+	if err := db.wal.Sync(); err != nil {
+		db.wal.Close()
+		return fmt.Errorf("failed to sync write-ahead log: %v", err)
+	}
+	return db.wal.Close()
+}
+
+func (db *DB) closeTables() {
+	for _, t := range db.tables {
+		t.close()
+	}
+}
@@ -0,0 +1,200 @@
+package kv
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func openDB(t *testing.T, dir string, opts Options) *DB {
+	db, err := Open(dir, opts)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func scanKeys(t *testing.T, db *DB, prefix string) []string {
+	var keys []string
+	err := db.Scan([]byte(prefix), func(key, value []byte) bool {
+		keys = append(keys, string(key))
+		return true
+	})
+	require.NoError(t, err)
+	return keys
+}
+
+func TestPutGetDelete(t *testing.T) {
+	db := openDB(t, t.TempDir(), DefaultOptions)
+
+	require.NoError(t, db.Put([]byte("a"), []byte("1")))
+	value, found, err := db.Get([]byte("a"))
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, []byte("1"), value)
+
+	require.NoError(t, db.Delete([]byte("a")))
+	_, found, err = db.Get([]byte("a"))
+	require.NoError(t, err)
+	require.False(t, found)
+}
+
+func TestScan(t *testing.T) {
+	opts := DefaultOptions
+	opts.MemtableSize = 64
+	db := openDB(t, t.TempDir(), opts)
+
+	var batch Batch
+	for _, key := range []string{"b/2", "a/1", "b/1", "c/1", "b/3"} {
+		batch.Put([]byte(key), []byte(key))
+	}
+	require.NoError(t, db.Write(&batch))
+	require.NoError(t, db.Delete([]byte("b/2")))
+
+	require.Equal(t, []string{"b/1", "b/3"}, scanKeys(t, db, "b/"))
+	require.Equal(t, []string{"a/1", "b/1", "b/3", "c/1"}, scanKeys(t, db, ""))
+
+	var first []string
+	db.Scan([]byte(""), func(key, value []byte) bool {
+		first = append(first, string(key))
+		return len(first) < 2
+	})
+	require.Equal(t, []string{"a/1", "b/1"}, first)
+}
+
+func TestReopenReplaysLog(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, DefaultOptions)
+	require.NoError(t, err)
+	require.NoError(t, db.Put([]byte("a"), []byte("1")))
+	require.NoError(t, db.Close())
+
+	// Append a torn record
+	f, err := os.OpenFile(filepath.Join(dir, walName), os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	f.Write([]byte{0xff, 0x00, 0x00})
+	f.Close()
+
+	reopened := openDB(t, dir, DefaultOptions)
+	value, found, err := reopened.Get([]byte("a"))
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, []byte("1"), value)
+	require.NoError(t, reopened.Put([]byte("b"), []byte("2")))
+}
+
+func TestFlushAndCompaction(t *testing.T) {
+	dir := t.TempDir()
+	opts := DefaultOptions
+	opts.MemtableSize = 256
+	opts.MaxTables = 3
+	db, err := Open(dir, opts)
+	require.NoError(t, err)
+
+	for i := 0; i < 200; i++ {
+		key := []byte(fmt.Sprintf("key/%03d", i))
+		require.NoError(t, db.Put(key, []byte(fmt.Sprintf("value-%d", i))))
+		if i%3 == 0 {
+			require.NoError(t, db.Delete(key))
+		}
+	}
+	require.LessOrEqual(t, len(db.tables), opts.MaxTables)
+	require.NoError(t, db.Compact())
+	require.Len(t, db.tables, 1)
+	require.NoError(t, db.Close())
+
+	reopened := openDB(t, dir, opts)
+	keys := scanKeys(t, reopened, "key/")
+	require.Len(t, keys, 133)
+	value, found, err := reopened.Get([]byte("key/199"))
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, []byte("value-199"), value)
+	_, found, err = reopened.Get([]byte("key/198"))
+	require.NoError(t, err)
+	require.False(t, found)
+}
+
+func TestCompactionCrashBeforeRemovingTables(t *testing.T) {
+	dir := t.TempDir()
+	opts := DefaultOptions
+	opts.MaxTables = 0
+	db, err := Open(dir, opts)
+	require.NoError(t, err)
+	require.NoError(t, db.Put([]byte("a"), []byte("1")))
+	require.NoError(t, db.Put([]byte("b"), []byte("2")))
+	require.NoError(t, db.Flush())
+	require.NoError(t, db.Delete([]byte("a")))
+	require.NoError(t, db.Flush())
+
+	// Keep the tables the compaction replaces, as if the process crashed
+	// before removing them
+	old := make(map[string][]byte)
+	for _, table := range db.tables {
+		data, err := os.ReadFile(table.path)
+		require.NoError(t, err)
+		old[table.path] = data
+	}
+	require.NoError(t, db.Compact())
+	require.NoError(t, db.Close())
+	for path, data := range old {
+		require.NoFileExists(t, path)
+		require.NoError(t, os.WriteFile(path, data, 0600))
+	}
+
+	reopened := openDB(t, dir, opts)
+	require.Len(t, reopened.tables, 1)
+	_, found, err := reopened.Get([]byte("a"))
+	require.NoError(t, err)
+	require.False(t, found)
+	require.Equal(t, []string{"b"}, scanKeys(t, reopened, ""))
+	for path := range old {
+		require.NoFileExists(t, path)
+	}
+}
+
+func TestWriteSucceedsWhenFlushFails(t *testing.T) {
+	dir := t.TempDir()
+	opts := DefaultOptions
+	opts.MemtableSize = 1
+	db := openDB(t, dir, opts)
+
+	// The table file can't be created while a directory has its name
+	blocker := filepath.Join(dir, tableName(db.nextID)+".tmp")
+	require.NoError(t, os.Mkdir(blocker, 0700))
+	require.NoError(t, db.Put([]byte("a"), []byte("1")), "Expected a logged write to succeed")
+
+	// The next write retries the flush first and is not applied if it fails
+	require.Error(t, db.Put([]byte("b"), []byte("2")))
+	_, found, err := db.Get([]byte("b"))
+	require.NoError(t, err)
+	require.False(t, found)
+
+	require.NoError(t, os.Remove(blocker))
+	require.NoError(t, db.Put([]byte("b"), []byte("2")))
+	require.NoError(t, db.Close())
+	reopened := openDB(t, dir, opts)
+	require.Equal(t, []string{"a", "b"}, scanKeys(t, reopened, ""))
+}
+
+func TestMemtableOrder(t *testing.T) {
+	m := newMemtable()
+	for i := 0; i < 1000; i++ {
+		m.set(fmt.Sprintf("key/%03d", (i*7)%1000), entry{value: []byte{byte(i)}})
+	}
+	m.set("key/500", entry{deleted: true})
+	keys, entries := m.entries()
+	require.Len(t, keys, 1000)
+	require.True(t, slices.IsSorted(keys))
+	require.True(t, entries[500].deleted)
+
+	e, found := m.get("key/007")
+	require.True(t, found)
+	require.Equal(t, []byte{1}, e.value)
+	_, found = m.get("key/1000")
+	require.False(t, found)
+	require.Equal(t, "key/990", m.seek("key/99", nil).key)
+}
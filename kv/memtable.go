@@ -0,0 +1,83 @@
+package kv
+
+import "math/rand/v2"
+
+const memtableMaxLevel = 16
+
+// memtable is the in-memory table, a skip list kept sorted by key so scans
+// can seek to a prefix.
+type memtable struct {
+	head  *memNode
+	level int
+	count int
+	size  int // approximate size of the keys and values in bytes
+}
+
+type memNode struct {
+	key  string
+	e    entry
+	next []*memNode
+}
+
+func newMemtable() *memtable {
+	return &memtable{head: &memNode{next: make([]*memNode, memtableMaxLevel)}, level: 1}
+}
+
+// seek returns the first node with a key not less than key, filling prev
+// with the last node before it on every level if prev is not nil.
+func (m *memtable) seek(key string, prev *[memtableMaxLevel]*memNode) *memNode {
+	node := m.head
+	for level := m.level - 1; level >= 0; level-- {
+		for node.next[level] != nil && node.next[level].key < key {
+			node = node.next[level]
+		}
+		if prev != nil {
+			prev[level] = node
+		}
+	}
+	return node.next[0]
+}
+
+func (m *memtable) get(key string) (entry, bool) {
+	node := m.seek(key, nil)
+	if node == nil || node.key != key {
+		return entry{}, false
+	}
+	return node.e, true
+}
+
+func (m *memtable) set(key string, e entry) {
+	var prev [memtableMaxLevel]*memNode
+	node := m.seek(key, &prev)
+	if node != nil && node.key == key {
+		m.size += len(e.value) - len(node.e.value)
+		node.e = e
+		return
+	}
+
+	level := 1
+	for level < memtableMaxLevel && rand.IntN(4) == 0 {
+		level++
+	}
+	for ; m.level < level; m.level++ {
+		prev[m.level] = m.head
+	}
+	node = &memNode{key: key, e: e, next: make([]*memNode, level)}
+	for i := 0; i < level; i++ {
+		node.next[i] = prev[i].next[i]
+		prev[i].next[i] = node
+	}
+	m.count++
+	m.size += len(key) + len(e.value)
+}
+
+// entries returns the keys and entries in ascending key order.
+func (m *memtable) entries() ([]string, []entry) {
+	keys := make([]string, 0, m.count)
+	entries := make([]entry, 0, m.count)
+	for node := m.head.next[0]; node != nil; node = node.next[0] {
+		keys = append(keys, node.key)
+		entries = append(entries, node.e)
+	}
+	return keys, entries
+}
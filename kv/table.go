@@ -0,0 +1,164 @@
+package kv
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// table is an immutable file of entries sorted by key. The keys and value
+// locations are indexed in memory, values are read from the file on demand.
+type table struct {
+	id   int
+	path string
+	file *os.File
+
+	keys    []string
+	offsets []int64 // value offset, -1 for deleted keys
+	lengths []int
+}
+
+func tableName(id int) string {
+	return fmt.Sprintf("%08d.sst", id)
+}
+
+// writeTable writes entries, sorted by key, to a new table file atomically.
+func writeTable(dir string, id int, keys []string, entries []entry) (*table, error) {
+	path := filepath.Join(dir, tableName(id))
+	tmpPath := path + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create table: %v", err)
+	}
+	// Index the entries while writing them rather than reading them back
+	t := &table{
+		id:      id,
+		path:    path,
+		keys:    keys,
+		offsets: make([]int64, len(keys)),
+		lengths: make([]int, len(keys)),
+	}
+	writer := bufio.NewWriter(f)
+	var buf bytes.Buffer
+	var pos int64
+	for i, key := range keys {
+		e := entries[i]
+		buf.Reset()
+		encodeEntry(&buf, key, e)
+		t.offsets[i] = -1
+		if !e.deleted {
+			t.offsets[i] = pos + int64(buf.Len()-len(e.value))
+		}
+		t.lengths[i] = len(e.value)
+		pos += int64(buf.Len())
+		if _, err := writer.Write(buf.Bytes()); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to write table: %v", err)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to write table: %v", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to sync table: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close table: %v", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return nil, fmt.Errorf("failed to rename table: %v", err)
+	}
+	if err := syncDir(dir); err != nil {
+		return nil, err
+	}
+	t.file, err = os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open table: %v", err)
+	}
+	return t, nil
+}
+
+func openTable(path string, id int) (*table, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read table: %v", err)
+	}
+	t := &table{id: id, path: path}
+	for pos := 0; pos < len(data); {
+		key, e, valueOffset, n, err := decodeEntry(data[pos:])
+		if err != nil {
+			return nil, fmt.Errorf("corrupt table %s at offset %d: %v", path, pos, err)
+		}
+		t.keys = append(t.keys, key)
+		if e.deleted {
+			t.offsets = append(t.offsets, -1)
+		} else {
+			t.offsets = append(t.offsets, int64(pos+valueOffset))
+		}
+		t.lengths = append(t.lengths, len(e.value))
+		pos += n
+	}
+
+	t.file, err = os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open table: %v", err)
+	}
+	return t, nil
+}
+
+func (t *table) value(i int) ([]byte, error) {
+	value := make([]byte, t.lengths[i])
+	if _, err := t.file.ReadAt(value, t.offsets[i]); err != nil {
+		return nil, fmt.Errorf("failed to read table %s: %v", t.path, err)
+	}
+	return value, nil
+}
+
+func (t *table) get(key string) (value []byte, deleted bool, found bool, err error) {
+	i := sort.SearchStrings(t.keys, key)
+	if i == len(t.keys) || t.keys[i] != key {
+		return nil, false, false, nil
+	}
+	if t.offsets[i] < 0 {
+		return nil, true, true, nil
+	}
+	value, err = t.value(i)
+	return value, false, true, err
+}
+
+func (t *table) scan(prefix string, fn func(key string, e entry)) error {
+	for i := sort.SearchStrings(t.keys, prefix); i < len(t.keys) && strings.HasPrefix(t.keys[i], prefix); i++ {
+		if t.offsets[i] < 0 {
+			fn(t.keys[i], entry{deleted: true})
+			continue
+		}
+		value, err := t.value(i)
+		if err != nil {
+			return err
+		}
+		fn(t.keys[i], entry{value: value})
+	}
+	return nil
+}
+
+func (t *table) close() {
+	t.file.Close()
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open directory: %v", err)
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to sync directory: %v", err)
+	}
+	return nil
+}
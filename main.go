@@ -2,32 +2,154 @@ package main
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"flag"
 	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"syscall"
 	"time"
 
 	"github.com/EliasManj/tx-parser/api"
+	"github.com/EliasManj/tx-parser/config"
+	"github.com/EliasManj/tx-parser/kv"
 	"github.com/EliasManj/tx-parser/parser"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		migrate(os.Args[2:])
+		return
+	}
+
 	rpcURL := flag.String("url", "https://ethereum-rpc.publicnode.com", "Ethereum RPC URL")
 	startFrom := flag.String("startblock", "", "Optional: Block Number to start parsing from")
 	filename := flag.String("file", "data.json", "File to persist the subscribed addresses and transactions")
+	storageKind := flag.String("storage", "json", "Storage backend: json, log, kv, sql or memory. The log and kv backends use -file as a directory, the sql backend as the data source name")
+	sqlDriver := flag.String("sqldriver", "sqlite", "database/sql driver for the sql storage backend, only registered in builds with -tags sqlite")
+	configFile := flag.String("config", "", "Optional: JSON config file listing the chains to parse")
+	keyFile := flag.String("keyfile", "", "Optional: file with the keys to encrypt stored data with, "+parser.KeyringEnv+" is used if not set")
+	maxAge := flag.Int64("maxage", 0, "Optional: keep only transactions from the last N blocks")
+	maxCount := flag.Int("maxcount", 0, "Optional: keep only the newest N transactions per address")
+	archive := flag.String("archive", "", "Optional: gzip file to archive pruned transactions to")
+	eventLog := flag.String("eventlog", "", "Optional: directory to journal streamed events in, so clients can resume across restarts")
+	webhooks := flag.String("webhooks", "", "Optional: directory to keep webhooks and their delivery queues in, so they survive restarts")
+	webhookAllowPrivate := flag.Bool("webhookallowprivate", false, "Allow webhooks to loopback, private and link-local addresses")
+	natsURL := flag.String("nats", "", "Optional: NATS server to publish transaction events to, as nats://[user:password@]host[:port]")
+	natsSubject := flag.String("natssubject", parser.DefaultNATSSubject, "Subject prefix of the events published to NATS")
+	spool := flag.String("spool", "", "Optional: directory to spool transaction events to, for other processes to tail")
+	confirmations := flag.Int64("confirmations", parser.DefaultConfirmations, "Blocks, counting its own, after which a transaction is confirmed and no longer reverted by reorganizations")
+	maxLag := flag.Int64("maxlag", parser.DefaultLagThreshold, "Blocks a parser may be behind the chain head before /readyz fails")
+	auth := flag.Bool("auth", false, "Require API keys for the HTTP API. An admin key is created and printed on the first start")
+	encryptPlaintext := flag.Bool("encryptplaintext", false, "Encrypt existing plaintext data instead of rejecting it")
 	flag.Parse()
 
+	cfg := &config.Config{
+		File:                *filename,
+		Storage:             *storageKind,
+		SQLDriver:           *sqlDriver,
+		KeyFile:             *keyFile,
+		MaxAge:              *maxAge,
+		MaxCount:            *maxCount,
+		Archive:             *archive,
+		EventLog:            *eventLog,
+		Webhooks:            *webhooks,
+		WebhookAllowPrivate: *webhookAllowPrivate,
+		NATS:                *natsURL,
+		NATSSubject:         *natsSubject,
+		Spool:               *spool,
+		Confirmations:       *confirmations,
+		MaxLag:              *maxLag,
+		Auth:                *auth,
+		Addr:                ":8082",
+	}
+	if *configFile != "" {
+		loaded, err := config.Load(*configFile)
+		if err != nil {
+			fmt.Println("Error loading config:", err)
+			return
+		}
+		if loaded.File == "" {
+			loaded.File = cfg.File
+		}
+		if loaded.Storage == "" {
+			loaded.Storage = cfg.Storage
+		}
+		if loaded.SQLDriver == "" {
+			loaded.SQLDriver = cfg.SQLDriver
+		}
+		if loaded.KeyFile == "" {
+			loaded.KeyFile = cfg.KeyFile
+		}
+		if loaded.MaxAge == 0 {
+			loaded.MaxAge = cfg.MaxAge
+		}
+		if loaded.MaxCount == 0 {
+			loaded.MaxCount = cfg.MaxCount
+		}
+		if loaded.Archive == "" {
+			loaded.Archive = cfg.Archive
+		}
+		if loaded.EventLog == "" {
+			loaded.EventLog = cfg.EventLog
+		}
+		if loaded.Webhooks == "" {
+			loaded.Webhooks = cfg.Webhooks
+		}
+		if !loaded.WebhookAllowPrivate {
+			loaded.WebhookAllowPrivate = cfg.WebhookAllowPrivate
+		}
+		if loaded.NATS == "" {
+			loaded.NATS = cfg.NATS
+		}
+		if loaded.NATSSubject == "" {
+			loaded.NATSSubject = cfg.NATSSubject
+		}
+		if loaded.Spool == "" {
+			loaded.Spool = cfg.Spool
+		}
+		if loaded.Confirmations == 0 {
+			loaded.Confirmations = cfg.Confirmations
+		}
+		if loaded.MaxLag == 0 {
+			loaded.MaxLag = cfg.MaxLag
+		}
+		if !loaded.Auth {
+			loaded.Auth = cfg.Auth
+		}
+		if loaded.Addr == "" {
+			loaded.Addr = cfg.Addr
+		}
+		cfg = loaded
+	} else {
+		chain := config.ChainConfig{URL: *rpcURL}
+		if *startFrom != "" {
+			start, err := strconv.ParseInt(*startFrom, 10, 64)
+			if err != nil {
+				fmt.Println("Error parsing start block:", err)
+				return
+			}
+			chain.StartBlock = &start
+		}
+		cfg.Chains = []config.ChainConfig{chain}
+	}
+	if err := config.CheckStorage(cfg.Storage); err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 
-	server := &http.Server{Addr: ":8082"}
+	server := &http.Server{Addr: cfg.Addr}
 
 	go func() {
 		<-sigChan
@@ -43,39 +165,262 @@ func main() {
 		}
 	}()
 
-	storage := &parser.JsonFileStorage{
-		FilePath: *filename,
-		Endpoint: *rpcURL,
+	fmt.Println("Server is running on", cfg.Addr)
+
+	// The sql backend shares one database between all chains
+	var db *sql.DB
+	if cfg.Storage == "sql" {
+		var err error
+		if !slices.Contains(sql.Drivers(), cfg.SQLDriver) {
+			fmt.Printf("Error opening database: driver %q is not registered, the sql backend requires building with -tags sqlite\n", cfg.SQLDriver)
+			return
+		}
+		db, err = sql.Open(cfg.SQLDriver, cfg.File)
+		if err != nil {
+			fmt.Printf("Error opening database: %v\n", err)
+			return
+		}
+		defer db.Close()
 	}
 
-	fmt.Println("Server is running on port 8082...")
-	fmt.Println("Using RPC URL:", *rpcURL)
-	fmt.Println("Using storage:", storage.Display())
+	keyring, err := parser.LoadKeyring(cfg.KeyFile)
+	if err != nil {
+		fmt.Println("Error loading encryption keys:", err)
+		return
+	}
 
-	// Initialize the parser
-	if *startFrom != "" {
-		start, err := strconv.ParseInt(*startFrom, 10, 64)
+	// Initialize one parser per chain
+	var parsers []api.Parser
+	var storages []parser.Storage
+	// One connection publishes the events of every chain
+	var nats *parser.NATSSink
+	if cfg.NATS != "" {
+		var err error
+		nats, err = parser.NewNATSSink(parser.NATSOptions{URL: cfg.NATS, Subject: cfg.NATSSubject})
 		if err != nil {
-			fmt.Println("Error parsing start block:", err)
+			fmt.Println("Error configuring NATS:", err)
 			return
 		}
-		fmt.Println("Starting from block:", start)
-		parser.Init(ctx, *rpcURL, storage, start)
-	} else {
-		parser.Init(ctx, *rpcURL, storage)
+		defer nats.Close()
+	}
+
+	seen := make(map[int64]bool)
+	for _, chain := range cfg.Chains {
+		storage, err := newStorage(cfg, chain, db)
+		if err != nil {
+			fmt.Println("Error opening storage:", err)
+			return
+		}
+		if keyring != nil {
+			encrypted := parser.NewEncryptedStorage(storage, keyring)
+			encrypted.AllowPlaintext = *encryptPlaintext
+			storage = encrypted
+		}
+		fmt.Println("Using RPC URL:", chain.URL)
+
+		var p *parser.MyParser
+		if chain.StartBlock != nil {
+			fmt.Println("Starting from block:", *chain.StartBlock)
+			p, err = parser.Init(chain.URL, storage, *chain.StartBlock)
+		} else {
+			p, err = parser.Init(chain.URL, storage)
+		}
+		if err != nil {
+			fmt.Println("Error initializing parser:", err)
+			return
+		}
+		if seen[p.ChainID()] {
+			fmt.Println("Error: chain", p.ChainID(), "is configured more than once")
+			return
+		}
+		seen[p.ChainID()] = true
+		p.SetRetention(parser.RetentionPolicy{MaxAge: cfg.MaxAge, MaxCount: cfg.MaxCount}, cfg.Archive)
+		if cfg.Confirmations > 0 {
+			p.SetConfirmations(cfg.Confirmations)
+		}
+		if cfg.MaxLag > 0 {
+			p.SetLagThreshold(cfg.MaxLag)
+		}
+		if cfg.EventLog != "" {
+			stream, err := openStream(cfg.EventLog, p.ChainID())
+			if err != nil {
+				fmt.Println("Error opening event journal:", err)
+				return
+			}
+			defer stream.Close()
+			p.SetStream(stream)
+		}
+		webhookOptions := parser.WebhookOptions{AllowPrivate: cfg.WebhookAllowPrivate, Keys: keyring}
+		if cfg.Webhooks != "" {
+			webhooks, err := openWebhooks(cfg.Webhooks, p.ChainID(), webhookOptions)
+			if err != nil {
+				fmt.Println("Error opening webhooks:", err)
+				return
+			}
+			p.SetWebhooks(webhooks)
+		} else {
+			p.SetWebhooks(parser.NewWebhooks(webhookOptions))
+		}
+		if nats != nil {
+			// A broker outage drops events instead of stopping the parser
+			p.Events().Register(nats, parser.SinkOptions{
+				Name:         "nats",
+				Filter:       parser.EventFilter{Types: parser.TransactionEvents},
+				Backpressure: parser.BackpressureDropOldest,
+			})
+		}
+		if cfg.Spool != "" {
+			spool, err := openSpool(cfg.Spool, p.ChainID())
+			if err != nil {
+				fmt.Println("Error opening spool:", err)
+				return
+			}
+			defer spool.Close()
+			if cfg.EventLog == "" {
+				fmt.Println("Warning: without -eventlog, events recorded right before a crash are not spooled")
+			}
+			// The spool follows the stream, so it never holds back the
+			// parser and resumes after its last record
+			go spool.Follow(ctx, p.Stream())
+		}
+		// Deferred after closing the stream and sinks, so it runs first and
+		// the buffered events reach them
+		defer p.Events().Close()
+		go p.Webhooks().Run(ctx, p.Stream())
+		p.Start(ctx)
+		fmt.Println("Using storage:", storage.Display())
+		parsers = append(parsers, p)
+		storages = append(storages, storage)
 	}
 
 	//parser.Init("http://localhost:8545")
 	//parser.Init("https://ethereum-rpc.publicnode.com")
 	//parser.Init("https://ethereum-sepolia-rpc.publicnode.com/", 6836867)
 
-	http.HandleFunc("/", api.HelloHandler)
-	http.HandleFunc("/getCurrentBlock", api.GetCurrentBlockHandler)
-	http.HandleFunc("/subscribe", api.SubscribeHandler)
-	http.HandleFunc("/getTransactions", api.GetTransactionsHandler)
-	http.HandleFunc("/getSubscriptions", api.GetSubscriptionsHandler)
+	handler := api.NewServer(parsers...)
+	if cfg.Auth && len(storages) > 0 {
+		access, err := openAccess(storages[0], parsers)
+		if err != nil {
+			fmt.Println("Error opening API keys:", err)
+			return
+		}
+		handler.SetAccess(access)
+	} else {
+		fmt.Printf("Warning: API keys are not required, anyone who can reach %s can manage subscriptions and webhooks. Start with -auth to require them\n", server.Addr)
+	}
+	server.Handler = handler
 
 	if err := server.ListenAndServe(); err != http.ErrServerClosed {
 		fmt.Println("Failed to start server:", err)
 	}
 }
+
+func newStorage(cfg *config.Config, chain config.ChainConfig, db *sql.DB) (parser.Storage, error) {
+	switch cfg.Storage {
+	case "log":
+		return parser.NewLogStorage(cfg.File, parser.DefaultLogStorageOptions), nil
+	case "memory":
+		return parser.NewMemoryStorage(parser.RetentionPolicy{}), nil
+	case "kv":
+		return parser.NewKVStorage(cfg.File, kv.DefaultOptions), nil
+	case "sql":
+		return parser.OpenSQLStorage(db)
+	case "", "json":
+		return &parser.JsonFileStorage{
+			FilePath: cfg.File,
+			Endpoint: chain.URL,
+		}, nil
+	}
+	return nil, config.CheckStorage(cfg.Storage)
+}
+
+// openAccess opens the API keys and tenants kept in storage, creating an
+// admin key if there is none, and subscribes parsers to the addresses
+// tenants hold. Every backend keeps them outside the chain's data, so any
+// chain's storage finds the same keys.
+func openAccess(storage parser.Storage, parsers []api.Parser) (*parser.Access, error) {
+	accessStorage, ok := storage.(parser.AccessStorage)
+	if !ok {
+		return nil, fmt.Errorf("%s does not support API keys", storage.Display())
+	}
+	access, err := parser.OpenAccess(accessStorage)
+	if err != nil {
+		return nil, err
+	}
+	if !access.HasAdmin() {
+		key, _, err := access.CreateKey("", true)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Println("Created admin API key, it is not shown again:", key)
+	}
+
+	// A tenant subscription is saved before the parser tracks the address,
+	// so a crash in between leaves addresses the parser has to pick up
+	for _, p := range parsers {
+		for _, address := range access.Addresses(p.ChainID()) {
+			err := p.Subscribe(context.Background(), address)
+			if err == nil {
+				fmt.Println("Subscribed address held by a tenant:", address)
+			} else if !errors.Is(err, parser.ErrAlreadySubscribed) {
+				return nil, fmt.Errorf("failed to subscribe %s: %v", address, err)
+			}
+		}
+	}
+	return access, nil
+}
+
+// openStream opens the event journal of a chain in dir.
+func openStream(dir string, chainID int64) (*parser.TxStream, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return parser.OpenTxStream(filepath.Join(dir, fmt.Sprintf("%d.jsonl", chainID)), parser.DefaultStreamCapacity)
+}
+
+// openWebhooks opens the webhooks of a chain in dir.
+func openWebhooks(dir string, chainID int64, options parser.WebhookOptions) (*parser.Webhooks, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return parser.OpenWebhooks(filepath.Join(dir, fmt.Sprintf("%d.json", chainID)), options)
+}
+
+// openSpool opens the spool of a chain in dir.
+func openSpool(dir string, chainID int64) (*parser.SpoolSink, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return parser.OpenSpoolSink(filepath.Join(dir, fmt.Sprintf("%d.jsonl", chainID)))
+}
+
+// migrate upgrades a JSON data file to the current schema version.
+func migrate(args []string) {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	filename := flags.String("file", "data.json", "JSON data file to migrate")
+	backup := flags.String("backup", "", "File to copy the original data to, defaults to the data file with a .bak suffix")
+	dryRun := flags.Bool("dry-run", false, "Only list the pending migrations")
+	flags.Parse(args)
+
+	if *backup == "" {
+		*backup = *filename + ".bak"
+	}
+	storage := &parser.JsonFileStorage{FilePath: *filename}
+	migrations, err := storage.Migrate(*backup, *dryRun)
+	if err != nil {
+		fmt.Println("Error migrating data:", err)
+		return
+	}
+	if len(migrations) == 0 {
+		fmt.Println("Data is at schema version", parser.SchemaVersion, "- nothing to migrate")
+		return
+	}
+	for _, migration := range migrations {
+		fmt.Printf("Version %d: %s\n", migration.Version, migration.Description)
+	}
+	if *dryRun {
+		fmt.Println("Dry run, no changes written")
+		return
+	}
+	fmt.Println("Migrated", *filename, "to schema version", parser.SchemaVersion, "- original saved as", *backup)
+}
@@ -0,0 +1,278 @@
+// Package metrics keeps counters, gauges and histograms and writes them in
+// the Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the content type of the text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefBuckets are the default histogram buckets, for durations in seconds.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Default is the registry the packages of this module record to.
+var Default = NewRegistry()
+
+const (
+	kindCounter   = "counter"
+	kindGauge     = "gauge"
+	kindHistogram = "histogram"
+)
+
+// Registry holds metric families, each with a series per combination of
+// label values.
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+type family struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+	series  map[string]*series
+}
+
+type series struct {
+	values []string
+	value  float64
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+// register returns the family with name, creating it if needed. It panics
+// if the family was registered with another kind or labels, which is a
+// programming error.
+func (r *Registry) register(name, help, kind string, buckets []float64, labels []string) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if f, exists := r.families[name]; exists {
+		if f.kind != kind || strings.Join(f.labels, ",") != strings.Join(labels, ",") {
+			panic(fmt.Sprintf("metrics: %s registered as %s with labels %v", name, f.kind, f.labels))
+		}
+		return f
+	}
+	f := &family{
+		name:    name,
+		help:    help,
+		kind:    kind,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*series),
+	}
+	r.families[name] = f
+	return f
+}
+
+// with returns the series for label values, creating it if needed. Called
+// with the lock held.
+func (f *family) with(values []string) *series {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s takes labels %v, got %d values", f.name, f.labels, len(values)))
+	}
+	key := strings.Join(values, "\x00")
+	s, exists := f.series[key]
+	if !exists {
+		s = &series{values: append([]string(nil), values...)}
+		if f.kind == kindHistogram {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+func (r *Registry) delete(f *family, values []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(f.series, strings.Join(values, "\x00"))
+}
+
+// Counter is a family of values that only go up.
+type Counter struct {
+	r *Registry
+	f *family
+}
+
+// Counter returns the counter family with name and labels.
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	return &Counter{r, r.register(name, help, kindCounter, nil, labels)}
+}
+
+// Add adds v, which must not be negative, to the series of values.
+func (c *Counter) Add(v float64, values ...string) {
+	c.r.mu.Lock()
+	defer c.r.mu.Unlock()
+	c.f.with(values).value += v
+}
+
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Set sets the series of values, for counters kept elsewhere.
+func (c *Counter) Set(v float64, values ...string) {
+	c.r.mu.Lock()
+	defer c.r.mu.Unlock()
+	c.f.with(values).value = v
+}
+
+// Delete removes the series of values.
+func (c *Counter) Delete(values ...string) {
+	c.r.delete(c.f, values)
+}
+
+// Gauge is a family of values that go up and down.
+type Gauge struct {
+	r *Registry
+	f *family
+}
+
+// Gauge returns the gauge family with name and labels.
+func (r *Registry) Gauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{r, r.register(name, help, kindGauge, nil, labels)}
+}
+
+func (g *Gauge) Set(v float64, values ...string) {
+	g.r.mu.Lock()
+	defer g.r.mu.Unlock()
+	g.f.with(values).value = v
+}
+
+// Delete removes the series of values.
+func (g *Gauge) Delete(values ...string) {
+	g.r.delete(g.f, values)
+}
+
+// Histogram is a family of distributions, counting observations in
+// buckets.
+type Histogram struct {
+	r *Registry
+	f *family
+}
+
+// Histogram returns the histogram family with name, sorted bucket upper
+// bounds and labels.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	return &Histogram{r, r.register(name, help, kindHistogram, buckets, labels)}
+}
+
+func (h *Histogram) Observe(v float64, values ...string) {
+	h.r.mu.Lock()
+	defer h.r.mu.Unlock()
+	s := h.f.with(values)
+	if i := sort.SearchFloat64s(h.f.buckets, v); i < len(s.counts) {
+		s.counts[i]++
+	}
+	s.value++
+	s.sum += v
+}
+
+// Delete removes the series of values.
+func (h *Histogram) Delete(values ...string) {
+	h.r.delete(h.f, values)
+}
+
+// WriteText writes every family with at least one series in the text
+// exposition format, sorted by name and label values.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	names := make([]string, 0, len(r.families))
+	for name, f := range r.families {
+		if len(f.series) > 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	bw := bufio.NewWriter(w)
+	for _, name := range names {
+		f := r.families[name]
+		fmt.Fprintf(bw, "# HELP %s %s\n", name, escapeHelp(f.help))
+		fmt.Fprintf(bw, "# TYPE %s %s\n", name, f.kind)
+		keys := make([]string, 0, len(f.series))
+		for key := range f.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			s := f.series[key]
+			labels := formatLabels(f.labels, s.values)
+			if f.kind != kindHistogram {
+				fmt.Fprintf(bw, "%s%s %s\n", name, labels, formatValue(s.value))
+				continue
+			}
+			var cumulative uint64
+			for i, bound := range f.buckets {
+				cumulative += s.counts[i]
+				fmt.Fprintf(bw, "%s_bucket%s %d\n", name, withLabel(labels, "le", formatValue(bound)), cumulative)
+			}
+			fmt.Fprintf(bw, "%s_bucket%s %s\n", name, withLabel(labels, "le", "+Inf"), formatValue(s.value))
+			fmt.Fprintf(bw, "%s_sum%s %s\n", name, labels, formatValue(s.sum))
+			fmt.Fprintf(bw, "%s_count%s %s\n", name, labels, formatValue(s.value))
+		}
+	}
+	return bw.Flush()
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + `="` + escapeLabel(values[i]) + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// withLabel appends a label to formatted labels.
+func withLabel(labels, name, value string) string {
+	pair := name + `="` + value + `"`
+	if labels == "" {
+		return "{" + pair + "}"
+	}
+	return labels[:len(labels)-1] + "," + pair + "}"
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
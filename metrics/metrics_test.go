@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteText(t *testing.T) {
+	r := NewRegistry()
+	requests := r.Counter("requests_total", "Requests sent.", "method")
+	requests.Inc("eth_call")
+	requests.Add(2, "eth_blockNumber")
+	r.Gauge("lag_blocks", "Blocks behind\nthe head.").Set(3)
+	latency := r.Histogram("latency_seconds", "Request latency.", []float64{0.1, 1}, "method")
+	latency.Observe(0.05, "eth_call")
+	latency.Observe(0.5, "eth_call")
+	latency.Observe(2, "eth_call")
+	r.Counter("unused_total", "Never incremented.")
+
+	var out strings.Builder
+	require.NoError(t, r.WriteText(&out))
+	require.Equal(t, `# HELP lag_blocks Blocks behind\nthe head.
+# TYPE lag_blocks gauge
+lag_blocks 3
+# HELP latency_seconds Request latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{method="eth_call",le="0.1"} 1
+latency_seconds_bucket{method="eth_call",le="1"} 2
+latency_seconds_bucket{method="eth_call",le="+Inf"} 3
+latency_seconds_sum{method="eth_call"} 2.55
+latency_seconds_count{method="eth_call"} 3
+# HELP requests_total Requests sent.
+# TYPE requests_total counter
+requests_total{method="eth_blockNumber"} 2
+requests_total{method="eth_call"} 1
+`, out.String())
+
+	// Deleted series are no longer written, and label values are escaped
+	requests.Delete("eth_call")
+	requests.Delete("eth_blockNumber")
+	r.Counter("requests_total", "Requests sent.", "method").Inc(`a"b\c`)
+	out.Reset()
+	require.NoError(t, r.WriteText(&out))
+	require.Contains(t, out.String(), `requests_total{method="a\"b\\c"} 1`)
+	require.NotContains(t, out.String(), "eth_blockNumber")
+
+	require.Panics(t, func() { r.Gauge("requests_total", "Requests sent.", "method") })
+	require.Panics(t, func() { requests.Inc() })
+}
@@ -0,0 +1,447 @@
+package parser
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AccessStorage is implemented by storages that can persist the tenants and
+// API keys of the HTTP API. Unlike the rest of the data, they are shared by
+// every chain.
+type AccessStorage interface {
+	// LoadAccess returns the stored tenants and keys, empty if none were
+	// saved yet.
+	LoadAccess() (*AccessData, error)
+
+	// SaveAccess replaces the stored tenants and keys.
+	SaveAccess(data *AccessData) error
+}
+
+// AccessData holds the tenants and API keys, keyed by id.
+type AccessData struct {
+	Tenants map[string]*Tenant `json:"tenants"`
+	Keys    map[string]*APIKey `json:"keys"`
+}
+
+func newAccessData() *AccessData {
+	return &AccessData{Tenants: make(map[string]*Tenant), Keys: make(map[string]*APIKey)}
+}
+
+// clone returns a deep copy of the data.
+func (d *AccessData) clone() *AccessData {
+	c := newAccessData()
+	for id, tenant := range d.Tenants {
+		copied := tenant.clone()
+		c.Tenants[id] = &copied
+	}
+	for id, key := range d.Keys {
+		copied := *key
+		c.Keys[id] = &copied
+	}
+	return c
+}
+
+// Tenant is a user of the HTTP API, who only sees the addresses it
+// subscribed.
+type Tenant struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+
+	// Addresses the tenant may subscribe on each chain, unlimited if 0
+	MaxSubscriptions int `json:"maxSubscriptions"`
+
+	// Requests per second allowed for each key of the tenant, unlimited if
+	// 0, and the requests a key may make at once above that rate, which
+	// defaults to the rate
+	RateLimit float64 `json:"rateLimit"`
+	Burst     int     `json:"burst"`
+
+	// Subscribed addresses by chain id
+	Subscriptions map[int64][]string `json:"subscriptions,omitempty"`
+}
+
+func (t *Tenant) clone() Tenant {
+	c := *t
+	c.Subscriptions = make(map[int64][]string, len(t.Subscriptions))
+	for chainID, addresses := range t.Subscriptions {
+		c.Subscriptions[chainID] = slices.Clone(addresses)
+	}
+	return c
+}
+
+// APIKey is a stored API key. Only the SHA-256 hash of the key is kept, the
+// key itself is returned once when it is created.
+type APIKey struct {
+	ID      string    `json:"id"`
+	Hash    string    `json:"hash"`
+	Tenant  string    `json:"tenant,omitempty"`
+	Admin   bool      `json:"admin,omitempty"`
+	Created time.Time `json:"created"`
+}
+
+// apiKeyPrefix starts every API key, which is txp_<key id>_<secret>.
+const apiKeyPrefix = "txp_"
+
+func hashAPIKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+// Access manages the tenants and API keys of the HTTP API, saving every
+// change to its storage.
+type Access struct {
+	storage AccessStorage
+
+	mu   sync.RWMutex
+	data *AccessData
+}
+
+// OpenAccess loads the tenants and keys from storage.
+func OpenAccess(storage AccessStorage) (*Access, error) {
+	data, err := storage.LoadAccess()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load access data: %v", err)
+	}
+	if data.Tenants == nil {
+		data.Tenants = make(map[string]*Tenant)
+	}
+	if data.Keys == nil {
+		data.Keys = make(map[string]*APIKey)
+	}
+	return &Access{storage: storage, data: data}, nil
+}
+
+// update applies fn to a copy of the data and saves it. The change is only
+// kept if fn and saving succeed.
+func (a *Access) update(fn func(data *AccessData) error) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	data := a.data.clone()
+	if err := fn(data); err != nil {
+		return err
+	}
+	if err := a.storage.SaveAccess(data); err != nil {
+		return fmt.Errorf("failed to save access data: %v", err)
+	}
+	a.data = data
+	return nil
+}
+
+func validateTenant(tenant Tenant) error {
+	if tenant.MaxSubscriptions < 0 || tenant.RateLimit < 0 || tenant.Burst < 0 {
+		return fmt.Errorf("%w: limits must not be negative", ErrInvalidTenant)
+	}
+	if strings.ContainsAny(tenant.ID, "/ ") {
+		return fmt.Errorf("%w: id must not contain slashes or spaces", ErrInvalidTenant)
+	}
+	return nil
+}
+
+// CreateTenant adds a tenant, generating an id if it has none. Its
+// subscriptions are ignored.
+func (a *Access) CreateTenant(tenant Tenant) (Tenant, error) {
+	if tenant.ID == "" {
+		tenant.ID = randomHex(8)
+	}
+	if err := validateTenant(tenant); err != nil {
+		return Tenant{}, err
+	}
+	tenant.Subscriptions = nil
+	err := a.update(func(data *AccessData) error {
+		if _, exists := data.Tenants[tenant.ID]; exists {
+			return ErrTenantExists
+		}
+		data.Tenants[tenant.ID] = &tenant
+		return nil
+	})
+	return tenant, err
+}
+
+// UpdateTenant changes the name and limits of a tenant. Lowering its quota
+// keeps the addresses it already subscribed.
+func (a *Access) UpdateTenant(tenant Tenant) (Tenant, error) {
+	if err := validateTenant(tenant); err != nil {
+		return Tenant{}, err
+	}
+	var updated Tenant
+	err := a.update(func(data *AccessData) error {
+		existing, exists := data.Tenants[tenant.ID]
+		if !exists {
+			return ErrTenantNotFound
+		}
+		existing.Name = tenant.Name
+		existing.MaxSubscriptions = tenant.MaxSubscriptions
+		existing.RateLimit = tenant.RateLimit
+		existing.Burst = tenant.Burst
+		updated = *existing
+		return nil
+	})
+	return updated, err
+}
+
+// DeleteTenant removes a tenant and revokes its keys. It returns the
+// removed tenant, whose subscriptions other tenants may no longer hold.
+func (a *Access) DeleteTenant(id string) (Tenant, error) {
+	var removed Tenant
+	err := a.update(func(data *AccessData) error {
+		tenant, exists := data.Tenants[id]
+		if !exists {
+			return ErrTenantNotFound
+		}
+		removed = *tenant
+		delete(data.Tenants, id)
+		for keyID, key := range data.Keys {
+			if key.Tenant == id {
+				delete(data.Keys, keyID)
+			}
+		}
+		return nil
+	})
+	return removed, err
+}
+
+// Tenant returns the tenant with id.
+func (a *Access) Tenant(id string) (Tenant, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	tenant, exists := a.data.Tenants[id]
+	if !exists {
+		return Tenant{}, ErrTenantNotFound
+	}
+	return tenant.clone(), nil
+}
+
+// Tenants returns every tenant, sorted by id.
+func (a *Access) Tenants() []Tenant {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	tenants := make([]Tenant, 0, len(a.data.Tenants))
+	for _, tenant := range a.data.Tenants {
+		tenants = append(tenants, tenant.clone())
+	}
+	sort.Slice(tenants, func(i, j int) bool { return tenants[i].ID < tenants[j].ID })
+	return tenants
+}
+
+// CreateKey creates a key for a tenant, or an admin key, which is not
+// scoped to a tenant. The key is only returned here.
+func (a *Access) CreateKey(tenant string, admin bool) (string, APIKey, error) {
+	if admin == (tenant != "") {
+		return "", APIKey{}, fmt.Errorf("%w: a key belongs to a tenant or is an admin key", ErrInvalidTenant)
+	}
+	id := randomHex(8)
+	secret := apiKeyPrefix + id + "_" + randomHex(32)
+	key := APIKey{
+		ID:      id,
+		Hash:    hashAPIKey(secret),
+		Tenant:  tenant,
+		Admin:   admin,
+		Created: time.Now().UTC().Truncate(time.Second),
+	}
+	err := a.update(func(data *AccessData) error {
+		if _, exists := data.Tenants[tenant]; !admin && !exists {
+			return ErrTenantNotFound
+		}
+		data.Keys[id] = &key
+		return nil
+	})
+	if err != nil {
+		return "", APIKey{}, err
+	}
+	return secret, key, nil
+}
+
+// Keys returns every key, sorted by creation time.
+func (a *Access) Keys() []APIKey {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	keys := make([]APIKey, 0, len(a.data.Keys))
+	for _, key := range a.data.Keys {
+		keys = append(keys, *key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].Created.Equal(keys[j].Created) {
+			return keys[i].Created.Before(keys[j].Created)
+		}
+		return keys[i].ID < keys[j].ID
+	})
+	return keys
+}
+
+// HasAdmin reports whether an admin key exists.
+func (a *Access) HasAdmin() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	for _, key := range a.data.Keys {
+		if key.Admin {
+			return true
+		}
+	}
+	return false
+}
+
+// RevokeKey deletes the key with id.
+func (a *Access) RevokeKey(id string) error {
+	return a.update(func(data *AccessData) error {
+		if _, exists := data.Keys[id]; !exists {
+			return ErrKeyNotFound
+		}
+		delete(data.Keys, id)
+		return nil
+	})
+}
+
+// Authenticate returns the stored key matching key.
+func (a *Access) Authenticate(key string) (APIKey, error) {
+	id, _, found := strings.Cut(strings.TrimPrefix(key, apiKeyPrefix), "_")
+	if !found || !strings.HasPrefix(key, apiKeyPrefix) {
+		return APIKey{}, ErrInvalidAPIKey
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	stored, exists := a.data.Keys[id]
+	if !exists || subtle.ConstantTimeCompare([]byte(stored.Hash), []byte(hashAPIKey(key))) != 1 {
+		return APIKey{}, ErrInvalidAPIKey
+	}
+	return *stored, nil
+}
+
+// Subscribe records that a tenant subscribed address on a chain, within
+// its quota.
+func (a *Access) Subscribe(tenant string, chainID int64, address string) error {
+	return a.update(func(data *AccessData) error {
+		t, exists := data.Tenants[tenant]
+		if !exists {
+			return ErrTenantNotFound
+		}
+		addresses := t.Subscriptions[chainID]
+		if slices.Contains(addresses, address) {
+			return ErrAlreadySubscribed
+		}
+		if t.MaxSubscriptions > 0 && len(addresses) >= t.MaxSubscriptions {
+			return fmt.Errorf("%w: the limit is %d addresses", ErrQuotaExceeded, t.MaxSubscriptions)
+		}
+		if t.Subscriptions == nil {
+			t.Subscriptions = make(map[int64][]string)
+		}
+		t.Subscriptions[chainID] = append(addresses, address)
+		return nil
+	})
+}
+
+// Unsubscribe removes address from the subscriptions of a tenant on a
+// chain. It reports whether no tenant holds the address anymore.
+func (a *Access) Unsubscribe(tenant string, chainID int64, address string) (bool, error) {
+	var orphaned bool
+	err := a.update(func(data *AccessData) error {
+		t, exists := data.Tenants[tenant]
+		if !exists {
+			return ErrTenantNotFound
+		}
+		i := slices.Index(t.Subscriptions[chainID], address)
+		if i < 0 {
+			return ErrNotSubscribed
+		}
+		t.Subscriptions[chainID] = slices.Delete(t.Subscriptions[chainID], i, i+1)
+		orphaned = !held(data, chainID, address)
+		return nil
+	})
+	return orphaned, err
+}
+
+// Release removes address from the subscriptions of every tenant on a
+// chain, after it was unsubscribed from the parser.
+func (a *Access) Release(chainID int64, address string) error {
+	a.mu.RLock()
+	holding := held(a.data, chainID, address)
+	a.mu.RUnlock()
+	if !holding {
+		return nil
+	}
+	return a.update(func(data *AccessData) error {
+		for _, t := range data.Tenants {
+			t.Subscriptions[chainID] = slices.DeleteFunc(t.Subscriptions[chainID], func(s string) bool { return s == address })
+		}
+		return nil
+	})
+}
+
+// Held reports whether any tenant subscribed address on a chain.
+func (a *Access) Held(chainID int64, address string) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return held(a.data, chainID, address)
+}
+
+func held(data *AccessData, chainID int64, address string) bool {
+	for _, t := range data.Tenants {
+		if slices.Contains(t.Subscriptions[chainID], address) {
+			return true
+		}
+	}
+	return false
+}
+
+// Addresses returns the addresses any tenant subscribed on a chain.
+func (a *Access) Addresses(chainID int64) []string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	var addresses []string
+	for _, t := range a.data.Tenants {
+		for _, address := range t.Subscriptions[chainID] {
+			if !slices.Contains(addresses, address) {
+				addresses = append(addresses, address)
+			}
+		}
+	}
+	return addresses
+}
+
+// Subscriptions returns the addresses a tenant subscribed on a chain.
+func (a *Access) Subscriptions(tenant string, chainID int64) []string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if t, exists := a.data.Tenants[tenant]; exists {
+		return slices.Clone(t.Subscriptions[chainID])
+	}
+	return nil
+}
+
+// Owns reports whether a tenant subscribed address on a chain.
+func (a *Access) Owns(tenant string, chainID int64, address string) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	t, exists := a.data.Tenants[tenant]
+	return exists && slices.Contains(t.Subscriptions[chainID], address)
+}
+
+// marshalAccess encodes data for storages that keep it as a document.
+func marshalAccess(data *AccessData) ([]byte, error) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal access data: %v", err)
+	}
+	return payload, nil
+}
+
+// unmarshalAccess decodes data written by marshalAccess, returning empty
+// data for an empty document.
+func unmarshalAccess(payload []byte) (*AccessData, error) {
+	data := newAccessData()
+	if len(payload) == 0 {
+		return data, nil
+	}
+	if err := json.Unmarshal(payload, data); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal access data: %v", err)
+	}
+	return data, nil
+}
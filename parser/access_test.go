@@ -0,0 +1,177 @@
+package parser
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccess(t *testing.T) {
+	storage := NewMemoryStorage(RetentionPolicy{})
+	access, err := OpenAccess(storage)
+	require.NoError(t, err)
+	require.False(t, access.HasAdmin())
+
+	_, err = access.CreateTenant(Tenant{ID: "acme", MaxSubscriptions: -1})
+	require.ErrorIs(t, err, ErrInvalidTenant)
+	tenant, err := access.CreateTenant(Tenant{ID: "acme", Name: "Acme", MaxSubscriptions: 2})
+	require.NoError(t, err)
+	require.Equal(t, "Acme", tenant.Name)
+	_, err = access.CreateTenant(Tenant{ID: "acme"})
+	require.ErrorIs(t, err, ErrTenantExists)
+	other, err := access.CreateTenant(Tenant{})
+	require.NoError(t, err)
+	require.NotEmpty(t, other.ID)
+
+	// Keys are returned once and only their hash is stored
+	secret, key, err := access.CreateKey("acme", false)
+	require.NoError(t, err)
+	require.NotContains(t, key.Hash, secret)
+	_, _, err = access.CreateKey("unknown", false)
+	require.ErrorIs(t, err, ErrTenantNotFound)
+	_, _, err = access.CreateKey("acme", true)
+	require.ErrorIs(t, err, ErrInvalidTenant)
+	admin, _, err := access.CreateKey("", true)
+	require.NoError(t, err)
+	require.True(t, access.HasAdmin())
+
+	authenticated, err := access.Authenticate(secret)
+	require.NoError(t, err)
+	require.Equal(t, key, authenticated)
+	_, err = access.Authenticate(secret[:len(secret)-1] + "x")
+	require.ErrorIs(t, err, ErrInvalidAPIKey)
+	_, err = access.Authenticate("secret")
+	require.ErrorIs(t, err, ErrInvalidAPIKey)
+
+	// Subscriptions count against the quota of each chain
+	require.NoError(t, access.Subscribe("acme", 1, "alice"))
+	require.ErrorIs(t, access.Subscribe("acme", 1, "alice"), ErrAlreadySubscribed)
+	require.NoError(t, access.Subscribe("acme", 1, "bob"))
+	require.ErrorIs(t, access.Subscribe("acme", 1, "carol"), ErrQuotaExceeded)
+	require.NoError(t, access.Subscribe("acme", 5, "carol"))
+	require.NoError(t, access.Subscribe(other.ID, 1, "bob"))
+	require.Equal(t, []string{"alice", "bob"}, access.Subscriptions("acme", 1))
+	require.ElementsMatch(t, []string{"alice", "bob"}, access.Addresses(1))
+	require.True(t, access.Owns("acme", 5, "carol"))
+	require.False(t, access.Owns(other.ID, 1, "alice"))
+
+	orphaned, err := access.Unsubscribe("acme", 1, "bob")
+	require.NoError(t, err)
+	require.False(t, orphaned)
+	orphaned, err = access.Unsubscribe("acme", 1, "alice")
+	require.NoError(t, err)
+	require.True(t, orphaned)
+	_, err = access.Unsubscribe("acme", 1, "alice")
+	require.ErrorIs(t, err, ErrNotSubscribed)
+	require.NoError(t, access.Release(1, "bob"))
+	require.False(t, access.Held(1, "bob"))
+
+	// The data survives reopening the storage
+	reopened, err := OpenAccess(storage)
+	require.NoError(t, err)
+	require.Equal(t, access.Tenants(), reopened.Tenants())
+	require.Equal(t, access.Keys(), reopened.Keys())
+	_, err = reopened.Authenticate(admin)
+	require.NoError(t, err)
+
+	// Deleting a tenant revokes its keys
+	_, err = access.DeleteTenant("acme")
+	require.NoError(t, err)
+	_, err = access.Authenticate(secret)
+	require.ErrorIs(t, err, ErrInvalidAPIKey)
+	require.Len(t, access.Keys(), 1)
+	require.ErrorIs(t, access.RevokeKey(key.ID), ErrKeyNotFound)
+}
+
+func TestAccessStorages(t *testing.T) {
+	dir := t.TempDir()
+	storages := map[string]func() AccessStorage{
+		"json": func() AccessStorage { return &JsonFileStorage{FilePath: filepath.Join(dir, "data.json")} },
+		"log":  func() AccessStorage { return openLogStorage(t, filepath.Join(dir, "log"), testLogStorageOptions()) },
+		"kv":   func() AccessStorage { return openKVStorage(t, filepath.Join(dir, "kv")) },
+		"sql":  func() AccessStorage { return openSQLiteStorage(t, filepath.Join(dir, "data.db")) },
+		"encrypted": func() AccessStorage {
+			return NewEncryptedStorage(NewMemoryStorage(RetentionPolicy{}), testKeyring(t, testKey("k1", 1)))
+		},
+	}
+	for name, open := range storages {
+		t.Run(name, func(t *testing.T) {
+			storage := open()
+			data, err := storage.LoadAccess()
+			require.NoError(t, err)
+			require.Empty(t, data.Tenants)
+
+			access, err := OpenAccess(storage)
+			require.NoError(t, err)
+			_, err = access.CreateTenant(Tenant{ID: "acme", Name: "Acme", MaxSubscriptions: 3, RateLimit: 2.5, Burst: 5})
+			require.NoError(t, err)
+			require.NoError(t, access.Subscribe("acme", 1, "alice"))
+			require.NoError(t, access.Subscribe("acme", 1, "bob"))
+			require.NoError(t, access.Subscribe("acme", 5, "alice"))
+			secret, _, err := access.CreateKey("acme", false)
+			require.NoError(t, err)
+
+			reopened, err := OpenAccess(storage)
+			require.NoError(t, err)
+			require.Equal(t, access.Tenants(), reopened.Tenants())
+			require.Equal(t, access.Keys(), reopened.Keys())
+			_, err = reopened.Authenticate(secret)
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestAccessSharedByChains(t *testing.T) {
+	dir := t.TempDir()
+	storages := map[string]func(chainID int64) AccessStorage{
+		"log": func(chainID int64) AccessStorage {
+			storage := openLogStorage(t, filepath.Join(dir, "log"), testLogStorageOptions())
+			storage.SetChainID(chainID)
+			return storage
+		},
+		"kv": func(chainID int64) AccessStorage {
+			storage := openKVStorage(t, filepath.Join(dir, "kv"))
+			storage.SetChainID(chainID)
+			return storage
+		},
+	}
+	for name, open := range storages {
+		t.Run(name, func(t *testing.T) {
+			access, err := OpenAccess(open(1))
+			require.NoError(t, err)
+			_, err = access.CreateTenant(Tenant{ID: "acme"})
+			require.NoError(t, err)
+
+			// Listing the chains in another order keeps the tenants
+			reopened, err := OpenAccess(open(5))
+			require.NoError(t, err)
+			require.Equal(t, access.Tenants(), reopened.Tenants())
+		})
+	}
+}
+
+func TestKVStorageLegacyAccess(t *testing.T) {
+	dir := t.TempDir()
+	storage := openKVStorage(t, dir)
+	payload, err := marshalAccess(&AccessData{Tenants: map[string]*Tenant{"acme": {ID: "acme"}}})
+	require.NoError(t, err)
+	require.NoError(t, storage.open())
+	require.NoError(t, storage.db.Put([]byte(kvAccessKey), payload))
+
+	// Tenants kept in the chain's store are read until they are saved again
+	access, err := OpenAccess(storage)
+	require.NoError(t, err)
+	require.Len(t, access.Tenants(), 1)
+	_, err = access.CreateTenant(Tenant{ID: "other"})
+	require.NoError(t, err)
+	_, found, err := storage.db.Get([]byte(kvAccessKey))
+	require.NoError(t, err)
+	require.False(t, found)
+
+	other := openKVStorage(t, dir)
+	other.SetChainID(5)
+	reopened, err := OpenAccess(other)
+	require.NoError(t, err)
+	require.Len(t, reopened.Tenants(), 2)
+}
@@ -0,0 +1,36 @@
+package parser
+
+import "context"
+
+// LegacyAdapter exposes a ParserV2 through the original Parser interface.
+// Errors are reported the way the original interface did: false from
+// Subscribe and nil from GetTransactions.
+type LegacyAdapter struct {
+	parser ParserV2
+}
+
+var _ Parser = &LegacyAdapter{}
+
+func NewLegacyAdapter(p ParserV2) *LegacyAdapter {
+	return &LegacyAdapter{parser: p}
+}
+
+func (a *LegacyAdapter) GetCurrentBlock() int {
+	blockNumber, err := a.parser.GetCurrentBlock(context.Background())
+	if err != nil {
+		return 0
+	}
+	return int(blockNumber)
+}
+
+func (a *LegacyAdapter) Subscribe(address string) bool {
+	return a.parser.Subscribe(context.Background(), address) == nil
+}
+
+func (a *LegacyAdapter) GetTransactions(address string) []Transaction {
+	transactions, err := a.parser.GetTransactions(context.Background(), address)
+	if err != nil {
+		return nil
+	}
+	return transactions
+}
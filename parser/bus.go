@@ -0,0 +1,334 @@
+package parser
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Types of parser events.
+const (
+	// A transaction was recorded for a subscribed address
+	TxDetected = "transaction"
+
+	// A recorded transaction reached the confirmation depth
+	TxConfirmed = "confirmation"
+
+	// A recorded transaction was removed by a chain reorganization
+	TxRemoved = "removal"
+
+	// A block was processed
+	BlockProcessed = "block"
+
+	// The parser is further behind the chain head than its lag threshold
+	ParserLagging = "lagging"
+)
+
+// TransactionEvents are the types of the events about a transaction.
+var TransactionEvents = []string{TxDetected, TxConfirmed, TxRemoved}
+
+const (
+	// DefaultLagThreshold is how many blocks a parser may be behind the
+	// chain head before it reports lagging.
+	DefaultLagThreshold = 100
+
+	// DefaultSinkBuffer is how many events are buffered for a sink.
+	DefaultSinkBuffer = 1024
+
+	// maxSinkBatch limits how many buffered events a sink handles at once.
+	maxSinkBatch = 256
+)
+
+// Event is something that happened in a parser. The fields used depend on
+// the type.
+type Event struct {
+	Type    string    `json:"type"`
+	ChainID int64     `json:"chainId"`
+	Time    time.Time `json:"time"`
+
+	// Transaction events
+	Address       string       `json:"address,omitempty"`
+	Transaction   *Transaction `json:"transaction,omitempty"`
+	Confirmations int64        `json:"confirmations,omitempty"`
+
+	// BlockProcessed: the block and how many transactions were recorded in
+	// it. ParserLagging: the current block and the chain head.
+	Block        int64  `json:"block,omitempty"`
+	BlockHash    string `json:"blockHash,omitempty"`
+	Transactions int    `json:"transactions,omitempty"`
+	Head         int64  `json:"head,omitempty"`
+}
+
+// Sink receives the events of an EventBus.
+type Sink interface {
+	// Handle delivers a batch of events, in the order they were published.
+	// Failed events are counted and logged, not retried.
+	Handle(events []Event) error
+}
+
+// SinkFunc adapts a function to a Sink.
+type SinkFunc func(events []Event) error
+
+func (f SinkFunc) Handle(events []Event) error {
+	return f(events)
+}
+
+// Backpressure is what happens to an event published while the buffer of a
+// sink is full.
+type Backpressure int
+
+const (
+	// The publisher waits for room, slowing down the parser
+	BackpressureBlock Backpressure = iota
+
+	// The event is dropped for this sink
+	BackpressureDropNewest
+
+	// The oldest buffered event is dropped to make room
+	BackpressureDropOldest
+)
+
+// EventFilter selects events. Empty fields match everything, events without
+// an address do not match an address filter.
+type EventFilter struct {
+	Types     []string
+	Addresses []string
+}
+
+func (f EventFilter) Match(event Event) bool {
+	if len(f.Types) > 0 && !slices.Contains(f.Types, event.Type) {
+		return false
+	}
+	return len(f.Addresses) == 0 || slices.Contains(f.Addresses, event.Address)
+}
+
+// SinkOptions configures how a sink is fed.
+type SinkOptions struct {
+	// Name in logs and stats
+	Name string
+
+	Filter       EventFilter
+	Backpressure Backpressure
+
+	// Events buffered for the sink, DefaultSinkBuffer if 0
+	Buffer int
+}
+
+// SinkStats counts the events of a sink.
+type SinkStats struct {
+	Name      string
+	Delivered uint64
+	Failed    uint64
+	Dropped   uint64
+}
+
+// EventBus fans the events published by a parser out to sinks. Each sink is
+// fed from its own buffer by its own goroutine, so a slow sink only holds
+// back the parser if its backpressure policy says so. Sinks must not wait
+// on the parser.
+type EventBus struct {
+	mu    sync.RWMutex
+	sinks []*SinkRegistration
+}
+
+// SinkRegistration is a sink registered with an EventBus.
+type SinkRegistration struct {
+	bus     *EventBus
+	sink    Sink
+	options SinkOptions
+	c       chan Event
+	done    chan struct{}
+
+	// Held to send to c, and to close it
+	sendMu sync.RWMutex
+	closed bool
+
+	mu    sync.Mutex
+	stats SinkStats
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{}
+}
+
+// Register starts feeding the events matching options.Filter to sink.
+func (b *EventBus) Register(sink Sink, options SinkOptions) *SinkRegistration {
+	if options.Buffer <= 0 {
+		options.Buffer = DefaultSinkBuffer
+	}
+	r := &SinkRegistration{
+		bus:     b,
+		sink:    sink,
+		options: options,
+		c:       make(chan Event, options.Buffer),
+		done:    make(chan struct{}),
+		stats:   SinkStats{Name: options.Name},
+	}
+	go r.run()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sinks = append(b.sinks, r)
+	return r
+}
+
+// Publish sends events to the sinks whose filter they match. Each sink
+// gets its own copy of the transaction. A sink that blocks only holds up
+// the publisher, not the registration of other sinks.
+func (b *EventBus) Publish(events ...Event) {
+	b.mu.RLock()
+	sinks := slices.Clone(b.sinks)
+	b.mu.RUnlock()
+
+	now := time.Now()
+	for _, event := range events {
+		if event.Time.IsZero() {
+			event.Time = now
+		}
+		for _, r := range sinks {
+			if r.options.Filter.Match(event) {
+				sent := event
+				if event.Transaction != nil {
+					tx := *event.Transaction
+					sent.Transaction = &tx
+				}
+				r.send(sent)
+			}
+		}
+	}
+}
+
+// Stats returns the counters of the registered sinks.
+func (b *EventBus) Stats() []SinkStats {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	stats := make([]SinkStats, 0, len(b.sinks))
+	for _, r := range b.sinks {
+		stats = append(stats, r.Stats())
+	}
+	return stats
+}
+
+// Close unregisters every sink, once they handled their buffered events.
+func (b *EventBus) Close() {
+	b.mu.RLock()
+	sinks := slices.Clone(b.sinks)
+	b.mu.RUnlock()
+	for _, r := range sinks {
+		r.Close()
+	}
+}
+
+// send buffers event, applying the backpressure policy if the buffer is
+// full. Events sent after Close are dropped.
+func (r *SinkRegistration) send(event Event) {
+	r.sendMu.RLock()
+	defer r.sendMu.RUnlock()
+	if r.closed {
+		return
+	}
+
+	select {
+	case r.c <- event:
+		return
+	default:
+	}
+	switch r.options.Backpressure {
+	case BackpressureBlock:
+		r.c <- event
+		return
+	case BackpressureDropOldest:
+		select {
+		case <-r.c:
+			r.count(0, 0, 1)
+		default:
+		}
+		select {
+		case r.c <- event:
+			return
+		default:
+		}
+	}
+	r.count(0, 0, 1)
+}
+
+func (r *SinkRegistration) count(delivered, failed, dropped int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stats.Delivered += uint64(delivered)
+	r.stats.Failed += uint64(failed)
+	r.stats.Dropped += uint64(dropped)
+}
+
+// run hands the buffered events to the sink in batches.
+func (r *SinkRegistration) run() {
+	defer close(r.done)
+	for event := range r.c {
+		batch := []Event{event}
+	fill:
+		for len(batch) < maxSinkBatch {
+			select {
+			case event, ok := <-r.c:
+				if !ok {
+					break fill
+				}
+				batch = append(batch, event)
+			default:
+				break fill
+			}
+		}
+		if err := r.sink.Handle(batch); err != nil {
+			fmt.Printf("Error delivering %d events to sink %s: %v\n", len(batch), r.options.Name, err)
+			r.count(0, len(batch), 0)
+		} else {
+			r.count(len(batch), 0, 0)
+		}
+	}
+}
+
+// Stats returns the counters of the sink.
+func (r *SinkRegistration) Stats() SinkStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.stats
+}
+
+// Close unregisters the sink and waits until it handled its buffered
+// events. Later calls do nothing.
+func (r *SinkRegistration) Close() {
+	r.bus.mu.Lock()
+	if i := slices.Index(r.bus.sinks, r); i >= 0 {
+		r.bus.sinks = slices.Delete(r.bus.sinks, i, i+1)
+	}
+	r.bus.mu.Unlock()
+
+	// A blocked send holds the lock until the sink makes room
+	r.sendMu.Lock()
+	if !r.closed {
+		r.closed = true
+		close(r.c)
+	}
+	r.sendMu.Unlock()
+	<-r.done
+}
+
+// LogSink prints transaction and lagging events.
+type LogSink struct{}
+
+func (LogSink) Handle(events []Event) error {
+	for _, event := range events {
+		switch event.Type {
+		case TxDetected:
+			fmt.Printf("Transaction found for address: %s; Hash: %s; Block: %d\n", event.Address, event.Transaction.Txhash, blockNumber(*event.Transaction))
+		case TxConfirmed:
+			fmt.Printf("Transaction confirmed for address: %s; Hash: %s; Confirmations: %d\n", event.Address, event.Transaction.Txhash, event.Confirmations)
+		case TxRemoved:
+			fmt.Printf("Transaction removed by reorganization for address: %s; Hash: %s\n", event.Address, event.Transaction.Txhash)
+		case ParserLagging:
+			fmt.Printf("Parser is lagging: block %d, chain head %d\n", event.Block, event.Head)
+		}
+	}
+	return nil
+}
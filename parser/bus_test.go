@@ -0,0 +1,188 @@
+package parser
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// collector is a sink recording the events it handles.
+type collector struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (c *collector) Handle(events []Event) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.events = append(c.events, events...)
+	return nil
+}
+
+func (c *collector) types() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var types []string
+	for _, event := range c.events {
+		types = append(types, event.Type)
+	}
+	return types
+}
+
+func TestEventBus(t *testing.T) {
+	bus := NewEventBus()
+	all := &collector{}
+	bob := &collector{}
+	bus.Register(all, SinkOptions{Name: "all"})
+	bus.Register(bob, SinkOptions{Name: "bob", Filter: EventFilter{Types: []string{TxDetected}, Addresses: []string{"bob"}}})
+	failing := bus.Register(SinkFunc(func(events []Event) error { return errors.New("unavailable") }), SinkOptions{Name: "failing"})
+
+	bus.Publish(
+		Event{Type: TxDetected, Address: "alice"},
+		Event{Type: TxDetected, Address: "bob"},
+		Event{Type: TxRemoved, Address: "bob"},
+		Event{Type: BlockProcessed, Block: 1},
+	)
+	bus.Close()
+	require.Equal(t, []string{TxDetected, TxDetected, TxRemoved, BlockProcessed}, all.types())
+	require.Len(t, bob.events, 1)
+	require.Equal(t, "bob", bob.events[0].Address)
+	require.False(t, all.events[0].Time.IsZero())
+	require.Equal(t, SinkStats{Name: "failing", Failed: 4}, failing.Stats())
+
+	// Closed sinks no longer receive events
+	bus.Publish(Event{Type: TxDetected})
+	require.Len(t, all.events, 4)
+	require.Empty(t, bus.Stats())
+}
+
+func TestEventBusBackpressure(t *testing.T) {
+	for _, test := range []struct {
+		name         string
+		backpressure Backpressure
+		handled      []int64
+		dropped      uint64
+	}{
+		{"drop newest", BackpressureDropNewest, []int64{1, 2}, 2},
+		{"drop oldest", BackpressureDropOldest, []int64{1, 4}, 2},
+		{"block", BackpressureBlock, []int64{1, 2, 3, 4}, 0},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			started := make(chan struct{})
+			release := make(chan struct{})
+			var handled []int64
+			bus := NewEventBus()
+			r := bus.Register(SinkFunc(func(events []Event) error {
+				if len(handled) == 0 {
+					close(started)
+					<-release
+				}
+				for _, event := range events {
+					handled = append(handled, event.Block)
+				}
+				return nil
+			}), SinkOptions{Name: test.name, Buffer: 1, Backpressure: test.backpressure})
+
+			// The first event is handled while the others wait for room
+			bus.Publish(Event{Block: 1})
+			<-started
+			published := make(chan struct{})
+			go func() {
+				bus.Publish(Event{Block: 2}, Event{Block: 3}, Event{Block: 4})
+				close(published)
+			}()
+			if test.backpressure == BackpressureBlock {
+				select {
+				case <-published:
+					t.Fatal("publishing did not wait for the sink")
+				case <-time.After(50 * time.Millisecond):
+				}
+			} else {
+				<-published
+			}
+			close(release)
+			<-published
+			r.Close()
+			require.Equal(t, test.handled, handled)
+			require.Equal(t, test.dropped, r.Stats().Dropped)
+			require.Equal(t, uint64(len(test.handled)), r.Stats().Delivered)
+		})
+	}
+}
+
+func TestParserEvents(t *testing.T) {
+	addresses := testAddresses(2)
+	rpc := newFakeRPC(t, addresses)
+	p := NewParser(nil, 0)
+	p.SetLagThreshold(10)
+	require.NoError(t, p.Subscribe(context.Background(), addresses[0]))
+	events := &collector{}
+	r := p.Events().Register(events, SinkOptions{Name: "test"})
+
+	_, err := p.ProcessBlock(1, rpc.URL)
+	require.NoError(t, err)
+	p.checkLag(11)
+	p.checkLag(12)
+	r.Close()
+
+	require.Equal(t, []string{TxDetected, BlockProcessed, ParserLagging}, events.types())
+	require.Equal(t, addresses[0], events.events[0].Address)
+	require.Equal(t, "0x1-0", events.events[0].Transaction.Txhash)
+	require.Equal(t, Event{Type: BlockProcessed, Time: events.events[1].Time, Block: 1, BlockHash: "0xblock0x1", Transactions: 1}, events.events[1])
+	require.Equal(t, int64(1), events.events[2].Block)
+	require.Equal(t, int64(12), events.events[2].Head)
+}
+
+func TestEventBusIsolation(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	bus := NewEventBus()
+	blocked := bus.Register(SinkFunc(func(events []Event) error {
+		select {
+		case <-started:
+		default:
+			close(started)
+			<-release
+		}
+		return nil
+	}), SinkOptions{Name: "blocked", Buffer: 1, Backpressure: BackpressureBlock})
+	bus.Register(SinkFunc(func(events []Event) error {
+		for _, event := range events {
+			event.Transaction.Txhash = "mutated"
+		}
+		return nil
+	}), SinkOptions{Name: "mutating"})
+
+	// A publisher waiting for a blocked sink does not hold up registration
+	tx := &Transaction{Txhash: "0x1"}
+	bus.Publish(Event{Type: TxDetected, Transaction: tx})
+	<-started
+	published := make(chan struct{})
+	go func() {
+		bus.Publish(Event{Type: TxDetected, Transaction: tx}, Event{Type: TxDetected, Transaction: tx})
+		close(published)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	other := &collector{}
+	registered := make(chan struct{})
+	go func() {
+		bus.Register(other, SinkOptions{Name: "other"}).Close()
+		close(registered)
+	}()
+	select {
+	case <-registered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("registration waited for a blocked sink")
+	}
+	close(release)
+	<-published
+	blocked.Close()
+	bus.Close()
+
+	// Sinks get their own copy of the transaction
+	require.Equal(t, "0x1", tx.Txhash)
+}
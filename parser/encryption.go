@@ -0,0 +1,562 @@
+package parser
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/EliasManj/tx-parser/utils"
+)
+
+// KeyringEnv is the environment variable LoadKeyring reads keys from when
+// no key file is given.
+const KeyringEnv = "TX_PARSER_ENCRYPTION_KEYS"
+
+// Keyring holds the AES-256 keys of an EncryptedStorage. Values are
+// encrypted with the primary key, the other keys are only used to read
+// values written before a key rotation.
+type Keyring struct {
+	primary string
+	keys    map[string]*encryptionKey
+}
+
+type encryptionKey struct {
+	aead     cipher.AEAD
+	nonceKey []byte
+}
+
+// ParseKeyring reads keys written as "<id>:<base64 key>", separated by
+// newlines or commas. The first key is the primary key. Blank lines and
+// lines starting with # are ignored.
+func ParseKeyring(text string) (*Keyring, error) {
+	keyring := &Keyring{keys: make(map[string]*encryptionKey)}
+	entries := strings.FieldsFunc(text, func(r rune) bool { return r == '\n' || r == ',' })
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		id, encoded, found := strings.Cut(entry, ":")
+		if !found || id == "" {
+			return nil, errors.New("keys must be written as <id>:<base64 key>")
+		}
+		if _, exists := keyring.keys[id]; exists {
+			return nil, fmt.Errorf("duplicate key id %s", id)
+		}
+		secret, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode key %s: %v", id, err)
+		}
+		if len(secret) != 32 {
+			return nil, fmt.Errorf("key %s must be 32 bytes, got %d", id, len(secret))
+		}
+		key, err := newEncryptionKey(secret)
+		if err != nil {
+			return nil, err
+		}
+		keyring.keys[id] = key
+		if keyring.primary == "" {
+			keyring.primary = id
+		}
+	}
+	if keyring.primary == "" {
+		return nil, errors.New("no keys found")
+	}
+	return keyring, nil
+}
+
+// LoadKeyring parses the keys in path, or in KeyringEnv if path is empty.
+// It returns nil if neither is set.
+func LoadKeyring(path string) (*Keyring, error) {
+	if path == "" {
+		text := os.Getenv(KeyringEnv)
+		if text == "" {
+			return nil, nil
+		}
+		return ParseKeyring(text)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %v", err)
+	}
+	return ParseKeyring(string(data))
+}
+
+// newEncryptionKey derives separate keys for encryption and nonces.
+func newEncryptionKey(secret []byte) (*encryptionKey, error) {
+	derive := func(label string) []byte {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(label))
+		return mac.Sum(nil)
+	}
+	block, err := aes.NewCipher(derive("tx-parser encryption"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %v", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %v", err)
+	}
+	return &encryptionKey{aead: aead, nonceKey: derive("tx-parser nonce")}, nil
+}
+
+const encryptedPrefix = "enc:"
+
+// encrypt seals plaintext with the primary key as "enc:<key id>:<base64>".
+// The nonce is derived from the context and plaintext, so equal values in
+// the same context encrypt equally and backends can still match addresses.
+// The context is authenticated, so a value can't be moved to a field of
+// another kind.
+func (k *Keyring) encrypt(context, plaintext string) string {
+	key := k.keys[k.primary]
+	mac := hmac.New(sha256.New, key.nonceKey)
+	mac.Write([]byte(context))
+	mac.Write([]byte{0})
+	mac.Write([]byte(plaintext))
+	nonce := mac.Sum(nil)[:key.aead.NonceSize()]
+
+	sealed := key.aead.Seal(nonce, nonce, []byte(plaintext), []byte(context))
+	return encryptedPrefix + k.primary + ":" + base64.RawStdEncoding.EncodeToString(sealed)
+}
+
+// decrypt opens a value written by encrypt and returns the id of the key
+// it was encrypted with. Plaintext values are returned with an empty key id
+// if allowPlaintext is set.
+func (k *Keyring) decrypt(context, value string, allowPlaintext bool) (string, string, error) {
+	rest, found := strings.CutPrefix(value, encryptedPrefix)
+	if !found {
+		if allowPlaintext {
+			return value, "", nil
+		}
+		return "", "", errors.New("value is not encrypted")
+	}
+	id, encoded, found := strings.Cut(rest, ":")
+	if !found {
+		return "", "", errors.New("malformed encrypted value")
+	}
+	key, exists := k.keys[id]
+	if !exists {
+		return "", "", fmt.Errorf("unknown key id %s", id)
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < key.aead.NonceSize() {
+		return "", "", errors.New("malformed encrypted value")
+	}
+	nonce, ciphertext := sealed[:key.aead.NonceSize()], sealed[key.aead.NonceSize():]
+	plaintext, err := key.aead.Open(nil, nonce, ciphertext, []byte(context))
+	if err != nil {
+		return "", "", fmt.Errorf("failed to decrypt %s: %v", context, err)
+	}
+	return string(plaintext), id, nil
+}
+
+// EncryptedStorage encrypts subscriptions and transactions with AES-GCM
+// before passing them to another Storage. Block numbers are kept in
+// plaintext since backends index by them.
+//
+// The encrypted hash of each transaction also holds a digest of its other
+// fields and the hash of the transaction stored before it for the same
+// address, and is bound to that address. Loading fails if a transaction was
+// modified, moved to another address, reordered, replayed or removed from
+// the middle of a list. Removing the newest transactions looks like a
+// reorganization and removing the oldest like pruning, so neither is
+// detected, and neither is a change of the last processed block.
+//
+// As the same transaction encrypts differently for every address, backends
+// can't look transactions up by hash or store a transaction shared by
+// several addresses once. Each address keeps its own copy.
+//
+// To rotate keys, put a new key first in the keyring and keep the old ones
+// after it. Load re-encrypts everything written with an older key, after
+// which the old keys can be removed.
+type EncryptedStorage struct {
+	inner Storage
+	keys  *Keyring
+
+	mu    sync.Mutex
+	tails map[string]string // hash of the last stored transaction by address
+
+	// Accept and encrypt plaintext data on Load, to enable encryption for
+	// existing data. Otherwise plaintext values are rejected as tampered.
+	AllowPlaintext bool
+}
+
+var (
+	_ Storage     = &EncryptedStorage{}
+	_ ChainScoped = &EncryptedStorage{}
+	_ Pruner      = &EncryptedStorage{}
+	_ Reverter    = &EncryptedStorage{}
+
+	_ AccessStorage = &EncryptedStorage{}
+)
+
+func NewEncryptedStorage(inner Storage, keys *Keyring) *EncryptedStorage {
+	return &EncryptedStorage{inner: inner, keys: keys, tails: make(map[string]string)}
+}
+
+func (s *EncryptedStorage) SetChainID(chainID int64) {
+	if scoped, ok := s.inner.(ChainScoped); ok {
+		scoped.SetChainID(chainID)
+	}
+}
+
+func (s *EncryptedStorage) Display() string {
+	return fmt.Sprintf("Encrypted %s (key %s)", s.inner.Display(), s.keys.primary)
+}
+
+const addressContext = "address"
+
+type encryptedField struct {
+	context string
+	value   *string
+
+	// Added after encryption was introduced, so older records leave it empty
+	optional bool
+}
+
+// transactionFields lists the encrypted fields of tx with their contexts,
+// except the hash, which is sealed with the whole record. Addresses share a
+// context so backends can compare them.
+func transactionFields(tx *Transaction) []encryptedField {
+	return []encryptedField{
+		{context: "blockhash", value: &tx.Blockhash},
+		{context: addressContext, value: &tx.From},
+		{context: addressContext, value: &tx.To},
+		{context: "value", value: &tx.Value, optional: true},
+		{context: "txtype", value: &tx.Txtype},
+		{context: "gasUsed", value: &tx.GasUsed},
+		{context: "gasPrice", value: &tx.GasPrice},
+		{context: addressContext, value: &tx.ContractAddress},
+		{context: "nonce", value: &tx.Nonce},
+		{context: "status", value: &tx.Status, optional: true},
+	}
+}
+
+// recordContext is the context of the encrypted hash of a transaction
+// stored for address.
+func recordContext(address string) string {
+	return "txhash\x00" + address
+}
+
+// recordDigest hashes the plaintext fields of tx other than its hash.
+func recordDigest(tx Transaction) string {
+	digest := sha256.New()
+	for _, value := range []string{tx.Blockhash, tx.BlockNumber, tx.From, tx.To, tx.Value, tx.Txtype,
+		tx.GasUsed, tx.GasPrice, tx.ContractAddress, tx.Nonce, tx.Status} {
+		digest.Write([]byte(value))
+		digest.Write([]byte{0})
+	}
+	return hex.EncodeToString(digest.Sum(nil))
+}
+
+// encryptTransactions encrypts the transactions of address stored after the
+// one with hash previous, and returns the hash of the last of them.
+func (s *EncryptedStorage) encryptTransactions(address, previous string, txs []Transaction) ([]Transaction, string) {
+	encrypted := make([]Transaction, len(txs))
+	for i, tx := range txs {
+		record := strings.Join([]string{tx.Txhash, previous, recordDigest(tx)}, "\x00")
+		previous = tx.Txhash
+		for _, field := range transactionFields(&tx) {
+			if field.optional && *field.value == "" {
+				continue
+			}
+			*field.value = s.keys.encrypt(field.context, *field.value)
+		}
+		tx.Txhash = s.keys.encrypt(recordContext(address), record)
+		encrypted[i] = tx
+	}
+	return encrypted, previous
+}
+
+// decryptTransactions decrypts a list of transactions of address, checking
+// each against its digest and the transaction before it. It also reports
+// whether any value was not encrypted with the primary key.
+func (s *EncryptedStorage) decryptTransactions(address string, txs []Transaction) ([]Transaction, bool, error) {
+	decrypted := make([]Transaction, len(txs))
+	stale := false
+	for i, tx := range txs {
+		record, id, err := s.keys.decrypt(recordContext(address), tx.Txhash, s.AllowPlaintext)
+		if err != nil {
+			return nil, false, err
+		}
+		stale = stale || id != s.keys.primary
+		for _, field := range transactionFields(&tx) {
+			if field.optional && *field.value == "" {
+				continue
+			}
+			value, id, err := s.keys.decrypt(field.context, *field.value, s.AllowPlaintext)
+			if err != nil {
+				return nil, false, err
+			}
+			*field.value = value
+			stale = stale || id != s.keys.primary
+		}
+		if id == "" {
+			// Plaintext data has nothing to check yet
+			decrypted[i] = tx
+			continue
+		}
+
+		parts := strings.Split(record, "\x00")
+		if len(parts) != 3 {
+			return nil, false, errors.New("malformed encrypted transaction")
+		}
+		tx.Txhash = parts[0]
+		if parts[2] != recordDigest(tx) {
+			return nil, false, fmt.Errorf("transaction %s was modified", tx.Txhash)
+		}
+		if i > 0 && parts[1] != decrypted[i-1].Txhash {
+			return nil, false, fmt.Errorf("transactions before %s were reordered or removed", tx.Txhash)
+		}
+		decrypted[i] = tx
+	}
+	return decrypted, stale, nil
+}
+
+// tail returns the hash of the last stored transaction of address. It must
+// be called with s.mu held.
+func (s *EncryptedStorage) tail(address string) (string, error) {
+	if hash, exists := s.tails[address]; exists {
+		return hash, nil
+	}
+	txs, err := s.GetTransactions(address, 0, 0)
+	if err != nil {
+		return "", err
+	}
+	hash := ""
+	if len(txs) > 0 {
+		hash = txs[len(txs)-1].Txhash
+	}
+	s.tails[address] = hash
+	return hash, nil
+}
+
+func (s *EncryptedStorage) AppendBlock(blockNumber int64, transactions map[string][]Transaction) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	encrypted := make(map[string][]Transaction, len(transactions))
+	tails := make(map[string]string, len(transactions))
+	for address, txs := range transactions {
+		previous, err := s.tail(address)
+		if err != nil {
+			return err
+		}
+		encrypted[s.keys.encrypt(addressContext, address)], tails[address] = s.encryptTransactions(address, previous, txs)
+	}
+	if err := s.inner.AppendBlock(blockNumber, encrypted); err != nil {
+		return err
+	}
+	for address, hash := range tails {
+		s.tails[address] = hash
+	}
+	return nil
+}
+
+func (s *EncryptedStorage) AddSubscription(address string) error {
+	return s.inner.AddSubscription(s.keys.encrypt(addressContext, address))
+}
+
+func (s *EncryptedStorage) RemoveSubscription(address string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.tails, address)
+	return s.inner.RemoveSubscription(s.keys.encrypt(addressContext, address))
+}
+
+func (s *EncryptedStorage) RevertBlocks(latest int64, removed map[string]int) error {
+	reverter, ok := s.inner.(Reverter)
+	if !ok {
+		return fmt.Errorf("%s does not support reverting blocks", s.inner.Display())
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	encrypted := make(map[string]int, len(removed))
+	for address, count := range removed {
+		encrypted[s.keys.encrypt(addressContext, address)] = count
+		// Read again on the next append
+		delete(s.tails, address)
+	}
+	return reverter.RevertBlocks(latest, encrypted)
+}
+
+func (s *EncryptedStorage) PruneTransactions(address string, count int) error {
+	pruner, ok := s.inner.(Pruner)
+	if !ok {
+		return fmt.Errorf("%s does not support pruning", s.inner.Display())
+	}
+	return pruner.PruneTransactions(s.keys.encrypt(addressContext, address), count)
+}
+
+func (s *EncryptedStorage) GetTransactions(address string, offset, limit int) ([]Transaction, error) {
+	txs, err := s.inner.GetTransactions(s.keys.encrypt(addressContext, address), offset, limit)
+	if err != nil {
+		return nil, err
+	}
+	decrypted, _, err := s.decryptTransactions(address, txs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt transactions of %s: %v", address, err)
+	}
+	return decrypted, nil
+}
+
+type storedSubscription struct {
+	key     string
+	details *AddressTransactions
+	stale   bool
+}
+
+// Load decrypts all data, failing if any value was tampered with, and
+// re-encrypts data written with an older key.
+func (s *EncryptedStorage) Load() (map[string]*AddressTransactions, int64, error) {
+	s.mu.Lock()
+	s.tails = make(map[string]string)
+	s.mu.Unlock()
+
+	stored, latest, err := s.inner.Load()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	subscriptions := make(map[string][]storedSubscription)
+	for key, details := range stored {
+		address, id, err := s.keys.decrypt(addressContext, key, s.AllowPlaintext)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to decrypt subscription: %v", err)
+		}
+		txs, stale, err := s.decryptTransactions(address, details.Transactions)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to decrypt transactions of %s: %v", address, err)
+		}
+		subscriptions[address] = append(subscriptions[address], storedSubscription{
+			key:     key,
+			details: &AddressTransactions{Transactions: txs},
+			stale:   stale || id != s.keys.primary,
+		})
+	}
+
+	addresses := make(map[string]*AddressTransactions)
+	for address, entries := range subscriptions {
+		var current, stale []storedSubscription
+		for _, entry := range entries {
+			if entry.stale {
+				stale = append(stale, entry)
+			} else {
+				current = append(current, entry)
+			}
+		}
+		if len(stale) == 0 {
+			addresses[address] = current[0].details
+			continue
+		}
+
+		// A stale entry is only removed once it has been fully rewritten, so
+		// an entry with the primary key next to it is left over from an
+		// interrupted rotation and may be incomplete
+		for _, entry := range current {
+			if err := s.inner.RemoveSubscription(entry.key); err != nil {
+				return nil, 0, err
+			}
+		}
+		if err := s.rotate(address, stale[0].details, latest); err != nil {
+			return nil, 0, fmt.Errorf("failed to re-encrypt %s: %v", address, err)
+		}
+		for _, entry := range stale {
+			if err := s.inner.RemoveSubscription(entry.key); err != nil {
+				return nil, 0, err
+			}
+		}
+		addresses[address] = stale[0].details
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for address, details := range addresses {
+		if txs := details.Transactions; len(txs) > 0 {
+			s.tails[address] = txs[len(txs)-1].Txhash
+		}
+	}
+	return addresses, latest, nil
+}
+
+// rotate writes a subscription with the primary key, block by block so
+// backends that index by block keep their indexes, and restores the
+// latest processed block afterwards.
+func (s *EncryptedStorage) rotate(address string, details *AddressTransactions, latest int64) error {
+	fmt.Println("Re-encrypting subscription with key", s.keys.primary)
+	if err := s.AddSubscription(address); err != nil {
+		return err
+	}
+	txs := details.Transactions
+	for len(txs) > 0 {
+		end := 1
+		for end < len(txs) && txs[end].BlockNumber == txs[0].BlockNumber {
+			end++
+		}
+		blockNumber := latest
+		if number, err := utils.HexToDec(txs[0].BlockNumber); err == nil {
+			blockNumber = number.Int64()
+		}
+		if err := s.AppendBlock(blockNumber, map[string][]Transaction{address: txs[:end]}); err != nil {
+			return err
+		}
+		txs = txs[end:]
+	}
+	if len(details.Transactions) > 0 {
+		return s.inner.AppendBlock(latest, nil)
+	}
+	return nil
+}
+
+// LoadAccess reads the tenants and keys from the inner storage, decrypting
+// the addresses tenants subscribed. Keys are only stored hashed and are
+// kept as they are.
+func (s *EncryptedStorage) LoadAccess() (*AccessData, error) {
+	access, ok := s.inner.(AccessStorage)
+	if !ok {
+		return nil, fmt.Errorf("%s does not support access data", s.inner.Display())
+	}
+	data, err := access.LoadAccess()
+	if err != nil {
+		return nil, err
+	}
+	for _, tenant := range data.Tenants {
+		for _, addresses := range tenant.Subscriptions {
+			for i := range addresses {
+				addresses[i], _, err = s.keys.decrypt(addressContext, addresses[i], s.AllowPlaintext)
+				if err != nil {
+					return nil, fmt.Errorf("failed to decrypt subscription of tenant %s: %v", tenant.ID, err)
+				}
+			}
+		}
+	}
+	return data, nil
+}
+
+// SaveAccess encrypts the addresses tenants subscribed, with the primary
+// key, and saves the data to the inner storage.
+func (s *EncryptedStorage) SaveAccess(data *AccessData) error {
+	access, ok := s.inner.(AccessStorage)
+	if !ok {
+		return fmt.Errorf("%s does not support access data", s.inner.Display())
+	}
+	encrypted := data.clone()
+	for _, tenant := range encrypted.Tenants {
+		for _, addresses := range tenant.Subscriptions {
+			for i := range addresses {
+				addresses[i] = s.keys.encrypt(addressContext, addresses[i])
+			}
+		}
+	}
+	return access.SaveAccess(encrypted)
+}
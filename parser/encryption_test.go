@@ -0,0 +1,188 @@
+package parser
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testKey(id string, fill byte) string {
+	return id + ":" + base64.StdEncoding.EncodeToString([]byte(strings.Repeat(string(fill), 32)))
+}
+
+func testKeyring(t *testing.T, keys ...string) *Keyring {
+	keyring, err := ParseKeyring(strings.Join(keys, "\n"))
+	require.NoError(t, err)
+	return keyring
+}
+
+func TestEncryptedStorage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.json")
+	inner := &JsonFileStorage{FilePath: path, ChainID: 1}
+	storage := NewEncryptedStorage(inner, testKeyring(t, testKey("k1", 1)))
+
+	alice := "0x0000000000000000000000000000000000000001"
+	transfer := Transaction{Txhash: "0xabcdef", BlockNumber: "0x5", From: alice, GasPrice: "0x1"}
+	require.NoError(t, storage.AddSubscription(alice))
+	require.NoError(t, storage.AppendBlock(5, map[string][]Transaction{alice: {transfer}}))
+
+	fileData, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.NotContains(t, string(fileData), alice)
+	require.NotContains(t, string(fileData), "0xabcdef")
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	loaded, latest, err := storage.Load()
+	require.NoError(t, err)
+	require.Equal(t, int64(5), latest)
+	require.Equal(t, []Transaction{transfer}, loaded[alice].Transactions)
+
+	page, err := storage.GetTransactions(alice, 0, 0)
+	require.NoError(t, err)
+	require.Equal(t, []Transaction{transfer}, page)
+
+	// Without the key nothing can be read
+	_, _, err = NewEncryptedStorage(inner, testKeyring(t, testKey("k2", 2))).Load()
+	require.ErrorContains(t, err, "unknown key id k1")
+}
+
+func TestEncryptedStorageDetectsTampering(t *testing.T) {
+	keyring := testKeyring(t, testKey("k1", 1))
+	alice := "0x0000000000000000000000000000000000000001"
+	encrypted := keyring.encrypt(addressContext, alice)
+
+	tests := map[string]map[string][]Transaction{
+		"modified ciphertext": {encrypted: {{Txhash: keyring.encrypt(recordContext(alice), "0x1")[:30] + "AAAA"}}},
+		"moved field":         {encrypted: {{Txhash: keyring.encrypt("nonce", "0x1")}}},
+		"plaintext value":     {encrypted: {{Txhash: "0x1"}}},
+	}
+	for name, transactions := range tests {
+		t.Run(name, func(t *testing.T) {
+			inner := &JsonFileStorage{FilePath: filepath.Join(t.TempDir(), "data.json"), ChainID: 1}
+			require.NoError(t, inner.AppendBlock(1, transactions))
+
+			_, _, err := NewEncryptedStorage(inner, keyring).Load()
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestEncryptedStorageDetectsMovedRecords(t *testing.T) {
+	keyring := testKeyring(t, testKey("k1", 1))
+	alice := "0x0000000000000000000000000000000000000001"
+	bob := "0x0000000000000000000000000000000000000002"
+	source := NewMemoryStorage(RetentionPolicy{})
+	storage := NewEncryptedStorage(source, keyring)
+	require.NoError(t, storage.AddSubscription(alice))
+	require.NoError(t, storage.AddSubscription(bob))
+	for block := int64(1); block <= 3; block++ {
+		number := fmt.Sprintf("0x%x", block)
+		require.NoError(t, storage.AppendBlock(block, map[string][]Transaction{
+			alice: {{Txhash: fmt.Sprintf("0xa%d", block), BlockNumber: number, From: alice, Value: number}},
+			bob:   {{Txhash: fmt.Sprintf("0xb%d", block), BlockNumber: number, To: bob, Value: number}},
+		}))
+	}
+
+	stored, _, err := source.Load()
+	require.NoError(t, err)
+	aliceKey, bobKey := keyring.encrypt(addressContext, alice), keyring.encrypt(addressContext, bob)
+	a, b := stored[aliceKey].Transactions, stored[bobKey].Transactions
+	modified := a[1]
+	modified.Value = a[2].Value
+
+	tests := map[string]struct {
+		transactions []Transaction
+		valid        bool
+	}{
+		"unchanged":             {transactions: a, valid: true},
+		"pruned":                {transactions: a[1:], valid: true},
+		"reverted":              {transactions: a[:2], valid: true},
+		"swapped":               {transactions: []Transaction{a[0], a[2], a[1]}},
+		"replayed":              {transactions: []Transaction{a[0], a[1], a[1], a[2]}},
+		"truncated":             {transactions: []Transaction{a[0], a[2]}},
+		"from another address":  {transactions: []Transaction{a[0], b[1], a[2]}},
+		"swapped field":         {transactions: []Transaction{a[0], modified, a[2]}},
+		"whole list of another": {transactions: b},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			inner := NewMemoryStorage(RetentionPolicy{})
+			require.NoError(t, inner.AppendBlock(3, map[string][]Transaction{aliceKey: test.transactions}))
+
+			loaded, _, err := NewEncryptedStorage(inner, keyring).Load()
+			if test.valid {
+				require.NoError(t, err)
+				require.Len(t, loaded[alice].Transactions, len(test.transactions))
+			} else {
+				require.Error(t, err)
+			}
+		})
+	}
+
+	// Appends continue the chain of what is stored
+	storage = NewEncryptedStorage(source, keyring)
+	require.NoError(t, storage.AppendBlock(4, map[string][]Transaction{alice: {{Txhash: "0xa4", BlockNumber: "0x4"}}}))
+	loaded, _, err := storage.Load()
+	require.NoError(t, err)
+	require.Len(t, loaded[alice].Transactions, 4)
+}
+
+func TestEncryptedStorageKeyRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.json")
+	inner := &JsonFileStorage{FilePath: path, ChainID: 1}
+	alice := "0x0000000000000000000000000000000000000001"
+	txs := []Transaction{
+		{Txhash: "0x1", BlockNumber: "0x1"},
+		{Txhash: "0x2", BlockNumber: "0x2"},
+	}
+
+	// Start from plaintext data
+	require.NoError(t, inner.AddSubscription(alice))
+	require.NoError(t, inner.AppendBlock(1, map[string][]Transaction{alice: txs[:1]}))
+	require.NoError(t, inner.AppendBlock(3, map[string][]Transaction{alice: txs[1:]}))
+
+	old := NewEncryptedStorage(inner, testKeyring(t, testKey("k1", 1)))
+	_, _, err := old.Load()
+	require.ErrorContains(t, err, "not encrypted")
+	old.AllowPlaintext = true
+	_, _, err = old.Load()
+	require.NoError(t, err)
+
+	rotated := NewEncryptedStorage(inner, testKeyring(t, testKey("k2", 2), testKey("k1", 1)))
+	loaded, latest, err := rotated.Load()
+	require.NoError(t, err)
+	require.Equal(t, int64(3), latest)
+	require.Equal(t, txs, loaded[alice].Transactions)
+
+	fileData, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.NotContains(t, string(fileData), "enc:k1:")
+
+	// The old key is no longer needed
+	loaded, latest, err = NewEncryptedStorage(inner, testKeyring(t, testKey("k2", 2))).Load()
+	require.NoError(t, err)
+	require.Equal(t, int64(3), latest)
+	require.Len(t, loaded, 1)
+	require.Equal(t, txs, loaded[alice].Transactions)
+}
+
+func TestParseKeyring(t *testing.T) {
+	keyring, err := ParseKeyring("# keys\n" + testKey("new", 2) + "\n\n" + testKey("old", 1))
+	require.NoError(t, err)
+	require.Equal(t, "new", keyring.primary)
+	require.Len(t, keyring.keys, 2)
+
+	_, err = ParseKeyring(testKey("k1", 1) + "," + testKey("k1", 2))
+	require.ErrorContains(t, err, "duplicate")
+	_, err = ParseKeyring("k1:" + base64.StdEncoding.EncodeToString([]byte("short")))
+	require.ErrorContains(t, err, "32 bytes")
+	_, err = ParseKeyring("")
+	require.Error(t, err)
+}
@@ -0,0 +1,64 @@
+package parser
+
+import "errors"
+
+var (
+	// ErrAlreadySubscribed is returned when subscribing an address twice.
+	ErrAlreadySubscribed = errors.New("address already subscribed")
+
+	// ErrNotSubscribed is returned when querying an address that is not
+	// subscribed.
+	ErrNotSubscribed = errors.New("address not subscribed")
+
+	// ErrInvalidAddress is returned when an address is not a valid
+	// Ethereum address.
+	ErrInvalidAddress = errors.New("invalid address")
+
+	// ErrUnsupportedSchema is returned when stored data was written with a
+	// newer SchemaVersion than this version supports.
+	ErrUnsupportedSchema = errors.New("unsupported schema version")
+
+	// ErrMigrationFailed is returned when stored data written with an older
+	// SchemaVersion cannot be migrated.
+	ErrMigrationFailed = errors.New("data migration failed")
+
+	// ErrInvalidQuery is returned when a transaction query has invalid
+	// filters.
+	ErrInvalidQuery = errors.New("invalid query")
+
+	// ErrInvalidCursor is returned when a pagination cursor is malformed.
+	ErrInvalidCursor = errors.New("invalid cursor")
+
+	// ErrInvalidWebhook is returned when registering a webhook with an
+	// invalid URL.
+	ErrInvalidWebhook = errors.New("invalid webhook")
+
+	// ErrWebhookNotFound is returned when an address has no webhook.
+	ErrWebhookNotFound = errors.New("webhook not found")
+
+	// ErrDeliveryNotFound is returned when replaying or discarding an
+	// unknown failed webhook delivery.
+	ErrDeliveryNotFound = errors.New("webhook delivery not found")
+
+	// ErrInvalidAPIKey is returned when authenticating with an unknown or
+	// revoked API key.
+	ErrInvalidAPIKey = errors.New("invalid API key")
+
+	// ErrKeyNotFound is returned when revoking an unknown API key.
+	ErrKeyNotFound = errors.New("API key not found")
+
+	// ErrInvalidTenant is returned when creating or updating a tenant with
+	// negative limits.
+	ErrInvalidTenant = errors.New("invalid tenant")
+
+	// ErrTenantNotFound is returned when referring to an unknown tenant.
+	ErrTenantNotFound = errors.New("tenant not found")
+
+	// ErrTenantExists is returned when creating a tenant with the id of an
+	// existing one.
+	ErrTenantExists = errors.New("tenant already exists")
+
+	// ErrQuotaExceeded is returned when a tenant subscribes more addresses
+	// than its quota allows.
+	ErrQuotaExceeded = errors.New("subscription quota exceeded")
+)
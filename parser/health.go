@@ -0,0 +1,137 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultStallTimeout is how long the parser loop may go without polling
+// or processing a block before the parser is reported as stalled.
+const DefaultStallTimeout = 5 * time.Minute
+
+// Health is a snapshot of the state of a parser, for health checks.
+type Health struct {
+	ChainID int64 `json:"chainId"`
+
+	// Result of the last poll of the RPC endpoint, and when one last
+	// succeeded
+	RPCReachable bool      `json:"rpcReachable"`
+	RPCError     string    `json:"rpcError,omitempty"`
+	LastPoll     time.Time `json:"lastPoll"`
+
+	// Chain head seen by the last successful poll, the last processed block
+	// and how far it is behind
+	Head         int64 `json:"head"`
+	CurrentBlock int64 `json:"currentBlock"`
+	Lag          int64 `json:"lag"`
+	LagThreshold int64 `json:"lagThreshold"`
+
+	// Result of the last storage write, and when one last succeeded
+	StorageHealthy bool      `json:"storageHealthy"`
+	StorageError   string    `json:"storageError,omitempty"`
+	LastWrite      time.Time `json:"lastWrite"`
+
+	// Last time the loop polled or processed a block
+	LastActivity time.Time `json:"lastActivity"`
+
+	// Live is false if the loop stalled, Ready is false if the parser is
+	// not live or cannot keep up with the chain. Problems explains why.
+	Live     bool     `json:"live"`
+	Ready    bool     `json:"ready"`
+	Problems []string `json:"problems,omitempty"`
+}
+
+// healthState records the outcome of polls and storage writes.
+type healthState struct {
+	mu         sync.Mutex
+	activity   time.Time
+	polled     bool
+	lastPoll   time.Time
+	head       int64
+	rpcErr     string
+	lastWrite  time.Time
+	storageErr error
+}
+
+// recordPoll records the outcome of polling endpoint. The endpoint is
+// removed from errors, since its URL may contain credentials.
+func (h *healthState) recordPoll(endpoint string, head int64, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := time.Now()
+	h.activity = now
+	h.polled = true
+	h.rpcErr = ""
+	if err != nil {
+		h.rpcErr = strings.ReplaceAll(err.Error(), endpoint, "[endpoint]")
+		return
+	}
+	h.lastPoll = now
+	h.head = head
+}
+
+func (h *healthState) recordBlock() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.activity = time.Now()
+}
+
+func (h *healthState) recordWrite(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.storageErr = err
+	if err == nil {
+		h.lastWrite = time.Now()
+	}
+}
+
+// Health reports the state of the parser. It is ready once a poll
+// succeeded, while the RPC endpoint is reachable, storage writes succeed
+// and it is at most the lag threshold behind the head.
+func (s *MyParser) Health() Health {
+	s.mu.RLock()
+	current, threshold := s.latestProcessedBlockNumber, s.lagThreshold
+	s.mu.RUnlock()
+
+	s.health.mu.Lock()
+	defer s.health.mu.Unlock()
+	h := Health{
+		ChainID:        s.chainID,
+		RPCReachable:   s.health.polled && s.health.rpcErr == "",
+		RPCError:       s.health.rpcErr,
+		LastPoll:       s.health.lastPoll,
+		Head:           s.health.head,
+		CurrentBlock:   current,
+		LagThreshold:   threshold,
+		StorageHealthy: s.health.storageErr == nil,
+		LastWrite:      s.health.lastWrite,
+		LastActivity:   s.health.activity,
+	}
+	if s.health.storageErr != nil {
+		h.StorageError = s.health.storageErr.Error()
+	}
+	if !h.LastPoll.IsZero() {
+		h.Lag = max(0, h.Head-current)
+	}
+
+	h.Live = time.Since(h.LastActivity) <= DefaultStallTimeout
+	if !h.Live {
+		h.Problems = append(h.Problems, fmt.Sprintf("no poll or block processed since %s", h.LastActivity.Format(time.RFC3339)))
+	}
+	switch {
+	case !s.health.polled:
+		h.Problems = append(h.Problems, "the RPC endpoint was not polled yet")
+	case !h.RPCReachable:
+		h.Problems = append(h.Problems, "the RPC endpoint is unreachable")
+	}
+	if !h.StorageHealthy {
+		h.Problems = append(h.Problems, "the last storage write failed")
+	}
+	if h.Lag > threshold {
+		h.Problems = append(h.Problems, fmt.Sprintf("%d blocks behind the head, more than %d", h.Lag, threshold))
+	}
+	h.Ready = len(h.Problems) == 0
+	return h
+}
@@ -0,0 +1,77 @@
+package parser
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// readOnlyStorage fails every subscription change.
+type readOnlyStorage struct {
+	*MemoryStorage
+}
+
+func (readOnlyStorage) AddSubscription(address string) error {
+	return errors.New("read-only file system")
+}
+
+func TestHealth(t *testing.T) {
+	addresses := testAddresses(1)
+	rpc := newFakeRPC(t, addresses)
+	p := NewParser(readOnlyStorage{NewMemoryStorage(RetentionPolicy{})}, 0)
+
+	health := p.Health()
+	require.True(t, health.Live)
+	require.False(t, health.Ready)
+	require.Equal(t, []string{"the RPC endpoint was not polled yet"}, health.Problems)
+
+	// The fake chain head is block 16
+	_, err := p.PollLatestBlock(rpc.URL)
+	require.NoError(t, err)
+	health = p.Health()
+	require.True(t, health.Ready)
+	require.True(t, health.RPCReachable)
+	require.Equal(t, int64(16), health.Head)
+	require.Equal(t, int64(16), health.Lag)
+
+	p.SetLagThreshold(10)
+	health = p.Health()
+	require.False(t, health.Ready)
+	require.Equal(t, []string{"16 blocks behind the head, more than 10"}, health.Problems)
+	for block := int64(1); block <= 6; block++ {
+		_, err := p.ProcessBlock(block, rpc.URL)
+		require.NoError(t, err)
+	}
+	health = p.Health()
+	require.True(t, health.Ready)
+	require.Equal(t, int64(10), health.Lag)
+	require.False(t, health.LastWrite.IsZero())
+
+	// Failed writes make the parser unready until a write succeeds
+	require.Error(t, p.Subscribe(context.Background(), addresses[0]))
+	health = p.Health()
+	require.False(t, health.StorageHealthy)
+	require.Equal(t, "read-only file system", health.StorageError)
+	require.False(t, health.Ready)
+	_, err = p.ProcessBlock(7, rpc.URL)
+	require.NoError(t, err)
+	require.True(t, p.Health().Ready)
+
+	// RPC errors are reported without the endpoint URL
+	rpc.Close()
+	_, err = p.PollLatestBlock(rpc.URL)
+	require.Error(t, err)
+	health = p.Health()
+	require.False(t, health.RPCReachable)
+	require.NotContains(t, health.RPCError, rpc.URL)
+	require.Equal(t, []string{"the RPC endpoint is unreachable"}, health.Problems)
+
+	// A loop that stopped polling is no longer live
+	p.health.activity = time.Now().Add(-DefaultStallTimeout - time.Second)
+	health = p.Health()
+	require.False(t, health.Live)
+	require.False(t, health.Ready)
+}
@@ -0,0 +1,429 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/EliasManj/tx-parser/kv"
+)
+
+// KVStorage persists data in an embedded key-value store, one per chain,
+// with secondary indexes so transactions can be looked up by address, by
+// hash and by block without scanning every address.
+//
+// Keys:
+//
+//	cursor                            latest processed block
+//	sub/<address>                     subscription
+//	tx/<hash>                         transaction JSON
+//	addr/<address>/<block>/<index>    transaction hash, in block order
+//	block/<block>/<hash>              transaction in block
+//	ref/<hash>/<address>              address referencing a transaction
+//
+// Block numbers and indexes are fixed-width hex so keys sort numerically.
+// The tenants and API keys are shared by every chain, so they are kept in a
+// file next to the chain stores.
+type KVStorage struct {
+	Dir     string
+	ChainID int64
+	opts    kv.Options
+
+	mu sync.Mutex
+	db *kv.DB
+}
+
+var (
+	_ Storage     = &KVStorage{}
+	_ ChainScoped = &KVStorage{}
+	_ Pruner      = &KVStorage{}
+	_ Reverter    = &KVStorage{}
+
+	_ AccessStorage = &KVStorage{}
+)
+
+const (
+	kvCursorKey = "cursor"
+
+	// kvAccessKey held the tenants and keys in the chain's store before they
+	// moved to accessPath
+	kvAccessKey = "access"
+)
+
+func NewKVStorage(dir string, opts kv.Options) *KVStorage {
+	return &KVStorage{
+		Dir:  dir,
+		opts: opts,
+	}
+}
+
+func (s *KVStorage) SetChainID(chainID int64) {
+	s.ChainID = chainID
+}
+
+func (s *KVStorage) Display() string {
+	return fmt.Sprintf("KV Storage - %s (chain %d)", s.chainDir(), s.ChainID)
+}
+
+func (s *KVStorage) chainDir() string {
+	return filepath.Join(s.Dir, strconv.FormatInt(s.ChainID, 10))
+}
+
+func (s *KVStorage) open() error {
+	if s.db != nil {
+		return nil
+	}
+	db, err := kv.Open(s.chainDir(), s.opts)
+	if err != nil {
+		return fmt.Errorf("failed to open key-value store: %v", err)
+	}
+	s.db = db
+	return nil
+}
+
+func blockKey(blockNumber int64) string {
+	return fmt.Sprintf("%016x", blockNumber)
+}
+
+func (s *KVStorage) AppendBlock(blockNumber int64, transactions map[string][]Transaction) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.open(); err != nil {
+		return err
+	}
+	block := blockKey(blockNumber)
+	var batch kv.Batch
+	for address, txs := range transactions {
+		for i, tx := range txs {
+			data, err := json.Marshal(tx)
+			if err != nil {
+				return fmt.Errorf("failed to marshal transaction: %v", err)
+			}
+			batch.Put([]byte("tx/"+tx.Txhash), data)
+			batch.Put([]byte(fmt.Sprintf("addr/%s/%s/%08x", address, block, i)), []byte(tx.Txhash))
+			batch.Put([]byte("block/"+block+"/"+tx.Txhash), nil)
+			batch.Put([]byte("ref/"+tx.Txhash+"/"+address), nil)
+		}
+	}
+	batch.Put([]byte(kvCursorKey), []byte(strconv.FormatInt(blockNumber, 10)))
+	return s.db.Write(&batch)
+}
+
+func (s *KVStorage) AddSubscription(address string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.open(); err != nil {
+		return err
+	}
+	return s.db.Put([]byte("sub/"+address), nil)
+}
+
+func (s *KVStorage) RemoveSubscription(address string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.open(); err != nil {
+		return err
+	}
+	var batch kv.Batch
+	batch.Delete([]byte("sub/" + address))
+	if err := s.dropTransactions(&batch, address, 0); err != nil {
+		return err
+	}
+	return s.db.Write(&batch)
+}
+
+func (s *KVStorage) PruneTransactions(address string, count int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.open(); err != nil {
+		return err
+	}
+	if count <= 0 {
+		return nil
+	}
+	var batch kv.Batch
+	if err := s.dropTransactions(&batch, address, count); err != nil {
+		return err
+	}
+	return s.db.Write(&batch)
+}
+
+func (s *KVStorage) RevertBlocks(latest int64, removed map[string]int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.open(); err != nil {
+		return err
+	}
+	var batch kv.Batch
+	for address, count := range removed {
+		if count <= 0 {
+			continue
+		}
+		if err := s.dropTransactions(&batch, address, -count); err != nil {
+			return err
+		}
+	}
+	batch.Put([]byte(kvCursorKey), []byte(strconv.FormatInt(latest, 10)))
+	return s.db.Write(&batch)
+}
+
+// dropTransactions adds deletes for the index entries of the oldest count
+// transactions of address, the newest -count if count is negative, or all
+// of them if count is 0, and for the transactions no other subscribed
+// address refers to.
+func (s *KVStorage) dropTransactions(batch *kv.Batch, address string, count int) error {
+	prefix := "addr/" + address + "/"
+	var keys, hashes []string
+	err := s.db.Scan([]byte(prefix), func(key, value []byte) bool {
+		keys = append(keys, string(key))
+		hashes = append(hashes, string(value))
+		return count <= 0 || len(keys) < count
+	})
+	if err != nil {
+		return err
+	}
+	if count < 0 {
+		start := max(len(keys)+count, 0)
+		keys, hashes = keys[start:], hashes[start:]
+	}
+
+	blocks := make(map[string]string)
+	for i, key := range keys {
+		batch.Delete([]byte(key))
+		blocks[hashes[i]], _, _ = strings.Cut(strings.TrimPrefix(key, prefix), "/")
+	}
+
+	for hash, block := range blocks {
+		batch.Delete([]byte("ref/" + hash + "/" + address))
+		shared := false
+		err := s.db.Scan([]byte("ref/"+hash+"/"), func(key, value []byte) bool {
+			shared = !strings.HasSuffix(string(key), "/"+address)
+			return !shared
+		})
+		if err != nil {
+			return err
+		}
+		if !shared {
+			batch.Delete([]byte("tx/" + hash))
+			batch.Delete([]byte("block/" + block + "/" + hash))
+		}
+	}
+	return nil
+}
+
+func (s *KVStorage) transaction(hash string) (Transaction, bool, error) {
+	var tx Transaction
+	data, found, err := s.db.Get([]byte("tx/" + hash))
+	if err != nil || !found {
+		return tx, found, err
+	}
+	if err := json.Unmarshal(data, &tx); err != nil {
+		return tx, false, fmt.Errorf("failed to unmarshal transaction %s: %v", hash, err)
+	}
+	return tx, true, nil
+}
+
+// scanAddress calls fn with the transactions of address in block order,
+// until fn returns false.
+func (s *KVStorage) scanAddress(address string, fn func(tx Transaction) bool) error {
+	var hashes []string
+	err := s.db.Scan([]byte("addr/"+address+"/"), func(key, value []byte) bool {
+		hashes = append(hashes, string(value))
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	for _, hash := range hashes {
+		tx, found, err := s.transaction(hash)
+		if err != nil {
+			return err
+		}
+		if found && !fn(tx) {
+			return nil
+		}
+	}
+	return nil
+}
+
+func (s *KVStorage) Load() (map[string]*AddressTransactions, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.open(); err != nil {
+		return nil, 0, err
+	}
+	latest := int64(-1)
+	data, found, err := s.db.Get([]byte(kvCursorKey))
+	if err != nil {
+		return nil, 0, err
+	}
+	if found {
+		latest, err = strconv.ParseInt(string(data), 10, 64)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to parse cursor: %v", err)
+		}
+	}
+
+	addresses := make(map[string]*AddressTransactions)
+	err = s.db.Scan([]byte("sub/"), func(key, value []byte) bool {
+		addresses[strings.TrimPrefix(string(key), "sub/")] = &AddressTransactions{Transactions: []Transaction{}}
+		return true
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	for address, details := range addresses {
+		err := s.scanAddress(address, func(tx Transaction) bool {
+			details.Transactions = append(details.Transactions, tx)
+			return true
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	return addresses, latest, nil
+}
+
+func (s *KVStorage) GetTransactions(address string, offset, limit int) ([]Transaction, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	page := []Transaction{}
+	skipped := 0
+	err := s.scanAddress(address, func(tx Transaction) bool {
+		if skipped < offset {
+			skipped++
+			return true
+		}
+		page = append(page, tx)
+		return limit <= 0 || len(page) < limit
+	})
+	if err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// GetTransactionByHash returns a stored transaction and whether it exists.
+func (s *KVStorage) GetTransactionByHash(hash string) (Transaction, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.open(); err != nil {
+		return Transaction{}, false, err
+	}
+	return s.transaction(hash)
+}
+
+// GetBlockTransactions returns the stored transactions of a block, ordered
+// by hash.
+func (s *KVStorage) GetBlockTransactions(blockNumber int64) ([]Transaction, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	prefix := "block/" + blockKey(blockNumber) + "/"
+	var hashes []string
+	err := s.db.Scan([]byte(prefix), func(key, value []byte) bool {
+		hashes = append(hashes, strings.TrimPrefix(string(key), prefix))
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	txs := []Transaction{}
+	for _, hash := range hashes {
+		tx, found, err := s.transaction(hash)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			txs = append(txs, tx)
+		}
+	}
+	return txs, nil
+}
+
+// Compact merges the store's table files into one.
+func (s *KVStorage) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.open(); err != nil {
+		return err
+	}
+	return s.db.Compact()
+}
+
+// accessPath is the file the tenants and API keys are kept in, next to the
+// chain stores since they are shared by every chain.
+func (s *KVStorage) accessPath() string {
+	return filepath.Join(s.Dir, "access.json")
+}
+
+// LoadAccess reads the tenants and keys. Until they are first saved, it
+// falls back to those kept in the store of the storage's chain.
+func (s *KVStorage) LoadAccess() (*AccessData, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	payload, err := os.ReadFile(s.accessPath())
+	if os.IsNotExist(err) {
+		if err := s.open(); err != nil {
+			return nil, err
+		}
+		payload, _, err = s.db.Get([]byte(kvAccessKey))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read access data: %v", err)
+	}
+	return unmarshalAccess(payload)
+}
+
+func (s *KVStorage) SaveAccess(data *AccessData) error {
+	payload, err := marshalAccess(data)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(s.Dir, 0700); err != nil {
+		return fmt.Errorf("failed to create directory: %v", err)
+	}
+	if err := replaceFile(s.accessPath(), payload); err != nil {
+		return err
+	}
+	if err := s.open(); err != nil {
+		return err
+	}
+	return s.db.Delete([]byte(kvAccessKey))
+}
+
+func (s *KVStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.db == nil {
+		return nil
+	}
+	err := s.db.Close()
+	s.db = nil
+	return err
+}
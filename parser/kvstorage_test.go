@@ -0,0 +1,99 @@
+package parser
+
+import (
+	"context"
+	"testing"
+
+	"github.com/EliasManj/tx-parser/kv"
+	"github.com/stretchr/testify/require"
+)
+
+func openKVStorage(t *testing.T, dir string) *KVStorage {
+	opts := kv.DefaultOptions
+	opts.MemtableSize = 1024
+	storage := NewKVStorage(dir, opts)
+	storage.SetChainID(1)
+	t.Cleanup(func() { storage.Close() })
+	return storage
+}
+
+func TestKVStorage(t *testing.T) {
+	dir := t.TempDir()
+	storage := openKVStorage(t, dir)
+
+	addresses, latest, err := storage.Load()
+	require.NoError(t, err)
+	require.Empty(t, addresses)
+	require.Equal(t, int64(-1), latest)
+
+	alice := "0x0000000000000000000000000000000000000001"
+	bob := "0x0000000000000000000000000000000000000002"
+	require.NoError(t, storage.AddSubscription(alice))
+	require.NoError(t, storage.AddSubscription(bob))
+
+	transfer := Transaction{Txhash: "0xa", BlockNumber: "0x1", From: alice, To: bob}
+	require.NoError(t, storage.AppendBlock(1, map[string][]Transaction{
+		alice: {transfer},
+		bob:   {transfer},
+	}))
+	require.NoError(t, storage.AppendBlock(2, map[string][]Transaction{
+		alice: {{Txhash: "0xc", BlockNumber: "0x2"}, {Txhash: "0xb", BlockNumber: "0x2"}},
+	}))
+
+	tx, found, err := storage.GetTransactionByHash("0xa")
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, transfer, tx)
+
+	block, err := storage.GetBlockTransactions(2)
+	require.NoError(t, err)
+	require.Len(t, block, 2)
+	require.Equal(t, "0xb", block[0].Txhash)
+
+	// Pages keep block order and the order within a block
+	page, err := storage.GetTransactions(alice, 1, 1)
+	require.NoError(t, err)
+	require.Equal(t, []Transaction{{Txhash: "0xc", BlockNumber: "0x2"}}, page)
+
+	// A shared transaction is kept until no subscription refers to it
+	require.NoError(t, storage.RemoveSubscription(alice))
+	_, found, err = storage.GetTransactionByHash("0xa")
+	require.NoError(t, err)
+	require.True(t, found)
+	_, found, err = storage.GetTransactionByHash("0xb")
+	require.NoError(t, err)
+	require.False(t, found)
+	block, err = storage.GetBlockTransactions(2)
+	require.NoError(t, err)
+	require.Empty(t, block)
+
+	require.NoError(t, storage.Compact())
+	require.NoError(t, storage.Close())
+
+	reopened := openKVStorage(t, dir)
+	addresses, latest, err = reopened.Load()
+	require.NoError(t, err)
+	require.Equal(t, int64(2), latest)
+	require.Len(t, addresses, 1)
+	require.Equal(t, []Transaction{transfer}, addresses[bob].Transactions)
+}
+
+func TestParserWithKVStorage(t *testing.T) {
+	addresses := testAddresses(2)
+	rpc := newFakeRPC(t, addresses)
+	storage := openKVStorage(t, t.TempDir())
+	p := NewParser(storage, 0)
+
+	for _, address := range addresses {
+		require.NoError(t, p.Subscribe(context.Background(), address))
+	}
+	_, err := p.ProcessBlock(1, rpc.URL)
+	require.NoError(t, err)
+
+	block, err := storage.GetBlockTransactions(1)
+	require.NoError(t, err)
+	require.Len(t, block, 2)
+	page, err := p.GetTransactionsPage(context.Background(), addresses[0], 0, 10)
+	require.NoError(t, err)
+	require.Len(t, page, 1)
+}
@@ -0,0 +1,689 @@
+package parser
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SyncPolicy controls when LogStorage fsyncs the active segment.
+type SyncPolicy int
+
+const (
+	// SyncAlways fsyncs after every save.
+	SyncAlways SyncPolicy = iota
+	// SyncInterval fsyncs at most once per LogStorageOptions.SyncInterval.
+	SyncInterval
+	// SyncNever leaves flushing to the operating system.
+	SyncNever
+)
+
+type LogStorageOptions struct {
+	SyncPolicy   SyncPolicy
+	SyncInterval time.Duration
+
+	// Size after which the active segment is sealed and a new one started
+	SegmentSize int64
+
+	// Number of sealed segments that triggers a compaction
+	CompactSegments int
+
+	// How often to check whether a compaction is needed, 0 disables it
+	CompactInterval time.Duration
+}
+
+var DefaultLogStorageOptions = LogStorageOptions{
+	SyncPolicy:      SyncAlways,
+	SyncInterval:    time.Second,
+	SegmentSize:     64 << 20,
+	CompactSegments: 4,
+	CompactInterval: time.Minute,
+}
+
+// Each record on disk is a batch of operations, framed as
+// [payload length uint32][crc32 of payload uint32][payload]. Large saves are
+// split across several records, all but the last flagged with More. A save
+// is applied entirely or, if any of its records is torn or corrupt, not at
+// all.
+const (
+	recordHeaderSize = 8
+	maxRecordSize    = 256 << 20
+
+	// Operations per record for large saves and compacted snapshots
+	recordChunkSize = 10000
+)
+
+type logOp struct {
+	Type    string       `json:"t"`
+	Address string       `json:"a,omitempty"`
+	Tx      *Transaction `json:"tx,omitempty"`
+	Count   int          `json:"n,omitempty"`
+}
+
+type logBatch struct {
+	Ops    []logOp `json:"ops,omitempty"`
+	Cursor *int64  `json:"cursor,omitempty"`
+	More   bool    `json:"more,omitempty"`
+}
+
+const (
+	opReset       = "reset"
+	opSubscribe   = "sub"
+	opUnsubscribe = "unsub"
+	opTransaction = "tx"
+	opPrune       = "prune"
+	opRevert      = "revert"
+)
+
+type logSegment struct {
+	id   int
+	path string
+	size int64
+}
+
+// LogStorage persists data as an append-only log of segment files, one
+// directory per chain, with an in-memory index of the current state.
+type LogStorage struct {
+	Dir     string
+	ChainID int64
+	opts    LogStorageOptions
+
+	mu        sync.Mutex
+	opened    bool
+	segments  []logSegment
+	active    *os.File
+	lastSync  time.Time
+	addresses map[string]*AddressTransactions
+	hashes    map[string]map[string]struct{}
+	latest    int64
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+var (
+	_ Storage     = &LogStorage{}
+	_ ChainScoped = &LogStorage{}
+	_ Pruner      = &LogStorage{}
+	_ Reverter    = &LogStorage{}
+
+	_ AccessStorage = &LogStorage{}
+)
+
+func NewLogStorage(dir string, opts LogStorageOptions) *LogStorage {
+	return &LogStorage{
+		Dir:  dir,
+		opts: opts,
+	}
+}
+
+func (s *LogStorage) SetChainID(chainID int64) {
+	s.ChainID = chainID
+}
+
+func (s *LogStorage) Display() string {
+	return fmt.Sprintf("Log Storage - %s (chain %d)", s.chainDir(), s.ChainID)
+}
+
+func (s *LogStorage) chainDir() string {
+	return filepath.Join(s.Dir, strconv.FormatInt(s.ChainID, 10))
+}
+
+func segmentName(id int) string {
+	return fmt.Sprintf("%08d.log", id)
+}
+
+// open replays the segments on disk into the index. A torn or corrupt
+// record at the end of the last segment is truncated away.
+func (s *LogStorage) open() error {
+	if s.opened {
+		return nil
+	}
+	dir := s.chainDir()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create directory: %v", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to read directory: %v", err)
+	}
+	s.segments = nil
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, ".log") {
+			continue
+		}
+		id, err := strconv.Atoi(strings.TrimSuffix(name, ".log"))
+		if err != nil {
+			continue
+		}
+		s.segments = append(s.segments, logSegment{id: id, path: filepath.Join(dir, name)})
+	}
+	sort.Slice(s.segments, func(i, j int) bool { return s.segments[i].id < s.segments[j].id })
+
+	s.addresses = make(map[string]*AddressTransactions)
+	s.hashes = make(map[string]map[string]struct{})
+	s.latest = -1
+	for i := range s.segments {
+		last := i == len(s.segments)-1
+		size, err := s.replay(s.segments[i].path, last)
+		if err != nil {
+			return err
+		}
+		s.segments[i].size = size
+	}
+
+	if len(s.segments) == 0 {
+		if err := s.newSegment(1); err != nil {
+			return err
+		}
+	} else {
+		activePath := s.segments[len(s.segments)-1].path
+		s.active, err = os.OpenFile(activePath, os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return fmt.Errorf("failed to open segment: %v", err)
+		}
+	}
+
+	s.opened = true
+	if s.opts.CompactInterval > 0 {
+		s.stop = make(chan struct{})
+		s.done = make(chan struct{})
+		go s.compactLoop()
+	}
+	return nil
+}
+
+// replay applies the records of one segment and returns the size of its
+// valid prefix.
+func (s *LogStorage) replay(path string, truncateTorn bool) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open segment: %v", err)
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	var offset, pendingOffset int64
+	var pending []*logBatch
+	for {
+		batch, n, err := readRecord(reader)
+		if err == io.EOF && len(pending) == 0 {
+			return offset, nil
+		}
+		if err == io.EOF {
+			err = errors.New("incomplete multi-record save")
+		}
+		if err != nil {
+			if !truncateTorn {
+				return 0, fmt.Errorf("corrupt record in %s at offset %d: %v", path, offset, err)
+			}
+			fmt.Printf("Truncating torn write in %s at offset %d: %v\n", path, pendingOffset, err)
+			if err := os.Truncate(path, pendingOffset); err != nil {
+				return 0, fmt.Errorf("failed to truncate segment: %v", err)
+			}
+			return pendingOffset, nil
+		}
+		offset += n
+		pending = append(pending, batch)
+		if batch.More {
+			continue
+		}
+		for _, b := range pending {
+			s.apply(b)
+		}
+		pending = nil
+		pendingOffset = offset
+	}
+}
+
+func readRecord(r io.Reader) (*logBatch, int64, error) {
+	header := make([]byte, recordHeaderSize)
+	n, err := io.ReadFull(r, header)
+	if err == io.EOF {
+		return nil, 0, io.EOF
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("short header (%d bytes)", n)
+	}
+	length := binary.LittleEndian.Uint32(header[0:4])
+	checksum := binary.LittleEndian.Uint32(header[4:8])
+	if length > maxRecordSize {
+		return nil, 0, fmt.Errorf("record too large (%d bytes)", length)
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, 0, errors.New("short payload")
+	}
+	if crc32.ChecksumIEEE(payload) != checksum {
+		return nil, 0, errors.New("checksum mismatch")
+	}
+
+	var batch logBatch
+	if err := json.Unmarshal(payload, &batch); err != nil {
+		return nil, 0, fmt.Errorf("invalid payload: %v", err)
+	}
+	return &batch, int64(recordHeaderSize + length), nil
+}
+
+func encodeRecord(batch *logBatch) ([]byte, error) {
+	payload, err := json.Marshal(batch)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal record: %v", err)
+	}
+	if len(payload) > maxRecordSize {
+		return nil, fmt.Errorf("record too large (%d bytes)", len(payload))
+	}
+	record := make([]byte, recordHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(payload))
+	copy(record[recordHeaderSize:], payload)
+	return record, nil
+}
+
+func (s *LogStorage) apply(batch *logBatch) {
+	for _, op := range batch.Ops {
+		switch op.Type {
+		case opReset:
+			s.addresses = make(map[string]*AddressTransactions)
+			s.hashes = make(map[string]map[string]struct{})
+		case opSubscribe:
+			s.subscribe(op.Address)
+		case opUnsubscribe:
+			delete(s.addresses, op.Address)
+			delete(s.hashes, op.Address)
+		case opTransaction:
+			s.subscribe(op.Address)
+			if _, exists := s.hashes[op.Address][op.Tx.Txhash]; !exists {
+				s.hashes[op.Address][op.Tx.Txhash] = struct{}{}
+				details := s.addresses[op.Address]
+				details.Transactions = append(details.Transactions, *op.Tx)
+			}
+		case opPrune:
+			if details, exists := s.addresses[op.Address]; exists {
+				count := min(op.Count, len(details.Transactions))
+				for _, tx := range details.Transactions[:count] {
+					delete(s.hashes[op.Address], tx.Txhash)
+				}
+				details.Transactions = details.Transactions[count:]
+			}
+		case opRevert:
+			if details, exists := s.addresses[op.Address]; exists {
+				keep := len(details.Transactions) - min(op.Count, len(details.Transactions))
+				for _, tx := range details.Transactions[keep:] {
+					delete(s.hashes[op.Address], tx.Txhash)
+				}
+				details.Transactions = details.Transactions[:keep]
+			}
+		}
+	}
+	if batch.Cursor != nil {
+		s.latest = *batch.Cursor
+	}
+}
+
+func (s *LogStorage) subscribe(address string) {
+	if _, exists := s.addresses[address]; !exists {
+		s.addresses[address] = &AddressTransactions{Transactions: []Transaction{}}
+		s.hashes[address] = make(map[string]struct{})
+	}
+}
+
+// commit appends batch to the log and applies it to the index.
+func (s *LogStorage) commit(batch *logBatch) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.open(); err != nil {
+		return err
+	}
+	if err := s.append(batch); err != nil {
+		return err
+	}
+	s.apply(batch)
+	return nil
+}
+
+func (s *LogStorage) AppendBlock(blockNumber int64, transactions map[string][]Transaction) error {
+	batch := &logBatch{Cursor: &blockNumber}
+	for address, txs := range transactions {
+		for i := range txs {
+			batch.Ops = append(batch.Ops, logOp{Type: opTransaction, Address: address, Tx: &txs[i]})
+		}
+	}
+	return s.commit(batch)
+}
+
+func (s *LogStorage) AddSubscription(address string) error {
+	return s.commit(&logBatch{Ops: []logOp{{Type: opSubscribe, Address: address}}})
+}
+
+func (s *LogStorage) RemoveSubscription(address string) error {
+	return s.commit(&logBatch{Ops: []logOp{{Type: opUnsubscribe, Address: address}}})
+}
+
+func (s *LogStorage) RevertBlocks(latest int64, removed map[string]int) error {
+	batch := &logBatch{Cursor: &latest}
+	for address, count := range removed {
+		batch.Ops = append(batch.Ops, logOp{Type: opRevert, Address: address, Count: count})
+	}
+	return s.commit(batch)
+}
+
+func (s *LogStorage) PruneTransactions(address string, count int) error {
+	return s.commit(&logBatch{Ops: []logOp{{Type: opPrune, Address: address, Count: count}}})
+}
+
+func (s *LogStorage) GetTransactions(address string, offset, limit int) ([]Transaction, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	details, exists := s.addresses[address]
+	if !exists {
+		return []Transaction{}, nil
+	}
+	return pageTransactions(details.Transactions, offset, limit), nil
+}
+
+func (s *LogStorage) append(batch *logBatch) error {
+	var records []byte
+	for start := 0; start == 0 || start < len(batch.Ops); start += recordChunkSize {
+		end := min(start+recordChunkSize, len(batch.Ops))
+		chunk := &logBatch{Ops: batch.Ops[start:end], More: end < len(batch.Ops)}
+		if !chunk.More {
+			chunk.Cursor = batch.Cursor
+		}
+		record, err := encodeRecord(chunk)
+		if err != nil {
+			return err
+		}
+		records = append(records, record...)
+	}
+
+	active := &s.segments[len(s.segments)-1]
+	if active.size > 0 && active.size+int64(len(records)) > s.opts.SegmentSize {
+		if err := s.roll(); err != nil {
+			return err
+		}
+		active = &s.segments[len(s.segments)-1]
+	}
+
+	if _, err := s.active.Write(records); err != nil {
+		// Drop the partial write so later records are not lost behind it
+		s.active.Truncate(active.size)
+		return fmt.Errorf("failed to write record: %v", err)
+	}
+	active.size += int64(len(records))
+	return s.sync(false)
+}
+
+func (s *LogStorage) sync(force bool) error {
+	switch {
+	case force, s.opts.SyncPolicy == SyncAlways:
+	case s.opts.SyncPolicy == SyncInterval && time.Since(s.lastSync) >= s.opts.SyncInterval:
+	default:
+		return nil
+	}
+	if err := s.active.Sync(); err != nil {
+		return fmt.Errorf("failed to sync segment: %v", err)
+	}
+	s.lastSync = time.Now()
+	return nil
+}
+
+// roll seals the active segment and starts a new one.
+func (s *LogStorage) roll() error {
+	if err := s.sync(true); err != nil {
+		return err
+	}
+	if err := s.active.Close(); err != nil {
+		return fmt.Errorf("failed to close segment: %v", err)
+	}
+	return s.newSegment(s.segments[len(s.segments)-1].id + 1)
+}
+
+func (s *LogStorage) newSegment(id int) error {
+	path := filepath.Join(s.chainDir(), segmentName(id))
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create segment: %v", err)
+	}
+	s.active = f
+	s.segments = append(s.segments, logSegment{id: id, path: path})
+	return nil
+}
+
+func (s *LogStorage) Load() (map[string]*AddressTransactions, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.open(); err != nil {
+		return nil, 0, err
+	}
+
+	addresses := make(map[string]*AddressTransactions, len(s.addresses))
+	for address, details := range s.addresses {
+		addresses[address] = &AddressTransactions{Transactions: copyTransactions(details.Transactions)}
+	}
+	return addresses, s.latest, nil
+}
+
+// Compact rewrites the current state into a single segment and removes the
+// segments it replaces.
+func (s *LogStorage) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.open(); err != nil {
+		return err
+	}
+	return s.compact()
+}
+
+func (s *LogStorage) compact() error {
+	if err := s.roll(); err != nil {
+		return err
+	}
+	obsolete := s.segments[:len(s.segments)-1]
+	active := s.segments[len(s.segments)-1]
+
+	last := obsolete[len(obsolete)-1]
+	tmpPath := last.path + ".tmp"
+	size, err := s.writeSnapshot(tmpPath)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, last.path); err != nil {
+		return fmt.Errorf("failed to replace segment: %v", err)
+	}
+	if err := syncDir(s.chainDir()); err != nil {
+		return err
+	}
+	for _, segment := range obsolete[:len(obsolete)-1] {
+		if err := os.Remove(segment.path); err != nil {
+			return fmt.Errorf("failed to remove segment: %v", err)
+		}
+	}
+
+	s.segments = []logSegment{{id: last.id, path: last.path, size: size}, active}
+	return nil
+}
+
+// writeSnapshot writes the current state to path as a sequence of records
+// and returns the number of bytes written. The snapshot starts with a
+// reset, so any older segment a crash leaves behind is replayed first and
+// then discarded.
+func (s *LogStorage) writeSnapshot(path string) (int64, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create snapshot: %v", err)
+	}
+	defer f.Close()
+	writer := bufio.NewWriter(f)
+
+	var size int64
+	batch := &logBatch{Ops: []logOp{{Type: opReset}}}
+	flush := func() error {
+		record, err := encodeRecord(batch)
+		if err != nil {
+			return err
+		}
+		n, err := writer.Write(record)
+		size += int64(n)
+		if err != nil {
+			return fmt.Errorf("failed to write snapshot: %v", err)
+		}
+		batch = &logBatch{}
+		return nil
+	}
+
+	for address, details := range s.addresses {
+		batch.Ops = append(batch.Ops, logOp{Type: opSubscribe, Address: address})
+		for i := range details.Transactions {
+			batch.Ops = append(batch.Ops, logOp{Type: opTransaction, Address: address, Tx: &details.Transactions[i]})
+			if len(batch.Ops) >= recordChunkSize {
+				if err := flush(); err != nil {
+					return 0, err
+				}
+			}
+		}
+	}
+	batch.Cursor = &s.latest
+	if err := flush(); err != nil {
+		return 0, err
+	}
+
+	if err := writer.Flush(); err != nil {
+		return 0, fmt.Errorf("failed to write snapshot: %v", err)
+	}
+	if err := f.Sync(); err != nil {
+		return 0, fmt.Errorf("failed to sync snapshot: %v", err)
+	}
+	return size, nil
+}
+
+func (s *LogStorage) compactLoop() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.opts.CompactInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			if len(s.segments)-1 >= s.opts.CompactSegments {
+				if err := s.compact(); err != nil {
+					fmt.Println("Error compacting log storage:", err)
+				}
+			}
+			if s.opts.SyncPolicy == SyncInterval {
+				if err := s.sync(false); err != nil {
+					fmt.Println("Error syncing log storage:", err)
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// Close stops background compaction and closes the active segment.
+func (s *LogStorage) Close() error {
+	s.mu.Lock()
+	if !s.opened {
+		s.mu.Unlock()
+		return nil
+	}
+	stop := s.stop
+	s.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-s.done
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.opened = false
+	if err := s.sync(true); err != nil {
+		return err
+	}
+	return s.active.Close()
+}
+
+// accessPath is the file the tenants and API keys are kept in, next to the
+// chain directories since they are shared by every chain.
+func (s *LogStorage) accessPath() string {
+	return filepath.Join(s.Dir, "access.json")
+}
+
+func (s *LogStorage) LoadAccess() (*AccessData, error) {
+	payload, err := os.ReadFile(s.accessPath())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read access data: %v", err)
+	}
+	return unmarshalAccess(payload)
+}
+
+func (s *LogStorage) SaveAccess(data *AccessData) error {
+	payload, err := marshalAccess(data)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.Dir, 0700); err != nil {
+		return fmt.Errorf("failed to create directory: %v", err)
+	}
+	return replaceFile(s.accessPath(), payload)
+}
+
+// replaceFile atomically replaces the file at path with data.
+func replaceFile(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync %s: %v", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close %s: %v", path, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace %s: %v", path, err)
+	}
+	return syncDir(dir)
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open directory: %v", err)
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to sync directory: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package parser
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/EliasManj/tx-parser/kv"
+	"github.com/stretchr/testify/require"
+)
+
+func testLogStorageOptions() LogStorageOptions {
+	opts := DefaultLogStorageOptions
+	opts.CompactInterval = 0
+	return opts
+}
+
+func openLogStorage(t *testing.T, dir string, opts LogStorageOptions) *LogStorage {
+	storage := NewLogStorage(dir, opts)
+	storage.SetChainID(1)
+	t.Cleanup(func() { storage.Close() })
+	return storage
+}
+
+func TestLogStorageSaveAndLoad(t *testing.T) {
+	dir := t.TempDir()
+	storage := openLogStorage(t, dir, testLogStorageOptions())
+
+	addresses, latest, err := storage.Load()
+	require.NoError(t, err)
+	require.Empty(t, addresses)
+	require.Equal(t, int64(-1), latest)
+
+	require.NoError(t, storage.AddSubscription("0xabc"))
+	require.NoError(t, storage.AddSubscription("0xdef"))
+	require.NoError(t, storage.AppendBlock(10, map[string][]Transaction{"0xabc": {{Txhash: "0x1"}}}))
+	require.NoError(t, storage.AppendBlock(11, map[string][]Transaction{"0xabc": {{Txhash: "0x2"}}}))
+	require.NoError(t, storage.RemoveSubscription("0xdef"))
+	require.NoError(t, storage.Close())
+
+	reopened := openLogStorage(t, dir, testLogStorageOptions())
+	loaded, latest, err := reopened.Load()
+	require.NoError(t, err)
+	require.Equal(t, int64(11), latest)
+	require.Len(t, loaded, 1)
+	require.Len(t, loaded["0xabc"].Transactions, 2)
+
+	page, err := reopened.GetTransactions("0xabc", 1, 0)
+	require.NoError(t, err)
+	require.Equal(t, []Transaction{{Txhash: "0x2"}}, page)
+}
+
+func TestLogStorageTruncatesTornWrite(t *testing.T) {
+	dir := t.TempDir()
+	storage := openLogStorage(t, dir, testLogStorageOptions())
+	require.NoError(t, storage.AppendBlock(10, map[string][]Transaction{"0xabc": {{Txhash: "0x1"}}}))
+	require.NoError(t, storage.Close())
+
+	// Simulate a crash in the middle of writing the next record
+	segment := filepath.Join(dir, "1", segmentName(1))
+	info, err := os.Stat(segment)
+	require.NoError(t, err)
+	record, err := encodeRecord(&logBatch{Ops: []logOp{{Type: opSubscribe, Address: "0xdef"}}})
+	require.NoError(t, err)
+	f, err := os.OpenFile(segment, os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	_, err = f.Write(record[:len(record)-3])
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	reopened := openLogStorage(t, dir, testLogStorageOptions())
+	loaded, latest, err := reopened.Load()
+	require.NoError(t, err)
+	require.Equal(t, int64(10), latest)
+	require.Len(t, loaded, 1)
+
+	truncated, err := os.Stat(segment)
+	require.NoError(t, err)
+	require.Equal(t, info.Size(), truncated.Size())
+
+	// Appending after recovery keeps the log readable
+	require.NoError(t, reopened.AppendBlock(12, nil))
+	require.NoError(t, reopened.Close())
+	_, latest, err = openLogStorage(t, dir, testLogStorageOptions()).Load()
+	require.NoError(t, err)
+	require.Equal(t, int64(12), latest)
+}
+
+func TestLogStorageCompaction(t *testing.T) {
+	dir := t.TempDir()
+	opts := testLogStorageOptions()
+	opts.SegmentSize = 256
+	storage := openLogStorage(t, dir, opts)
+
+	require.NoError(t, storage.AddSubscription("0xdef"))
+	for i := 0; i < 50; i++ {
+		transactions := map[string][]Transaction{"0xabc": {{Txhash: fmt.Sprintf("0x%d", i)}}}
+		require.NoError(t, storage.AppendBlock(int64(i), transactions))
+	}
+	require.NoError(t, storage.RemoveSubscription("0xdef"))
+	require.NoError(t, storage.AppendBlock(50, nil))
+	require.Greater(t, len(storage.segments), 2)
+
+	require.NoError(t, storage.Compact())
+	require.Len(t, storage.segments, 2)
+	require.NoError(t, storage.Close())
+
+	reopened := openLogStorage(t, dir, opts)
+	loaded, latest, err := reopened.Load()
+	require.NoError(t, err)
+	require.Equal(t, int64(50), latest)
+	require.Len(t, loaded, 1)
+	require.Len(t, loaded["0xabc"].Transactions, 50)
+}
+
+func benchmarkTransaction(i int) Transaction {
+	return Transaction{
+		Txhash:      fmt.Sprintf("0x%064x", i),
+		Blockhash:   fmt.Sprintf("0x%064x", i/100),
+		BlockNumber: fmt.Sprintf("0x%x", i/100),
+		From:        fmt.Sprintf("0x%040x", i%1000),
+		To:          "0x0000000000000000000000000000000000000000",
+		Txtype:      "0x2",
+		GasUsed:     "0x5208",
+		GasPrice:    "0x3b9aca00",
+		Nonce:       fmt.Sprintf("0x%x", i),
+	}
+}
+
+// benchmarkAppendBlock measures appending one block of 10 transactions on
+// top of a history of one million, spread over 1000 addresses.
+func benchmarkAppendBlock(b *testing.B, storage Storage) {
+	const history = 1000000
+	seed := make(map[string][]Transaction)
+	for i := 0; i < history; i++ {
+		tx := benchmarkTransaction(i)
+		seed[tx.From] = append(seed[tx.From], tx)
+	}
+	require.NoError(b, storage.AppendBlock(0, seed))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		transactions := make(map[string][]Transaction)
+		for j := 0; j < 10; j++ {
+			tx := benchmarkTransaction(history + i*10 + j)
+			transactions[tx.From] = append(transactions[tx.From], tx)
+		}
+		require.NoError(b, storage.AppendBlock(int64(i+1), transactions))
+	}
+}
+
+func BenchmarkJsonFileStorageAppendBlock1M(b *testing.B) {
+	storage := &JsonFileStorage{FilePath: filepath.Join(b.TempDir(), "data.json"), ChainID: 1}
+	benchmarkAppendBlock(b, storage)
+}
+
+func BenchmarkLogStorageAppendBlock1M(b *testing.B) {
+	opts := DefaultLogStorageOptions
+	opts.CompactInterval = 0
+	storage := NewLogStorage(b.TempDir(), opts)
+	storage.SetChainID(1)
+	defer storage.Close()
+	benchmarkAppendBlock(b, storage)
+}
+
+func BenchmarkKVStorageAppendBlock1M(b *testing.B) {
+	storage := NewKVStorage(b.TempDir(), kv.DefaultOptions)
+	storage.SetChainID(1)
+	defer storage.Close()
+	benchmarkAppendBlock(b, storage)
+}
@@ -0,0 +1,194 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// MemoryStorage keeps all data in memory. It suits tests and ephemeral
+// deployments, which can persist it with Snapshot and Restore.
+type MemoryStorage struct {
+	// Retention prunes transactions on every block. NewParser moves it to
+	// the parser, which also prunes its own copy and can archive them.
+	Retention RetentionPolicy
+
+	mu        sync.RWMutex
+	addresses map[string]*AddressTransactions
+	latest    int64
+	access    []byte
+}
+
+var (
+	_ Storage       = &MemoryStorage{}
+	_ Pruner        = &MemoryStorage{}
+	_ Reverter      = &MemoryStorage{}
+	_ AccessStorage = &MemoryStorage{}
+)
+
+func NewMemoryStorage(retention RetentionPolicy) *MemoryStorage {
+	return &MemoryStorage{
+		Retention: retention,
+		addresses: make(map[string]*AddressTransactions),
+		latest:    -1,
+	}
+}
+
+func (s *MemoryStorage) Display() string {
+	return "Memory Storage"
+}
+
+func (s *MemoryStorage) AppendBlock(blockNumber int64, transactions map[string][]Transaction) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for address, txs := range transactions {
+		details, exists := s.addresses[address]
+		if !exists {
+			details = &AddressTransactions{Transactions: []Transaction{}}
+			s.addresses[address] = details
+		}
+		details.Transactions = append(details.Transactions, txs...)
+	}
+	s.latest = blockNumber
+	for _, details := range s.addresses {
+		details.Transactions = details.Transactions[s.Retention.expired(details.Transactions, blockNumber):]
+	}
+	return nil
+}
+
+func (s *MemoryStorage) AddSubscription(address string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.addresses[address]; !exists {
+		s.addresses[address] = &AddressTransactions{Transactions: []Transaction{}}
+	}
+	return nil
+}
+
+func (s *MemoryStorage) RemoveSubscription(address string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.addresses, address)
+	return nil
+}
+
+func (s *MemoryStorage) GetTransactions(address string, offset, limit int) ([]Transaction, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	details, exists := s.addresses[address]
+	if !exists {
+		return []Transaction{}, nil
+	}
+	return pageTransactions(details.Transactions, offset, limit), nil
+}
+
+func (s *MemoryStorage) PruneTransactions(address string, count int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if details, exists := s.addresses[address]; exists {
+		details.Transactions = details.Transactions[min(count, len(details.Transactions)):]
+	}
+	return nil
+}
+
+func (s *MemoryStorage) RevertBlocks(latest int64, removed map[string]int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for address, count := range removed {
+		if details, exists := s.addresses[address]; exists {
+			details.Transactions = details.Transactions[:len(details.Transactions)-min(count, len(details.Transactions))]
+		}
+	}
+	s.latest = latest
+	return nil
+}
+
+// Load returns a copy of the stored data.
+func (s *MemoryStorage) Load() (map[string]*AddressTransactions, int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	addresses := make(map[string]*AddressTransactions, len(s.addresses))
+	for address, details := range s.addresses {
+		addresses[address] = &AddressTransactions{Transactions: copyTransactions(details.Transactions)}
+	}
+	return addresses, s.latest, nil
+}
+
+func (s *MemoryStorage) LoadAccess() (*AccessData, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return unmarshalAccess(s.access)
+}
+
+func (s *MemoryStorage) SaveAccess(data *AccessData) error {
+	payload, err := marshalAccess(data)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.access = payload
+	return nil
+}
+
+type memorySnapshot struct {
+	Version int `json:"version"`
+	EndpointData
+}
+
+// Snapshot writes the stored data to w as JSON.
+func (s *MemoryStorage) Snapshot(w io.Writer) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	err := json.NewEncoder(w).Encode(memorySnapshot{
+		Version: SchemaVersion,
+		EndpointData: EndpointData{
+			LatestBlockNumber:   s.latest,
+			SubscribedAddresses: s.addresses,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write snapshot: %v", err)
+	}
+	return nil
+}
+
+// Restore replaces the stored data with a snapshot read from r.
+func (s *MemoryStorage) Restore(r io.Reader) error {
+	var snapshot memorySnapshot
+	if err := json.NewDecoder(r).Decode(&snapshot); err != nil {
+		return fmt.Errorf("failed to read snapshot: %v", err)
+	}
+	if snapshot.Version > SchemaVersion {
+		return fmt.Errorf("%w: snapshot version %d is newer than supported version %d", ErrUnsupportedSchema, snapshot.Version, SchemaVersion)
+	}
+
+	addresses := snapshot.SubscribedAddresses
+	if addresses == nil {
+		addresses = make(map[string]*AddressTransactions)
+	}
+	for address, details := range addresses {
+		if details == nil {
+			details = &AddressTransactions{}
+			addresses[address] = details
+		}
+		if details.Transactions == nil {
+			details.Transactions = []Transaction{}
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addresses = addresses
+	s.latest = snapshot.LatestBlockNumber
+	return nil
+}
@@ -0,0 +1,93 @@
+package parser
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/EliasManj/tx-parser/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func blockTransactions(address string, from, to int64) map[string][]Transaction {
+	var txs []Transaction
+	for number := from; number <= to; number++ {
+		txs = append(txs, Transaction{Txhash: utils.IntToHex(number), BlockNumber: utils.IntToHex(number)})
+	}
+	return map[string][]Transaction{address: txs}
+}
+
+func TestMemoryStorageRetention(t *testing.T) {
+	address := testAddresses(1)[0]
+
+	byCount := NewMemoryStorage(RetentionPolicy{MaxCount: 3})
+	require.NoError(t, byCount.AppendBlock(10, blockTransactions(address, 1, 10)))
+	txs, err := byCount.GetTransactions(address, 0, 0)
+	require.NoError(t, err)
+	require.Len(t, txs, 3)
+	require.Equal(t, utils.IntToHex(8), txs[0].BlockNumber)
+
+	byAge := NewMemoryStorage(RetentionPolicy{MaxAge: 5})
+	require.NoError(t, byAge.AppendBlock(10, blockTransactions(address, 1, 10)))
+	txs, err = byAge.GetTransactions(address, 0, 0)
+	require.NoError(t, err)
+	require.Len(t, txs, 5)
+	require.Equal(t, utils.IntToHex(6), txs[0].BlockNumber)
+
+	// Older transactions expire as later blocks are processed
+	require.NoError(t, byAge.AppendBlock(13, nil))
+	txs, err = byAge.GetTransactions(address, 0, 0)
+	require.NoError(t, err)
+	require.Len(t, txs, 2)
+}
+
+func TestMemoryStorageSnapshot(t *testing.T) {
+	address := testAddresses(1)[0]
+	storage := NewMemoryStorage(RetentionPolicy{})
+	require.NoError(t, storage.AddSubscription(address))
+	require.NoError(t, storage.AppendBlock(3, blockTransactions(address, 1, 3)))
+
+	var snapshot bytes.Buffer
+	require.NoError(t, storage.Snapshot(&snapshot))
+
+	restored := NewMemoryStorage(RetentionPolicy{})
+	require.NoError(t, restored.Restore(&snapshot))
+	expected, latest, err := storage.Load()
+	require.NoError(t, err)
+	loaded, restoredLatest, err := restored.Load()
+	require.NoError(t, err)
+	require.Equal(t, latest, restoredLatest)
+	require.Equal(t, expected, loaded)
+
+	// Loaded data is a copy
+	loaded[address].Transactions[0].Txhash = "changed"
+	txs, err := restored.GetTransactions(address, 0, 1)
+	require.NoError(t, err)
+	require.Equal(t, utils.IntToHex(1), txs[0].Txhash)
+
+	err = restored.Restore(bytes.NewBufferString(`{"version": 99}`))
+	require.ErrorIs(t, err, ErrUnsupportedSchema)
+}
+
+type failingStorage struct {
+	*MemoryStorage
+}
+
+func (failingStorage) Load() (map[string]*AddressTransactions, int64, error) {
+	return nil, 0, errors.New("unavailable")
+}
+
+func TestNewParserStorageFallbacks(t *testing.T) {
+	address := testAddresses(1)[0]
+	ctx := context.Background()
+
+	p := NewParser(nil, 7)
+	require.Equal(t, int64(7), p.currentBlock())
+	require.NoError(t, p.Subscribe(ctx, address))
+
+	// A storage that fails to load still leaves a usable parser
+	p = NewParser(failingStorage{NewMemoryStorage(RetentionPolicy{})}, 7)
+	require.Equal(t, int64(7), p.currentBlock())
+	require.NoError(t, p.Subscribe(ctx, address))
+}
@@ -0,0 +1,73 @@
+package parser
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/EliasManj/tx-parser/metrics"
+)
+
+var (
+	blocksProcessed = metrics.Default.Counter("txparser_blocks_processed_total",
+		"Blocks processed, by chain.", "chain")
+	transactionsDetected = metrics.Default.Counter("txparser_transactions_detected_total",
+		"Transactions detected, by chain and subscribed address.", "chain", "address")
+	storageDuration = metrics.Default.Histogram("txparser_storage_save_duration_seconds",
+		"Duration of storage writes, by chain and operation.", metrics.DefBuckets, "chain", "operation")
+	storageErrors = metrics.Default.Counter("txparser_storage_save_errors_total",
+		"Failed storage writes, by chain and operation.", "chain", "operation")
+	headBlock = metrics.Default.Gauge("txparser_head_block",
+		"Chain head seen by the last successful poll, by chain.", "chain")
+	processedBlock = metrics.Default.Gauge("txparser_current_block",
+		"Last processed block, by chain.", "chain")
+	headLag = metrics.Default.Gauge("txparser_head_lag_blocks",
+		"Blocks the parser is behind the chain head, by chain.", "chain")
+	sinkEvents = metrics.Default.Counter("txparser_sink_events_total",
+		"Events handled by event bus sinks, by chain, sink and outcome.", "chain", "sink", "outcome")
+)
+
+// Storage operations, as labelled in metrics
+const (
+	opAppendBlock        = "append_block"
+	opAddSubscription    = "add_subscription"
+	opRemoveSubscription = "remove_subscription"
+	opRevertBlocks       = "revert_blocks"
+)
+
+func (s *MyParser) chainLabel() string {
+	return strconv.FormatInt(s.chainID, 10)
+}
+
+// recordWrite records the outcome and duration of a storage write started
+// at start.
+func (s *MyParser) recordWrite(operation string, start time.Time, err error) {
+	s.health.recordWrite(err)
+	storageDuration.Observe(time.Since(start).Seconds(), s.chainLabel(), operation)
+	if err != nil {
+		storageErrors.Inc(s.chainLabel(), operation)
+	}
+}
+
+// recordBlock records a processed block and the transactions found in it.
+func (s *MyParser) recordBlock(transactions map[string][]Transaction) {
+	s.health.recordBlock()
+	blocksProcessed.Inc(s.chainLabel())
+	for address, txs := range transactions {
+		transactionsDetected.Add(float64(len(txs)), s.chainLabel(), address)
+	}
+}
+
+// CollectMetrics updates the metrics that are sampled when scraped: the
+// head lag and the outcomes of event bus sinks.
+func (s *MyParser) CollectMetrics() {
+	chain := s.chainLabel()
+	health := s.Health()
+	headBlock.Set(float64(health.Head), chain)
+	processedBlock.Set(float64(health.CurrentBlock), chain)
+	headLag.Set(float64(health.Lag), chain)
+	for _, stats := range s.events.Stats() {
+		sinkEvents.Set(float64(stats.Delivered), chain, stats.Name, "delivered")
+		sinkEvents.Set(float64(stats.Failed), chain, stats.Name, "failed")
+		sinkEvents.Set(float64(stats.Dropped), chain, stats.Name, "dropped")
+	}
+}
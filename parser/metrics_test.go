@@ -0,0 +1,71 @@
+package parser
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/EliasManj/tx-parser/metrics"
+	"github.com/stretchr/testify/require"
+)
+
+func scrape(t *testing.T) string {
+	var out strings.Builder
+	require.NoError(t, metrics.Default.WriteText(&out))
+	return out.String()
+}
+
+// scrapeValues returns the value of every series in the default registry.
+func scrapeValues(t *testing.T) map[string]float64 {
+	values := make(map[string]float64)
+	for _, line := range strings.Split(scrape(t), "\n") {
+		i := strings.LastIndexByte(line, ' ')
+		if line == "" || strings.HasPrefix(line, "#") || i < 0 {
+			continue
+		}
+		value, err := strconv.ParseFloat(line[i+1:], 64)
+		require.NoError(t, err, line)
+		values[line[:i]] = value
+	}
+	return values
+}
+
+func TestMetrics(t *testing.T) {
+	addresses := testAddresses(2)
+	rpc := newFakeRPC(t, addresses)
+	p := NewParser(nil, 0)
+	p.chainID = 901 // Series of other tests use chain 0
+	// The registry is global, so counters are compared with their values
+	// before the test, which differ when it runs more than once
+	before := scrapeValues(t)
+	for _, address := range addresses {
+		require.NoError(t, p.Subscribe(context.Background(), address))
+	}
+	// Providers put API keys in the endpoint path
+	endpoint := rpc.URL + "/v3/secret"
+	for block := int64(1); block <= 3; block++ {
+		_, err := p.ProcessBlock(block, endpoint)
+		require.NoError(t, err)
+	}
+	_, err := p.PollLatestBlock(endpoint)
+	require.NoError(t, err)
+	p.CollectMetrics()
+
+	after := scrapeValues(t)
+	for series, delta := range map[string]float64{
+		`txparser_blocks_processed_total{chain="901"}`:                                           3,
+		`txparser_transactions_detected_total{chain="901",address="` + addresses[0] + `"}`:       3,
+		`txparser_storage_save_duration_seconds_count{chain="901",operation="append_block"}`:     3,
+		`txparser_storage_save_duration_seconds_count{chain="901",operation="add_subscription"}`: 2,
+	} {
+		require.Equal(t, delta, after[series]-before[series], series)
+	}
+	require.Equal(t, float64(13), after[`txparser_head_lag_blocks{chain="901"}`])
+	require.Contains(t, after, `txparser_rpc_requests_total{method="eth_getBlockByNumber",endpoint="`+rpc.URL+`"}`)
+	require.NotContains(t, scrape(t), "secret")
+
+	// Unsubscribed addresses leave no series behind
+	require.NoError(t, p.Unsubscribe(context.Background(), addresses[0]))
+	require.NotContains(t, scrape(t), `chain="901",address="`+addresses[0]+`"`)
+}
@@ -0,0 +1,100 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// SchemaVersion is the version of the data format JsonFileStorage writes.
+// Files without a version were written before versioning and are version 1.
+const SchemaVersion = 2
+
+// Migration upgrades the data of a JsonFileStorage file from Version-1 to
+// Version. Migrate receives the decoded data, keyed by chain, and changes
+// it in place.
+type Migration struct {
+	Version     int
+	Description string
+	Migrate     func(chains map[string]any) error
+}
+
+// Migrations lists every migration in version order, one per version after
+// the first.
+var Migrations = []Migration{
+	{
+		Version:     2,
+		Description: "rename transaction field blobGasPrice to gasPrice",
+		Migrate:     migrateGasPriceField,
+	},
+}
+
+// PendingMigrations returns the migrations needed to bring data of the given
+// version up to SchemaVersion.
+func PendingMigrations(version int) []Migration {
+	var pending []Migration
+	for _, migration := range Migrations {
+		if migration.Version > version {
+			pending = append(pending, migration)
+		}
+	}
+	return pending
+}
+
+// migratePayload upgrades payload from version to SchemaVersion.
+func migratePayload(payload []byte, version int) ([]byte, error) {
+	if version > SchemaVersion {
+		return nil, fmt.Errorf("%w: data version %d is newer than supported version %d", ErrUnsupportedSchema, version, SchemaVersion)
+	}
+	pending := PendingMigrations(version)
+	if len(pending) == 0 {
+		return payload, nil
+	}
+
+	// Numbers are kept as written so block numbers don't lose precision
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	var chains map[string]any
+	if err := decoder.Decode(&chains); err != nil {
+		return nil, fmt.Errorf("%w: failed to unmarshal version %d data: %v", ErrMigrationFailed, version, err)
+	}
+	for _, migration := range pending {
+		if err := migration.Migrate(chains); err != nil {
+			return nil, fmt.Errorf("%w: migration to version %d: %v", ErrMigrationFailed, migration.Version, err)
+		}
+	}
+	migrated, err := json.Marshal(chains)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to marshal version %d data: %v", ErrMigrationFailed, SchemaVersion, err)
+	}
+	return migrated, nil
+}
+
+// eachTransaction calls fn with every transaction object in chains.
+func eachTransaction(chains map[string]any, fn func(tx map[string]any)) {
+	for _, chain := range chains {
+		chainData, _ := chain.(map[string]any)
+		addresses, _ := chainData["subscribedAddresses"].(map[string]any)
+		for _, details := range addresses {
+			detailsData, _ := details.(map[string]any)
+			txs, _ := detailsData["transactions"].([]any)
+			for _, tx := range txs {
+				if txData, ok := tx.(map[string]any); ok {
+					fn(txData)
+				}
+			}
+		}
+	}
+}
+
+func migrateGasPriceField(chains map[string]any) error {
+	eachTransaction(chains, func(tx map[string]any) {
+		if value, exists := tx["blobGasPrice"]; exists {
+			if _, exists := tx["gasPrice"]; !exists {
+				tx["gasPrice"] = value
+			}
+			delete(tx, "blobGasPrice")
+		}
+	})
+	return nil
+}
@@ -0,0 +1,222 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NATS defaults.
+const (
+	DefaultNATSSubject  = "txparser"
+	DefaultNATSTimeout  = 5 * time.Second
+	DefaultNATSAttempts = 3
+)
+
+// NATSOptions configures a NATSSink.
+type NATSOptions struct {
+	// Server URL, nats://[user:password@]host[:port] or nats://token@host
+	URL string
+
+	// Events are published to <Subject>.<chain id>.<event type>
+	Subject string
+
+	// Client name shown by the server
+	Name string
+
+	// Timeout to connect and to publish a batch
+	Timeout time.Duration
+
+	// Attempts to publish a batch, reconnecting in between
+	Attempts int
+}
+
+// NATSSink publishes events as JSON to a NATS server, speaking the NATS
+// text protocol. A batch is only acknowledged once the server answered a
+// PING sent after it, so it may be published twice if the connection fails
+// in between.
+type NATSSink struct {
+	options  NATSOptions
+	address  string
+	user     string
+	password string
+	token    string
+
+	mu     sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+var _ Sink = &NATSSink{}
+
+// NewNATSSink creates a sink for the server at options.URL. It connects on
+// the first batch.
+func NewNATSSink(options NATSOptions) (*NATSSink, error) {
+	u, err := url.Parse(options.URL)
+	if err != nil || u.Scheme != "nats" || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid NATS url %q", options.URL)
+	}
+	if options.Subject == "" {
+		options.Subject = DefaultNATSSubject
+	}
+	if options.Name == "" {
+		options.Name = "tx-parser"
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = DefaultNATSTimeout
+	}
+	if options.Attempts <= 0 {
+		options.Attempts = DefaultNATSAttempts
+	}
+
+	s := &NATSSink{options: options, address: u.Host}
+	if u.Port() == "" {
+		s.address = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	if u.User != nil {
+		if password, ok := u.User.Password(); ok {
+			s.user, s.password = u.User.Username(), password
+		} else {
+			s.token = u.User.Username()
+		}
+	}
+	return s, nil
+}
+
+// Handle publishes events, retrying on a new connection if it fails.
+func (s *NATSSink) Handle(events []Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var err error
+	for attempt := 0; attempt < s.options.Attempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * 100 * time.Millisecond)
+		}
+		if err = s.publish(events); err == nil {
+			return nil
+		}
+		s.disconnect()
+	}
+	return fmt.Errorf("failed to publish to NATS: %v", err)
+}
+
+// Close closes the connection.
+func (s *NATSSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.disconnect()
+	return nil
+}
+
+func (s *NATSSink) disconnect() {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+}
+
+// connect opens a connection and waits until the server accepted it.
+func (s *NATSSink) connect() error {
+	conn, err := net.DialTimeout("tcp", s.address, s.options.Timeout)
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+	s.reader = bufio.NewReader(conn)
+	conn.SetDeadline(time.Now().Add(s.options.Timeout))
+
+	line, err := s.readLine()
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		return fmt.Errorf("unexpected greeting %q", line)
+	}
+	connect := map[string]interface{}{
+		"verbose":  false,
+		"pedantic": false,
+		"name":     s.options.Name,
+		"lang":     "go",
+		"version":  "1.0.0",
+		"protocol": 0,
+	}
+	if s.user != "" {
+		connect["user"], connect["pass"] = s.user, s.password
+	}
+	if s.token != "" {
+		connect["auth_token"] = s.token
+	}
+	data, err := json.Marshal(connect)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(conn, "CONNECT %s\r\nPING\r\n", data); err != nil {
+		return err
+	}
+	return s.waitPong()
+}
+
+func (s *NATSSink) readLine() (string, error) {
+	line, err := s.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// waitPong reads until the server answers a PING, which it does after
+// processing everything sent before it.
+func (s *NATSSink) waitPong() error {
+	for {
+		line, err := s.readLine()
+		if err != nil {
+			return err
+		}
+		switch {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			if _, err := s.conn.Write([]byte("PONG\r\n")); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return errors.New(strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+		// +OK and INFO updates need no answer
+	}
+}
+
+func (s *NATSSink) publish(events []Event) error {
+	if s.conn == nil {
+		if err := s.connect(); err != nil {
+			return err
+		}
+	}
+
+	var buf bytes.Buffer
+	for _, event := range events {
+		payload, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		subject := fmt.Sprintf("%s.%d.%s", s.options.Subject, event.ChainID, event.Type)
+		fmt.Fprintf(&buf, "PUB %s %d\r\n", subject, len(payload))
+		buf.Write(payload)
+		buf.WriteString("\r\n")
+	}
+	buf.WriteString("PING\r\n")
+
+	s.conn.SetDeadline(time.Now().Add(s.options.Timeout))
+	if _, err := s.conn.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	return s.waitPong()
+}
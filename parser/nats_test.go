@@ -0,0 +1,124 @@
+package parser
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type natsMessage struct {
+	subject string
+	payload []byte
+}
+
+// fakeNATS is a NATS server that records published messages and connect
+// options. With drop set, it closes a connection on its first PING after a
+// PUB without answering.
+type fakeNATS struct {
+	listener net.Listener
+	messages chan natsMessage
+	connects chan map[string]interface{}
+	drop     atomic.Bool
+}
+
+func newFakeNATS(t *testing.T) *fakeNATS {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	server := &fakeNATS{
+		listener: listener,
+		messages: make(chan natsMessage, 100),
+		connects: make(chan map[string]interface{}, 10),
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	return server
+}
+
+func (s *fakeNATS) serve(conn net.Conn) {
+	defer conn.Close()
+	fmt.Fprint(conn, "INFO {\"server_id\":\"fake\",\"max_payload\":1048576}\r\n")
+	reader := bufio.NewReader(conn)
+	published := false
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		switch fields[0] {
+		case "CONNECT":
+			var options map[string]interface{}
+			json.Unmarshal([]byte(strings.TrimPrefix(strings.TrimSpace(line), "CONNECT ")), &options)
+			s.connects <- options
+		case "PUB":
+			size, _ := strconv.Atoi(fields[2])
+			payload := make([]byte, size+2)
+			if _, err := io.ReadFull(reader, payload); err != nil {
+				return
+			}
+			if !s.drop.Load() {
+				s.messages <- natsMessage{subject: fields[1], payload: payload[:size]}
+			}
+			published = true
+		case "PING":
+			if published && s.drop.CompareAndSwap(true, false) {
+				return
+			}
+			fmt.Fprint(conn, "PONG\r\n")
+		}
+	}
+}
+
+func TestNATSSink(t *testing.T) {
+	server := newFakeNATS(t)
+	_, err := NewNATSSink(NATSOptions{URL: "http://localhost"})
+	require.Error(t, err)
+	sink, err := NewNATSSink(NATSOptions{URL: "nats://user:secret@" + server.listener.Addr().String(), Subject: "test"})
+	require.NoError(t, err)
+	defer sink.Close()
+
+	events := transactionEvents(1, TxDetected, map[string][]Transaction{
+		"alice": {{Txhash: "0x1"}},
+		"bob":   {{Txhash: "0x2"}},
+	})
+	require.NoError(t, sink.Handle(events))
+	options := <-server.connects
+	require.Equal(t, "user", options["user"])
+	require.Equal(t, "secret", options["pass"])
+	message := <-server.messages
+	require.Equal(t, "test.1.transaction", message.subject)
+	var event Event
+	require.NoError(t, json.Unmarshal(message.payload, &event))
+	require.Equal(t, "alice", event.Address)
+	require.Equal(t, "0x1", event.Transaction.Txhash)
+	require.Equal(t, "test.1.transaction", (<-server.messages).subject)
+
+	// A batch the server did not acknowledge is published again on a new
+	// connection
+	server.drop.Store(true)
+	require.NoError(t, sink.Handle([]Event{{Type: TxRemoved, ChainID: 5, Address: "alice"}}))
+	<-server.connects
+	require.Equal(t, "test.5.removal", (<-server.messages).subject)
+	require.Empty(t, server.messages)
+
+	// Unreachable servers fail after the attempts
+	server.listener.Close()
+	sink.Close()
+	require.Error(t, sink.Handle(events))
+}
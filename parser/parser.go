@@ -2,11 +2,14 @@ package parser
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"strconv"
+	"slices"
 	"sync"
 	"time"
 
+	"github.com/EliasManj/tx-parser/ens"
 	"github.com/EliasManj/tx-parser/rpcclient"
 	"github.com/EliasManj/tx-parser/utils"
 )
@@ -17,11 +20,38 @@ type Transaction struct {
 	BlockNumber     string `json:"blocknumber"`
 	From            string `json:"from"`
 	To              string `json:"to"`
+	Value           string `json:"value"`
 	Txtype          string `json:"txtype"`
 	GasUsed         string `json:"gasUsed"`
-	GasPrice        string `json:"blobGasPrice"`
+	GasPrice        string `json:"gasPrice"`
 	ContractAddress string `json:"contractAddress"`
 	Nonce           string `json:"nonce"`
+
+	// Receipt status, 0x1 if the transaction succeeded and 0x0 if it failed
+	Status string `json:"status"`
+
+	// Reverse-resolved names of the counterparties, only filled on request
+	FromName string `json:"fromName,omitempty"`
+	ToName   string `json:"toName,omitempty"`
+}
+
+// UnmarshalJSON also accepts the blobGasPrice field name, which held the gas
+// price before schema version 2. JsonFileStorage migrates it on load, but
+// log and key-value storage records keep the transactions as written.
+func (t *Transaction) UnmarshalJSON(data []byte) error {
+	type transaction Transaction
+	var decoded struct {
+		transaction
+		BlobGasPrice string `json:"blobGasPrice"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	*t = Transaction(decoded.transaction)
+	if t.GasPrice == "" {
+		t.GasPrice = decoded.BlobGasPrice
+	}
+	return nil
 }
 
 type AddressTransactions struct {
@@ -29,10 +59,31 @@ type AddressTransactions struct {
 }
 
 type MyParser struct {
+	chainID                    int64
+	endpoint                   string // polled by Start
 	latestProcessedBlockNumber int64
 	subscribedAddresses        map[string]*AddressTransactions
+	hashes                     map[string]map[string]struct{} // transaction hashes per address
 	mu                         sync.RWMutex
+	publishMu                  sync.Mutex // serializes changes, saved without mu, and publishing their events
 	storage                    Storage
+	names                      NameResolver
+	retention                  RetentionPolicy
+	archivePath                string
+	events                     *EventBus
+	stream                     *TxStream
+	webhooks                   *Webhooks
+	confirmations              int64
+	lagThreshold               int64
+	health                     healthState
+	blockHashes                map[int64]string // hashes of the unconfirmed processed blocks
+}
+
+// NameResolver resolves human-readable names such as ENS names to
+// addresses and back.
+type NameResolver interface {
+	Resolve(name string) (string, error)
+	LookupAddress(address string) (string, error)
 }
 
 type Parser interface {
@@ -46,49 +97,223 @@ type Parser interface {
 	GetTransactions(address string) []Transaction
 }
 
-var _ Parser = &MyParser{}
+// ParserV2 is the context-aware parser interface. Failures are reported
+// with ErrAlreadySubscribed, ErrNotSubscribed and ErrInvalidAddress.
+type ParserV2 interface {
+	// Get the last parsed block
+	GetCurrentBlock(ctx context.Context) (int64, error)
+
+	// Add an address to the observer
+	Subscribe(ctx context.Context, address string) error
 
+	// List of inbound or outbound transactions for an address
+	GetTransactions(ctx context.Context, address string) ([]Transaction, error)
+}
+
+var _ ParserV2 = &MyParser{}
+
+// NewParser creates a parser with the data in storage, or with a
+// MemoryStorage if storage is nil. The Retention of a MemoryStorage becomes
+// the retention policy of the parser, see SetRetention.
 func NewParser(storage Storage, startFrom int64) *MyParser {
+	if storage == nil {
+		storage = NewMemoryStorage(RetentionPolicy{})
+	}
 
-	var addresses = make(map[string]*AddressTransactions)
-	var latestBlockNumber int64
-	var err error
+	addresses, latestBlockNumber, err := storage.Load()
+	if err != nil {
+		fmt.Printf("Error loading from storage: %v\n", err)
+		addresses = make(map[string]*AddressTransactions)
+		latestBlockNumber = startFrom
+	}
+	if latestBlockNumber == -1 {
+		latestBlockNumber = startFrom
+	}
 
-	if storage != nil {
-		addresses, latestBlockNumber, err = storage.Load()
-		if err != nil {
-			fmt.Printf("Error loading from storage: %v\n", err)
-			latestBlockNumber = startFrom
-		}
-		if latestBlockNumber == -1 {
-			latestBlockNumber = startFrom
+	hashes := make(map[string]map[string]struct{}, len(addresses))
+	for address, details := range addresses {
+		hashes[address] = make(map[string]struct{}, len(details.Transactions))
+		for _, tx := range details.Transactions {
+			hashes[address][tx.Txhash] = struct{}{}
 		}
 	}
 
-	return &MyParser{
+	p := &MyParser{
 		latestProcessedBlockNumber: latestBlockNumber,
 		subscribedAddresses:        addresses,
+		hashes:                     hashes,
 		storage:                    storage,
+		events:                     NewEventBus(),
+		webhooks:                   NewWebhooks(WebhookOptions{}),
+		confirmations:              DefaultConfirmations,
+		lagThreshold:               DefaultLagThreshold,
+		blockHashes:                make(map[int64]string),
+	}
+	if memory, ok := storage.(*MemoryStorage); ok {
+		// The parser prunes the storage with its own copy, so it takes
+		// over the retention of the storage
+		memory.mu.Lock()
+		p.retention, memory.Retention = memory.Retention, RetentionPolicy{}
+		memory.mu.Unlock()
 	}
+	p.health.activity = time.Now()
+	p.SetStream(NewTxStream(DefaultStreamCapacity))
+	p.events.Register(LogSink{}, SinkOptions{
+		Name:         "log",
+		Filter:       EventFilter{Types: append(slices.Clone(TransactionEvents), ParserLagging)},
+		Backpressure: BackpressureDropNewest,
+	})
+	return p
 }
 
+// Events returns the bus the parser publishes its events to, to register
+// sinks with.
+func (s *MyParser) Events() *EventBus {
+	return s.events
+}
+
+// SetStream replaces the stream the transaction events of the parser are
+// numbered and retained in, e.g. with one journaled by OpenTxStream. The
+// stream backs resumable clients, so unlike bus sinks it is written before
+// the change that caused the events is reported done.
+func (s *MyParser) SetStream(stream *TxStream) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stream = stream
+}
+
+// publish journals the transaction events among events in the stream, then
+// sends all of them to the bus. It must be called with publishMu held, so
+// events keep the order of the changes, and without mu, so slow sinks do
+// not hold back readers.
+func (s *MyParser) publish(events []Event) error {
+	err := s.Stream().Handle(events)
+	s.events.Publish(events...)
+	if err != nil {
+		return fmt.Errorf("error journaling events: %v", err)
+	}
+	return nil
+}
+
+// Stream returns the stream of transactions recorded by the parser.
+func (s *MyParser) Stream() *TxStream {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.stream
+}
+
+// SetWebhooks replaces the webhooks of the parser's subscriptions, e.g.
+// with ones persisted by OpenWebhooks. They are delivered once running.
+func (s *MyParser) SetWebhooks(webhooks *Webhooks) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.webhooks = webhooks
+}
+
+// Webhooks returns the webhooks of the parser's subscriptions.
+func (s *MyParser) Webhooks() *Webhooks {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.webhooks
+}
+
+// SetRetention prunes the oldest transactions beyond policy after every
+// processed block. If archivePath is set, pruned transactions are first
+// appended to it as gzip compressed JSON lines.
+func (s *MyParser) SetRetention(policy RetentionPolicy, archivePath string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.storage.(Pruner); !ok && policy.enabled() {
+		fmt.Println("Warning:", s.storage.Display(), "does not support pruning, only transactions in memory are pruned")
+	}
+	s.retention = policy
+	s.archivePath = archivePath
+}
+
+// prune applies the retention policy once latest is the last processed
+// block. Transactions are archived before they are removed, so a failure
+// can archive them again but never loses them. Must be called with
+// publishMu held.
+func (s *MyParser) prune(latest int64) error {
+	s.mu.RLock()
+	retention, archivePath := s.retention, s.archivePath
+	pruned := make(map[string][]Transaction)
+	if retention.enabled() {
+		for address, details := range s.subscribedAddresses {
+			if count := retention.expired(details.Transactions, latest); count > 0 {
+				pruned[address] = details.Transactions[:count]
+			}
+		}
+	}
+	s.mu.RUnlock()
+	if len(pruned) == 0 {
+		return nil
+	}
+
+	if archivePath != "" {
+		if err := archiveTransactions(archivePath, s.chainID, pruned); err != nil {
+			return err
+		}
+	}
+	pruner, _ := s.storage.(Pruner)
+	for address, txs := range pruned {
+		if pruner != nil {
+			if err := pruner.PruneTransactions(address, len(txs)); err != nil {
+				return fmt.Errorf("error pruning transactions of %s: %v", address, err)
+			}
+		}
+		s.mu.Lock()
+		for _, tx := range txs {
+			delete(s.hashes[address], tx.Txhash)
+		}
+		details := s.subscribedAddresses[address]
+		details.Transactions = details.Transactions[len(txs):]
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+// PollLatestBlock returns the head of the chain, recording the outcome for
+// Health.
 func (s *MyParser) PollLatestBlock(endpoint string) (int64, error) {
 	blockNumber, err := rpcclient.GetLatestBlockNumber(endpoint)
 	if err != nil {
+		s.health.recordPoll(endpoint, 0, err)
 		return 0, err
 	}
+	s.health.recordPoll(endpoint, blockNumber.Int64(), nil)
 	return blockNumber.Int64(), nil
 }
 
-func (s *MyParser) ProcessBlock(blockNumber int64, endpoint string) bool {
-	txfound := false
-	for address, details := range s.subscribedAddresses {
+// ProcessBlock records the new transactions of subscribed addresses found
+// in block blockNumber and advances the current block to it. The block is
+// appended to storage before the in-memory state changes, so on error both
+// stay at the previous block and the block can be retried. Its transaction
+// events are journaled in the stream before it returns. It reports whether
+// any new transaction was found.
+//
+// If the block does not extend the previously processed one, the chain was
+// reorganized. The blocks after the last common one are reverted instead,
+// and the current block moves back to it.
+func (s *MyParser) ProcessBlock(blockNumber int64, endpoint string) (bool, error) {
+	header, err := rpcclient.GetBlockHeader(utils.IntToHex(blockNumber), endpoint)
+	if err != nil {
+		return false, fmt.Errorf("error getting block %d: %v", blockNumber, err)
+	}
+	blockHash, _ := header["hash"].(string)
+	parentHash, _ := header["parentHash"].(string)
+	if reverted, err := s.checkReorg(blockNumber, parentHash, endpoint); err != nil || reverted {
+		return false, err
+	}
+
+	found := make(map[string][]Transaction)
+	var txHashes []string
+	for _, address := range s.subscriptions() {
 		transactions, err := rpcclient.GetTransactionsByBlockNumber(utils.IntToHex(blockNumber), address, endpoint)
 		if err != nil {
-			fmt.Println("Error getting transactions for block number", blockNumber, "and address", address)
-			continue
+			return false, fmt.Errorf("error getting transactions for block number %d and address %s: %v", blockNumber, address, err)
 		}
-		s.mu.Lock()
 		for _, tx := range transactions {
 			txHash, _ := tx["hash"].(string)
 			blockHash, _ := tx["blockHash"].(string)
@@ -101,41 +326,134 @@ func (s *MyParser) ProcessBlock(blockNumber int64, endpoint string) bool {
 			if tx["type"] != nil {
 				txtype = tx["type"].(string)
 			}
-			txDetails := Transaction{
+			value, _ := tx["value"].(string)
+			txHashes = append(txHashes, txHash)
+			found[address] = append(found[address], Transaction{
 				Txhash:      txHash,
 				Blockhash:   blockHash,
 				From:        from,
 				To:          to,
+				Value:       value,
 				BlockNumber: tx["blockNumber"].(string),
 				Txtype:      txtype,
 				GasUsed:     tx["gas"].(string),
 				GasPrice:    tx["gasPrice"].(string),
 				Nonce:       tx["nonce"].(string),
+			})
+		}
+	}
+
+	// Receipts are fetched together. One the node doesn't return leaves
+	// the status and contract address of its transaction empty.
+	slices.Sort(txHashes)
+	receipts, err := rpcclient.GetTransactionReceipts(slices.Compact(txHashes), endpoint)
+	if err != nil {
+		return false, fmt.Errorf("error getting receipts of block %d: %v", blockNumber, err)
+	}
+	for _, transactions := range found {
+		for i := range transactions {
+			receipt, exists := receipts[transactions[i].Txhash]
+			if !exists {
+				fmt.Printf("Warning: no receipt for transaction %s, its status is unknown\n", transactions[i].Txhash)
+				continue
 			}
-			if !s.transactionExists(details.Transactions, txDetails.Txhash) {
-				txfound = true
-				details.Transactions = append(details.Transactions, txDetails)
-				fmt.Printf("Transaction found for address: %s; Hash: %s; Block: %s\n", address, txHash, strconv.FormatInt(blockNumber, 10))
-			}
+			transactions[i].Status, _ = receipt["status"].(string)
+			transactions[i].ContractAddress, _ = receipt["contractAddress"].(string)
 		}
-		s.mu.Unlock()
 	}
-	return txfound
+
+	s.publishMu.Lock()
+	defer s.publishMu.Unlock()
+	events, err := s.commitBlock(blockNumber, blockHash, found)
+	if err != nil {
+		return false, err
+	}
+	if err := s.publish(events); err != nil {
+		return false, fmt.Errorf("block %d was saved but its events were not: %v", blockNumber, err)
+	}
+	for _, event := range events {
+		if event.Type == TxDetected {
+			return true, nil
+		}
+	}
+	return false, nil
 }
 
-func (s *MyParser) transactionExists(transactions []Transaction, txHash string) bool {
-	for _, tx := range transactions {
-		if tx.Txhash == txHash {
-			return true
+// commitBlock saves the transactions found in block blockNumber that are
+// not recorded yet, and returns the events to publish for it. It must be
+// called with publishMu held, which keeps the recorded data from changing
+// while it is saved without mu.
+func (s *MyParser) commitBlock(blockNumber int64, blockHash string, found map[string][]Transaction) ([]Event, error) {
+	// Skip transactions already recorded and addresses unsubscribed meanwhile
+	s.mu.RLock()
+	newTransactions := make(map[string][]Transaction)
+	for address, transactions := range found {
+		hashes, exists := s.hashes[address]
+		if !exists {
+			continue
+		}
+		for _, tx := range transactions {
+			if _, exists := hashes[tx.Txhash]; !exists {
+				newTransactions[address] = append(newTransactions[address], tx)
+			}
+		}
+	}
+	s.mu.RUnlock()
+
+	start := time.Now()
+	err := s.storage.AppendBlock(blockNumber, newTransactions)
+	s.recordWrite(opAppendBlock, start, err)
+	if err != nil {
+		return nil, fmt.Errorf("error saving block %d: %v", blockNumber, err)
+	}
+	s.recordBlock(newTransactions)
+
+	s.mu.Lock()
+	for address, transactions := range newTransactions {
+		details := s.subscribedAddresses[address]
+		details.Transactions = append(details.Transactions, transactions...)
+		for _, tx := range transactions {
+			s.hashes[address][tx.Txhash] = struct{}{}
 		}
 	}
-	return false
+	s.latestProcessedBlockNumber = blockNumber
+	events := transactionEvents(s.chainID, TxDetected, newTransactions)
+	events = append(events, s.confirm(blockNumber, blockHash)...)
+	count := 0
+	for _, transactions := range newTransactions {
+		count += len(transactions)
+	}
+	events = append(events, Event{Type: BlockProcessed, ChainID: s.chainID, Block: blockNumber, BlockHash: blockHash, Transactions: count})
+	s.mu.Unlock()
+
+	// The block is already stored, so a pruning failure is retried with
+	// the next block instead of failing this one
+	if err := s.prune(blockNumber); err != nil {
+		fmt.Printf("Error pruning transactions: %v\n", err)
+	}
+	return events, nil
+}
+
+func copyTransactions(transactions []Transaction) []Transaction {
+	copied := make([]Transaction, len(transactions))
+	copy(copied, transactions)
+	return copied
 }
 
-func (s *MyParser) Save() {
-	if s.storage != nil {
-		s.storage.Save(s.subscribedAddresses, s.latestProcessedBlockNumber)
+// pageTransactions returns up to limit transactions starting at offset. A
+// limit of 0 or less means no limit.
+func pageTransactions(transactions []Transaction, offset, limit int) []Transaction {
+	if offset < 0 {
+		offset = 0
 	}
+	if offset > len(transactions) {
+		offset = len(transactions)
+	}
+	end := len(transactions)
+	if limit > 0 && offset+limit < end {
+		end = offset + limit
+	}
+	return copyTransactions(transactions[offset:end])
 }
 
 func (s *MyParser) Loop(ctx context.Context, endpoint string) {
@@ -144,66 +462,231 @@ func (s *MyParser) Loop(ctx context.Context, endpoint string) {
 	for {
 		select {
 		case <-ctx.Done():
-			fmt.Println("Loop stopped, saving data...")
-			s.Save()
+			fmt.Println("Loop stopped")
 			return
 		case <-ticker.C:
-			txfound := false
 			//fmt.Println("Looping")
 			latestBlockNumber, err := s.PollLatestBlock(endpoint)
 			if err != nil {
 				fmt.Println("Error polling latest block:", err)
 				continue
 			}
-			if latestBlockNumber > s.latestProcessedBlockNumber {
-				for blockNumber := s.latestProcessedBlockNumber + 1; blockNumber <= latestBlockNumber; blockNumber++ {
-					fmt.Println("Processing block number:", blockNumber)
-					s.latestProcessedBlockNumber = blockNumber
-					txfound = s.ProcessBlock(blockNumber, endpoint)
-				}
-				if txfound {
-					s.Save()
+			s.checkLag(latestBlockNumber)
+			// A reorganization moves the current block back, so it is read
+			// again for every block
+			for ctx.Err() == nil && s.currentBlock() < latestBlockNumber {
+				blockNumber := s.currentBlock() + 1
+				fmt.Println("Processing block number:", blockNumber)
+				if _, err := s.ProcessBlock(blockNumber, endpoint); err != nil {
+					fmt.Println("Error processing block:", err)
+					break
 				}
 			}
 		}
 	}
 }
 
-func (s *MyParser) GetCurrentBlock() int {
+// SetLagThreshold sets how many blocks the parser may be behind the chain
+// head before it publishes ParserLagging events and is no longer ready.
+func (s *MyParser) SetLagThreshold(blocks int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lagThreshold = blocks
+}
+
+// checkLag publishes a ParserLagging event if the current block is further
+// behind head than the lag threshold.
+func (s *MyParser) checkLag(head int64) {
+	s.mu.RLock()
+	current, threshold := s.latestProcessedBlockNumber, s.lagThreshold
+	s.mu.RUnlock()
+	if head-current > threshold {
+		s.events.Publish(Event{Type: ParserLagging, ChainID: s.chainID, Block: current, Head: head})
+	}
+}
+
+// SetNameResolver enables subscribing by name and reverse lookups.
+func (s *MyParser) SetNameResolver(names NameResolver) {
+	s.names = names
+}
+
+// ResolveAddress returns the canonical lowercase address for a hex address
+// or, when a NameResolver is set, an ENS name.
+func (s *MyParser) ResolveAddress(ctx context.Context, address string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	if ens.IsName(address) {
+		if s.names == nil {
+			return "", fmt.Errorf("%w: name resolution is not enabled", ErrInvalidAddress)
+		}
+		resolved, err := s.names.Resolve(address)
+		if errors.Is(err, ens.ErrNotFound) {
+			return "", fmt.Errorf("%w: %v", ErrInvalidAddress, err)
+		}
+		return resolved, err
+	}
+	normalized, err := utils.NormalizeAddress(address)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidAddress, err)
+	}
+	return normalized, nil
+}
+
+// LookupName returns the primary name of address, or an empty string when
+// it has none or no NameResolver is set.
+func (s *MyParser) LookupName(ctx context.Context, address string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	if s.names == nil || address == "" {
+		return "", nil
+	}
+	name, err := s.names.LookupAddress(address)
+	if errors.Is(err, ens.ErrNotFound) {
+		return "", nil
+	}
+	return name, err
+}
+
+// ChainID returns the id of the chain this parser tracks.
+func (s *MyParser) ChainID() int64 {
+	return s.chainID
+}
+
+func (s *MyParser) GetCurrentBlock(ctx context.Context) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return s.currentBlock(), nil
+}
+
+func (s *MyParser) currentBlock() int64 {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	return int(s.latestProcessedBlockNumber)
+	return s.latestProcessedBlockNumber
 }
 
-func (s *MyParser) Subscribe(address string) bool {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+func (s *MyParser) Subscribe(ctx context.Context, address string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	address, err := s.ResolveAddress(ctx, address)
+	if err != nil {
+		return err
+	}
 
-	if _, exists := s.subscribedAddresses[address]; exists {
-		return false
+	s.publishMu.Lock()
+	defer s.publishMu.Unlock()
+
+	s.mu.RLock()
+	_, exists := s.subscribedAddresses[address]
+	s.mu.RUnlock()
+	if exists {
+		return ErrAlreadySubscribed
+	}
+	start := time.Now()
+	err = s.storage.AddSubscription(address)
+	s.recordWrite(opAddSubscription, start, err)
+	if err != nil {
+		return fmt.Errorf("error saving subscription: %v", err)
 	}
 
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.subscribedAddresses[address] = &AddressTransactions{
 		Transactions: []Transaction{},
 	}
-	return true
+	s.hashes[address] = make(map[string]struct{})
+	return nil
 }
 
-func (s *MyParser) GetSubscriptions() []string {
+// Unsubscribe stops observing address and drops its transactions.
+func (s *MyParser) Unsubscribe(ctx context.Context, address string) error {
+	address, err := s.ResolveAddress(ctx, address)
+	if err != nil {
+		return err
+	}
+
+	s.publishMu.Lock()
+	defer s.publishMu.Unlock()
+
+	s.mu.RLock()
+	_, exists := s.subscribedAddresses[address]
+	s.mu.RUnlock()
+	if !exists {
+		return ErrNotSubscribed
+	}
+	start := time.Now()
+	err = s.storage.RemoveSubscription(address)
+	s.recordWrite(opRemoveSubscription, start, err)
+	if err != nil {
+		return fmt.Errorf("error removing subscription: %v", err)
+	}
+	s.mu.Lock()
+	delete(s.subscribedAddresses, address)
+	delete(s.hashes, address)
+	s.mu.Unlock()
+	transactionsDetected.Delete(s.chainLabel(), address)
+	if err := s.Webhooks().Remove(address); err != nil && !errors.Is(err, ErrWebhookNotFound) {
+		fmt.Printf("Error removing webhook: %v\n", err)
+	}
+	return nil
+}
+
+func (s *MyParser) GetSubscriptions(ctx context.Context) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.subscriptions(), nil
+}
+
+func (s *MyParser) subscriptions() []string {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	var subscriptions []string
+	subscriptions := make([]string, 0, len(s.subscribedAddresses))
 	for address := range s.subscribedAddresses {
 		subscriptions = append(subscriptions, address)
 	}
 	return subscriptions
 }
 
-func (s *MyParser) GetTransactions(address string) []Transaction {
+// GetTransactions returns a copy of the transactions recorded for address.
+// It returns ErrNotSubscribed when the address is not subscribed, and an
+// empty slice when it is subscribed but has no transactions yet.
+func (s *MyParser) GetTransactions(ctx context.Context, address string) ([]Transaction, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	address, err := s.ResolveAddress(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	addrTrans, exists := s.subscribedAddresses[address]
 	if !exists {
-		return nil
+		return nil, ErrNotSubscribed
+	}
+	return copyTransactions(addrTrans.Transactions), nil
+}
+
+// GetTransactionsPage returns up to limit transactions recorded for address,
+// starting at offset, read from storage.
+func (s *MyParser) GetTransactionsPage(ctx context.Context, address string, offset, limit int) ([]Transaction, error) {
+	address, err := s.ResolveAddress(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, exists := s.subscribedAddresses[address]; !exists {
+		return nil, ErrNotSubscribed
 	}
-	return addrTrans.Transactions
+	return s.storage.GetTransactions(address, offset, limit)
 }
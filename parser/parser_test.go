@@ -0,0 +1,387 @@
+package parser
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/EliasManj/tx-parser/ens"
+	"github.com/EliasManj/tx-parser/utils"
+	"github.com/stretchr/testify/require"
+)
+
+// rpcRequest is a JSON-RPC call received by a test server.
+type rpcRequest struct {
+	ID     interface{}   `json:"id"`
+	Method string        `json:"method"`
+	Params []interface{} `json:"params"`
+}
+
+// serveRPC starts a JSON-RPC server that answers calls, single or batched,
+// with the result of handle.
+func serveRPC(t *testing.T, handle func(req rpcRequest) interface{}) *httptest.Server {
+	respond := func(req rpcRequest) map[string]interface{} {
+		return map[string]interface{}{"jsonrpc": "2.0", "id": req.ID, "result": handle(req)}
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if bytes.HasPrefix(bytes.TrimSpace(body), []byte("[")) {
+			var batch []rpcRequest
+			if err := json.Unmarshal(body, &batch); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			responses := make([]map[string]interface{}, len(batch))
+			for i, req := range batch {
+				responses[i] = respond(req)
+			}
+			json.NewEncoder(w).Encode(responses)
+			return
+		}
+		var req rpcRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(respond(req))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// newFakeRPC starts a JSON-RPC server whose blocks each contain one
+// transaction from every address in senders, with the sender's index as
+// value. Transactions of the second sender fail, and those of the third
+// have no receipt.
+func newFakeRPC(t *testing.T, senders []string) *httptest.Server {
+	return serveRPC(t, func(req rpcRequest) interface{} {
+		switch req.Method {
+		case "eth_chainId":
+			return "0x1"
+		case "eth_blockNumber":
+			return "0x10"
+		case "eth_getBlockByNumber":
+			blockNumber := req.Params[0].(string)
+			var txs []interface{}
+			for i, sender := range senders {
+				txs = append(txs, map[string]interface{}{
+					"hash":        fmt.Sprintf("%s-%d", blockNumber, i),
+					"blockHash":   "0xblock" + blockNumber,
+					"blockNumber": blockNumber,
+					"from":        sender,
+					"to":          "0x0000000000000000000000000000000000000000",
+					"value":       utils.IntToHex(int64(i)),
+					"type":        "0x2",
+					"gas":         "0x5208",
+					"gasPrice":    "0x1",
+					"nonce":       "0x0",
+				})
+			}
+			number, _ := utils.HexToDec(blockNumber)
+			return map[string]interface{}{
+				"hash":         "0xblock" + blockNumber,
+				"parentHash":   "0xblock" + utils.IntToHex(number.Int64()-1),
+				"transactions": txs,
+			}
+		case "eth_getTransactionReceipt":
+			switch hash := req.Params[0].(string); {
+			case strings.HasSuffix(hash, "-1"):
+				return map[string]interface{}{"status": "0x0"}
+			case strings.HasSuffix(hash, "-2"):
+				return nil
+			}
+			return map[string]interface{}{"status": "0x1"}
+		}
+		return nil
+	})
+}
+
+func testAddresses(n int) []string {
+	addresses := make([]string, n)
+	for i := range addresses {
+		addresses[i] = fmt.Sprintf("0x%040x", i+1)
+	}
+	return addresses
+}
+
+func TestProcessBlock(t *testing.T) {
+	addresses := testAddresses(2)
+	rpc := newFakeRPC(t, addresses)
+	p := NewParser(nil, 0)
+
+	ctx := context.Background()
+	require.NoError(t, p.Subscribe(ctx, addresses[0]))
+	require.ErrorIs(t, p.Subscribe(ctx, addresses[0]), ErrAlreadySubscribed)
+
+	found, err := p.ProcessBlock(1, rpc.URL)
+	require.NoError(t, err)
+	require.True(t, found)
+	found, err = p.ProcessBlock(1, rpc.URL)
+	require.NoError(t, err)
+	require.False(t, found, "Expected duplicate transactions to be ignored")
+
+	transactions, err := p.GetTransactions(ctx, addresses[0])
+	require.NoError(t, err)
+	require.Len(t, transactions, 1)
+	require.Equal(t, utils.IntToHex(1), transactions[0].BlockNumber)
+	require.Equal(t, int64(1), p.currentBlock())
+
+	_, err = p.GetTransactions(ctx, addresses[1])
+	require.ErrorIs(t, err, ErrNotSubscribed)
+}
+
+func TestProcessBlockReceipts(t *testing.T) {
+	addresses := testAddresses(3)
+	rpc := newFakeRPC(t, addresses)
+	p := NewParser(nil, 0)
+	ctx := context.Background()
+	for _, address := range addresses {
+		require.NoError(t, p.Subscribe(ctx, address))
+	}
+
+	// A missing receipt leaves the status unknown instead of failing the block
+	_, err := p.ProcessBlock(1, rpc.URL)
+	require.NoError(t, err)
+	for i, status := range []string{"0x1", "0x0", ""} {
+		transactions, err := p.GetTransactions(ctx, addresses[i])
+		require.NoError(t, err)
+		require.Len(t, transactions, 1)
+		require.Equal(t, status, transactions[0].Status)
+	}
+}
+
+// blockingStorage holds AppendBlock until release is closed.
+type blockingStorage struct {
+	*MemoryStorage
+	appending chan struct{}
+	release   chan struct{}
+}
+
+func (s *blockingStorage) AppendBlock(blockNumber int64, transactions map[string][]Transaction) error {
+	s.appending <- struct{}{}
+	<-s.release
+	return s.MemoryStorage.AppendBlock(blockNumber, transactions)
+}
+
+func TestParserReadsDuringWrites(t *testing.T) {
+	addresses := testAddresses(1)
+	rpc := newFakeRPC(t, addresses)
+	storage := &blockingStorage{NewMemoryStorage(RetentionPolicy{}), make(chan struct{}), make(chan struct{})}
+	p := NewParser(storage, 0)
+	ctx := context.Background()
+	require.NoError(t, p.Subscribe(ctx, addresses[0]))
+
+	done := make(chan error)
+	go func() {
+		_, err := p.ProcessBlock(1, rpc.URL)
+		done <- err
+	}()
+	<-storage.appending
+
+	// Reads see the previous state while the block is saved
+	transactions, err := p.GetTransactions(ctx, addresses[0])
+	require.NoError(t, err)
+	require.Empty(t, transactions)
+	require.Equal(t, int64(0), p.currentBlock())
+
+	close(storage.release)
+	require.NoError(t, <-done)
+	transactions, err = p.GetTransactions(ctx, addresses[0])
+	require.NoError(t, err)
+	require.Len(t, transactions, 1)
+}
+
+func TestSubscribeErrors(t *testing.T) {
+	p := NewParser(nil, 0)
+	ctx := context.Background()
+
+	require.ErrorIs(t, p.Subscribe(ctx, "hello"), ErrInvalidAddress)
+	require.ErrorIs(t, p.Subscribe(ctx, "0x123"), ErrInvalidAddress)
+	require.ErrorIs(t, p.Subscribe(ctx, "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAeD"), ErrInvalidAddress)
+
+	require.NoError(t, p.Subscribe(ctx, "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"))
+	require.ErrorIs(t, p.Subscribe(ctx, "0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed"), ErrAlreadySubscribed)
+
+	address := testAddresses(1)[0]
+	require.NoError(t, p.Subscribe(ctx, address))
+	transactions, err := p.GetTransactions(ctx, address)
+	require.NoError(t, err)
+	require.NotNil(t, transactions)
+	require.Empty(t, transactions)
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	require.ErrorIs(t, p.Subscribe(cancelled, testAddresses(2)[1]), context.Canceled)
+}
+
+type fakeNames map[string]string
+
+func (f fakeNames) Resolve(name string) (string, error) {
+	for address, n := range f {
+		if n == name {
+			return address, nil
+		}
+	}
+	return "", ens.ErrNotFound
+}
+
+func (f fakeNames) LookupAddress(address string) (string, error) {
+	if name, ok := f[address]; ok {
+		return name, nil
+	}
+	return "", ens.ErrNotFound
+}
+
+func TestSubscribeByName(t *testing.T) {
+	address := testAddresses(1)[0]
+	p := NewParser(nil, 0)
+	ctx := context.Background()
+
+	require.ErrorIs(t, p.Subscribe(ctx, "alice.eth"), ErrInvalidAddress, "Expected names to be rejected without a resolver")
+
+	p.SetNameResolver(fakeNames{address: "alice.eth"})
+	require.NoError(t, p.Subscribe(ctx, "alice.eth"))
+	require.ErrorIs(t, p.Subscribe(ctx, address), ErrAlreadySubscribed)
+	require.ErrorIs(t, p.Subscribe(ctx, "bob.eth"), ErrInvalidAddress)
+
+	name, err := p.LookupName(ctx, address)
+	require.NoError(t, err)
+	require.Equal(t, "alice.eth", name)
+
+	name, err = p.LookupName(ctx, testAddresses(2)[1])
+	require.NoError(t, err)
+	require.Empty(t, name)
+}
+
+func TestLegacyAdapter(t *testing.T) {
+	addresses := testAddresses(1)
+	rpc := newFakeRPC(t, addresses)
+	p := NewParser(nil, 0)
+	legacy := NewLegacyAdapter(p)
+
+	require.True(t, legacy.Subscribe(addresses[0]))
+	require.False(t, legacy.Subscribe(addresses[0]))
+	require.False(t, legacy.Subscribe("hello"))
+	require.Nil(t, legacy.GetTransactions(testAddresses(2)[1]))
+
+	p.ProcessBlock(1, rpc.URL)
+	require.Equal(t, 1, legacy.GetCurrentBlock())
+	require.Len(t, legacy.GetTransactions(addresses[0]), 1)
+}
+
+func TestGetTransactionsReturnsCopy(t *testing.T) {
+	addresses := testAddresses(1)
+	rpc := newFakeRPC(t, addresses)
+	p := NewParser(nil, 0)
+	legacy := NewLegacyAdapter(p)
+	legacy.Subscribe(addresses[0])
+	p.ProcessBlock(1, rpc.URL)
+
+	transactions := legacy.GetTransactions(addresses[0])
+	transactions[0].Txhash = "modified"
+	transactions = append(transactions, Transaction{Txhash: "appended"})
+
+	stored := legacy.GetTransactions(addresses[0])
+	require.Len(t, stored, 1)
+	require.NotEqual(t, "modified", stored[0].Txhash)
+}
+
+func TestProcessBlockWithStorage(t *testing.T) {
+	addresses := testAddresses(2)
+	rpc := newFakeRPC(t, addresses)
+	storage := &JsonFileStorage{FilePath: filepath.Join(t.TempDir(), "data.json"), ChainID: 1}
+	p := NewParser(storage, 0)
+	ctx := context.Background()
+
+	require.NoError(t, p.Subscribe(ctx, addresses[0]))
+	require.NoError(t, p.Subscribe(ctx, addresses[1]))
+	for blockNumber := int64(1); blockNumber <= 3; blockNumber++ {
+		_, err := p.ProcessBlock(blockNumber, rpc.URL)
+		require.NoError(t, err)
+	}
+	require.NoError(t, p.Unsubscribe(ctx, addresses[1]))
+	require.ErrorIs(t, p.Unsubscribe(ctx, addresses[1]), ErrNotSubscribed)
+
+	// A new parser picks up exactly the stored progress
+	reloaded := NewParser(storage, 0)
+	require.Equal(t, int64(3), reloaded.currentBlock())
+	subscriptions, err := reloaded.GetSubscriptions(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{addresses[0]}, subscriptions)
+
+	page, err := reloaded.GetTransactionsPage(ctx, addresses[0], 1, 1)
+	require.NoError(t, err)
+	require.Len(t, page, 1)
+	require.Equal(t, utils.IntToHex(2), page[0].BlockNumber)
+}
+
+func TestProcessBlockRPCFailure(t *testing.T) {
+	addresses := testAddresses(1)
+	rpc := newFakeRPC(t, addresses)
+	p := NewParser(nil, 0)
+	require.NoError(t, p.Subscribe(context.Background(), addresses[0]))
+	rpc.Close()
+
+	_, err := p.ProcessBlock(1, rpc.URL)
+	require.Error(t, err)
+	require.Equal(t, int64(0), p.currentBlock(), "Expected the current block not to advance")
+}
+
+func TestConcurrentAccess(t *testing.T) {
+	addresses := testAddresses(8)
+	rpc := newFakeRPC(t, addresses)
+	p := NewParser(nil, 0)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for _, address := range addresses {
+		wg.Add(1)
+		go func(address string) {
+			defer wg.Done()
+			p.Subscribe(ctx, address)
+		}(address)
+	}
+	for blockNumber := int64(1); blockNumber <= 5; blockNumber++ {
+		wg.Add(1)
+		go func(blockNumber int64) {
+			defer wg.Done()
+			p.ProcessBlock(blockNumber, rpc.URL)
+		}(blockNumber)
+	}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(address string) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				p.GetTransactions(ctx, address)
+				p.GetSubscriptions(ctx)
+				p.GetCurrentBlock(ctx)
+				p.GetTransactionsPage(ctx, address, 0, 1)
+			}
+		}(addresses[i])
+	}
+	wg.Wait()
+
+	subscriptions, err := p.GetSubscriptions(ctx)
+	require.NoError(t, err)
+	require.Len(t, subscriptions, len(addresses))
+	for _, address := range addresses {
+		transactions, err := p.GetTransactions(ctx, address)
+		require.NoError(t, err)
+		for _, tx := range transactions {
+			require.Equal(t, address, tx.From)
+		}
+	}
+}
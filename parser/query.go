@@ -0,0 +1,283 @@
+package parser
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/EliasManj/tx-parser/utils"
+)
+
+const (
+	// DefaultQueryLimit is the page size used when a query sets no limit.
+	DefaultQueryLimit = 50
+
+	// MaxQueryLimit caps the page size of a query.
+	MaxQueryLimit = 1000
+)
+
+// Direction of a transaction relative to the queried address.
+const (
+	DirectionIn   = "in"
+	DirectionOut  = "out"
+	DirectionSelf = "self"
+)
+
+// Receipt statuses accepted by TransactionQuery.Status.
+const (
+	StatusSuccess = "success"
+	StatusFailed  = "failed"
+)
+
+// Sort orders accepted by TransactionQuery.Sort.
+const (
+	SortAsc  = "asc"
+	SortDesc = "desc"
+)
+
+// TransactionQuery filters and pages the transactions of an address. Zero
+// values match everything.
+type TransactionQuery struct {
+	// in, out or self
+	Direction string
+
+	// Inclusive block range, 0 leaves the range open
+	FromBlock int64
+	ToBlock   int64
+
+	// Transaction type, e.g. 0x2
+	Type string
+
+	// Minimum value in wei
+	MinValue *big.Int
+
+	// success or failed
+	Status string
+
+	// Address or ENS name on the other side of the transaction
+	Counterparty string
+
+	// asc (oldest first, the default) or desc
+	Sort string
+
+	// Page size, DefaultQueryLimit if 0
+	Limit int
+
+	// Cursor returned with the previous page
+	Cursor string
+}
+
+// queryCursor is the position of the last transaction of a page. The block
+// number lets a query resume if that transaction has since been pruned.
+type queryCursor struct {
+	Block int64  `json:"b"`
+	Hash  string `json:"h"`
+}
+
+func encodeCursor(tx Transaction) string {
+	block, _ := utils.HexToDec(tx.BlockNumber)
+	cursor := queryCursor{Hash: tx.Txhash}
+	if block != nil {
+		cursor.Block = block.Int64()
+	}
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(value string) (queryCursor, error) {
+	var cursor queryCursor
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return cursor, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.Hash == "" {
+		return cursor, ErrInvalidCursor
+	}
+	return cursor, nil
+}
+
+// validate checks the query and fills in defaults.
+func (q *TransactionQuery) validate() error {
+	switch q.Direction {
+	case "", DirectionIn, DirectionOut, DirectionSelf:
+	default:
+		return fmt.Errorf("%w: unknown direction %q", ErrInvalidQuery, q.Direction)
+	}
+	switch q.Status {
+	case "", StatusSuccess, StatusFailed:
+	default:
+		return fmt.Errorf("%w: unknown status %q", ErrInvalidQuery, q.Status)
+	}
+	switch q.Sort {
+	case "":
+		q.Sort = SortAsc
+	case SortAsc, SortDesc:
+	default:
+		return fmt.Errorf("%w: unknown sort order %q", ErrInvalidQuery, q.Sort)
+	}
+	if q.FromBlock < 0 || q.ToBlock < 0 || (q.ToBlock > 0 && q.FromBlock > q.ToBlock) {
+		return fmt.Errorf("%w: invalid block range", ErrInvalidQuery)
+	}
+	if q.MinValue != nil && q.MinValue.Sign() < 0 {
+		return fmt.Errorf("%w: negative minimum value", ErrInvalidQuery)
+	}
+	if q.Limit < 0 || q.Limit > MaxQueryLimit {
+		return fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidQuery, MaxQueryLimit)
+	}
+	if q.Limit == 0 {
+		q.Limit = DefaultQueryLimit
+	}
+	if q.Type != "" {
+		txtype, err := utils.HexToDec(q.Type)
+		if err != nil {
+			return fmt.Errorf("%w: invalid transaction type %q", ErrInvalidQuery, q.Type)
+		}
+		q.Type = "0x" + txtype.Text(16)
+	}
+	return nil
+}
+
+// matches reports whether tx of address passes the query's filters.
+func (q *TransactionQuery) matches(address string, tx Transaction) bool {
+	from := strings.EqualFold(tx.From, address)
+	to := strings.EqualFold(tx.To, address)
+	switch q.Direction {
+	case DirectionIn:
+		if !to || from {
+			return false
+		}
+	case DirectionOut:
+		if !from || to {
+			return false
+		}
+	case DirectionSelf:
+		if !from || !to {
+			return false
+		}
+	}
+	if q.Counterparty != "" {
+		counterparty := tx.To
+		if to {
+			counterparty = tx.From
+		}
+		if !strings.EqualFold(counterparty, q.Counterparty) {
+			return false
+		}
+	}
+	if q.FromBlock > 0 || q.ToBlock > 0 {
+		block, err := utils.HexToDec(tx.BlockNumber)
+		if err != nil || block.Int64() < q.FromBlock || (q.ToBlock > 0 && block.Int64() > q.ToBlock) {
+			return false
+		}
+	}
+	if q.Type != "" {
+		txtype, err := utils.HexToDec(tx.Txtype)
+		if err != nil || "0x"+txtype.Text(16) != q.Type {
+			return false
+		}
+	}
+	if q.MinValue != nil {
+		value, err := utils.HexToDec(tx.Value)
+		if err != nil || value.Cmp(q.MinValue) < 0 {
+			return false
+		}
+	}
+	switch q.Status {
+	case StatusSuccess:
+		return tx.Status == "0x1"
+	case StatusFailed:
+		return tx.Status == "0x0"
+	}
+	return true
+}
+
+// start returns the index in transactions, ordered by block, of the first
+// transaction after cursor in the query's sort order. The index is
+// len(transactions) or -1 once there is nothing left.
+func (q *TransactionQuery) start(transactions []Transaction, cursor queryCursor) int {
+	for i, tx := range transactions {
+		if tx.Txhash == cursor.Hash {
+			if q.Sort == SortDesc {
+				return i - 1
+			}
+			return i + 1
+		}
+	}
+
+	// The transaction was pruned, resume at the next block
+	if q.Sort == SortDesc {
+		for i := len(transactions) - 1; i >= 0; i-- {
+			block, err := utils.HexToDec(transactions[i].BlockNumber)
+			if err == nil && block.Int64() < cursor.Block {
+				return i
+			}
+		}
+		return -1
+	}
+	for i, tx := range transactions {
+		block, err := utils.HexToDec(tx.BlockNumber)
+		if err == nil && block.Int64() > cursor.Block {
+			return i
+		}
+	}
+	return len(transactions)
+}
+
+// QueryTransactions returns a page of the transactions of address matching
+// q, and the cursor of the next page, empty on the last page.
+func (s *MyParser) QueryTransactions(ctx context.Context, address string, q TransactionQuery) ([]Transaction, string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, "", err
+	}
+	if err := q.validate(); err != nil {
+		return nil, "", err
+	}
+	address, err := s.ResolveAddress(ctx, address)
+	if err != nil {
+		return nil, "", err
+	}
+	if q.Counterparty != "" {
+		q.Counterparty, err = s.ResolveAddress(ctx, q.Counterparty)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	var cursor queryCursor
+	if q.Cursor != "" {
+		cursor, err = decodeCursor(q.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	addrTrans, exists := s.subscribedAddresses[address]
+	if !exists {
+		return nil, "", ErrNotSubscribed
+	}
+	transactions := addrTrans.Transactions
+
+	i, step := 0, 1
+	if q.Sort == SortDesc {
+		i, step = len(transactions)-1, -1
+	}
+	if q.Cursor != "" {
+		i = q.start(transactions, cursor)
+	}
+	page := []Transaction{}
+	for ; i >= 0 && i < len(transactions); i += step {
+		if !q.matches(address, transactions[i]) {
+			continue
+		}
+		if len(page) == q.Limit {
+			return page, encodeCursor(page[len(page)-1]), nil
+		}
+		page = append(page, transactions[i])
+	}
+	return page, "", nil
+}
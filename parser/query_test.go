@@ -0,0 +1,111 @@
+package parser
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/EliasManj/tx-parser/utils"
+)
+
+const zeroAddress = "0x0000000000000000000000000000000000000000"
+
+func TestQueryTransactions(t *testing.T) {
+	addresses := testAddresses(3)
+	rpc := newFakeRPC(t, addresses)
+	p := NewParser(nil, 0)
+	ctx := context.Background()
+	require.NoError(t, p.Subscribe(ctx, addresses[0]))
+	require.NoError(t, p.Subscribe(ctx, zeroAddress))
+	for blockNumber := int64(1); blockNumber <= 4; blockNumber++ {
+		_, err := p.ProcessBlock(blockNumber, rpc.URL)
+		require.NoError(t, err)
+	}
+
+	hashes := func(txs []Transaction) []string {
+		result := []string{}
+		for _, tx := range txs {
+			result = append(result, tx.Txhash)
+		}
+		return result
+	}
+	tests := map[string]struct {
+		address string
+		query   TransactionQuery
+		want    []string
+	}{
+		"outgoing":     {addresses[0], TransactionQuery{Direction: DirectionOut}, []string{"0x1-0", "0x2-0", "0x3-0", "0x4-0"}},
+		"no incoming":  {addresses[0], TransactionQuery{Direction: DirectionIn}, []string{}},
+		"block range":  {zeroAddress, TransactionQuery{FromBlock: 2, ToBlock: 2}, []string{"0x2-0", "0x2-1", "0x2-2"}},
+		"counterparty": {zeroAddress, TransactionQuery{Counterparty: addresses[2], Sort: SortDesc}, []string{"0x4-2", "0x3-2", "0x2-2", "0x1-2"}},
+		"min value":    {zeroAddress, TransactionQuery{MinValue: big.NewInt(2), ToBlock: 2}, []string{"0x1-2", "0x2-2"}},
+		"failed":       {zeroAddress, TransactionQuery{Status: StatusFailed, FromBlock: 3}, []string{"0x3-1", "0x4-1"}},
+		"type":         {addresses[0], TransactionQuery{Type: "0x1"}, []string{}},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			txs, next, err := p.QueryTransactions(ctx, test.address, test.query)
+			require.NoError(t, err)
+			require.Equal(t, test.want, hashes(txs))
+			require.Empty(t, next)
+		})
+	}
+
+	txs, _, err := p.QueryTransactions(ctx, zeroAddress, TransactionQuery{Limit: 1})
+	require.NoError(t, err)
+	require.Equal(t, utils.IntToHex(0), txs[0].Value)
+	require.Equal(t, "0x1", txs[0].Status)
+
+	_, _, err = p.QueryTransactions(ctx, zeroAddress, TransactionQuery{Direction: "sideways"})
+	require.ErrorIs(t, err, ErrInvalidQuery)
+	_, _, err = p.QueryTransactions(ctx, zeroAddress, TransactionQuery{Cursor: "not a cursor"})
+	require.ErrorIs(t, err, ErrInvalidCursor)
+	_, _, err = p.QueryTransactions(ctx, addresses[1], TransactionQuery{})
+	require.ErrorIs(t, err, ErrNotSubscribed)
+}
+
+func TestQueryTransactionsCursor(t *testing.T) {
+	addresses := testAddresses(2)
+	rpc := newFakeRPC(t, addresses)
+	p := NewParser(nil, 0)
+	ctx := context.Background()
+	require.NoError(t, p.Subscribe(ctx, zeroAddress))
+	for blockNumber := int64(1); blockNumber <= 5; blockNumber++ {
+		_, err := p.ProcessBlock(blockNumber, rpc.URL)
+		require.NoError(t, err)
+	}
+
+	for _, sort := range []string{SortAsc, SortDesc} {
+		t.Run(sort, func(t *testing.T) {
+			all, next, err := p.QueryTransactions(ctx, zeroAddress, TransactionQuery{Sort: sort})
+			require.NoError(t, err)
+			require.Len(t, all, 10)
+			require.Empty(t, next)
+
+			var paged []Transaction
+			q := TransactionQuery{Sort: sort, Limit: 3}
+			for {
+				page, next, err := p.QueryTransactions(ctx, zeroAddress, q)
+				require.NoError(t, err)
+				paged = append(paged, page...)
+				if next == "" {
+					break
+				}
+				q.Cursor = next
+			}
+			require.Equal(t, all, paged)
+		})
+	}
+
+	// A cursor still resumes after its transaction was pruned
+	page, next, err := p.QueryTransactions(ctx, zeroAddress, TransactionQuery{Limit: 3})
+	require.NoError(t, err)
+	require.Equal(t, "0x2-0", page[2].Txhash)
+	p.SetRetention(RetentionPolicy{MaxAge: 3}, "")
+	require.NoError(t, p.prune(5))
+	page, _, err = p.QueryTransactions(ctx, zeroAddress, TransactionQuery{Limit: 3, Cursor: next})
+	require.NoError(t, err)
+	require.Equal(t, "0x3-0", page[0].Txhash)
+}
@@ -0,0 +1,167 @@
+package parser
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/EliasManj/tx-parser/rpcclient"
+	"github.com/EliasManj/tx-parser/utils"
+)
+
+// DefaultConfirmations is the number of blocks, counting its own, after
+// which a transaction is considered final.
+const DefaultConfirmations = 12
+
+// Reverter is implemented by storages that can undo processed blocks after
+// a chain reorganization.
+type Reverter interface {
+	// RevertBlocks removes the newest removed[address] transactions of each
+	// address and sets the latest processed block back to latest.
+	RevertBlocks(latest int64, removed map[string]int) error
+}
+
+// SetConfirmations sets the number of blocks, counting its own, after which
+// a transaction is confirmed. Reorganizations are detected up to that
+// depth, confirmed transactions are never reverted.
+func (s *MyParser) SetConfirmations(confirmations int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.confirmations = max(confirmations, 1)
+}
+
+// blockNumber returns the block number of tx, or -1 if it is invalid.
+func blockNumber(tx Transaction) int64 {
+	number, err := utils.HexToDec(tx.BlockNumber)
+	if err != nil {
+		return -1
+	}
+	return number.Int64()
+}
+
+// checkReorg compares the parent hash of block blockNumber with the hash
+// recorded for the previous block. On a mismatch it looks for the last
+// block both chains share and reverts the blocks after it, reporting
+// whether it did.
+func (s *MyParser) checkReorg(blockNumber int64, parentHash string, endpoint string) (bool, error) {
+	s.mu.RLock()
+	known, exists := s.blockHashes[blockNumber-1]
+	s.mu.RUnlock()
+	if !exists || known == parentHash {
+		return false, nil
+	}
+
+	// Blocks no longer recorded are confirmed, and final
+	fork := blockNumber - 1
+	for {
+		s.mu.RLock()
+		known, exists := s.blockHashes[fork]
+		s.mu.RUnlock()
+		if !exists {
+			break
+		}
+		header, err := rpcclient.GetBlockHeader(utils.IntToHex(fork), endpoint)
+		if err != nil {
+			return false, fmt.Errorf("error getting block %d: %v", fork, err)
+		}
+		if header["hash"] == known {
+			break
+		}
+		fork--
+	}
+	if err := s.revert(fork); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// revert drops the transactions recorded after block fork and continues
+// from it.
+func (s *MyParser) revert(fork int64) error {
+	s.publishMu.Lock()
+	defer s.publishMu.Unlock()
+	events, err := s.revertBlocks(fork)
+	if err != nil {
+		return err
+	}
+	return s.publish(events)
+}
+
+// revertBlocks reverts the blocks after fork and returns the events of the
+// removed transactions. It must be called with publishMu held.
+func (s *MyParser) revertBlocks(fork int64) ([]Event, error) {
+	s.mu.RLock()
+	removed := make(map[string][]Transaction)
+	counts := make(map[string]int)
+	for address, details := range s.subscribedAddresses {
+		keep := len(details.Transactions)
+		for keep > 0 && blockNumber(details.Transactions[keep-1]) > fork {
+			keep--
+		}
+		if keep < len(details.Transactions) {
+			removed[address] = copyTransactions(details.Transactions[keep:])
+			counts[address] = len(details.Transactions) - keep
+		}
+	}
+	s.mu.RUnlock()
+
+	var err error
+	start := time.Now()
+	if reverter, ok := s.storage.(Reverter); ok {
+		err = reverter.RevertBlocks(fork, counts)
+	} else {
+		fmt.Printf("Warning: %s does not support reverting blocks, removed transactions stay stored\n", s.storage.Display())
+		err = s.storage.AppendBlock(fork, nil)
+	}
+	s.recordWrite(opRevertBlocks, start, err)
+	if err != nil {
+		return nil, fmt.Errorf("error reverting to block %d: %v", fork, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for address, txs := range removed {
+		details := s.subscribedAddresses[address]
+		details.Transactions = details.Transactions[:len(details.Transactions)-len(txs)]
+		for _, tx := range txs {
+			delete(s.hashes[address], tx.Txhash)
+		}
+	}
+	for number := range s.blockHashes {
+		if number > fork {
+			delete(s.blockHashes, number)
+		}
+	}
+	fmt.Printf("Chain reorganization: reverted blocks %d to %d\n", fork+1, s.latestProcessedBlockNumber)
+	s.latestProcessedBlockNumber = fork
+
+	return transactionEvents(s.chainID, TxRemoved, removed), nil
+}
+
+// confirm records the hash of block latest, the latest processed block, and
+// returns the events of the transactions it confirms.
+func (s *MyParser) confirm(latest int64, hash string) []Event {
+	s.blockHashes[latest] = hash
+	confirmed := latest - s.confirmations + 1
+	for number := range s.blockHashes {
+		if number <= confirmed {
+			delete(s.blockHashes, number)
+		}
+	}
+
+	transactions := make(map[string][]Transaction)
+	for address, details := range s.subscribedAddresses {
+		txs := details.Transactions
+		i := len(txs)
+		for i > 0 && blockNumber(txs[i-1]) >= confirmed {
+			i--
+		}
+		for ; i < len(txs) && blockNumber(txs[i]) == confirmed; i++ {
+			transactions[address] = append(transactions[address], txs[i])
+		}
+	}
+	events := transactionEvents(s.chainID, TxConfirmed, transactions)
+	for i := range events {
+		events[i].Confirmations = s.confirmations
+	}
+	return events
+}
@@ -0,0 +1,155 @@
+package parser
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/EliasManj/tx-parser/utils"
+)
+
+// newForkingRPC starts a JSON-RPC server whose blocks each contain one
+// transaction from sender. Blocks from the height stored in fork on belong
+// to a branch named after that height, with different hashes.
+func newForkingRPC(t *testing.T, sender string, fork *atomic.Int64) *httptest.Server {
+	hash := func(number int64) string {
+		if f := fork.Load(); f > 0 && number >= f {
+			return fmt.Sprintf("0xfork%d-%s", f, utils.IntToHex(number))
+		}
+		return "0xblock" + utils.IntToHex(number)
+	}
+	return serveRPC(t, func(req rpcRequest) interface{} {
+		switch req.Method {
+		case "eth_getBlockByNumber":
+			number, _ := utils.HexToDec(req.Params[0].(string))
+			return map[string]interface{}{
+				"hash":       hash(number.Int64()),
+				"parentHash": hash(number.Int64() - 1),
+				"transactions": []interface{}{map[string]interface{}{
+					"hash":        hash(number.Int64()) + "-tx",
+					"blockHash":   hash(number.Int64()),
+					"blockNumber": utils.IntToHex(number.Int64()),
+					"from":        sender,
+					"to":          "0x0000000000000000000000000000000000000000",
+					"value":       "0x1",
+					"type":        "0x2",
+					"gas":         "0x5208",
+					"gasPrice":    "0x1",
+					"nonce":       "0x0",
+				}},
+			}
+		case "eth_getTransactionReceipt":
+			return map[string]interface{}{"status": "0x1"}
+		}
+		return nil
+	})
+}
+
+func TestParserReorg(t *testing.T) {
+	address := testAddresses(1)[0]
+	var fork atomic.Int64
+	rpc := newForkingRPC(t, address, &fork)
+	storage := &JsonFileStorage{FilePath: filepath.Join(t.TempDir(), "data.json"), ChainID: 1}
+	p := NewParser(storage, 0)
+	p.SetConfirmations(3)
+	ctx := context.Background()
+	require.NoError(t, p.Subscribe(ctx, address))
+	listener := p.Stream().Listen(nil, 100)
+	defer listener.Close()
+
+	process := func(blockNumber int64) {
+		_, err := p.ProcessBlock(blockNumber, rpc.URL)
+		require.NoError(t, err)
+	}
+	next := func() TxEvent {
+		select {
+		case event := <-listener.C:
+			return event
+		case <-time.After(5 * time.Second):
+			t.Fatal("no event published")
+			return TxEvent{}
+		}
+	}
+	for blockNumber := int64(1); blockNumber <= 4; blockNumber++ {
+		process(blockNumber)
+	}
+	for _, want := range []string{TxDetected, TxDetected, TxDetected, TxConfirmed, TxDetected, TxConfirmed} {
+		require.Equal(t, want, next().Type)
+	}
+
+	// Block 4 is replaced, block 5 builds on the new one
+	fork.Store(4)
+	process(5)
+	event := next()
+	require.Equal(t, TxRemoved, event.Type)
+	require.Equal(t, "0xblock0x4-tx", event.Transaction.Txhash)
+	current, err := p.GetCurrentBlock(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), current)
+
+	process(4)
+	event = next()
+	require.Equal(t, TxDetected, event.Type)
+	require.Equal(t, "0xfork4-0x4-tx", event.Transaction.Txhash)
+	require.Equal(t, TxConfirmed, next().Type)
+	process(5)
+	require.Equal(t, TxDetected, next().Type)
+	require.Equal(t, TxConfirmed, next().Type)
+
+	transactions, err := p.GetTransactions(ctx, address)
+	require.NoError(t, err)
+	require.Len(t, transactions, 5)
+	require.Equal(t, "0xfork4-0x4-tx", transactions[3].Txhash)
+	stored, _, err := storage.Load()
+	require.NoError(t, err)
+	require.Equal(t, transactions, stored[address].Transactions)
+
+	// Confirmed blocks are final, only the later ones are reverted
+	fork.Store(2)
+	process(6)
+	require.Equal(t, TxRemoved, next().Type)
+	require.Equal(t, TxRemoved, next().Type)
+	current, err = p.GetCurrentBlock(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), current)
+}
+
+func TestRevertBlocks(t *testing.T) {
+	dir := t.TempDir()
+	storages := map[string]Storage{
+		"memory": NewMemoryStorage(RetentionPolicy{}),
+		"json":   &JsonFileStorage{FilePath: filepath.Join(dir, "data.json"), ChainID: 1},
+		"log":    openLogStorage(t, filepath.Join(dir, "log"), testLogStorageOptions()),
+		"kv":     openKVStorage(t, filepath.Join(dir, "kv")),
+		"sql":    openSQLiteStorage(t, ":memory:"),
+		"encrypted": NewEncryptedStorage(NewMemoryStorage(RetentionPolicy{}),
+			testKeyring(t, testKey("k1", 1))),
+	}
+	addresses := testAddresses(2)
+	alice, bob := addresses[0], addresses[1]
+	for name, storage := range storages {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, storage.AddSubscription(alice))
+			require.NoError(t, storage.AddSubscription(bob))
+			blocks := blockTransactions(alice, 1, 3)
+			blocks[bob] = blockTransactions(bob, 2, 3)[bob]
+			require.NoError(t, storage.AppendBlock(3, blocks))
+			require.NoError(t, storage.(Reverter).RevertBlocks(2, map[string]int{alice: 1, bob: 2}))
+
+			loaded, latest, err := storage.Load()
+			require.NoError(t, err)
+			require.Equal(t, int64(2), latest)
+			require.Equal(t, blockTransactions(alice, 1, 2)[alice], loaded[alice].Transactions)
+			require.Empty(t, loaded[bob].Transactions)
+			page, err := storage.GetTransactions(alice, 0, 0)
+			require.NoError(t, err)
+			require.Len(t, page, 2)
+		})
+	}
+}
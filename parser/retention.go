@@ -0,0 +1,103 @@
+package parser
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/EliasManj/tx-parser/utils"
+)
+
+// RetentionPolicy bounds how many transactions are kept per address.
+type RetentionPolicy struct {
+	// Keep only transactions from the last MaxAge blocks, 0 keeps all
+	MaxAge int64
+
+	// Keep only the newest MaxCount transactions per address, 0 keeps all
+	MaxCount int
+}
+
+func (r RetentionPolicy) enabled() bool {
+	return r.MaxAge > 0 || r.MaxCount > 0
+}
+
+// expired returns how many of the oldest transactions the policy drops once
+// latest is the last processed block. Transactions are in block order, so
+// aging stops at the first one that is recent enough or has no valid block
+// number.
+func (r RetentionPolicy) expired(transactions []Transaction, latest int64) int {
+	count := 0
+	if r.MaxAge > 0 {
+		for count < len(transactions) {
+			number, err := utils.HexToDec(transactions[count].BlockNumber)
+			if err != nil || number.Int64() > latest-r.MaxAge {
+				break
+			}
+			count++
+		}
+	}
+	if r.MaxCount > 0 {
+		count = max(count, len(transactions)-r.MaxCount)
+	}
+	return count
+}
+
+// Pruner is implemented by storages that can drop old transactions.
+type Pruner interface {
+	// PruneTransactions removes the oldest count transactions of address.
+	PruneTransactions(address string, count int) error
+}
+
+// archiveMu serializes appends to archives, since parsers for several
+// chains may share the same file.
+var archiveMu sync.Mutex
+
+type archivedTransaction struct {
+	ChainID     int64       `json:"chainId"`
+	Address     string      `json:"address"`
+	Transaction Transaction `json:"transaction"`
+}
+
+// archiveTransactions appends transactions to a gzip compressed file of JSON
+// lines. Each call adds a gzip member, which readers such as zcat and
+// gzip.Reader read as one stream. A failed append is truncated away.
+func archiveTransactions(path string, chainID int64, pruned map[string][]Transaction) (err error) {
+	archiveMu.Lock()
+	defer archiveMu.Unlock()
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open archive: %v", err)
+	}
+	defer f.Close()
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("failed to open archive: %v", err)
+	}
+	defer func() {
+		if err != nil {
+			f.Truncate(size)
+		}
+	}()
+
+	writer := gzip.NewWriter(f)
+	encoder := json.NewEncoder(writer)
+	for address, txs := range pruned {
+		for _, tx := range txs {
+			err := encoder.Encode(archivedTransaction{ChainID: chainID, Address: address, Transaction: tx})
+			if err != nil {
+				return fmt.Errorf("failed to write archive: %v", err)
+			}
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to write archive: %v", err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("failed to sync archive: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package parser
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/EliasManj/tx-parser/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func readArchive(t *testing.T, path string) []archivedTransaction {
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	reader, err := gzip.NewReader(f)
+	require.NoError(t, err)
+
+	var archived []archivedTransaction
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		var entry archivedTransaction
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &entry))
+		archived = append(archived, entry)
+	}
+	require.NoError(t, scanner.Err())
+	return archived
+}
+
+func TestParserRetention(t *testing.T) {
+	addresses := testAddresses(1)
+	rpc := newFakeRPC(t, addresses)
+	dir := t.TempDir()
+	storage := &JsonFileStorage{FilePath: filepath.Join(dir, "data.json"), ChainID: 1}
+	archive := filepath.Join(dir, "archive.jsonl.gz")
+
+	p := NewParser(storage, 0)
+	p.SetRetention(RetentionPolicy{MaxCount: 2}, archive)
+	ctx := context.Background()
+	require.NoError(t, p.Subscribe(ctx, addresses[0]))
+	for blockNumber := int64(1); blockNumber <= 5; blockNumber++ {
+		_, err := p.ProcessBlock(blockNumber, rpc.URL)
+		require.NoError(t, err)
+	}
+
+	transactions, err := p.GetTransactions(ctx, addresses[0])
+	require.NoError(t, err)
+	require.Len(t, transactions, 2)
+	require.Equal(t, utils.IntToHex(4), transactions[0].BlockNumber)
+	stored, err := p.GetTransactionsPage(ctx, addresses[0], 0, 0)
+	require.NoError(t, err)
+	require.Equal(t, transactions, stored)
+
+	archived := readArchive(t, archive)
+	require.Len(t, archived, 3)
+	require.Equal(t, addresses[0], archived[0].Address)
+	require.Equal(t, utils.IntToHex(1), archived[0].Transaction.BlockNumber)
+
+	// A reloaded parser keeps deduplicating retained transactions
+	reloaded := NewParser(storage, 0)
+	found, err := reloaded.ProcessBlock(5, rpc.URL)
+	require.NoError(t, err)
+	require.False(t, found)
+}
+
+func TestParserTakesMemoryStorageRetention(t *testing.T) {
+	addresses := testAddresses(1)
+	rpc := newFakeRPC(t, addresses)
+	storage := NewMemoryStorage(RetentionPolicy{MaxCount: 2})
+
+	p := NewParser(storage, 0)
+	require.Equal(t, RetentionPolicy{}, storage.Retention)
+	ctx := context.Background()
+	require.NoError(t, p.Subscribe(ctx, addresses[0]))
+	for blockNumber := int64(1); blockNumber <= 5; blockNumber++ {
+		_, err := p.ProcessBlock(blockNumber, rpc.URL)
+		require.NoError(t, err)
+	}
+
+	// The parser and the storage keep the same transactions
+	transactions, err := p.GetTransactions(ctx, addresses[0])
+	require.NoError(t, err)
+	require.Len(t, transactions, 2)
+	stored, err := storage.GetTransactions(addresses[0], 0, 0)
+	require.NoError(t, err)
+	require.Equal(t, transactions, stored)
+}
+
+func TestPruneTransactions(t *testing.T) {
+	dir := t.TempDir()
+	storages := map[string]Storage{
+		"memory": NewMemoryStorage(RetentionPolicy{}),
+		"json":   &JsonFileStorage{FilePath: filepath.Join(dir, "data.json"), ChainID: 1},
+		"log":    openLogStorage(t, filepath.Join(dir, "log"), testLogStorageOptions()),
+		"kv":     openKVStorage(t, filepath.Join(dir, "kv")),
+		"sql":    openSQLiteStorage(t, ":memory:"),
+		"encrypted": NewEncryptedStorage(NewMemoryStorage(RetentionPolicy{}),
+			testKeyring(t, testKey("k1", 1))),
+	}
+	address := testAddresses(1)[0]
+	for name, storage := range storages {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, storage.AddSubscription(address))
+			require.NoError(t, storage.AppendBlock(3, blockTransactions(address, 1, 3)))
+			require.NoError(t, storage.(Pruner).PruneTransactions(address, 2))
+
+			loaded, _, err := storage.Load()
+			require.NoError(t, err)
+			require.Equal(t, blockTransactions(address, 3, 3)[address], loaded[address].Transactions)
+		})
+	}
+}
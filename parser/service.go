@@ -2,22 +2,32 @@ package parser
 
 import (
 	"context"
+	"fmt"
+	"time"
 
+	"github.com/EliasManj/tx-parser/ens"
 	"github.com/EliasManj/tx-parser/rpcclient"
 )
 
-var (
-	db *MyParser
-)
-
-func GetParser() *MyParser {
-	return db
-}
+// How long resolved ENS names are cached
+const nameCacheTTL = 10 * time.Minute
 
-func Init(ctx context.Context, endpoint string, storage Storage, optionalStartFrom ...int64) *MyParser {
+// Init creates a parser for the given endpoint, to be configured and then
+// started with Start. Each call returns an independent instance, so several
+// parsers can run side by side in the same process. The chain id reported by
+// the endpoint is used to scope the storage, so switching providers for the
+// same chain keeps the stored history.
+func Init(endpoint string, storage Storage, optionalStartFrom ...int64) (*MyParser, error) {
+	chainID, err := rpcclient.GetChainID(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("error getting chain id: %v", err)
+	}
+	if scoped, ok := storage.(ChainScoped); ok {
+		scoped.SetChainID(chainID.Int64())
+	}
 	startingBlockNumber, err := rpcclient.GetLatestBlockNumber(endpoint)
 	if err != nil {
-		panic("Error getting latest block number")
+		return nil, fmt.Errorf("error getting latest block number: %v", err)
 	}
 	var startFrom int64
 	if len(optionalStartFrom) > 0 {
@@ -25,7 +35,16 @@ func Init(ctx context.Context, endpoint string, storage Storage, optionalStartFr
 	} else {
 		startFrom = startingBlockNumber.Int64() - 1
 	}
-	db = NewParser(storage, startFrom)
-	go db.Loop(ctx, endpoint)
-	return db
+	p := NewParser(storage, startFrom)
+	p.chainID = chainID.Int64()
+	p.endpoint = endpoint
+	p.SetNameResolver(ens.NewResolver(endpoint, nameCacheTTL))
+	return p, nil
+}
+
+// Start polls the endpoint of a parser created by Init until ctx is done.
+// It is called once the parser is configured, so no block is processed
+// before then.
+func (s *MyParser) Start(ctx context.Context) {
+	go s.Loop(ctx, s.endpoint)
 }
@@ -0,0 +1,298 @@
+package parser
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// SpoolRecord is a line of a spool file. StreamSeq is the sequence number
+// of the event in the TxStream it was spooled from, if any.
+type SpoolRecord struct {
+	Seq       uint64 `json:"seq"`
+	StreamSeq uint64 `json:"streamSeq,omitempty"`
+	Event
+}
+
+// SpoolSink appends events to a JSONL file other processes can tail. Every
+// record has a sequence number, continued across restarts, so a consumer
+// that remembers the last one it processed handles each record exactly
+// once, see SpoolReader.
+//
+// Fed by Follow, the spool copies the events of a journaled TxStream and
+// resumes after the last stream event it wrote, so no event is lost or
+// spooled twice across restarts.
+type SpoolSink struct {
+	mu        sync.Mutex
+	file      *os.File
+	size      int64
+	seq       uint64
+	streamSeq uint64
+}
+
+var _ Sink = &SpoolSink{}
+
+// OpenSpoolSink opens or creates the spool file at path. A torn last line,
+// left by a crash while writing, is dropped.
+func OpenSpoolSink(path string) (*SpoolSink, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open spool: %v", err)
+	}
+	s := &SpoolSink{file: f}
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			break
+		}
+		var record SpoolRecord
+		if err := json.Unmarshal(line, &record); err != nil || record.Seq <= s.seq {
+			break
+		}
+		s.size += int64(len(line))
+		s.seq = record.Seq
+		if record.StreamSeq != 0 {
+			s.streamSeq = record.StreamSeq
+		}
+	}
+	if err := f.Truncate(s.size); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to truncate spool: %v", err)
+	}
+	if _, err := f.Seek(s.size, io.SeekStart); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to open spool: %v", err)
+	}
+	return s, nil
+}
+
+// LastSeq returns the sequence number of the last record.
+func (s *SpoolSink) LastSeq() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.seq
+}
+
+// Handle appends events and syncs the file.
+func (s *SpoolSink) Handle(events []Event) error {
+	records := make([]SpoolRecord, len(events))
+	for i, event := range events {
+		records[i].Event = event
+	}
+	return s.append(records)
+}
+
+// append numbers records and appends them, syncing the file. If that fails,
+// the file is cut back so no partial batch is left.
+func (s *SpoolSink) append(records []SpoolRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var data []byte
+	seq, streamSeq := s.seq, s.streamSeq
+	for _, record := range records {
+		if record.StreamSeq != 0 && record.StreamSeq <= streamSeq {
+			continue
+		}
+		seq++
+		record.Seq = seq
+		if record.StreamSeq != 0 {
+			streamSeq = record.StreamSeq
+		}
+		line, err := json.Marshal(record)
+		if err != nil {
+			return fmt.Errorf("failed to marshal spool record: %v", err)
+		}
+		data = append(append(data, line...), '\n')
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	if _, err := s.file.Write(data); err != nil {
+		s.rollback()
+		return fmt.Errorf("failed to write spool: %v", err)
+	}
+	if err := s.file.Sync(); err != nil {
+		s.rollback()
+		return fmt.Errorf("failed to sync spool: %v", err)
+	}
+	s.size += int64(len(data))
+	s.seq, s.streamSeq = seq, streamSeq
+	return nil
+}
+
+// Follow spools the events of stream after the last one spooled, until ctx
+// is done or the stream is closed. Only a stream journaled by OpenTxStream
+// keeps its events across restarts.
+func (s *SpoolSink) Follow(ctx context.Context, stream *TxStream) {
+	s.mu.Lock()
+	if last := stream.LastSeq(); s.streamSeq > last {
+		// The stream restarted its sequence numbers
+		s.streamSeq = last
+	}
+	s.mu.Unlock()
+
+	for ctx.Err() == nil {
+		// Listen before reading the backlog so no event falls in between
+		listener := stream.Listen(nil, DefaultStreamCapacity)
+		s.mu.Lock()
+		cursor := s.streamSeq
+		s.mu.Unlock()
+		backlog, complete := stream.Since(nil, cursor)
+		if !complete {
+			fmt.Printf("Warning: events after %d are no longer retained, they are not spooled\n", cursor)
+		}
+		s.spool(backlog)
+
+		for listening := true; listening; {
+			select {
+			case <-ctx.Done():
+				listener.Close()
+				return
+			case event, ok := <-listener.C:
+				if !ok {
+					if !listener.Overflowed() {
+						// The stream was closed
+						return
+					}
+					listening = false
+					continue
+				}
+				// Spool the events already waiting with this one
+				events := []TxEvent{event}
+				for more := true; more && len(events) < maxSinkBatch; {
+					select {
+					case event, ok := <-listener.C:
+						if ok {
+							events = append(events, event)
+						}
+						more = ok
+					default:
+						more = false
+					}
+				}
+				s.spool(events)
+			}
+		}
+	}
+}
+
+// spool appends stream events, logging failures. The events stay in the
+// stream, so they are spooled again once the listener is reopened.
+func (s *SpoolSink) spool(events []TxEvent) {
+	records := make([]SpoolRecord, len(events))
+	for i, event := range events {
+		tx := event.Transaction
+		records[i] = SpoolRecord{StreamSeq: event.Seq, Event: Event{
+			Type:          event.Type,
+			ChainID:       event.ChainID,
+			Time:          time.Now(),
+			Address:       event.Address,
+			Transaction:   &tx,
+			Confirmations: event.Confirmations,
+		}}
+	}
+	if err := s.append(records); err != nil {
+		fmt.Printf("Error spooling %d events: %v\n", len(records), err)
+	}
+}
+
+func (s *SpoolSink) rollback() {
+	s.file.Truncate(s.size)
+	s.file.Seek(s.size, io.SeekStart)
+}
+
+// Close closes the file.
+func (s *SpoolSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// spoolPollInterval is how often a SpoolReader checks for new records.
+const spoolPollInterval = 100 * time.Millisecond
+
+// SpoolReader tails a spool file, returning each record after a sequence
+// number once.
+type SpoolReader struct {
+	file    *os.File
+	reader  *bufio.Reader
+	offset  int64  // bytes read, including partial
+	partial []byte // start of a line still being written
+	last    uint64
+}
+
+// OpenSpoolReader opens the spool file at path to read the records after
+// sequence number after.
+func OpenSpoolReader(path string, after uint64) (*SpoolReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open spool: %v", err)
+	}
+	return &SpoolReader{file: f, reader: bufio.NewReader(f), last: after}, nil
+}
+
+// Next returns the next record, waiting for one to be written until ctx is
+// done.
+func (r *SpoolReader) Next(ctx context.Context) (SpoolRecord, error) {
+	for {
+		line, err := r.reader.ReadBytes('\n')
+		r.offset += int64(len(line))
+		r.partial = append(r.partial, line...)
+		if err == io.EOF {
+			if err := r.wait(ctx); err != nil {
+				return SpoolRecord{}, err
+			}
+			continue
+		}
+		if err != nil {
+			return SpoolRecord{}, fmt.Errorf("failed to read spool: %v", err)
+		}
+
+		var record SpoolRecord
+		err = json.Unmarshal(r.partial, &record)
+		r.partial = nil
+		if err != nil {
+			return SpoolRecord{}, fmt.Errorf("failed to unmarshal spool record: %v", err)
+		}
+		if record.Seq <= r.last {
+			continue
+		}
+		r.last = record.Seq
+		return record, nil
+	}
+}
+
+// wait waits for the file to grow. A torn line cut off by the writer is
+// read again from its start.
+func (r *SpoolReader) wait(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(spoolPollInterval):
+	}
+	info, err := r.file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to read spool: %v", err)
+	}
+	if info.Size() < r.offset {
+		r.offset -= int64(len(r.partial))
+		r.partial = nil
+		if _, err := r.file.Seek(r.offset, io.SeekStart); err != nil {
+			return fmt.Errorf("failed to read spool: %v", err)
+		}
+		r.reader.Reset(r.file)
+	}
+	return nil
+}
+
+// Close closes the file.
+func (r *SpoolReader) Close() error {
+	return r.file.Close()
+}
@@ -0,0 +1,108 @@
+package parser
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/EliasManj/tx-parser/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpool(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spool.jsonl")
+	sink, err := OpenSpoolSink(path)
+	require.NoError(t, err)
+	require.NoError(t, sink.Handle(transactionEvents(1, TxDetected, blockTransactions("alice", 1, 3))))
+	require.Equal(t, uint64(3), sink.LastSeq())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	reader, err := OpenSpoolReader(path, 1)
+	require.NoError(t, err)
+	defer reader.Close()
+	record, err := reader.Next(ctx)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), record.Seq)
+	require.Equal(t, "0x2", record.Transaction.BlockNumber)
+	record, err = reader.Next(ctx)
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), record.Seq)
+
+	// A crash leaves a torn line, which is dropped when reopening and
+	// skipped by readers
+	require.NoError(t, sink.Close())
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"seq":4,"type":"trans`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	time.Sleep(2 * spoolPollInterval)
+
+	// Sequence numbers continue after a restart, and the reader waits for
+	// new records
+	sink, err = OpenSpoolSink(path)
+	require.NoError(t, err)
+	defer sink.Close()
+	require.Equal(t, uint64(3), sink.LastSeq())
+	go func() {
+		time.Sleep(2 * spoolPollInterval)
+		sink.Handle([]Event{{Type: BlockProcessed, Block: 4}})
+	}()
+	record, err = reader.Next(ctx)
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), record.Seq)
+	require.Equal(t, BlockProcessed, record.Type)
+
+	cancel()
+	_, err = reader.Next(ctx)
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestSpoolFollowsStream(t *testing.T) {
+	dir := t.TempDir()
+	streamPath, spoolPath := filepath.Join(dir, "events.jsonl"), filepath.Join(dir, "spool.jsonl")
+	stream, err := OpenTxStream(streamPath, 100)
+	require.NoError(t, err)
+	sink, err := OpenSpoolSink(spoolPath)
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		sink.Follow(ctx, stream)
+	}()
+	require.NoError(t, stream.Handle(transactionEvents(1, TxDetected, blockTransactions("alice", 1, 2))))
+	require.Eventually(t, func() bool { return sink.LastSeq() == 2 }, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	<-done
+	require.NoError(t, sink.Close())
+
+	// Events journaled while the spool was down, as in a crash right after
+	// processing a block, are spooled after a restart, and none twice
+	require.NoError(t, stream.Handle(transactionEvents(1, TxDetected, blockTransactions("alice", 3, 4))))
+	require.NoError(t, stream.Close())
+	stream, err = OpenTxStream(streamPath, 100)
+	require.NoError(t, err)
+	defer stream.Close()
+	sink, err = OpenSpoolSink(spoolPath)
+	require.NoError(t, err)
+	defer sink.Close()
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	go sink.Follow(ctx, stream)
+	require.Eventually(t, func() bool { return sink.LastSeq() == 4 }, 5*time.Second, 10*time.Millisecond)
+
+	reader, err := OpenSpoolReader(spoolPath, 0)
+	require.NoError(t, err)
+	defer reader.Close()
+	for seq := uint64(1); seq <= 4; seq++ {
+		record, err := reader.Next(ctx)
+		require.NoError(t, err)
+		require.Equal(t, seq, record.Seq)
+		require.Equal(t, seq, record.StreamSeq)
+		require.Equal(t, utils.IntToHex(int64(seq)), record.Transaction.Txhash)
+	}
+}
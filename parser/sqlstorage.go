@@ -0,0 +1,542 @@
+package parser
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/EliasManj/tx-parser/utils"
+)
+
+// sqlMigrations are applied in order and recorded in schema_migrations. The
+// statements and the queries below are written for SQLite, other databases
+// are not supported. Never edit a released migration, append a new one
+// instead.
+var sqlMigrations = []string{
+	`CREATE TABLE chains (
+		id INTEGER PRIMARY KEY
+	);
+	CREATE TABLE cursors (
+		chain_id     INTEGER PRIMARY KEY REFERENCES chains(id),
+		block_number INTEGER NOT NULL
+	);
+	CREATE TABLE subscriptions (
+		chain_id INTEGER NOT NULL REFERENCES chains(id),
+		address  TEXT    NOT NULL,
+		PRIMARY KEY (chain_id, address)
+	);
+	CREATE TABLE transactions (
+		chain_id         INTEGER NOT NULL REFERENCES chains(id),
+		hash             TEXT    NOT NULL,
+		block_hash       TEXT    NOT NULL,
+		block_number     INTEGER NOT NULL,
+		from_address     TEXT    NOT NULL,
+		to_address       TEXT    NOT NULL,
+		tx_type          TEXT    NOT NULL,
+		gas              TEXT    NOT NULL,
+		gas_price        TEXT    NOT NULL,
+		contract_address TEXT    NOT NULL,
+		nonce            TEXT    NOT NULL,
+		PRIMARY KEY (chain_id, hash)
+	);
+	CREATE TABLE transfers (
+		id        INTEGER PRIMARY KEY,
+		chain_id  INTEGER NOT NULL,
+		address   TEXT    NOT NULL,
+		tx_hash   TEXT    NOT NULL,
+		direction TEXT    NOT NULL,
+		UNIQUE (chain_id, address, tx_hash),
+		FOREIGN KEY (chain_id, tx_hash) REFERENCES transactions(chain_id, hash)
+	);`,
+	`CREATE INDEX transactions_block ON transactions (chain_id, block_number);
+	CREATE INDEX transactions_hash ON transactions (hash);
+	CREATE INDEX transfers_address ON transfers (chain_id, address, id);
+	CREATE INDEX transfers_tx ON transfers (chain_id, tx_hash);`,
+	`ALTER TABLE transactions ADD COLUMN value TEXT NOT NULL DEFAULT '';
+	ALTER TABLE transactions ADD COLUMN status TEXT NOT NULL DEFAULT '';`,
+	`CREATE TABLE tenants (
+		id                TEXT    PRIMARY KEY,
+		name              TEXT    NOT NULL,
+		max_subscriptions INTEGER NOT NULL,
+		rate_limit        REAL    NOT NULL,
+		burst             INTEGER NOT NULL
+	);
+	CREATE TABLE tenant_subscriptions (
+		tenant_id TEXT    NOT NULL REFERENCES tenants(id),
+		chain_id  INTEGER NOT NULL,
+		address   TEXT    NOT NULL,
+		position  INTEGER NOT NULL,
+		PRIMARY KEY (tenant_id, chain_id, address)
+	);
+	CREATE TABLE api_keys (
+		id        TEXT    PRIMARY KEY,
+		hash      TEXT    NOT NULL,
+		tenant_id TEXT    NOT NULL,
+		admin     INTEGER NOT NULL,
+		created   INTEGER NOT NULL
+	);`,
+}
+
+const (
+	sqlInsertChain = `INSERT INTO chains (id) VALUES (?) ON CONFLICT DO NOTHING`
+
+	sqlUpsertCursor = `INSERT INTO cursors (chain_id, block_number) VALUES (?, ?)
+		ON CONFLICT (chain_id) DO UPDATE SET block_number = excluded.block_number`
+
+	sqlSelectCursor = `SELECT block_number FROM cursors WHERE chain_id = ?`
+
+	sqlInsertSubscription = `INSERT INTO subscriptions (chain_id, address) VALUES (?, ?) ON CONFLICT DO NOTHING`
+
+	sqlDeleteSubscription = `DELETE FROM subscriptions WHERE chain_id = ? AND address = ?`
+
+	sqlSelectSubscriptions = `SELECT address FROM subscriptions WHERE chain_id = ?`
+
+	sqlInsertTransaction = `INSERT INTO transactions (chain_id, hash, block_hash, block_number, from_address,
+		to_address, value, tx_type, gas, gas_price, contract_address, nonce, status)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT DO NOTHING`
+
+	sqlInsertTransfer = `INSERT INTO transfers (chain_id, address, tx_hash, direction) VALUES (?, ?, ?, ?)
+		ON CONFLICT DO NOTHING`
+
+	sqlDeleteTransfers = `DELETE FROM transfers WHERE chain_id = ? AND address = ? RETURNING tx_hash`
+
+	sqlPruneTransfers = `DELETE FROM transfers WHERE id IN
+		(SELECT id FROM transfers WHERE chain_id = ? AND address = ? ORDER BY id LIMIT ?) RETURNING tx_hash`
+
+	sqlRevertTransfers = `DELETE FROM transfers WHERE id IN
+		(SELECT id FROM transfers WHERE chain_id = ? AND address = ? ORDER BY id DESC LIMIT ?) RETURNING tx_hash`
+
+	sqlDeleteOrphanTransaction = `DELETE FROM transactions WHERE chain_id = ? AND hash = ?
+		AND NOT EXISTS (SELECT 1 FROM transfers WHERE chain_id = ? AND tx_hash = ?)`
+
+	sqlTransactionColumns = `t.hash, t.block_hash, t.block_number, t.from_address, t.to_address,
+		t.value, t.tx_type, t.gas, t.gas_price, t.contract_address, t.nonce, t.status`
+
+	sqlSelectAllTransfers = `SELECT tr.address, ` + sqlTransactionColumns + `
+		FROM transfers tr JOIN transactions t ON t.chain_id = tr.chain_id AND t.hash = tr.tx_hash
+		WHERE tr.chain_id = ? ORDER BY tr.id`
+
+	sqlSelectTransfers = `SELECT tr.address, ` + sqlTransactionColumns + `
+		FROM transfers tr JOIN transactions t ON t.chain_id = tr.chain_id AND t.hash = tr.tx_hash
+		WHERE tr.chain_id = ? AND tr.address = ? ORDER BY tr.id LIMIT ? OFFSET ?`
+)
+
+// SQLStorage persists data in a normalized SQL schema through database/sql.
+// Transactions are stored once per chain and linked to subscribed addresses
+// through transfers, which also record the direction of each transfer.
+type SQLStorage struct {
+	ChainID int64
+
+	db    *sql.DB
+	stmts map[string]*sql.Stmt
+}
+
+var (
+	_ Storage     = &SQLStorage{}
+	_ ChainScoped = &SQLStorage{}
+	_ Pruner      = &SQLStorage{}
+	_ Reverter    = &SQLStorage{}
+
+	_ AccessStorage = &SQLStorage{}
+)
+
+// OpenSQLStorage applies pending migrations to db and prepares the
+// statements used by the storage.
+func OpenSQLStorage(db *sql.DB) (*SQLStorage, error) {
+	if err := migrateSQL(db); err != nil {
+		return nil, err
+	}
+
+	s := &SQLStorage{
+		db:    db,
+		stmts: make(map[string]*sql.Stmt),
+	}
+	queries := []string{
+		sqlInsertChain, sqlUpsertCursor, sqlSelectCursor, sqlInsertSubscription, sqlDeleteSubscription,
+		sqlSelectSubscriptions, sqlInsertTransaction, sqlInsertTransfer, sqlDeleteTransfers,
+		sqlPruneTransfers, sqlRevertTransfers, sqlDeleteOrphanTransaction, sqlSelectAllTransfers, sqlSelectTransfers,
+	}
+	for _, query := range queries {
+		stmt, err := db.Prepare(query)
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("failed to prepare statement: %v", err)
+		}
+		s.stmts[query] = stmt
+	}
+	return s, nil
+}
+
+func migrateSQL(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)`)
+	if err != nil {
+		return fmt.Errorf("failed to create migrations table: %v", err)
+	}
+
+	var current int
+	err = db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current)
+	if err != nil {
+		return fmt.Errorf("failed to read schema version: %v", err)
+	}
+
+	for version := current + 1; version <= len(sqlMigrations); version++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return fmt.Errorf("failed to begin migration %d: %v", version, err)
+		}
+		if _, err := tx.Exec(sqlMigrations[version-1]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to apply migration %d: %v", version, err)
+		}
+		if _, err := tx.Exec(`INSERT INTO schema_migrations (version) VALUES (?)`, version); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to record migration %d: %v", version, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit migration %d: %v", version, err)
+		}
+	}
+	return nil
+}
+
+func (s *SQLStorage) SetChainID(chainID int64) {
+	s.ChainID = chainID
+}
+
+func (s *SQLStorage) Display() string {
+	return fmt.Sprintf("SQL Storage (chain %d)", s.ChainID)
+}
+
+// Close releases the prepared statements. The database is owned by the
+// caller.
+func (s *SQLStorage) Close() error {
+	for _, stmt := range s.stmts {
+		stmt.Close()
+	}
+	return nil
+}
+
+// inTx runs fn in a database transaction with this chain registered.
+func (s *SQLStorage) inTx(fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	if _, err := tx.Stmt(s.stmts[sqlInsertChain]).Exec(s.ChainID); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to register chain: %v", err)
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	return nil
+}
+
+func transferDirection(address string, tx Transaction) string {
+	from := address == tx.From
+	to := address == tx.To
+	switch {
+	case from && to:
+		return "self"
+	case from:
+		return "out"
+	default:
+		return "in"
+	}
+}
+
+func (s *SQLStorage) AppendBlock(blockNumber int64, transactions map[string][]Transaction) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		insertTransaction := tx.Stmt(s.stmts[sqlInsertTransaction])
+		insertTransfer := tx.Stmt(s.stmts[sqlInsertTransfer])
+		for address, txs := range transactions {
+			if _, err := tx.Stmt(s.stmts[sqlInsertSubscription]).Exec(s.ChainID, address); err != nil {
+				return fmt.Errorf("failed to insert subscription: %v", err)
+			}
+			for _, t := range txs {
+				number, err := utils.HexToDec(t.BlockNumber)
+				if err != nil {
+					return fmt.Errorf("invalid block number %q: %v", t.BlockNumber, err)
+				}
+				_, err = insertTransaction.Exec(s.ChainID, t.Txhash, t.Blockhash, number.Int64(), t.From,
+					t.To, t.Value, t.Txtype, t.GasUsed, t.GasPrice, t.ContractAddress, t.Nonce, t.Status)
+				if err != nil {
+					return fmt.Errorf("failed to insert transaction: %v", err)
+				}
+				_, err = insertTransfer.Exec(s.ChainID, address, t.Txhash, transferDirection(address, t))
+				if err != nil {
+					return fmt.Errorf("failed to insert transfer: %v", err)
+				}
+			}
+		}
+		if _, err := tx.Stmt(s.stmts[sqlUpsertCursor]).Exec(s.ChainID, blockNumber); err != nil {
+			return fmt.Errorf("failed to update cursor: %v", err)
+		}
+		return nil
+	})
+}
+
+func (s *SQLStorage) AddSubscription(address string) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		if _, err := tx.Stmt(s.stmts[sqlInsertSubscription]).Exec(s.ChainID, address); err != nil {
+			return fmt.Errorf("failed to insert subscription: %v", err)
+		}
+		return nil
+	})
+}
+
+// deleteTransfers runs a query deleting transfers, then deletes the
+// transactions of the deleted transfers no other transfer refers to.
+func (s *SQLStorage) deleteTransfers(tx *sql.Tx, query string, args ...any) error {
+	rows, err := tx.Stmt(s.stmts[query]).Query(args...)
+	if err != nil {
+		return fmt.Errorf("failed to delete transfers: %v", err)
+	}
+	var hashes []string
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to delete transfers: %v", err)
+		}
+		hashes = append(hashes, hash)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to delete transfers: %v", err)
+	}
+
+	deleteOrphan := tx.Stmt(s.stmts[sqlDeleteOrphanTransaction])
+	for _, hash := range hashes {
+		if _, err := deleteOrphan.Exec(s.ChainID, hash, s.ChainID, hash); err != nil {
+			return fmt.Errorf("failed to delete transactions: %v", err)
+		}
+	}
+	return nil
+}
+
+func (s *SQLStorage) RemoveSubscription(address string) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		if err := s.deleteTransfers(tx, sqlDeleteTransfers, s.ChainID, address); err != nil {
+			return err
+		}
+		if _, err := tx.Stmt(s.stmts[sqlDeleteSubscription]).Exec(s.ChainID, address); err != nil {
+			return fmt.Errorf("failed to delete subscription: %v", err)
+		}
+		return nil
+	})
+}
+
+func (s *SQLStorage) RevertBlocks(latest int64, removed map[string]int) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		for address, count := range removed {
+			if err := s.deleteTransfers(tx, sqlRevertTransfers, s.ChainID, address, count); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.Stmt(s.stmts[sqlUpsertCursor]).Exec(s.ChainID, latest); err != nil {
+			return fmt.Errorf("failed to update cursor: %v", err)
+		}
+		return nil
+	})
+}
+
+func (s *SQLStorage) PruneTransactions(address string, count int) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		return s.deleteTransfers(tx, sqlPruneTransfers, s.ChainID, address, count)
+	})
+}
+
+// scanTransfers reads rows of an address column followed by the
+// transaction columns.
+func scanTransfers(rows *sql.Rows, fn func(address string, tx Transaction)) error {
+	defer rows.Close()
+	for rows.Next() {
+		var address string
+		var blockNumber int64
+		var t Transaction
+		err := rows.Scan(&address, &t.Txhash, &t.Blockhash, &blockNumber, &t.From, &t.To,
+			&t.Value, &t.Txtype, &t.GasUsed, &t.GasPrice, &t.ContractAddress, &t.Nonce, &t.Status)
+		if err != nil {
+			return fmt.Errorf("failed to scan transaction: %v", err)
+		}
+		t.BlockNumber = utils.IntToHex(blockNumber)
+		fn(address, t)
+	}
+	return rows.Err()
+}
+
+func (s *SQLStorage) Load() (map[string]*AddressTransactions, int64, error) {
+	addresses := make(map[string]*AddressTransactions)
+
+	rows, err := s.stmts[sqlSelectSubscriptions].Query(s.ChainID)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query subscriptions: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var address string
+		if err := rows.Scan(&address); err != nil {
+			return nil, 0, fmt.Errorf("failed to scan subscription: %v", err)
+		}
+		addresses[address] = &AddressTransactions{Transactions: []Transaction{}}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err = s.stmts[sqlSelectAllTransfers].Query(s.ChainID)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query transactions: %v", err)
+	}
+	err = scanTransfers(rows, func(address string, tx Transaction) {
+		if details, exists := addresses[address]; exists {
+			details.Transactions = append(details.Transactions, tx)
+		}
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	latestBlockNumber := int64(-1)
+	err = s.stmts[sqlSelectCursor].QueryRow(s.ChainID).Scan(&latestBlockNumber)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, 0, fmt.Errorf("failed to query cursor: %v", err)
+	}
+	return addresses, latestBlockNumber, nil
+}
+
+func (s *SQLStorage) GetTransactions(address string, offset, limit int) ([]Transaction, error) {
+	sqlLimit := int64(limit)
+	if limit <= 0 {
+		sqlLimit = math.MaxInt64
+	}
+	rows, err := s.stmts[sqlSelectTransfers].Query(s.ChainID, address, sqlLimit, max(offset, 0))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query transactions: %v", err)
+	}
+	transactions := []Transaction{}
+	err = scanTransfers(rows, func(_ string, tx Transaction) {
+		transactions = append(transactions, tx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return transactions, nil
+}
+
+// LoadAccess reads the tenants and keys, which are shared by every chain.
+func (s *SQLStorage) LoadAccess() (*AccessData, error) {
+	data := newAccessData()
+	rows, err := s.db.Query(`SELECT id, name, max_subscriptions, rate_limit, burst FROM tenants`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tenants: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t Tenant
+		if err := rows.Scan(&t.ID, &t.Name, &t.MaxSubscriptions, &t.RateLimit, &t.Burst); err != nil {
+			return nil, fmt.Errorf("failed to scan tenant: %v", err)
+		}
+		data.Tenants[t.ID] = &t
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query tenants: %v", err)
+	}
+
+	rows, err = s.db.Query(`SELECT tenant_id, chain_id, address FROM tenant_subscriptions ORDER BY position`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tenant subscriptions: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var tenantID, address string
+		var chainID int64
+		if err := rows.Scan(&tenantID, &chainID, &address); err != nil {
+			return nil, fmt.Errorf("failed to scan tenant subscription: %v", err)
+		}
+		t, exists := data.Tenants[tenantID]
+		if !exists {
+			continue
+		}
+		if t.Subscriptions == nil {
+			t.Subscriptions = make(map[int64][]string)
+		}
+		t.Subscriptions[chainID] = append(t.Subscriptions[chainID], address)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query tenant subscriptions: %v", err)
+	}
+
+	rows, err = s.db.Query(`SELECT id, hash, tenant_id, admin, created FROM api_keys`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query API keys: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key APIKey
+		var created int64
+		if err := rows.Scan(&key.ID, &key.Hash, &key.Tenant, &key.Admin, &created); err != nil {
+			return nil, fmt.Errorf("failed to scan API key: %v", err)
+		}
+		key.Created = time.Unix(created, 0).UTC()
+		data.Keys[key.ID] = &key
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query API keys: %v", err)
+	}
+	return data, nil
+}
+
+// SaveAccess replaces the tenants and keys in one transaction.
+func (s *SQLStorage) SaveAccess(data *AccessData) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	if err := saveAccess(tx, data); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	return nil
+}
+
+func saveAccess(tx *sql.Tx, data *AccessData) error {
+	for _, table := range []string{"api_keys", "tenant_subscriptions", "tenants"} {
+		if _, err := tx.Exec(`DELETE FROM ` + table); err != nil {
+			return fmt.Errorf("failed to clear %s: %v", table, err)
+		}
+	}
+	for _, t := range data.Tenants {
+		_, err := tx.Exec(`INSERT INTO tenants (id, name, max_subscriptions, rate_limit, burst) VALUES (?, ?, ?, ?, ?)`,
+			t.ID, t.Name, t.MaxSubscriptions, t.RateLimit, t.Burst)
+		if err != nil {
+			return fmt.Errorf("failed to insert tenant: %v", err)
+		}
+		for chainID, addresses := range t.Subscriptions {
+			for position, address := range addresses {
+				_, err := tx.Exec(`INSERT INTO tenant_subscriptions (tenant_id, chain_id, address, position) VALUES (?, ?, ?, ?)`,
+					t.ID, chainID, address, position)
+				if err != nil {
+					return fmt.Errorf("failed to insert tenant subscription: %v", err)
+				}
+			}
+		}
+	}
+	for _, key := range data.Keys {
+		_, err := tx.Exec(`INSERT INTO api_keys (id, hash, tenant_id, admin, created) VALUES (?, ?, ?, ?, ?)`,
+			key.ID, key.Hash, key.Tenant, key.Admin, key.Created.Unix())
+		if err != nil {
+			return fmt.Errorf("failed to insert API key: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package parser
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	_ "modernc.org/sqlite"
+)
+
+func openSQLiteStorage(t *testing.T, path string) *SQLStorage {
+	db, err := sql.Open("sqlite", path)
+	require.NoError(t, err)
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	storage, err := OpenSQLStorage(db)
+	require.NoError(t, err)
+	t.Cleanup(func() { storage.Close() })
+	storage.SetChainID(1)
+	return storage
+}
+
+func TestSQLStorage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.db")
+	storage := openSQLiteStorage(t, path)
+
+	addresses, latest, err := storage.Load()
+	require.NoError(t, err)
+	require.Empty(t, addresses)
+	require.Equal(t, int64(-1), latest)
+
+	alice := "0x0000000000000000000000000000000000000001"
+	bob := "0x0000000000000000000000000000000000000002"
+	require.NoError(t, storage.AddSubscription(alice))
+	require.NoError(t, storage.AddSubscription(bob))
+
+	// A transfer between two subscribed addresses is stored once
+	transfer := Transaction{Txhash: "0xa", BlockNumber: "0x1", From: alice, To: bob}
+	require.NoError(t, storage.AppendBlock(1, map[string][]Transaction{
+		alice: {transfer},
+		bob:   {transfer},
+	}))
+	require.NoError(t, storage.AppendBlock(2, map[string][]Transaction{
+		alice: {{Txhash: "0xb", BlockNumber: "0x2", From: alice, To: alice}},
+	}))
+
+	var count int
+	require.NoError(t, storage.db.QueryRow(`SELECT COUNT(*) FROM transactions`).Scan(&count))
+	require.Equal(t, 2, count)
+	var direction string
+	require.NoError(t, storage.db.QueryRow(`SELECT direction FROM transfers WHERE address = ? AND tx_hash = '0xb'`, alice).Scan(&direction))
+	require.Equal(t, "self", direction)
+
+	page, err := storage.GetTransactions(alice, 1, 1)
+	require.NoError(t, err)
+	require.Len(t, page, 1)
+	require.Equal(t, "0xb", page[0].Txhash)
+	require.Equal(t, "0x2", page[0].BlockNumber)
+
+	// Transactions are only removed with their last transfer
+	require.NoError(t, storage.RemoveSubscription(bob))
+	require.NoError(t, storage.db.QueryRow(`SELECT COUNT(*) FROM transactions`).Scan(&count))
+	require.Equal(t, 2, count)
+	require.NoError(t, storage.PruneTransactions(alice, 1))
+	require.NoError(t, storage.db.QueryRow(`SELECT COUNT(*) FROM transactions`).Scan(&count))
+	require.Equal(t, 1, count)
+	require.NoError(t, storage.RemoveSubscription(alice))
+	require.NoError(t, storage.AddSubscription(alice))
+	require.NoError(t, storage.db.QueryRow(`SELECT COUNT(*) FROM transactions`).Scan(&count))
+	require.Equal(t, 0, count, "Expected transactions without transfers to be removed")
+
+	// Data survives reopening and migrations are not reapplied
+	reopened := openSQLiteStorage(t, path)
+	addresses, latest, err = reopened.Load()
+	require.NoError(t, err)
+	require.Equal(t, int64(2), latest)
+	require.Len(t, addresses, 1)
+	require.Empty(t, addresses[alice].Transactions)
+}
+
+func TestSQLStorageChainsAreIsolated(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.db")
+	mainnet := openSQLiteStorage(t, path)
+	sepolia := openSQLiteStorage(t, path)
+	sepolia.SetChainID(11155111)
+
+	require.NoError(t, mainnet.AppendBlock(5, map[string][]Transaction{"0xabc": {{Txhash: "0x1", BlockNumber: "0x5"}}}))
+
+	addresses, latest, err := sepolia.Load()
+	require.NoError(t, err)
+	require.Empty(t, addresses)
+	require.Equal(t, int64(-1), latest)
+}
+
+func TestParserWithSQLStorage(t *testing.T) {
+	addresses := testAddresses(1)
+	rpc := newFakeRPC(t, addresses)
+	storage := openSQLiteStorage(t, ":memory:")
+	p := NewParser(storage, 0)
+
+	require.NoError(t, p.Subscribe(context.Background(), addresses[0]))
+	_, err := p.ProcessBlock(1, rpc.URL)
+	require.NoError(t, err)
+
+	page, err := p.GetTransactionsPage(context.Background(), addresses[0], 0, 10)
+	require.NoError(t, err)
+	require.Len(t, page, 1)
+}
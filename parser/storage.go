@@ -1,20 +1,92 @@
 package parser
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
 )
 
+// Storage persists subscriptions, their transactions and the last processed
+// block. Every method updates or reads the stored state incrementally.
 type Storage interface {
-	Save(subscribedAddresses map[string]*AddressTransactions, latestBlockNumber int64) error
+	// Load returns all subscriptions with their transactions and the last
+	// processed block, or -1 if no block has been processed yet.
 	Load() (map[string]*AddressTransactions, int64, error)
+
+	// AppendBlock records the transactions found in a block, keyed by
+	// subscribed address, and advances the last processed block to it. Both
+	// happen atomically.
+	AppendBlock(blockNumber int64, transactions map[string][]Transaction) error
+
+	AddSubscription(address string) error
+
+	// RemoveSubscription removes an address and its transactions.
+	RemoveSubscription(address string) error
+
+	// GetTransactions returns up to limit transactions of address, oldest
+	// first, starting at offset. A limit of 0 or less means no limit.
+	GetTransactions(address string, offset, limit int) ([]Transaction, error)
+
 	Display() string
 }
 
+// ChainScoped is implemented by storages that keep separate data per chain.
+// The parser calls SetChainID with the chain id detected from the RPC
+// endpoint before loading any data.
+type ChainScoped interface {
+	SetChainID(chainID int64)
+}
+
+// JsonFileStorage persists every chain's data to a single JSON file, keyed by
+// chain id. Endpoint is only used to pick up data written by older versions,
+// which keyed the file by RPC URL.
+//
+// The file is replaced atomically on every write, and the previous versions
+// are kept as FilePath.1 (newest) to FilePath.N. Each version carries a
+// checksum, and Load falls back to the newest valid version. Data written
+// with an older SchemaVersion is migrated when it is read.
 type JsonFileStorage struct {
 	FilePath string
+	ChainID  int64
 	Endpoint string
+
+	// Number of backup generations to keep, DefaultBackupGenerations if 0
+	// and none if negative
+	Generations int
+
+	// Every write rewrites the whole file, so blocks without transactions
+	// only advance the stored last processed block every CursorInterval
+	// blocks, DefaultCursorInterval if 0. Other writes save it too. After
+	// a crash, up to that many blocks are processed again.
+	CursorInterval int64
+
+	saved   int64 // last processed block in the file, 0 if not known
+	pending int64 // last processed block not written yet, 0 if none
+
+	// This chain's transactions as last written, nil until read. Pages
+	// are served from it, as this is the only writer of the chain's data.
+	cache map[string]*AddressTransactions
+}
+
+const (
+	DefaultBackupGenerations = 3
+	DefaultCursorInterval    = 16
+)
+
+// fileEnvelope is the on-disk format of JsonFileStorage. Checksum is the
+// hex SHA-256 of the compact JSON encoding of Data, and Version the
+// SchemaVersion of Data.
+type fileEnvelope struct {
+	Version  int             `json:"version,omitempty"`
+	Checksum string          `json:"checksum"`
+	Data     json.RawMessage `json:"data"`
 }
 
 type EndpointData struct {
@@ -22,69 +94,390 @@ type EndpointData struct {
 	SubscribedAddresses map[string]*AddressTransactions `json:"subscribedAddresses"`
 }
 
-var _ Storage = &JsonFileStorage{}
+var (
+	_ Storage     = &JsonFileStorage{}
+	_ ChainScoped = &JsonFileStorage{}
+	_ Pruner      = &JsonFileStorage{}
+	_ Reverter    = &JsonFileStorage{}
+
+	_ AccessStorage = &JsonFileStorage{}
+)
+
+// fileMu serializes read-modify-write cycles of JsonFileStorage, since
+// parsers for several chains may share the same file.
+var fileMu sync.Mutex
+
+func (s *JsonFileStorage) SetChainID(chainID int64) {
+	fileMu.Lock()
+	defer fileMu.Unlock()
+	s.ChainID = chainID
+	s.cache = nil
+}
+
+func (s *JsonFileStorage) key() string {
+	return strconv.FormatInt(s.ChainID, 10)
+}
 
 func (s *JsonFileStorage) Display() string {
-	return fmt.Sprintf("Json File Storage - %s", s.FilePath)
+	return fmt.Sprintf("Json File Storage - %s (chain %d)", s.FilePath, s.ChainID)
 }
 
-func (s *JsonFileStorage) Save(subscribedAddresses map[string]*AddressTransactions, latestBlockNumber int64) error {
+// update applies fn to this chain's data and rewrites the file, along with
+// a last processed block not written yet.
+func (s *JsonFileStorage) update(fn func(data *EndpointData)) error {
+	fileMu.Lock()
+	defer fileMu.Unlock()
+
 	existingData, err := s.loadAll()
-	if err != nil && !os.IsNotExist(err) {
+	if err != nil {
 		return fmt.Errorf("failed to load existing data: %v", err)
 	}
 
-	existingData[s.Endpoint] = EndpointData{
-		LatestBlockNumber:   latestBlockNumber,
-		SubscribedAddresses: subscribedAddresses,
+	data := s.current(existingData)
+	fn(&data)
+	if s.pending > 0 && s.pending > data.LatestBlockNumber {
+		data.LatestBlockNumber = s.pending
+	}
+	existingData[s.key()] = data
+	if s.Endpoint != "" {
+		delete(existingData, s.Endpoint)
 	}
+	if err := s.write(existingData); err != nil {
+		s.cache = nil
+		return err
+	}
+	s.saved, s.pending = data.LatestBlockNumber, 0
+	s.cache = data.SubscribedAddresses
+	return nil
+}
+
+// deferCursor reports whether writing blockNumber as the last processed
+// block can wait, and keeps it as pending if so.
+func (s *JsonFileStorage) deferCursor(blockNumber int64) bool {
+	fileMu.Lock()
+	defer fileMu.Unlock()
 
-	jsonData, err := json.MarshalIndent(existingData, "", "  ")
+	interval := s.CursorInterval
+	if interval == 0 {
+		interval = DefaultCursorInterval
+	}
+	if s.saved <= 0 || blockNumber <= s.saved || blockNumber-s.saved >= interval {
+		return false
+	}
+	s.pending = blockNumber
+	return true
+}
+
+// write replaces the file with data at the current SchemaVersion.
+func (s *JsonFileStorage) write(data map[string]EndpointData) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal data: %v", err)
+	}
+	checksum := sha256.Sum256(payload)
+	jsonData, err := json.MarshalIndent(fileEnvelope{
+		Version:  SchemaVersion,
+		Checksum: hex.EncodeToString(checksum[:]),
+		Data:     payload,
+	}, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal data: %v", err)
 	}
 
-	err = os.WriteFile(s.FilePath, jsonData, 0644)
+	return s.writeAtomic(jsonData)
+}
+
+func (s *JsonFileStorage) generations() int {
+	if s.Generations == 0 {
+		return DefaultBackupGenerations
+	}
+	return max(s.Generations, 0)
+}
+
+func (s *JsonFileStorage) generationPath(generation int) string {
+	if generation == 0 {
+		return s.FilePath
+	}
+	return fmt.Sprintf("%s.%d", s.FilePath, generation)
+}
+
+// writeAtomic writes data to a temporary file, syncs it, shifts the backup
+// generations and renames the temporary file over FilePath. A crash at any
+// point leaves either the old or the new file, or the old one as the
+// newest backup.
+func (s *JsonFileStorage) writeAtomic(data []byte) error {
+	dir := filepath.Dir(s.FilePath)
+	tmp, err := os.CreateTemp(dir, filepath.Base(s.FilePath)+".tmp-*")
 	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
 		return fmt.Errorf("failed to write to file: %v", err)
 	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync file: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close file: %v", err)
+	}
 
-	fmt.Println("Data saved for endpoint:", s.Endpoint)
-	return nil
+	for generation := s.generations(); generation > 0; generation-- {
+		err := os.Rename(s.generationPath(generation-1), s.generationPath(generation))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to rotate backup: %v", err)
+		}
+	}
+	if err := os.Rename(tmp.Name(), s.FilePath); err != nil {
+		return fmt.Errorf("failed to replace file: %v", err)
+	}
+	return syncDir(dir)
+}
+
+// current returns this chain's data from the file contents, falling back to
+// data stored under the endpoint URL by older versions.
+func (s *JsonFileStorage) current(existingData map[string]EndpointData) EndpointData {
+	data, ok := existingData[s.key()]
+	if !ok && s.Endpoint != "" {
+		data, ok = existingData[s.Endpoint]
+		if ok {
+			fmt.Println("Migrating data stored for endpoint", s.Endpoint, "to chain", s.ChainID)
+		}
+	}
+	if !ok {
+		data.LatestBlockNumber = -1
+	}
+	if data.SubscribedAddresses == nil {
+		data.SubscribedAddresses = make(map[string]*AddressTransactions)
+	}
+	return data
+}
+
+func (s *JsonFileStorage) AppendBlock(blockNumber int64, transactions map[string][]Transaction) error {
+	if len(transactions) == 0 && s.deferCursor(blockNumber) {
+		return nil
+	}
+	return s.update(func(data *EndpointData) {
+		for address, txs := range transactions {
+			details, exists := data.SubscribedAddresses[address]
+			if !exists {
+				details = &AddressTransactions{Transactions: []Transaction{}}
+				data.SubscribedAddresses[address] = details
+			}
+			details.Transactions = append(details.Transactions, txs...)
+		}
+		data.LatestBlockNumber = blockNumber
+	})
+}
+
+func (s *JsonFileStorage) AddSubscription(address string) error {
+	return s.update(func(data *EndpointData) {
+		if _, exists := data.SubscribedAddresses[address]; !exists {
+			data.SubscribedAddresses[address] = &AddressTransactions{Transactions: []Transaction{}}
+		}
+	})
+}
+
+func (s *JsonFileStorage) RemoveSubscription(address string) error {
+	return s.update(func(data *EndpointData) {
+		delete(data.SubscribedAddresses, address)
+	})
+}
+
+func (s *JsonFileStorage) RevertBlocks(latest int64, removed map[string]int) error {
+	return s.update(func(data *EndpointData) {
+		for address, count := range removed {
+			if details, exists := data.SubscribedAddresses[address]; exists {
+				details.Transactions = details.Transactions[:len(details.Transactions)-min(count, len(details.Transactions))]
+			}
+		}
+		data.LatestBlockNumber = latest
+		s.pending = 0
+	})
+}
+
+func (s *JsonFileStorage) PruneTransactions(address string, count int) error {
+	return s.update(func(data *EndpointData) {
+		if details, exists := data.SubscribedAddresses[address]; exists {
+			details.Transactions = details.Transactions[min(count, len(details.Transactions)):]
+		}
+	})
 }
 
+// GetTransactions returns a page from the data last written, reading the
+// file only the first time.
+func (s *JsonFileStorage) GetTransactions(address string, offset, limit int) ([]Transaction, error) {
+	fileMu.Lock()
+	defer fileMu.Unlock()
+
+	if s.cache == nil {
+		existingData, err := s.loadAll()
+		if err != nil {
+			return nil, err
+		}
+		s.cache = s.current(existingData).SubscribedAddresses
+	}
+	details, exists := s.cache[address]
+	if !exists {
+		return []Transaction{}, nil
+	}
+	return pageTransactions(details.Transactions, offset, limit), nil
+}
+
+// loadAll reads the newest valid generation of the file. It starts fresh
+// if no generation exists and fails if none is valid. It also fails if the
+// newest readable one was written by a newer version or cannot be migrated,
+// rather than falling back to an older backup the next write would replace
+// the newer data with.
 func (s *JsonFileStorage) loadAll() (map[string]EndpointData, error) {
-	data := make(map[string]EndpointData)
+	var errs []error
+	for generation := 0; generation <= s.generations(); generation++ {
+		path := s.generationPath(generation)
+		data, _, err := readGeneration(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if errors.Is(err, ErrUnsupportedSchema) || errors.Is(err, ErrMigrationFailed) {
+			return nil, err
+		}
+		if err != nil {
+			fmt.Printf("Skipping invalid data file %s: %v\n", path, err)
+			errs = append(errs, err)
+			continue
+		}
+		if generation > 0 {
+			fmt.Println("Recovered data from backup", path)
+		}
+		return data, nil
+	}
 
-	fileData, err := os.ReadFile(s.FilePath)
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("no valid data file found: %v", errors.Join(errs...))
+	}
+	fmt.Println("File does not exist, starting fresh")
+	return make(map[string]EndpointData), nil
+}
+
+// readGeneration reads one file, migrating its data to SchemaVersion, and
+// returns the version it was written with.
+func readGeneration(path string) (map[string]EndpointData, int, error) {
+	fileData, err := os.ReadFile(path)
 	if err != nil {
 		if os.IsNotExist(err) {
-			fmt.Println("File does not exist, starting fresh")
-			return data, nil
+			return nil, 0, err
 		}
-		return nil, fmt.Errorf("failed to read file: %v", err)
+		return nil, 0, fmt.Errorf("failed to read file: %v", err)
 	}
 
-	err = json.Unmarshal(fileData, &data)
+	var envelope fileEnvelope
+	err = json.Unmarshal(fileData, &envelope)
 	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal data: %v", err)
+		return nil, 0, fmt.Errorf("failed to unmarshal data: %v", err)
 	}
 
-	return data, nil
+	payload := fileData
+	if envelope.Checksum != "" {
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, envelope.Data); err != nil {
+			return nil, 0, fmt.Errorf("failed to read data: %v", err)
+		}
+		checksum := sha256.Sum256(compact.Bytes())
+		if hex.EncodeToString(checksum[:]) != envelope.Checksum {
+			return nil, 0, fmt.Errorf("checksum mismatch in %s", path)
+		}
+		payload = envelope.Data
+	}
+	// Files written by older versions have no envelope or no version
+	version := max(envelope.Version, 1)
+
+	payload, err = migratePayload(payload, version)
+	if err != nil {
+		return nil, 0, err
+	}
+	data := make(map[string]EndpointData)
+	err = json.Unmarshal(payload, &data)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to unmarshal data: %v", err)
+	}
+	return data, version, nil
+}
+
+// Migrate upgrades the file to SchemaVersion and returns the migrations
+// applied. The original file is first copied to backupPath, unless it is
+// empty. With dryRun set it only returns the pending migrations.
+func (s *JsonFileStorage) Migrate(backupPath string, dryRun bool) ([]Migration, error) {
+	fileMu.Lock()
+	defer fileMu.Unlock()
+
+	data, version, err := readGeneration(s.FilePath)
+	if err != nil {
+		return nil, err
+	}
+	pending := PendingMigrations(version)
+	if dryRun || len(pending) == 0 {
+		return pending, nil
+	}
+
+	if backupPath != "" {
+		original, err := os.ReadFile(s.FilePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file: %v", err)
+		}
+		if err := os.WriteFile(backupPath, original, 0600); err != nil {
+			return nil, fmt.Errorf("failed to write backup: %v", err)
+		}
+	}
+	s.cache = nil
+	if err := s.write(data); err != nil {
+		return nil, err
+	}
+	return pending, nil
 }
 
 func (s *JsonFileStorage) Load() (map[string]*AddressTransactions, int64, error) {
+	fileMu.Lock()
+	defer fileMu.Unlock()
+
 	// Load the entire file data
 	existingData, err := s.loadAll()
 	if err != nil {
 		return nil, 0, err
 	}
 
-	// Filter for the specific endpoint
-	if endpointData, ok := existingData[s.Endpoint]; ok {
-		return endpointData.SubscribedAddresses, endpointData.LatestBlockNumber, nil
+	// Filter for the specific chain
+	data := s.current(existingData)
+	if s.pending > 0 && s.pending > data.LatestBlockNumber {
+		return data.SubscribedAddresses, s.pending, nil
 	}
+	return data.SubscribedAddresses, data.LatestBlockNumber, nil
+}
+
+// accessPath is the file the tenants and API keys are kept in. They are
+// rarely written, so they are not part of the versioned data file.
+func (s *JsonFileStorage) accessPath() string {
+	return s.FilePath + ".access"
+}
 
-	// If no data exists for this endpoint, return fresh data
-	return make(map[string]*AddressTransactions), -1, nil
+func (s *JsonFileStorage) LoadAccess() (*AccessData, error) {
+	fileMu.Lock()
+	defer fileMu.Unlock()
+
+	payload, err := os.ReadFile(s.accessPath())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read access data: %v", err)
+	}
+	return unmarshalAccess(payload)
+}
+
+func (s *JsonFileStorage) SaveAccess(data *AccessData) error {
+	payload, err := marshalAccess(data)
+	if err != nil {
+		return err
+	}
+	fileMu.Lock()
+	defer fileMu.Unlock()
+	return replaceFile(s.accessPath(), payload)
 }
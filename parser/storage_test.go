@@ -0,0 +1,268 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJsonFileStorageKeyedByChainID(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.json")
+	mainnet := &JsonFileStorage{FilePath: path, ChainID: 1}
+	sepolia := &JsonFileStorage{FilePath: path, ChainID: 11155111}
+
+	require.NoError(t, mainnet.AddSubscription("0xabc"))
+	require.NoError(t, mainnet.AppendBlock(100, map[string][]Transaction{"0xabc": {{Txhash: "0x1"}}}))
+
+	loaded, latest, err := sepolia.Load()
+	require.NoError(t, err)
+	require.Empty(t, loaded)
+	require.Equal(t, int64(-1), latest)
+
+	// A different provider for the same chain sees the same data
+	other := &JsonFileStorage{FilePath: path, Endpoint: "https://other.example"}
+	other.SetChainID(1)
+	loaded, latest, err = other.Load()
+	require.NoError(t, err)
+	require.Equal(t, int64(100), latest)
+	require.Len(t, loaded["0xabc"].Transactions, 1)
+}
+
+func TestJsonFileStorageMigratesEndpointKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.json")
+	endpoint := "http://localhost:8545"
+	legacy := map[string]EndpointData{
+		endpoint: {
+			LatestBlockNumber:   42,
+			SubscribedAddresses: map[string]*AddressTransactions{"0xabc": {}},
+		},
+	}
+	data, err := json.Marshal(legacy)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, data, 0644))
+
+	storage := &JsonFileStorage{FilePath: path, ChainID: 31337, Endpoint: endpoint}
+	loaded, latest, err := storage.Load()
+	require.NoError(t, err)
+	require.Equal(t, int64(42), latest)
+	require.Contains(t, loaded, "0xabc")
+
+	require.NoError(t, storage.AppendBlock(43, nil))
+	all, err := storage.loadAll()
+	require.NoError(t, err)
+	require.NotContains(t, all, endpoint)
+	require.Contains(t, all, "31337")
+}
+
+func TestJsonFileStorageIncrementalUpdates(t *testing.T) {
+	storage := &JsonFileStorage{FilePath: filepath.Join(t.TempDir(), "data.json"), ChainID: 1}
+
+	require.NoError(t, storage.AddSubscription("0xabc"))
+	require.NoError(t, storage.AddSubscription("0xdef"))
+	require.NoError(t, storage.AppendBlock(1, map[string][]Transaction{"0xabc": {{Txhash: "0x1"}, {Txhash: "0x2"}}}))
+	require.NoError(t, storage.AppendBlock(2, map[string][]Transaction{"0xabc": {{Txhash: "0x3"}}}))
+	require.NoError(t, storage.RemoveSubscription("0xdef"))
+
+	loaded, latest, err := storage.Load()
+	require.NoError(t, err)
+	require.Equal(t, int64(2), latest)
+	require.Len(t, loaded, 1)
+
+	page, err := storage.GetTransactions("0xabc", 1, 5)
+	require.NoError(t, err)
+	require.Equal(t, []Transaction{{Txhash: "0x2"}, {Txhash: "0x3"}}, page)
+
+	page, err = storage.GetTransactions("0xabc", 10, 5)
+	require.NoError(t, err)
+	require.Empty(t, page)
+
+	// Pages are served from the data last written, without reading the file
+	require.NoError(t, os.WriteFile(storage.FilePath, []byte("{"), 0644))
+	page, err = storage.GetTransactions("0xabc", 2, 5)
+	require.NoError(t, err)
+	require.Equal(t, []Transaction{{Txhash: "0x3"}}, page)
+}
+
+func TestJsonFileStorageKeepsBackupGenerations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.json")
+	storage := &JsonFileStorage{FilePath: path, ChainID: 1, Generations: 2, CursorInterval: 1}
+
+	for blockNumber := int64(1); blockNumber <= 4; blockNumber++ {
+		require.NoError(t, storage.AppendBlock(blockNumber, nil))
+	}
+
+	for generation, expected := range []int64{4, 3, 2} {
+		data, _, err := readGeneration(storage.generationPath(generation))
+		require.NoError(t, err)
+		require.Equal(t, expected, data["1"].LatestBlockNumber)
+	}
+	_, err := os.Stat(storage.generationPath(3))
+	require.True(t, os.IsNotExist(err), "Expected only 2 backup generations")
+
+	matches, err := filepath.Glob(path + ".tmp-*")
+	require.NoError(t, err)
+	require.Empty(t, matches, "Expected temporary files to be cleaned up")
+}
+
+func TestJsonFileStorageDefersEmptyBlocks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.json")
+	storage := &JsonFileStorage{FilePath: path, ChainID: 1, CursorInterval: 4}
+	require.NoError(t, storage.AppendBlock(1, nil))
+	written := func() int64 {
+		data, _, err := readGeneration(path)
+		require.NoError(t, err)
+		return data["1"].LatestBlockNumber
+	}
+
+	// Empty blocks are only written every CursorInterval blocks
+	for blockNumber := int64(2); blockNumber <= 4; blockNumber++ {
+		require.NoError(t, storage.AppendBlock(blockNumber, nil))
+	}
+	require.Equal(t, int64(1), written())
+	_, latest, err := storage.Load()
+	require.NoError(t, err)
+	require.Equal(t, int64(4), latest)
+	require.NoError(t, storage.AppendBlock(5, nil))
+	require.Equal(t, int64(5), written())
+
+	// Other writes save the pending block, a revert drops it
+	require.NoError(t, storage.AppendBlock(6, nil))
+	require.NoError(t, storage.AddSubscription("0xabc"))
+	require.Equal(t, int64(6), written())
+	require.NoError(t, storage.AppendBlock(7, nil))
+	require.NoError(t, storage.RevertBlocks(3, nil))
+	require.Equal(t, int64(3), written())
+	_, latest, err = storage.Load()
+	require.NoError(t, err)
+	require.Equal(t, int64(3), latest)
+	require.NoError(t, storage.AppendBlock(4, map[string][]Transaction{"0xabc": {{Txhash: "0x1"}}}))
+	require.Equal(t, int64(4), written())
+}
+
+func TestJsonFileStorageRecoversFromCorruption(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.json")
+	storage := &JsonFileStorage{FilePath: path, ChainID: 1, CursorInterval: 1}
+	require.NoError(t, storage.AppendBlock(1, nil))
+	require.NoError(t, storage.AppendBlock(2, nil))
+
+	// Tamper with the newest version without fixing its checksum
+	fileData, err := os.ReadFile(path)
+	require.NoError(t, err)
+	tampered := bytes.Replace(fileData, []byte(`"latestBlockNumber": 2`), []byte(`"latestBlockNumber": 9`), 1)
+	require.NotEqual(t, fileData, tampered)
+	require.NoError(t, os.WriteFile(path, tampered, 0644))
+
+	_, latest, err := storage.Load()
+	require.NoError(t, err)
+	require.Equal(t, int64(1), latest, "Expected to recover from the newest valid backup")
+
+	// A truncated file is also skipped
+	require.NoError(t, os.WriteFile(path, fileData[:len(fileData)/2], 0644))
+	_, latest, err = storage.Load()
+	require.NoError(t, err)
+	require.Equal(t, int64(1), latest)
+
+	// Writing again produces a valid newest version
+	require.NoError(t, storage.AppendBlock(3, nil))
+	_, latest, err = storage.Load()
+	require.NoError(t, err)
+	require.Equal(t, int64(3), latest)
+
+	// Without any valid version loading fails instead of starting fresh
+	for generation := 0; generation <= DefaultBackupGenerations; generation++ {
+		os.WriteFile(storage.generationPath(generation), []byte("{"), 0644)
+	}
+	_, _, err = storage.Load()
+	require.Error(t, err)
+}
+
+func TestJsonFileStorageMigratesSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.json")
+	legacy := []byte(`{"1": {"latestBlockNumber": 7, "subscribedAddresses": {"0xabc": {"transactions": [{"txhash": "0x1", "blobGasPrice": "0x3b9aca00"}]}}}}`)
+	require.NoError(t, os.WriteFile(path, legacy, 0644))
+	storage := &JsonFileStorage{FilePath: path, ChainID: 1}
+
+	loaded, latest, err := storage.Load()
+	require.NoError(t, err)
+	require.Equal(t, int64(7), latest)
+	require.Equal(t, "0x3b9aca00", loaded["0xabc"].Transactions[0].GasPrice)
+
+	// A dry run reports the pending migrations without touching the file
+	backup := path + ".bak"
+	pending, err := storage.Migrate(backup, true)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	require.Equal(t, 2, pending[0].Version)
+	fileData, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, legacy, fileData)
+
+	applied, err := storage.Migrate(backup, false)
+	require.NoError(t, err)
+	require.Len(t, applied, 1)
+	backupData, err := os.ReadFile(backup)
+	require.NoError(t, err)
+	require.Equal(t, legacy, backupData)
+
+	var envelope fileEnvelope
+	fileData, err = os.ReadFile(path)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(fileData, &envelope))
+	require.Equal(t, SchemaVersion, envelope.Version)
+	require.Contains(t, string(envelope.Data), `"gasPrice": "0x3b9aca00"`)
+	require.NotContains(t, string(envelope.Data), "blobGasPrice")
+
+	pending, err = storage.Migrate(backup, true)
+	require.NoError(t, err)
+	require.Empty(t, pending)
+
+	// Log and key-value records still carry the old field name
+	var tx Transaction
+	require.NoError(t, json.Unmarshal([]byte(`{"txhash": "0x1", "blobGasPrice": "0x1"}`), &tx))
+	require.Equal(t, Transaction{Txhash: "0x1", GasPrice: "0x1"}, tx)
+}
+
+func TestJsonFileStorageRejectsNewerSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.json")
+	storage := &JsonFileStorage{FilePath: path, ChainID: 1, CursorInterval: 1}
+	require.NoError(t, storage.AppendBlock(1, nil))
+	require.NoError(t, storage.AppendBlock(2, nil))
+
+	fileData, err := os.ReadFile(path)
+	require.NoError(t, err)
+	newer := bytes.Replace(fileData, []byte(`"version": 2`), []byte(`"version": 3`), 1)
+	require.NotEqual(t, fileData, newer)
+	require.NoError(t, os.WriteFile(path, newer, 0644))
+
+	// Older backups are not used in place of data from a newer version
+	_, _, err = storage.Load()
+	require.ErrorIs(t, err, ErrUnsupportedSchema)
+}
+
+func TestJsonFileStorageFailedMigrationIsFatal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.json")
+	storage := &JsonFileStorage{FilePath: path, ChainID: 1, CursorInterval: 1}
+	require.NoError(t, storage.AppendBlock(1, nil))
+	require.NoError(t, storage.AppendBlock(2, nil))
+	require.FileExists(t, storage.generationPath(1))
+	legacy := []byte(`{"1": {"latestBlockNumber": 7, "subscribedAddresses": {}}}`)
+	require.NoError(t, os.WriteFile(path, legacy, 0644))
+
+	migrate := Migrations[0].Migrate
+	Migrations[0].Migrate = func(chains map[string]any) error { return errors.New("unexpected field") }
+	defer func() { Migrations[0].Migrate = migrate }()
+
+	// The backup is not used in place of the newer data, which the next
+	// write would overwrite
+	_, _, err := storage.Load()
+	require.ErrorIs(t, err, ErrMigrationFailed)
+	require.Error(t, storage.AppendBlock(8, nil))
+	fileData, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, legacy, fileData)
+}
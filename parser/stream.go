@@ -0,0 +1,385 @@
+package parser
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// DefaultStreamCapacity is how many events a TxStream keeps for resuming
+// listeners.
+const DefaultStreamCapacity = 10000
+
+// TxEvent is a change to the transactions of a subscribed address. Seq
+// numbers the events of a stream in the order they were published, starting
+// at 1.
+type TxEvent struct {
+	Seq           uint64      `json:"seq"`
+	Type          string      `json:"type"`
+	ChainID       int64       `json:"chainId"`
+	Address       string      `json:"address"`
+	Transaction   Transaction `json:"transaction"`
+	Confirmations int64       `json:"confirmations,omitempty"`
+}
+
+// transactionEvents returns events of type eventType for transactions,
+// ordered by address.
+func transactionEvents(chainID int64, eventType string, transactions map[string][]Transaction) []Event {
+	addresses := make([]string, 0, len(transactions))
+	for address := range transactions {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+
+	var events []Event
+	for _, address := range addresses {
+		for _, tx := range transactions[address] {
+			events = append(events, Event{Type: eventType, ChainID: chainID, Address: address, Transaction: &tx})
+		}
+	}
+	return events
+}
+
+// TxStream numbers the transaction events of a parser and fans them out to
+// listeners. The latest events are kept so listeners can resume after
+// the last sequence number they saw. With a journal file, events and their
+// sequence numbers survive restarts.
+type TxStream struct {
+	path     string
+	capacity int
+
+	mu        sync.Mutex
+	events    []TxEvent // retained events, in sequence order
+	lastSeq   uint64
+	file      *os.File
+	lines     int // events in the journal file
+	listeners map[*TxListener]struct{}
+}
+
+// TxListener receives the events of a stream on C. When its buffer is full
+// the listener is dropped and C closed, so a slow consumer cannot hold back
+// the parser. It can then resume with Since.
+type TxListener struct {
+	C <-chan TxEvent
+
+	c         chan TxEvent
+	addresses map[string]struct{}
+	stream    *TxStream
+	overflow  bool
+}
+
+// NewTxStream creates a stream that keeps the last capacity events in
+// memory.
+func NewTxStream(capacity int) *TxStream {
+	if capacity <= 0 {
+		capacity = DefaultStreamCapacity
+	}
+	return &TxStream{
+		capacity:  capacity,
+		listeners: make(map[*TxListener]struct{}),
+	}
+}
+
+// OpenTxStream creates a stream that also keeps its last capacity events in
+// a journal file at path, and continues the sequence numbers stored there.
+func OpenTxStream(path string, capacity int) (*TxStream, error) {
+	s := NewTxStream(capacity)
+	s.path = path
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open event journal: %v", err)
+	}
+	var valid int64
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			// A torn last line is dropped
+			break
+		}
+		var event TxEvent
+		if err := json.Unmarshal(line, &event); err != nil || event.Seq <= s.lastSeq {
+			break
+		}
+		valid += int64(len(line))
+		s.lines++
+		s.lastSeq = event.Seq
+		s.retain(event)
+	}
+	if err := f.Truncate(valid); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to truncate event journal: %v", err)
+	}
+	if _, err := f.Seek(valid, 0); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to open event journal: %v", err)
+	}
+	s.file = f
+	return s, nil
+}
+
+// retain keeps event. Older events are only dropped once twice the
+// capacity is held, so they are not moved on every event.
+func (s *TxStream) retain(event TxEvent) {
+	s.events = append(s.events, event)
+	if len(s.events) > 2*s.capacity {
+		s.events = append(s.events[:0], s.retained()...)
+	}
+}
+
+// retained returns the last capacity events. Called with the lock held.
+func (s *TxStream) retained() []TxEvent {
+	return s.events[max(0, len(s.events)-s.capacity):]
+}
+
+// LastSeq returns the sequence number of the latest event.
+func (s *TxStream) LastSeq() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastSeq
+}
+
+// Handle publishes the transaction events of a bus, so the stream can be
+// registered as a sink.
+func (s *TxStream) Handle(events []Event) error {
+	var published []TxEvent
+	for _, event := range events {
+		if event.Transaction == nil {
+			continue
+		}
+		published = append(published, TxEvent{
+			Type:          event.Type,
+			ChainID:       event.ChainID,
+			Address:       event.Address,
+			Transaction:   *event.Transaction,
+			Confirmations: event.Confirmations,
+		})
+	}
+	return s.Publish(published...)
+}
+
+// Publish numbers events and sends them to the listeners. They are
+// journaled first, so an event is never delivered with a sequence number
+// that could be reused.
+func (s *TxStream) Publish(events ...TxEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seq := s.lastSeq
+	events = append([]TxEvent(nil), events...)
+	for i := range events {
+		seq++
+		events[i].Seq = seq
+	}
+	if err := s.journal(events); err != nil {
+		return err
+	}
+	s.lastSeq = seq
+
+	for _, event := range events {
+		s.retain(event)
+		for listener := range s.listeners {
+			if !listener.matches(event.Address) {
+				continue
+			}
+			select {
+			case listener.c <- event:
+			default:
+				listener.overflow = true
+				s.remove(listener)
+			}
+		}
+	}
+	return nil
+}
+
+// journal appends events to the journal file, rewriting it with only the
+// retained events once it holds twice the capacity.
+func (s *TxStream) journal(events []TxEvent) error {
+	if s.file == nil {
+		return nil
+	}
+	if s.lines+len(events) > 2*s.capacity {
+		if err := s.compact(); err != nil {
+			return err
+		}
+	}
+	var data []byte
+	for _, event := range events {
+		line, err := json.Marshal(event)
+		if err != nil {
+			return fmt.Errorf("failed to marshal event: %v", err)
+		}
+		data = append(append(data, line...), '\n')
+	}
+	if _, err := s.file.Write(data); err != nil {
+		return fmt.Errorf("failed to write event journal: %v", err)
+	}
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync event journal: %v", err)
+	}
+	s.lines += len(events)
+	return nil
+}
+
+func (s *TxStream) compact() error {
+	tmp := s.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to compact event journal: %v", err)
+	}
+	writer := bufio.NewWriter(f)
+	encoder := json.NewEncoder(writer)
+	retained := s.retained()
+	for _, event := range retained {
+		if err := encoder.Encode(event); err != nil {
+			f.Close()
+			return fmt.Errorf("failed to compact event journal: %v", err)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to compact event journal: %v", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to compact event journal: %v", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to compact event journal: %v", err)
+	}
+	if err := syncDir(filepath.Dir(s.path)); err != nil {
+		f.Close()
+		return err
+	}
+	s.file.Close()
+	s.file = f
+	s.lines = len(retained)
+	return nil
+}
+
+// Listen registers a listener for the events of addresses, or of every
+// address if addresses is nil, buffering up to buffer events. A listener
+// created with an empty list receives nothing until addresses are added.
+func (s *TxStream) Listen(addresses []string, buffer int) *TxListener {
+	c := make(chan TxEvent, buffer)
+	listener := &TxListener{C: c, c: c, stream: s}
+	if addresses != nil {
+		listener.addresses = make(map[string]struct{}, len(addresses))
+		for _, address := range addresses {
+			listener.addresses[address] = struct{}{}
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listeners[listener] = struct{}{}
+	return listener
+}
+
+// Since returns the retained events of addresses after seq. It reports
+// false if older events after seq are no longer retained.
+func (s *TxStream) Since(addresses []string, seq uint64) ([]TxEvent, bool) {
+	filter := TxListener{}
+	if len(addresses) > 0 {
+		filter.addresses = make(map[string]struct{}, len(addresses))
+		for _, address := range addresses {
+			filter.addresses[address] = struct{}{}
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	retained := s.retained()
+	complete := seq >= s.lastSeq || (len(retained) > 0 && retained[0].Seq <= seq+1)
+	start := sort.Search(len(retained), func(i int) bool { return retained[i].Seq > seq })
+	var events []TxEvent
+	for _, event := range retained[start:] {
+		if filter.matches(event.Address) {
+			events = append(events, event)
+		}
+	}
+	return events, complete
+}
+
+// remove unregisters listener and closes its channel. Called with the lock
+// held.
+func (s *TxStream) remove(listener *TxListener) {
+	if _, exists := s.listeners[listener]; exists {
+		delete(s.listeners, listener)
+		close(listener.c)
+	}
+}
+
+// Close closes the journal file and every listener.
+func (s *TxStream) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for listener := range s.listeners {
+		s.remove(listener)
+	}
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+func (l *TxListener) matches(address string) bool {
+	if l.addresses == nil {
+		return true
+	}
+	_, exists := l.addresses[address]
+	return exists
+}
+
+// Add makes the listener receive the events of addresses. It does nothing
+// for a listener of every address.
+func (l *TxListener) Add(addresses ...string) {
+	l.stream.mu.Lock()
+	defer l.stream.mu.Unlock()
+	if l.addresses == nil {
+		return
+	}
+	for _, address := range addresses {
+		l.addresses[address] = struct{}{}
+	}
+}
+
+// Remove stops the listener receiving the events of addresses. It does
+// nothing for a listener of every address.
+func (l *TxListener) Remove(addresses ...string) {
+	l.stream.mu.Lock()
+	defer l.stream.mu.Unlock()
+	for _, address := range addresses {
+		delete(l.addresses, address)
+	}
+}
+
+// Overflowed reports whether the listener was dropped because its buffer
+// was full.
+func (l *TxListener) Overflowed() bool {
+	l.stream.mu.Lock()
+	defer l.stream.mu.Unlock()
+	return l.overflow
+}
+
+// Close unregisters the listener.
+func (l *TxListener) Close() {
+	l.stream.mu.Lock()
+	defer l.stream.mu.Unlock()
+	l.stream.remove(l)
+}
@@ -0,0 +1,194 @@
+package parser
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTxStreamJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	alice := "0x0000000000000000000000000000000000000001"
+
+	stream, err := OpenTxStream(path, 3)
+	require.NoError(t, err)
+	for blockNumber := int64(1); blockNumber <= 4; blockNumber++ {
+		require.NoError(t, stream.Handle(transactionEvents(1, TxDetected, blockTransactions(alice, blockNumber, blockNumber))))
+	}
+	require.Equal(t, uint64(4), stream.LastSeq())
+	require.NoError(t, stream.Close())
+
+	// Leave a torn event behind
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"seq":5,"chainId"`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	stream, err = OpenTxStream(path, 3)
+	require.NoError(t, err)
+	defer stream.Close()
+	require.Equal(t, uint64(4), stream.LastSeq())
+
+	events, complete := stream.Since(nil, 2)
+	require.True(t, complete)
+	require.Len(t, events, 2)
+	require.Equal(t, uint64(3), events[0].Seq)
+	require.Equal(t, alice, events[0].Address)
+	require.Equal(t, int64(1), events[0].ChainID)
+
+	// Only the last 3 events are retained
+	events, complete = stream.Since(nil, 0)
+	require.False(t, complete)
+	require.Len(t, events, 3)
+
+	require.NoError(t, stream.Handle(transactionEvents(1, TxDetected, blockTransactions(alice, 5, 5))))
+	events, _ = stream.Since(nil, 4)
+	require.Equal(t, uint64(5), events[0].Seq)
+	require.Equal(t, "0x5", events[0].Transaction.BlockNumber)
+
+	// The journal is compacted to the retained events
+	for blockNumber := int64(6); blockNumber <= 10; blockNumber++ {
+		require.NoError(t, stream.Handle(transactionEvents(1, TxDetected, blockTransactions(alice, blockNumber, blockNumber))))
+	}
+	require.NoError(t, stream.Close())
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.LessOrEqual(t, strings.Count(string(data), "\n"), 6)
+	stream, err = OpenTxStream(path, 3)
+	require.NoError(t, err)
+	defer stream.Close()
+	require.Equal(t, uint64(10), stream.LastSeq())
+}
+
+func TestTxStreamRetention(t *testing.T) {
+	stream := NewTxStream(3)
+	alice := "0x0000000000000000000000000000000000000001"
+	for blockNumber := int64(1); blockNumber <= 10; blockNumber++ {
+		require.NoError(t, stream.Handle(transactionEvents(1, TxDetected, blockTransactions(alice, blockNumber, blockNumber))))
+
+		// Only the last capacity events are returned, however many are held
+		events, complete := stream.Since(nil, 0)
+		require.Len(t, events, min(int(blockNumber), 3))
+		require.Equal(t, uint64(blockNumber), events[len(events)-1].Seq)
+		require.Equal(t, blockNumber <= 3, complete)
+		require.LessOrEqual(t, len(stream.events), 6)
+	}
+}
+
+func TestTxStreamListeners(t *testing.T) {
+	stream := NewTxStream(10)
+	alice := "0x0000000000000000000000000000000000000001"
+	bob := "0x0000000000000000000000000000000000000002"
+
+	all := stream.Listen(nil, 10)
+	defer all.Close()
+	onlyBob := stream.Listen([]string{bob}, 10)
+	defer onlyBob.Close()
+	slow := stream.Listen(nil, 1)
+
+	require.NoError(t, stream.Handle(transactionEvents(1, TxDetected, map[string][]Transaction{
+		alice: {{Txhash: "0x1"}},
+		bob:   {{Txhash: "0x2"}},
+	})))
+	require.Equal(t, "0x1", (<-all.C).Transaction.Txhash)
+	require.Equal(t, "0x2", (<-all.C).Transaction.Txhash)
+	event := <-onlyBob.C
+	require.Equal(t, uint64(2), event.Seq)
+	require.Equal(t, bob, event.Address)
+
+	// The slow listener is dropped instead of blocking the stream
+	<-slow.C
+	_, ok := <-slow.C
+	require.False(t, ok)
+	require.True(t, slow.Overflowed())
+	slow.Close()
+
+	// A listener of no address receives the addresses added to it
+	some := stream.Listen([]string{}, 10)
+	defer some.Close()
+	some.Add(alice)
+	require.NoError(t, stream.Handle(transactionEvents(2, TxDetected, map[string][]Transaction{
+		alice: {{Txhash: "0x3"}},
+		bob:   {{Txhash: "0x4"}},
+	})))
+	require.Equal(t, "0x3", (<-some.C).Transaction.Txhash)
+	some.Remove(alice)
+	some.Add(bob)
+	require.NoError(t, stream.Handle(transactionEvents(3, TxDetected, map[string][]Transaction{
+		alice: {{Txhash: "0x5"}},
+		bob:   {{Txhash: "0x6"}},
+	})))
+	require.Equal(t, "0x6", (<-some.C).Transaction.Txhash)
+	require.Empty(t, some.C)
+}
+
+func TestParserPublishesTransactions(t *testing.T) {
+	addresses := testAddresses(2)
+	rpc := newFakeRPC(t, addresses)
+	p := NewParser(nil, 0)
+	ctx := context.Background()
+	require.NoError(t, p.Subscribe(ctx, addresses[1]))
+	listener := p.Stream().Listen(nil, 10)
+	defer listener.Close()
+
+	_, err := p.ProcessBlock(1, rpc.URL)
+	require.NoError(t, err)
+	event := <-listener.C
+	require.Equal(t, uint64(1), event.Seq)
+	require.Equal(t, addresses[1], event.Address)
+	require.Equal(t, "0x1-1", event.Transaction.Txhash)
+
+	// Nothing new is published when a block is processed again
+	_, err = p.ProcessBlock(1, rpc.URL)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), p.Stream().LastSeq())
+}
+
+func TestParserJournalsBeforeReturning(t *testing.T) {
+	addresses := testAddresses(1)
+	rpc := newFakeRPC(t, addresses)
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	stream, err := OpenTxStream(path, 10)
+	require.NoError(t, err)
+	defer stream.Close()
+	p := NewParser(nil, 0)
+	p.SetStream(stream)
+	ctx := context.Background()
+	require.NoError(t, p.Subscribe(ctx, addresses[0]))
+
+	// A sink that blocks the bus holds back neither the journal nor
+	// readers of the parser
+	release := make(chan struct{})
+	p.Events().Register(SinkFunc(func(events []Event) error {
+		<-release
+		return nil
+	}), SinkOptions{Name: "stuck", Buffer: 1, Backpressure: BackpressureBlock})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for block := int64(1); block <= 3; block++ {
+			p.ProcessBlock(block, rpc.URL)
+		}
+	}()
+	defer func() {
+		close(release)
+		<-done
+	}()
+	// Block 2 is committed once block 1 was published
+	require.Eventually(t, func() bool { return p.currentBlock() >= 2 }, 5*time.Second, 10*time.Millisecond)
+	transactions, err := p.GetTransactions(ctx, addresses[0])
+	require.NoError(t, err)
+	require.Len(t, transactions, 2)
+
+	// The events of block 1 are on disk while the bus is stuck
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Contains(t, string(data), `"txhash":"0x1-0"`)
+}
@@ -0,0 +1,769 @@
+package parser
+
+import (
+	"bytes"
+	"container/heap"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Headers of webhook requests. The signature is the hex HMAC-SHA256 of the
+// timestamp, a dot and the body, keyed with the webhook secret.
+const (
+	WebhookIDHeader        = "X-Webhook-Id"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+// Webhook defaults.
+const (
+	DefaultWebhookAttempts    = 10
+	DefaultWebhookMinBackoff  = time.Second
+	DefaultWebhookMaxBackoff  = time.Hour
+	DefaultWebhookTimeout     = 10 * time.Second
+	DefaultWebhookMaxFailed   = 1000
+	DefaultWebhookConcurrency = 4
+)
+
+// Webhook is an endpoint the events of a subscription are posted to.
+type Webhook struct {
+	URL    string `json:"url"`
+	Secret string `json:"secret"`
+}
+
+// WebhookDelivery is an event queued for, or failed to be, posted to the
+// webhook of an address. URL is where it was last attempted.
+type WebhookDelivery struct {
+	ID          string          `json:"id"`
+	Address     string          `json:"address"`
+	URL         string          `json:"url,omitempty"`
+	Payload     json.RawMessage `json:"payload"`
+	Attempts    int             `json:"attempts"`
+	NextAttempt time.Time       `json:"nextAttempt"`
+	LastError   string          `json:"lastError,omitempty"`
+}
+
+// WebhookOptions configures the delivery of webhooks. Zero values use the
+// defaults.
+type WebhookOptions struct {
+	// Attempts before a delivery is moved to the failed list
+	MaxAttempts int
+
+	// Delay before the first retry, doubled on every further retry up to
+	// MaxBackoff
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// Failed deliveries to keep, the oldest are dropped beyond it
+	MaxFailed int
+
+	// Deliveries posted at once to the same webhook URL, so a slow
+	// endpoint only holds up its own deliveries
+	Concurrency int
+
+	// Encrypt the webhook secrets in the state file, if set
+	Keys *Keyring
+
+	// Allow webhooks to loopback, private, link-local and other internal
+	// addresses. They are rejected by default, so API clients can't make
+	// the parser reach services that are not public, like cloud metadata.
+	AllowPrivate bool
+
+	// Client to post with, with a DefaultWebhookTimeout timeout if nil. The
+	// default client enforces AllowPrivate when connecting, a custom client
+	// has to do so itself.
+	Client *http.Client
+}
+
+// sharedAddressSpace is the carrier-grade NAT range, which some clouds
+// serve metadata from.
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// privateIP reports whether ip is not a public unicast address.
+func privateIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || sharedAddressSpace.Contains(ip)
+}
+
+// webhookClient returns a client that refuses to connect to private
+// addresses. The check runs on the resolved address of every connection,
+// redirects included, so a host name can't be pointed at one later.
+func webhookClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: DefaultWebhookTimeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || privateIP(ip) {
+				return fmt.Errorf("%w: %s is not a public address", ErrInvalidWebhook, host)
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// A proxy would be checked instead of the webhook
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: DefaultWebhookTimeout, Transport: transport}
+}
+
+// webhookState is what Webhooks persists. The file is only readable by its
+// owner, as it holds the webhook secrets.
+type webhookState struct {
+	// Sequence number of the last stream event queued
+	Cursor    uint64             `json:"cursor"`
+	Endpoints map[string]Webhook `json:"endpoints"`
+	Queue     []WebhookDelivery  `json:"queue"`
+	Failed    []WebhookDelivery  `json:"failed"`
+}
+
+// Webhooks posts the events of a TxStream to the webhooks of their
+// addresses, retrying failed deliveries with exponential backoff. Deliveries
+// that keep failing are kept in a failed list, from which they can be
+// replayed. With a file, the webhooks, the queue and the failed list
+// survive restarts.
+type Webhooks struct {
+	path    string
+	options WebhookOptions
+
+	mu      sync.Mutex
+	state   webhookState                 // the queue is only filled while saving
+	queue   deliveryQueue                // deliveries not started, by next attempt
+	waiting map[string][]WebhookDelivery // due deliveries by webhook URL, waiting for a worker
+	active  map[string]WebhookDelivery   // deliveries being posted, by id
+	busy    map[string]int               // deliveries being posted, by webhook URL
+	unsaved int                          // finished deliveries not saved yet
+	wake    chan struct{}
+}
+
+// deliveryQueue is a heap of deliveries ordered by their next attempt.
+type deliveryQueue []WebhookDelivery
+
+func (q deliveryQueue) Len() int           { return len(q) }
+func (q deliveryQueue) Less(i, j int) bool { return q[i].NextAttempt.Before(q[j].NextAttempt) }
+func (q deliveryQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q *deliveryQueue) Push(x any)        { *q = append(*q, x.(WebhookDelivery)) }
+
+func (q *deliveryQueue) Pop() any {
+	old := *q
+	delivery := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return delivery
+}
+
+// NewWebhooks creates webhooks kept in memory.
+func NewWebhooks(options WebhookOptions) *Webhooks {
+	if options.MaxAttempts <= 0 {
+		options.MaxAttempts = DefaultWebhookAttempts
+	}
+	if options.MinBackoff <= 0 {
+		options.MinBackoff = DefaultWebhookMinBackoff
+	}
+	if options.MaxBackoff <= 0 {
+		options.MaxBackoff = DefaultWebhookMaxBackoff
+	}
+	if options.MaxFailed <= 0 {
+		options.MaxFailed = DefaultWebhookMaxFailed
+	}
+	if options.Concurrency <= 0 {
+		options.Concurrency = DefaultWebhookConcurrency
+	}
+	if options.Client == nil {
+		options.Client = &http.Client{Timeout: DefaultWebhookTimeout}
+		if !options.AllowPrivate {
+			options.Client = webhookClient()
+		}
+	}
+	return &Webhooks{
+		options: options,
+		state:   webhookState{Endpoints: make(map[string]Webhook)},
+		waiting: make(map[string][]WebhookDelivery),
+		active:  make(map[string]WebhookDelivery),
+		busy:    make(map[string]int),
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// OpenWebhooks creates webhooks persisted in the file at path.
+func OpenWebhooks(path string, options WebhookOptions) (*Webhooks, error) {
+	w := NewWebhooks(options)
+	w.path = path
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return w, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read webhooks: %v", err)
+	}
+	if err := json.Unmarshal(data, &w.state); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal webhooks: %v", err)
+	}
+	if w.state.Endpoints == nil {
+		w.state.Endpoints = make(map[string]Webhook)
+	}
+	if options.Keys != nil {
+		for address, webhook := range w.state.Endpoints {
+			// Secrets saved before encryption was enabled are encrypted
+			// by the next save
+			webhook.Secret, _, err = options.Keys.decrypt(webhookSecretContext, webhook.Secret, true)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read webhook of %s: %v", address, err)
+			}
+			w.state.Endpoints[address] = webhook
+		}
+	}
+	w.queue, w.state.Queue = w.state.Queue, nil
+	heap.Init(&w.queue)
+	return w, nil
+}
+
+const webhookSecretContext = "webhook secret"
+
+// save persists the state. Called with the lock held.
+func (w *Webhooks) save() error {
+	w.unsaved = 0
+	if w.path == "" {
+		return nil
+	}
+	state := w.state
+	state.Queue = w.pending()
+	if w.options.Keys != nil {
+		state.Endpoints = make(map[string]Webhook, len(w.state.Endpoints))
+		for address, webhook := range w.state.Endpoints {
+			webhook.Secret = w.options.Keys.encrypt(webhookSecretContext, webhook.Secret)
+			state.Endpoints[address] = webhook
+		}
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to marshal webhooks: %v", err)
+	}
+	return replaceFile(w.path, data)
+}
+
+// pending returns the queued, waiting and active deliveries by next
+// attempt. Called with the lock held.
+func (w *Webhooks) pending() []WebhookDelivery {
+	deliveries := append([]WebhookDelivery{}, w.queue...)
+	for _, waiting := range w.waiting {
+		deliveries = append(deliveries, waiting...)
+	}
+	for _, delivery := range w.active {
+		deliveries = append(deliveries, delivery)
+	}
+	sort.SliceStable(deliveries, func(i, j int) bool {
+		return deliveries[i].NextAttempt.Before(deliveries[j].NextAttempt)
+	})
+	return deliveries
+}
+
+// SignWebhook returns the signature of a webhook body sent at timestamp,
+// in Unix seconds.
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Set registers the webhook of address, generating a secret if it has
+// none, and returns it.
+func (w *Webhooks) Set(address string, webhook Webhook) (Webhook, error) {
+	u, err := url.Parse(webhook.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return Webhook{}, fmt.Errorf("%w: url must be an absolute http or https url", ErrInvalidWebhook)
+	}
+	if !w.options.AllowPrivate {
+		// Host names are checked again on every connection
+		ip := net.ParseIP(u.Hostname())
+		if u.Hostname() == "localhost" || (ip != nil && privateIP(ip)) {
+			return Webhook{}, fmt.Errorf("%w: url must point to a public address", ErrInvalidWebhook)
+		}
+	}
+	if webhook.Secret == "" {
+		webhook.Secret = randomHex(32)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	previous, exists := w.state.Endpoints[address]
+	w.state.Endpoints[address] = webhook
+	if err := w.save(); err != nil {
+		if exists {
+			w.state.Endpoints[address] = previous
+		} else {
+			delete(w.state.Endpoints, address)
+		}
+		return Webhook{}, err
+	}
+	return webhook, nil
+}
+
+// Get returns the webhook of address.
+func (w *Webhooks) Get(address string) (Webhook, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	webhook, exists := w.state.Endpoints[address]
+	return webhook, exists
+}
+
+// Remove unregisters the webhook of address and drops its queued
+// deliveries.
+func (w *Webhooks) Remove(address string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	webhook, exists := w.state.Endpoints[address]
+	if !exists {
+		return ErrWebhookNotFound
+	}
+	queue, waiting, active := w.queue, w.waiting, w.active
+	delete(w.state.Endpoints, address)
+	w.queue = nil
+	for _, delivery := range queue {
+		if delivery.Address != address {
+			w.queue = append(w.queue, delivery)
+		}
+	}
+	heap.Init(&w.queue)
+	w.waiting = make(map[string][]WebhookDelivery)
+	for url, deliveries := range waiting {
+		for _, delivery := range deliveries {
+			if delivery.Address != address {
+				w.waiting[url] = append(w.waiting[url], delivery)
+			}
+		}
+	}
+	// Deliveries being posted are not retried
+	w.active = make(map[string]WebhookDelivery)
+	for id, delivery := range active {
+		if delivery.Address != address {
+			w.active[id] = delivery
+		}
+	}
+	if err := w.save(); err != nil {
+		w.state.Endpoints[address] = webhook
+		w.queue, w.waiting, w.active = queue, waiting, active
+		return err
+	}
+	return nil
+}
+
+// Pending returns the queued deliveries, including those being posted.
+func (w *Webhooks) Pending() []WebhookDelivery {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.pending()
+}
+
+// Failed returns the deliveries that ran out of attempts, oldest first. At
+// most MaxFailed are kept.
+func (w *Webhooks) Failed() []WebhookDelivery {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]WebhookDelivery{}, w.state.Failed...)
+}
+
+// Replay queues a failed delivery again, with a fresh set of attempts.
+func (w *Webhooks) Replay(id string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	i := w.failedIndex(id)
+	if i < 0 {
+		return ErrDeliveryNotFound
+	}
+	delivery := w.state.Failed[i]
+	delivery.Attempts = 0
+	delivery.NextAttempt = time.Now()
+	failed := w.state.Failed
+	w.state.Failed = append(append([]WebhookDelivery{}, failed[:i]...), failed[i+1:]...)
+	heap.Push(&w.queue, delivery)
+	if err := w.save(); err != nil {
+		w.state.Failed = failed
+		for j := range w.queue {
+			if w.queue[j].ID == id {
+				heap.Remove(&w.queue, j)
+				break
+			}
+		}
+		return err
+	}
+	w.notify()
+	return nil
+}
+
+// Discard drops a failed delivery.
+func (w *Webhooks) Discard(id string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	i := w.failedIndex(id)
+	if i < 0 {
+		return ErrDeliveryNotFound
+	}
+	failed := w.state.Failed
+	w.state.Failed = append(append([]WebhookDelivery{}, failed[:i]...), failed[i+1:]...)
+	if err := w.save(); err != nil {
+		w.state.Failed = failed
+		return err
+	}
+	return nil
+}
+
+func (w *Webhooks) failedIndex(id string) int {
+	for i, delivery := range w.state.Failed {
+		if delivery.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (w *Webhooks) notify() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run queues the events of stream for the webhooks of their addresses and
+// delivers them until ctx is done. It resumes after the last event it
+// queued, if the stream still retains it.
+func (w *Webhooks) Run(ctx context.Context, stream *TxStream) {
+	go w.follow(ctx, stream)
+	w.deliver(ctx)
+}
+
+// follow queues the events of stream.
+func (w *Webhooks) follow(ctx context.Context, stream *TxStream) {
+	w.mu.Lock()
+	if last := stream.LastSeq(); w.state.Cursor > last {
+		// The stream restarted its sequence numbers
+		w.state.Cursor = last
+	}
+	w.mu.Unlock()
+
+	for ctx.Err() == nil {
+		// Listen before reading the backlog so no event falls in between
+		listener := stream.Listen(nil, DefaultStreamCapacity)
+		w.mu.Lock()
+		cursor := w.state.Cursor
+		w.mu.Unlock()
+		backlog, complete := stream.Since(nil, cursor)
+		if !complete {
+			fmt.Printf("Warning: events after %d are no longer retained, their webhooks are not delivered\n", cursor)
+		}
+		w.enqueue(backlog)
+
+		for listening := true; listening; {
+			select {
+			case <-ctx.Done():
+				listener.Close()
+				return
+			case event, ok := <-listener.C:
+				if !ok {
+					if !listener.Overflowed() {
+						// The stream was closed
+						return
+					}
+					listening = false
+					continue
+				}
+				// Queue the events already waiting with this one, saving once
+				events := []TxEvent{event}
+				for more := true; more && len(events) < maxSinkBatch; {
+					select {
+					case event, ok := <-listener.C:
+						if ok {
+							events = append(events, event)
+						}
+						more = ok
+					default:
+						more = false
+					}
+				}
+				w.enqueue(events)
+			}
+		}
+	}
+}
+
+// enqueue queues the events after the cursor for the webhooks of their
+// addresses.
+func (w *Webhooks) enqueue(events []TxEvent) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	queued := false
+	for _, event := range events {
+		if event.Seq <= w.state.Cursor {
+			continue
+		}
+		w.state.Cursor = event.Seq
+		if _, exists := w.state.Endpoints[event.Address]; !exists {
+			continue
+		}
+		payload, err := json.Marshal(event)
+		if err != nil {
+			fmt.Printf("Error marshaling webhook payload: %v\n", err)
+			continue
+		}
+		heap.Push(&w.queue, WebhookDelivery{
+			ID:          randomHex(16),
+			Address:     event.Address,
+			Payload:     payload,
+			NextAttempt: time.Now(),
+		})
+		queued = true
+	}
+	if !queued {
+		// The cursor is saved with the next delivery
+		return
+	}
+	if err := w.save(); err != nil {
+		fmt.Printf("Error saving webhook queue: %v\n", err)
+	}
+	w.notify()
+}
+
+// webhookSaveBatch is how many deliveries may finish before the state is
+// saved. Deliveries finished but not saved before a crash are sent again.
+const webhookSaveBatch = 100
+
+// deliver posts the due deliveries until ctx is done, up to Concurrency at
+// once for each webhook URL. The state is saved once no delivery is being
+// posted, or every webhookSaveBatch deliveries.
+func (w *Webhooks) deliver(ctx context.Context) {
+	var workers sync.WaitGroup
+	defer func() {
+		workers.Wait()
+		w.flush()
+	}()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		case <-w.wake:
+		}
+
+		for ctx.Err() == nil {
+			delivery, wait, ok := w.next()
+			if !ok {
+				w.flush()
+				timer.Reset(wait)
+				break
+			}
+			workers.Add(1)
+			go func() {
+				defer workers.Done()
+				url, err := w.post(ctx, delivery)
+				w.finish(delivery, url, err)
+				w.notify()
+			}()
+		}
+	}
+}
+
+// flush saves the finished deliveries once none is being posted.
+func (w *Webhooks) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.unsaved == 0 || len(w.active) > 0 {
+		return
+	}
+	if err := w.save(); err != nil {
+		fmt.Printf("Error saving webhook queue: %v\n", err)
+	}
+}
+
+// next starts a due delivery whose webhook URL has a free worker, with the
+// URL it is posted to, or returns how long until the next one is due.
+func (w *Webhooks) next() (WebhookDelivery, time.Duration, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := time.Now()
+	for len(w.queue) > 0 && !w.queue[0].NextAttempt.After(now) {
+		delivery := heap.Pop(&w.queue).(WebhookDelivery)
+		url := w.state.Endpoints[delivery.Address].URL
+		w.waiting[url] = append(w.waiting[url], delivery)
+	}
+	for url, waiting := range w.waiting {
+		if w.busy[url] >= w.options.Concurrency {
+			continue
+		}
+		delivery := waiting[0]
+		if len(waiting) == 1 {
+			delete(w.waiting, url)
+		} else {
+			w.waiting[url] = waiting[1:]
+		}
+		w.busy[url]++
+		w.active[delivery.ID] = delivery
+		delivery.URL = url
+		return delivery, 0, true
+	}
+
+	wait := time.Hour
+	if len(w.queue) > 0 {
+		wait = time.Until(w.queue[0].NextAttempt)
+	}
+	return WebhookDelivery{}, wait, false
+}
+
+// post sends a delivery to the current webhook of its address, returning
+// the webhook's URL.
+func (w *Webhooks) post(ctx context.Context, delivery WebhookDelivery) (string, error) {
+	webhook, exists := w.Get(delivery.Address)
+	if !exists {
+		return "", ErrWebhookNotFound
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return webhook.URL, err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookIDHeader, delivery.ID)
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(webhook.Secret, timestamp, delivery.Payload))
+	resp, err := w.options.Client.Do(req)
+	if err != nil {
+		return webhook.URL, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return webhook.URL, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return webhook.URL, nil
+}
+
+// finish frees the worker of a delivery started by next, and removes the
+// delivery once sent or schedules its retry. The change is saved by the
+// next flush.
+func (w *Webhooks) finish(started WebhookDelivery, url string, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.busy[started.URL]--; w.busy[started.URL] <= 0 {
+		delete(w.busy, started.URL)
+	}
+	delivery, exists := w.active[started.ID]
+	if !exists {
+		// The webhook was removed
+		return
+	}
+	delete(w.active, started.ID)
+	if errors.Is(err, context.Canceled) {
+		// Shutting down, retried on the next run
+		heap.Push(&w.queue, delivery)
+		return
+	}
+	if err != nil {
+		delivery.URL = url
+		delivery.Attempts++
+		delivery.LastError = err.Error()
+		if delivery.Attempts >= w.options.MaxAttempts {
+			fmt.Printf("Webhook delivery %s to %s failed %d times: %v\n", delivery.ID, delivery.URL, delivery.Attempts, err)
+			w.state.Failed = append(w.state.Failed, delivery)
+			if dropped := len(w.state.Failed) - w.options.MaxFailed; dropped > 0 {
+				fmt.Printf("Dropping %d failed webhook deliveries, at most %d are kept\n", dropped, w.options.MaxFailed)
+				w.state.Failed = append([]WebhookDelivery{}, w.state.Failed[dropped:]...)
+			}
+		} else {
+			delivery.NextAttempt = time.Now().Add(w.backoff(delivery.Attempts))
+			heap.Push(&w.queue, delivery)
+		}
+	}
+	if w.unsaved++; w.unsaved >= webhookSaveBatch {
+		if err := w.save(); err != nil {
+			fmt.Printf("Error saving webhook queue: %v\n", err)
+		}
+	}
+}
+
+// backoff returns the delay before the retry following attempts failures.
+func (w *Webhooks) backoff(attempts int) time.Duration {
+	delay := w.options.MinBackoff
+	for i := 1; i < attempts && delay < w.options.MaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, w.options.MaxBackoff)
+}
+
+// SetWebhook registers the webhook the events of a subscribed address are
+// posted to, replacing any previous one, and returns it with its secret.
+func (s *MyParser) SetWebhook(ctx context.Context, address string, webhook Webhook) (Webhook, error) {
+	address, err := s.subscribed(ctx, address)
+	if err != nil {
+		return Webhook{}, err
+	}
+	return s.Webhooks().Set(address, webhook)
+}
+
+// GetWebhook returns the webhook of a subscribed address.
+func (s *MyParser) GetWebhook(ctx context.Context, address string) (Webhook, error) {
+	address, err := s.subscribed(ctx, address)
+	if err != nil {
+		return Webhook{}, err
+	}
+	webhook, exists := s.Webhooks().Get(address)
+	if !exists {
+		return Webhook{}, ErrWebhookNotFound
+	}
+	return webhook, nil
+}
+
+// RemoveWebhook stops posting the events of a subscribed address.
+func (s *MyParser) RemoveWebhook(ctx context.Context, address string) error {
+	address, err := s.subscribed(ctx, address)
+	if err != nil {
+		return err
+	}
+	return s.Webhooks().Remove(address)
+}
+
+// subscribed resolves address and checks it is subscribed.
+func (s *MyParser) subscribed(ctx context.Context, address string) (string, error) {
+	address, err := s.ResolveAddress(ctx, address)
+	if err != nil {
+		return "", err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if _, exists := s.subscribedAddresses[address]; !exists {
+		return "", ErrNotSubscribed
+	}
+	return address, nil
+}
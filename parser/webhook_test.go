@@ -0,0 +1,276 @@
+package parser
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// webhookRequest is a request received by a test webhook.
+type webhookRequest struct {
+	header http.Header
+	body   []byte
+}
+
+// newWebhookReceiver starts a webhook that answers with the status returned
+// by status and sends the requests it receives to the returned channel.
+func newWebhookReceiver(t *testing.T, status func() int) (*httptest.Server, <-chan webhookRequest) {
+	requests := make(chan webhookRequest, 100)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- webhookRequest{header: r.Header, body: body}
+		w.WriteHeader(status())
+	}))
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+func receive(t *testing.T, requests <-chan webhookRequest) webhookRequest {
+	select {
+	case req := <-requests:
+		return req
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook not delivered")
+		return webhookRequest{}
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	var calls atomic.Int64
+	receiver, requests := newWebhookReceiver(t, func() int {
+		if calls.Add(1) <= 2 {
+			return http.StatusInternalServerError
+		}
+		return http.StatusOK
+	})
+	addresses := testAddresses(2)
+	alice, bob := addresses[0], addresses[1]
+
+	stream := NewTxStream(0)
+	webhooks := NewWebhooks(WebhookOptions{MinBackoff: 10 * time.Millisecond, AllowPrivate: true})
+	_, err := webhooks.Set(alice, Webhook{URL: "ftp://example.com"})
+	require.ErrorIs(t, err, ErrInvalidWebhook)
+	webhook, err := webhooks.Set(alice, Webhook{URL: receiver.URL})
+	require.NoError(t, err)
+	require.Len(t, webhook.Secret, 64)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go webhooks.Run(ctx, stream)
+	require.NoError(t, stream.Publish(
+		TxEvent{Type: TxDetected, Address: bob, Transaction: Transaction{Txhash: "0x1"}},
+		TxEvent{Type: TxDetected, Address: alice, Transaction: Transaction{Txhash: "0x2"}},
+	))
+
+	// Failed attempts are retried with the same id
+	first := receive(t, requests)
+	receive(t, requests)
+	req := receive(t, requests)
+	require.Equal(t, first.header.Get(WebhookIDHeader), req.header.Get(WebhookIDHeader))
+	timestamp, err := strconv.ParseInt(req.header.Get(WebhookTimestampHeader), 10, 64)
+	require.NoError(t, err)
+	require.Equal(t, SignWebhook(webhook.Secret, timestamp, req.body), req.header.Get(WebhookSignatureHeader))
+	var event TxEvent
+	require.NoError(t, json.Unmarshal(req.body, &event))
+	require.Equal(t, uint64(2), event.Seq)
+	require.Equal(t, "0x2", event.Transaction.Txhash)
+
+	require.Eventually(t, func() bool { return len(webhooks.Pending()) == 0 }, 5*time.Second, 10*time.Millisecond)
+	require.Empty(t, webhooks.Failed())
+	select {
+	case req := <-requests:
+		t.Fatalf("unexpected delivery %s", req.body)
+	default:
+	}
+}
+
+func TestWebhooksFailed(t *testing.T) {
+	var status atomic.Int64
+	status.Store(http.StatusServiceUnavailable)
+	receiver, requests := newWebhookReceiver(t, func() int { return int(status.Load()) })
+	address := testAddresses(1)[0]
+	path := filepath.Join(t.TempDir(), "webhooks.json")
+	options := WebhookOptions{MaxAttempts: 2, MinBackoff: 10 * time.Millisecond, AllowPrivate: true}
+
+	stream := NewTxStream(0)
+	webhooks, err := OpenWebhooks(path, options)
+	require.NoError(t, err)
+	_, err = webhooks.Set(address, Webhook{URL: receiver.URL, Secret: "secret"})
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		webhooks.Run(ctx, stream)
+		close(done)
+	}()
+	require.NoError(t, stream.Publish(TxEvent{Type: TxDetected, Address: address}))
+	receive(t, requests)
+	receive(t, requests)
+	require.Eventually(t, func() bool { return len(webhooks.Failed()) == 1 }, 5*time.Second, 10*time.Millisecond)
+	require.Empty(t, webhooks.Pending())
+	cancel()
+	<-done
+
+	// The failed list survives restarts and can be replayed
+	webhooks, err = OpenWebhooks(path, options)
+	require.NoError(t, err)
+	failed := webhooks.Failed()
+	require.Len(t, failed, 1)
+	require.Equal(t, 2, failed[0].Attempts)
+	require.Equal(t, receiver.URL, failed[0].URL)
+	require.Contains(t, failed[0].LastError, "503")
+	require.ErrorIs(t, webhooks.Replay("unknown"), ErrDeliveryNotFound)
+	require.ErrorIs(t, webhooks.Discard("unknown"), ErrDeliveryNotFound)
+
+	status.Store(http.StatusNoContent)
+	require.NoError(t, webhooks.Replay(failed[0].ID))
+	require.Empty(t, webhooks.Failed())
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	go webhooks.Run(ctx, stream)
+	req := receive(t, requests)
+	require.Equal(t, failed[0].ID, req.header.Get(WebhookIDHeader))
+	require.Eventually(t, func() bool { return len(webhooks.Pending()) == 0 }, 5*time.Second, 10*time.Millisecond)
+
+	// Queued deliveries survive restarts too, and are dropped with their
+	// webhook
+	status.Store(http.StatusInternalServerError)
+	require.NoError(t, stream.Publish(TxEvent{Type: TxDetected, Address: address}))
+	receive(t, requests)
+	cancel()
+	webhooks, err = OpenWebhooks(path, WebhookOptions{AllowPrivate: true})
+	require.NoError(t, err)
+	require.Len(t, webhooks.Pending(), 1)
+	require.NoError(t, webhooks.Remove(address))
+	require.Empty(t, webhooks.Pending())
+	require.ErrorIs(t, webhooks.Remove(address), ErrWebhookNotFound)
+}
+
+func TestWebhooksFailedLimit(t *testing.T) {
+	receiver, requests := newWebhookReceiver(t, func() int { return http.StatusServiceUnavailable })
+	address := testAddresses(1)[0]
+
+	stream := NewTxStream(0)
+	webhooks := NewWebhooks(WebhookOptions{MaxAttempts: 1, MaxFailed: 2, AllowPrivate: true})
+	_, err := webhooks.Set(address, Webhook{URL: receiver.URL})
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go webhooks.Run(ctx, stream)
+	for i := 1; i <= 3; i++ {
+		require.NoError(t, stream.Publish(TxEvent{Type: TxDetected, Address: address, Transaction: Transaction{Txhash: strconv.Itoa(i)}}))
+		receive(t, requests)
+	}
+
+	// The oldest failed delivery is dropped
+	require.Eventually(t, func() bool { return len(webhooks.Pending()) == 0 }, 5*time.Second, 10*time.Millisecond)
+	failed := webhooks.Failed()
+	require.Len(t, failed, 2)
+	var event TxEvent
+	require.NoError(t, json.Unmarshal(failed[0].Payload, &event))
+	require.Equal(t, "2", event.Transaction.Txhash)
+}
+
+func TestWebhooksSlowEndpoint(t *testing.T) {
+	release := make(chan struct{})
+	var slowCalls atomic.Int64
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		slowCalls.Add(1)
+		<-release
+	}))
+	t.Cleanup(slow.Close)
+	t.Cleanup(func() { close(release) })
+	fast, requests := newWebhookReceiver(t, func() int { return http.StatusOK })
+	addresses := testAddresses(2)
+	alice, bob := addresses[0], addresses[1]
+
+	stream := NewTxStream(0)
+	webhooks := NewWebhooks(WebhookOptions{Concurrency: 2, AllowPrivate: true})
+	_, err := webhooks.Set(alice, Webhook{URL: slow.URL})
+	require.NoError(t, err)
+	_, err = webhooks.Set(bob, Webhook{URL: fast.URL})
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go webhooks.Run(ctx, stream)
+
+	// A slow webhook only holds up its own deliveries, at most Concurrency
+	// at once
+	for i := 0; i < 5; i++ {
+		require.NoError(t, stream.Publish(TxEvent{Type: TxDetected, Address: alice}))
+	}
+	require.Eventually(t, func() bool { return slowCalls.Load() == 2 }, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, stream.Publish(TxEvent{Type: TxDetected, Address: bob}))
+	receive(t, requests)
+	require.Eventually(t, func() bool { return len(webhooks.Pending()) == 5 }, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, int64(2), slowCalls.Load())
+}
+
+func TestWebhookSecretsEncrypted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "webhooks.json")
+	address := testAddresses(1)[0]
+	options := WebhookOptions{Keys: testKeyring(t, testKey("k1", 1))}
+	webhooks, err := OpenWebhooks(path, options)
+	require.NoError(t, err)
+	webhook, err := webhooks.Set(address, Webhook{URL: "https://example.com/hook"})
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.NotContains(t, string(data), webhook.Secret)
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	webhooks, err = OpenWebhooks(path, options)
+	require.NoError(t, err)
+	reopened, exists := webhooks.Get(address)
+	require.True(t, exists)
+	require.Equal(t, webhook, reopened)
+	_, err = OpenWebhooks(path, WebhookOptions{Keys: testKeyring(t, testKey("k2", 2))})
+	require.Error(t, err)
+}
+
+func TestWebhookPrivateAddresses(t *testing.T) {
+	webhooks := NewWebhooks(WebhookOptions{})
+	for _, url := range []string{
+		"http://localhost:8080/hook",
+		"http://127.0.0.1/hook",
+		"http://10.1.2.3/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"http://100.100.100.200/hook",
+		"http://[::1]/hook",
+		"http://[fd00:ec2::254]/hook",
+		"http://0.0.0.0/hook",
+	} {
+		_, err := webhooks.Set("0x1", Webhook{URL: url})
+		require.ErrorIs(t, err, ErrInvalidWebhook, url)
+	}
+	_, err := webhooks.Set("0x1", Webhook{URL: "https://example.com/hook"})
+	require.NoError(t, err)
+
+	// Host names are checked when connecting
+	receiver, _ := newWebhookReceiver(t, func() int { return http.StatusOK })
+	_, err = webhookClient().Get(receiver.URL)
+	require.ErrorIs(t, err, ErrInvalidWebhook)
+	_, err = NewWebhooks(WebhookOptions{AllowPrivate: true}).Set("0x1", Webhook{URL: receiver.URL})
+	require.NoError(t, err)
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	webhooks := NewWebhooks(WebhookOptions{MinBackoff: time.Second, MaxBackoff: 5 * time.Second})
+	require.Equal(t, time.Second, webhooks.backoff(1))
+	require.Equal(t, 4*time.Second, webhooks.backoff(3))
+	require.Equal(t, 5*time.Second, webhooks.backoff(4))
+	require.Equal(t, 5*time.Second, webhooks.backoff(100))
+}
@@ -8,11 +8,74 @@ import (
 	"math/big"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/EliasManj/tx-parser/utils"
 )
 
+// sendRequest posts a JSON-RPC payload to endpoint, recording its outcome
+// and latency.
 func sendRequest(endpoint string, payload map[string]interface{}) (map[string]interface{}, error) {
+	start := time.Now()
+	result, err := post(endpoint, payload)
+	observeRequest(endpoint, payload, result, err, time.Since(start))
+	return result, err
+}
+
+func post(endpoint string, payload map[string]interface{}) (map[string]interface{}, error) {
+	body, err := postBody(endpoint, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	var result map[string]interface{}
+	err = json.Unmarshal(body, &result)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshaling response: %v", err)
+	}
+
+	return result, nil
+}
+
+// sendBatch posts payloads as one JSON-RPC batch, recording each of them,
+// and returns the responses in the order of the payloads. Payloads without
+// a response get nil.
+func sendBatch(endpoint string, payloads []map[string]interface{}) ([]map[string]interface{}, error) {
+	start := time.Now()
+	results, err := postBatch(endpoint, payloads)
+	elapsed := time.Since(start)
+	for i, payload := range payloads {
+		var result map[string]interface{}
+		if results != nil {
+			result = results[i]
+		}
+		observeRequest(endpoint, payload, result, err, elapsed)
+	}
+	return results, err
+}
+
+func postBatch(endpoint string, payloads []map[string]interface{}) ([]map[string]interface{}, error) {
+	body, err := postBody(endpoint, payloads)
+	if err != nil {
+		return nil, err
+	}
+
+	var responses []map[string]interface{}
+	if err := json.Unmarshal(body, &responses); err != nil {
+		return nil, fmt.Errorf("error unmarshaling batch response: %v", err)
+	}
+	byID := make(map[string]map[string]interface{}, len(responses))
+	for _, response := range responses {
+		byID[fmt.Sprint(response["id"])] = response
+	}
+	results := make([]map[string]interface{}, len(payloads))
+	for i, payload := range payloads {
+		results[i] = byID[fmt.Sprint(payload["id"])]
+	}
+	return results, nil
+}
+
+func postBody(endpoint string, payload interface{}) ([]byte, error) {
 	jsonData, err := json.Marshal(payload)
 	if err != nil {
 		return nil, fmt.Errorf("error encoding JSON: %v", err)
@@ -35,14 +98,7 @@ func sendRequest(endpoint string, payload map[string]interface{}) (map[string]in
 	if err != nil {
 		return nil, fmt.Errorf("error reading response body: %v", err)
 	}
-
-	var result map[string]interface{}
-	err = json.Unmarshal(body, &result)
-	if err != nil {
-		return nil, fmt.Errorf("error unmarshaling response: %v", err)
-	}
-
-	return result, nil
+	return body, nil
 }
 
 func GetBalance(address string, endpoint string) (string, error) {
@@ -95,6 +151,32 @@ func GetTransactionsByBlockNumber(blockNumber string, address string, endpoint s
 	return filteredTxs, nil
 }
 
+// GetBlockHeader returns a block without its transactions, with fields
+// such as hash and parentHash.
+func GetBlockHeader(blockNumber string, endpoint string) (map[string]interface{}, error) {
+	payload := map[string]interface{}{
+		"jsonrpc": "2.0",
+		"method":  "eth_getBlockByNumber",
+		"params":  []interface{}{blockNumber, false},
+		"id":      1,
+	}
+	result, err := sendRequest(endpoint, payload)
+	if err != nil {
+		return nil, fmt.Errorf("error sending request: %v", err)
+	}
+
+	if result["error"] != nil {
+		errorInfo := result["error"].(map[string]interface{})
+		return nil, fmt.Errorf("RPC error: %v", errorInfo["message"])
+	}
+
+	header, ok := result["result"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("block %s not found", blockNumber)
+	}
+	return header, nil
+}
+
 func GetLatestBlockNumber(endpoint string) (*big.Int, error) {
 	payload := map[string]interface{}{
 		"jsonrpc": "2.0",
@@ -117,6 +199,64 @@ func GetLatestBlockNumber(endpoint string) (*big.Int, error) {
 	return number, nil
 }
 
+func GetChainID(endpoint string) (*big.Int, error) {
+	payload := map[string]interface{}{
+		"jsonrpc": "2.0",
+		"method":  "eth_chainId",
+		"params":  []interface{}{},
+		"id":      1,
+	}
+
+	result, err := sendRequest(endpoint, payload)
+	if err != nil {
+		return nil, fmt.Errorf("error sending request: %v", err)
+	}
+
+	chainIDStr, ok := result["result"].(string)
+	if !ok {
+		return nil, fmt.Errorf("chain id not found in response")
+	}
+	chainID, err := utils.HexToDec(chainIDStr)
+	if err != nil {
+		return nil, fmt.Errorf("error converting chain id: %v", err)
+	}
+
+	return chainID, nil
+}
+
+// Call executes a read-only contract call against the latest block and
+// returns the hex-encoded return data.
+func Call(to string, data string, endpoint string) (string, error) {
+	payload := map[string]interface{}{
+		"jsonrpc": "2.0",
+		"method":  "eth_call",
+		"params": []interface{}{
+			map[string]interface{}{
+				"to":   to,
+				"data": data,
+			},
+			"latest",
+		},
+		"id": 1,
+	}
+
+	result, err := sendRequest(endpoint, payload)
+	if err != nil {
+		return "", fmt.Errorf("error sending request: %v", err)
+	}
+
+	if result["error"] != nil {
+		errorInfo := result["error"].(map[string]interface{})
+		return "", fmt.Errorf("RPC error: %v", errorInfo["message"])
+	}
+
+	returnData, ok := result["result"].(string)
+	if !ok {
+		return "", fmt.Errorf("call result not found in response")
+	}
+	return returnData, nil
+}
+
 func AnvilGetAccounts(endpoint string) ([]interface{}, error) {
 	payload := map[string]interface{}{
 		"jsonrpc": "2.0",
@@ -186,10 +326,43 @@ func GetTransactionReceipt(txHash string, endpoint string) (map[string]interface
 		return nil, fmt.Errorf("RPC error: %v", errorInfo["message"])
 	}
 
-	receipt := result["result"].(map[string]interface{})
+	receipt, ok := result["result"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("no receipt for transaction %s", txHash)
+	}
 	return receipt, nil
 }
 
+// GetTransactionReceipts returns the receipts of txHashes by hash, fetched
+// in one batch request. Transactions the node has no receipt for, or failed
+// to return one for, are left out.
+func GetTransactionReceipts(txHashes []string, endpoint string) (map[string]map[string]interface{}, error) {
+	receipts := make(map[string]map[string]interface{}, len(txHashes))
+	if len(txHashes) == 0 {
+		return receipts, nil
+	}
+	payloads := make([]map[string]interface{}, len(txHashes))
+	for i, txHash := range txHashes {
+		payloads[i] = map[string]interface{}{
+			"jsonrpc": "2.0",
+			"method":  "eth_getTransactionReceipt",
+			"params":  []interface{}{txHash},
+			"id":      i + 1,
+		}
+	}
+
+	results, err := sendBatch(endpoint, payloads)
+	if err != nil {
+		return nil, fmt.Errorf("error sending request: %v", err)
+	}
+	for i, result := range results {
+		if receipt, ok := result["result"].(map[string]interface{}); ok {
+			receipts[txHashes[i]] = receipt
+		}
+	}
+	return receipts, nil
+}
+
 func GetAddressTxHistory(address string, endpoint string) ([]map[string]interface{}, error) {
 	var transactions []map[string]interface{}
 	latestBlock, err := GetLatestBlockNumber(endpoint)
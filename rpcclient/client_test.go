@@ -70,6 +70,12 @@ func TestGetBlockNumber(t *testing.T) {
 	require.NotEmpty(t, blockNumber)
 }
 
+func TestGetChainID(t *testing.T) {
+	chainID, err := GetChainID(URL)
+	require.NoError(t, err)
+	require.Equal(t, int64(31337), chainID.Int64(), "Expected the Anvil default chain id")
+}
+
 func TestGetTransactions(t *testing.T) {
 	acc1 := Accounts[0].(string)
 	acc2 := Accounts[1].(string)
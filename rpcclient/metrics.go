@@ -0,0 +1,39 @@
+package rpcclient
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/EliasManj/tx-parser/metrics"
+)
+
+var (
+	requestsTotal = metrics.Default.Counter("txparser_rpc_requests_total",
+		"JSON-RPC requests sent, by method and endpoint.", "method", "endpoint")
+	requestErrors = metrics.Default.Counter("txparser_rpc_request_errors_total",
+		"JSON-RPC requests that failed or returned an error, by method and endpoint.", "method", "endpoint")
+	requestDuration = metrics.Default.Histogram("txparser_rpc_request_duration_seconds",
+		"Latency of JSON-RPC requests, by method and endpoint.", metrics.DefBuckets, "method", "endpoint")
+)
+
+// observeRequest records a request to endpoint and its outcome.
+func observeRequest(endpoint string, payload, result map[string]interface{}, err error, elapsed time.Duration) {
+	method := fmt.Sprint(payload["method"])
+	host := endpointLabel(endpoint)
+	requestsTotal.Inc(method, host)
+	requestDuration.Observe(elapsed.Seconds(), method, host)
+	if err != nil || result["error"] != nil {
+		requestErrors.Inc(method, host)
+	}
+}
+
+// endpointLabel reduces an endpoint URL to its scheme and host, since
+// providers put API keys in the path or user info.
+func endpointLabel(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return "unknown"
+	}
+	return u.Scheme + "://" + u.Host
+}
@@ -0,0 +1,7 @@
+//go:build sqlite
+
+package main
+
+// Build with -tags sqlite to register a SQLite driver for the sql storage
+// backend. The default build has no external dependencies.
+import _ "modernc.org/sqlite"
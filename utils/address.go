@@ -0,0 +1,45 @@
+package utils
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// NormalizeAddress validates a 20-byte hex address and returns its canonical
+// lowercase form. Mixed-case input must carry a valid EIP-55 checksum;
+// all-lowercase and all-uppercase input is accepted without one.
+func NormalizeAddress(address string) (string, error) {
+	if !strings.HasPrefix(address, "0x") {
+		return "", fmt.Errorf("address must start with 0x: %q", address)
+	}
+	digits := address[2:]
+	if len(digits) != 40 {
+		return "", fmt.Errorf("address must have 40 hex digits, got %d: %q", len(digits), address)
+	}
+	if _, err := hex.DecodeString(digits); err != nil {
+		return "", fmt.Errorf("address is not hex: %q", address)
+	}
+
+	lower := strings.ToLower(address)
+	if digits != strings.ToLower(digits) && digits != strings.ToUpper(digits) {
+		if ChecksumAddress(lower) != address {
+			return "", fmt.Errorf("address has an invalid EIP-55 checksum: %q", address)
+		}
+	}
+	return lower, nil
+}
+
+// ChecksumAddress returns the EIP-55 mixed-case form of a valid hex address.
+func ChecksumAddress(address string) string {
+	lower := strings.ToLower(strings.TrimPrefix(address, "0x"))
+	hash := hex.EncodeToString(Keccak256([]byte(lower)))
+
+	checksummed := []byte(lower)
+	for i, c := range checksummed {
+		if c >= 'a' && c <= 'f' && hash[i] >= '8' {
+			checksummed[i] = c - 'a' + 'A'
+		}
+	}
+	return "0x" + string(checksummed)
+}
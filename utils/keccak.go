@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// Keccak-256 as used by Ethereum. This is the original Keccak submission,
+// which differs from the standardized SHA3-256 only in its padding byte.
+
+const keccak256Rate = 136
+
+var keccakRoundConstants = [24]uint64{
+	0x0000000000000001, 0x0000000000008082, 0x800000000000808a, 0x8000000080008000,
+	0x000000000000808b, 0x0000000080000001, 0x8000000080008081, 0x8000000000008009,
+	0x000000000000008a, 0x0000000000000088, 0x0000000080008009, 0x000000008000000a,
+	0x000000008000808b, 0x800000000000008b, 0x8000000000008089, 0x8000000000008003,
+	0x8000000000008002, 0x8000000000000080, 0x000000000000800a, 0x800000008000000a,
+	0x8000000080008081, 0x8000000000008080, 0x0000000080000001, 0x8000000080008008,
+}
+
+var keccakRotations = [25]int{
+	0, 1, 62, 28, 27,
+	36, 44, 6, 55, 20,
+	3, 10, 43, 25, 39,
+	41, 45, 15, 21, 8,
+	18, 2, 61, 56, 14,
+}
+
+func keccakF1600(a *[25]uint64) {
+	var c [5]uint64
+	var b [25]uint64
+	for round := 0; round < 24; round++ {
+		// Theta
+		for x := 0; x < 5; x++ {
+			c[x] = a[x] ^ a[x+5] ^ a[x+10] ^ a[x+15] ^ a[x+20]
+		}
+		for x := 0; x < 5; x++ {
+			d := c[(x+4)%5] ^ bits.RotateLeft64(c[(x+1)%5], 1)
+			for y := 0; y < 25; y += 5 {
+				a[y+x] ^= d
+			}
+		}
+		// Rho and pi
+		for x := 0; x < 5; x++ {
+			for y := 0; y < 5; y++ {
+				b[y+5*((2*x+3*y)%5)] = bits.RotateLeft64(a[x+5*y], keccakRotations[x+5*y])
+			}
+		}
+		// Chi
+		for y := 0; y < 25; y += 5 {
+			for x := 0; x < 5; x++ {
+				a[y+x] = b[y+x] ^ (^b[y+(x+1)%5] & b[y+(x+2)%5])
+			}
+		}
+		// Iota
+		a[0] ^= keccakRoundConstants[round]
+	}
+}
+
+// Keccak256 returns the Keccak-256 hash of the concatenated inputs.
+func Keccak256(data ...[]byte) []byte {
+	var input []byte
+	for _, d := range data {
+		input = append(input, d...)
+	}
+
+	padded := make([]byte, (len(input)/keccak256Rate+1)*keccak256Rate)
+	copy(padded, input)
+	padded[len(input)] ^= 0x01
+	padded[len(padded)-1] ^= 0x80
+
+	var state [25]uint64
+	for offset := 0; offset < len(padded); offset += keccak256Rate {
+		for i := 0; i < keccak256Rate/8; i++ {
+			state[i] ^= binary.LittleEndian.Uint64(padded[offset+8*i:])
+		}
+		keccakF1600(&state)
+	}
+
+	out := make([]byte, 32)
+	for i := 0; i < 4; i++ {
+		binary.LittleEndian.PutUint64(out[8*i:], state[i])
+	}
+	return out
+}
@@ -0,0 +1,63 @@
+package utils
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeccak256(t *testing.T) {
+	tests := map[string]string{
+		"":            "c5d2460186f7233c927e7db2dcc703c0e500b653ca82273b7bfad8045d85a470",
+		"abc":         "4e03657aea45a94fc7d47ba826c8d667c0d1e6e33a64a036ec44f58fa12d6c45",
+		"hello world": "47173285a8d7341e5e972fc677286384f802f8ef42a5ec5f03bbfa254cb01fad",
+	}
+	for input, expected := range tests {
+		require.Equal(t, expected, hex.EncodeToString(Keccak256([]byte(input))), "input %q", input)
+	}
+
+	// Inputs spanning several blocks
+	long := strings.Repeat("a", 200)
+	require.Equal(t, Keccak256([]byte(long)), Keccak256([]byte(long[:100]), []byte(long[100:])))
+}
+
+func TestChecksumAddress(t *testing.T) {
+	// Test vectors from EIP-55
+	addresses := []string{
+		"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed",
+		"0xfB6916095ca1df60bB79Ce92cE3Ea74c37c5d359",
+		"0xdbF03B407c01E7cD3CBea99509d93f8DDDC8C6FB",
+		"0xD1220A0cf47c7B9Be7A2E6BA89F429762e7b9aDb",
+	}
+	for _, address := range addresses {
+		require.Equal(t, address, ChecksumAddress(strings.ToLower(address)))
+	}
+}
+
+func TestNormalizeAddress(t *testing.T) {
+	checksummed := "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"
+	lower := strings.ToLower(checksummed)
+
+	valid := []string{checksummed, lower, "0x" + strings.ToUpper(lower[2:])}
+	for _, address := range valid {
+		normalized, err := NormalizeAddress(address)
+		require.NoError(t, err, address)
+		require.Equal(t, lower, normalized)
+	}
+
+	invalid := []string{
+		"",
+		"hello",
+		lower[2:],
+		lower[:41],
+		lower + "0",
+		"0x" + strings.Repeat("g", 40),
+		"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAeD",
+	}
+	for _, address := range invalid {
+		_, err := NormalizeAddress(address)
+		require.Error(t, err, address)
+	}
+}